@@ -0,0 +1,188 @@
+#!/usr/bin/env bash
+#
+# End-to-end tests of the manager against a real Grafana instance, run in
+# Docker. It runs pull and push cycles in "simple sync" mode and checks that
+# dashboards survive the round trip between Grafana and the files unchanged.
+#
+# Requires docker, curl and jq, and the manager's binaries (built with
+# `make build`, or in the directory set with BIN_DIR).
+#
+# Environment variables:
+#   BIN_DIR        Directory containing the puller and gdm binaries (default: bin)
+#   GRAFANA_IMAGE  Docker image of Grafana to test against (default: grafana/grafana:9.5.0)
+#   GRAFANA_PORT   Port Grafana is exposed on on the host (default: 3300)
+
+set -euo pipefail
+
+BIN_DIR="$(cd "${BIN_DIR:-bin}" && pwd)"
+GRAFANA_IMAGE="${GRAFANA_IMAGE:-grafana/grafana:9.5.0}"
+GRAFANA_PORT="${GRAFANA_PORT:-3300}"
+GRAFANA_URL="http://127.0.0.1:${GRAFANA_PORT}"
+AUTH="admin:admin"
+UID_="gdm-e2e-roundtrip"
+# A dashboard that is never deleted, since gdm clean refuses to run when there
+# are no dashboards on Grafana.
+KEPT_UID="gdm-e2e-kept"
+
+WORK="$(mktemp -d)"
+CONTAINER=""
+
+cleanup() {
+	if [ -n "$CONTAINER" ]; then
+		docker rm -f "$CONTAINER" > /dev/null || true
+	fi
+	rm -rf "$WORK"
+}
+trap cleanup EXIT
+
+step() {
+	echo "==> $*"
+}
+
+fail() {
+	echo "FAIL: $*" >&2
+	exit 1
+}
+
+# grafana_api performs a request on Grafana's HTTP API, authenticated as the
+# admin user.
+grafana_api() {
+	local method="$1" route="$2"
+	shift 2
+	curl -sf -u "$AUTH" -X "$method" -H "Content-Type: application/json" \
+		"${GRAFANA_URL}/api/${route}" "$@"
+}
+
+# dashboard_file prints the path of the file the puller wrote the dashboard
+# with the given UID to.
+dashboard_file() {
+	grep -rl --include='*.json' "\"uid\": \"$1\"" "$WORK/repo" | head -n 1
+}
+
+# normalise prints the given dashboard's JSON description with sorted keys and
+# without the fields Grafana sets itself.
+normalise() {
+	jq -S 'del(.id, .version)' "$@"
+}
+
+for tool in docker curl jq; do
+	command -v "$tool" > /dev/null || fail "$tool is required"
+done
+for bin in puller gdm; do
+	[ -x "$BIN_DIR/$bin" ] || fail "$BIN_DIR/$bin not found, run make build first"
+done
+
+step "Starting $GRAFANA_IMAGE"
+CONTAINER="$(docker run -d -p "127.0.0.1:${GRAFANA_PORT}:3000" \
+	-e GF_SECURITY_ADMIN_PASSWORD=admin "$GRAFANA_IMAGE")"
+
+for _ in $(seq 60); do
+	if curl -sf "${GRAFANA_URL}/api/health" > /dev/null; then
+		break
+	fi
+	sleep 1
+done
+curl -sf "${GRAFANA_URL}/api/health" > /dev/null || fail "Grafana didn't start"
+
+cat > "$WORK/config.yaml" <<CONFIG
+grafana:
+    base_url: ${GRAFANA_URL}
+    username: admin
+    password: admin
+simple_sync:
+    sync_path: ${WORK}/repo
+CONFIG
+
+puller() {
+	"$BIN_DIR/puller" --config "$WORK/config.yaml" 2> "$WORK/puller.log" ||
+		{ cat "$WORK/puller.log" >&2; fail "The puller failed"; }
+}
+
+gdm() {
+	"$BIN_DIR/gdm" --config "$WORK/config.yaml" "$@" 2> "$WORK/gdm.log" ||
+		{ cat "$WORK/gdm.log" >&2; fail "gdm $1 failed"; }
+}
+
+step "Creating dashboards on Grafana"
+grafana_api POST dashboards/db -d @- > /dev/null <<DASHBOARD
+{
+	"dashboard": {
+		"uid": "${UID_}",
+		"title": "GDM e2e round trip",
+		"tags": ["e2e"],
+		"schemaVersion": 16,
+		"panels": [
+			{
+				"id": 1,
+				"type": "graph",
+				"title": "Graph",
+				"gridPos": {"x": 0, "y": 0, "w": 12, "h": 8},
+				"targets": [{"refId": "A", "expr": "up"}]
+			},
+			{
+				"id": 2,
+				"type": "text",
+				"title": "Text",
+				"mode": "markdown",
+				"content": "Hello",
+				"gridPos": {"x": 12, "y": 0, "w": 12, "h": 8}
+			}
+		]
+	},
+	"overwrite": true
+}
+DASHBOARD
+
+grafana_api POST dashboards/db -d @- > /dev/null <<DASHBOARD
+{
+	"dashboard": {"uid": "${KEPT_UID}", "title": "GDM e2e kept", "schemaVersion": 16, "panels": []},
+	"overwrite": true
+}
+DASHBOARD
+
+step "Pulling the dashboard"
+puller
+file="$(dashboard_file "$UID_")"
+[ -n "$file" ] || fail "The puller didn't write the dashboard"
+
+grafana_api GET "dashboards/uid/${UID_}" | jq '.dashboard' > "$WORK/live.json"
+diff <(normalise "$WORK/live.json") <(normalise "$file") ||
+	fail "The pulled file doesn't match the dashboard on Grafana"
+
+step "Pulling again without changes"
+cp "$file" "$WORK/before.json"
+puller
+cmp -s "$file" "$WORK/before.json" || fail "Pulling twice changed the file"
+
+step "Pushing a modified dashboard"
+jq '.title = "GDM e2e round trip (modified)"' "$file" > "$WORK/modified.json"
+cp "$WORK/modified.json" "$file"
+gdm push "$file" > /dev/null
+
+grafana_api GET "dashboards/uid/${UID_}" | jq '.dashboard' > "$WORK/live.json"
+[ "$(jq -r '.title' "$WORK/live.json")" = "GDM e2e round trip (modified)" ] ||
+	fail "The pushed change wasn't applied on Grafana"
+diff <(normalise "$WORK/live.json") <(normalise "$WORK/modified.json") ||
+	fail "Grafana didn't store the pushed dashboard as it was pushed"
+
+step "Pulling the pushed dashboard back"
+puller
+file="$(dashboard_file "$UID_")"
+diff <(normalise "$WORK/modified.json") <(normalise "$file") ||
+	fail "The round trip changed the dashboard"
+
+step "Getting the dashboard with gdm"
+gdm get "$UID_" > "$WORK/get.json"
+diff <(normalise "$WORK/live.json") <(normalise "$WORK/get.json") ||
+	fail "gdm get didn't print the dashboard from Grafana"
+
+step "Cleaning the file of a deleted dashboard"
+grafana_api DELETE "dashboards/uid/${UID_}" > /dev/null
+gdm clean > /dev/null
+[ -z "$(dashboard_file "$UID_")" ] || fail "gdm clean didn't remove the file"
+[ -n "$(dashboard_file "$KEPT_UID")" ] || fail "gdm clean removed the file of an existing dashboard"
+
+step "Running the self-test"
+gdm selftest
+
+echo "All end-to-end tests passed"
@@ -0,0 +1,206 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/buildinfo"
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/pause"
+	"github.com/babolivier/grafana-dashboards-manager/src/state"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Serve exposes the admin API on the address from the given settings. It serves
+// the manager's state as JSON on "/state", and the dashboards' sync timestamps
+// as Prometheus metrics on "/metrics". The state is read from the state file
+// on each request, so it includes the syncs made by other processes (e.g. the
+// puller). It also lets the pushes to Grafana be paused and resumed with the
+// given switch, with POST requests on "/pause" and "/resume" (see
+// pause.Switch), which respond, as GET requests on "/pause" do, with whether
+// the pushes are paused.
+// Returns an error if the server couldn't be started or stopped unexpectedly.
+func Serve(
+	cfg *config.AdminSettings, stateFile string, paused *pause.Switch,
+) error {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		s, err := state.Load(stateFile)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	})
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		s, err := state.Load(stateFile)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, s)
+		writePauseMetric(w, paused)
+	})
+
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			paused.Pause()
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		writePauseStatus(w, paused)
+	})
+
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		paused.Resume()
+		writePauseStatus(w, paused)
+	})
+
+	logrus.WithFields(logrus.Fields{
+		"address": cfg.Address,
+	}).Info("Serving the admin API")
+
+	return http.ListenAndServe(cfg.Address, mux)
+}
+
+// writePauseStatus responds with whether the pushes to Grafana are paused with
+// the given switch, as JSON.
+func writePauseStatus(w http.ResponseWriter, paused *pause.Switch) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"paused": paused.Paused()})
+}
+
+// serverError logs the given error and responds with a 500 status code.
+func serverError(w http.ResponseWriter, err error) {
+	logrus.WithFields(logrus.Fields{
+		"error": err,
+	}).Error("Failed to load the state")
+
+	http.Error(w, "Failed to load the state", http.StatusInternalServerError)
+}
+
+// writeMetrics writes the manager's build information, as the labels of a
+// constant metric, and the dashboards' sync timestamps from the given state in
+// the Prometheus text exposition format, as Unix timestamps labelled with the
+// dashboards' slugs. Dashboards which were never synced a given way are left
+// out of the matching metric. The timeouts of the transfers with the Git remote
+// are written too.
+func writeMetrics(w http.ResponseWriter, s *state.State) {
+	fmt.Fprintln(w, "# HELP gdm_build_info Build information of the manager, as labels.")
+	fmt.Fprintln(w, "# TYPE gdm_build_info gauge")
+	fmt.Fprintf(
+		w, "gdm_build_info{version=\"%s\",commit=\"%s\",build_date=\"%s\"} 1\n",
+		escapeLabel(buildinfo.Version), escapeLabel(buildinfo.Commit),
+		escapeLabel(buildinfo.BuildDate),
+	)
+
+	slugs := make([]string, 0, len(s.Dashboards))
+	for slug := range s.Dashboards {
+		slugs = append(slugs, slug)
+	}
+	sort.Strings(slugs)
+
+	metrics := []struct {
+		name  string
+		help  string
+		value func(d *state.DashboardState) *time.Time
+	}{
+		{
+			name:  "gdm_dashboard_last_pull_timestamp_seconds",
+			help:  "Time of the last successful pull of the dashboard from Grafana.",
+			value: func(d *state.DashboardState) *time.Time { return d.LastPull },
+		},
+		{
+			name:  "gdm_dashboard_last_push_timestamp_seconds",
+			help:  "Time of the last successful push of the dashboard to Grafana.",
+			value: func(d *state.DashboardState) *time.Time { return d.LastPush },
+		},
+		{
+			name:  "gdm_dashboard_last_push_failure_timestamp_seconds",
+			help:  "Time of the last failed push of the dashboard to Grafana.",
+			value: func(d *state.DashboardState) *time.Time { return d.LastPushFailure },
+		},
+	}
+
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", metric.name)
+
+		for _, slug := range slugs {
+			if t := metric.value(s.Dashboards[slug]); t != nil {
+				fmt.Fprintf(
+					w, "%s{slug=\"%s\"} %d\n", metric.name, escapeLabel(slug), t.Unix(),
+				)
+			}
+		}
+	}
+	writeGitMetrics(w, s.Git)
+}
+
+// writeGitMetrics writes the number of timeouts of each Git operation, and the
+// time of the latest timeout, from the given state of the transfers with the
+// Git remote, in the Prometheus text exposition format.
+func writeGitMetrics(w http.ResponseWriter, s *state.GitState) {
+	fmt.Fprintln(w, "# HELP gdm_git_timeouts_total Number of transfers with the Git remote which timed out, by operation.")
+	fmt.Fprintln(w, "# TYPE gdm_git_timeouts_total counter")
+	if s == nil {
+		return
+	}
+
+	operations := make([]string, 0, len(s.Timeouts))
+	for operation := range s.Timeouts {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+
+	for _, operation := range operations {
+		fmt.Fprintf(
+			w, "gdm_git_timeouts_total{operation=\"%s\"} %d\n",
+			escapeLabel(operation), s.Timeouts[operation],
+		)
+	}
+
+	if s.LastTimeout != nil {
+		fmt.Fprintln(w, "# HELP gdm_git_last_timeout_timestamp_seconds Time of the latest transfer with the Git remote which timed out.")
+		fmt.Fprintln(w, "# TYPE gdm_git_last_timeout_timestamp_seconds gauge")
+		fmt.Fprintf(w, "gdm_git_last_timeout_timestamp_seconds %d\n", s.LastTimeout.Unix())
+	}
+}
+
+// writePauseMetric writes whether the pushes to Grafana are paused with the
+// given switch, as 1 or 0, in the Prometheus text exposition format.
+func writePauseMetric(w http.ResponseWriter, paused *pause.Switch) {
+	value := 0
+	if paused.Paused() {
+		value = 1
+	}
+
+	fmt.Fprintln(w, "# HELP gdm_pushes_paused Whether the pushes to Grafana are paused.")
+	fmt.Fprintln(w, "# TYPE gdm_pushes_paused gauge")
+	fmt.Fprintf(w, "gdm_pushes_paused %d\n", value)
+}
+
+// escapeLabel escapes a label value for the Prometheus text exposition format.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
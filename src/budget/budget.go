@@ -0,0 +1,104 @@
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/jsonpath"
+)
+
+// panel represents the parts of a panel's JSON description needed to check it
+// against the budgets.
+type panel struct {
+	ID      int               `json:"id"`
+	Title   string            `json:"title"`
+	Type    string            `json:"type"`
+	Targets []json.RawMessage `json:"targets"`
+	Panels  []panel           `json:"panels"`
+}
+
+// Check checks a dashboard's JSON description against the given budgets, and
+// returns a description of each budget it exceeds (e.g. "12 panels (max 10)").
+// The descriptions of the budgets exceeded by a panel start with the panel's
+// path and position in the description (see jsonpath.Error). Budgets set to 0
+// aren't checked.
+// Returns an error if the description couldn't be parsed.
+func Check(dashboardJSON []byte, cfg *config.BudgetsSettings) ([]string, error) {
+	violations := make([]string, 0)
+
+	if cfg.MaxSize > 0 && len(dashboardJSON) > cfg.MaxSize {
+		violations = append(violations, fmt.Sprintf(
+			"JSON description is %d bytes (max %d)", len(dashboardJSON), cfg.MaxSize,
+		))
+	}
+
+	var dashboard struct {
+		Panels []panel `json:"panels"`
+		Rows   []struct {
+			Panels []panel `json:"panels"`
+		} `json:"rows"`
+	}
+
+	if err := json.Unmarshal(dashboardJSON, &dashboard); err != nil {
+		return nil, err
+	}
+
+	// Keep track of the panels' paths, so the violations can point to them.
+	panels := make([]panel, 0, len(dashboard.Panels))
+	paths := make([]string, 0, len(dashboard.Panels))
+	for i, p := range dashboard.Panels {
+		panels = append(panels, p)
+		paths = append(paths, fmt.Sprintf("panels[%d]", i))
+	}
+	for i, row := range dashboard.Rows {
+		for j, p := range row.Panels {
+			panels = append(panels, p)
+			paths = append(paths, fmt.Sprintf("rows[%d].panels[%d]", i, j))
+		}
+	}
+
+	// The panels of collapsed rows are nested in the rows' panels, which
+	// themselves don't count.
+	count := 0
+	for i, p := range panels {
+		nestedPaths := []string{paths[i]}
+		for j := range p.Panels {
+			nestedPaths = append(nestedPaths, fmt.Sprintf("%s.panels[%d]", paths[i], j))
+		}
+
+		for j, nested := range append([]panel{p}, p.Panels...) {
+			if nested.Type == "row" {
+				continue
+			}
+
+			count++
+
+			if cfg.MaxQueriesPerPanel > 0 && len(nested.Targets) > cfg.MaxQueriesPerPanel {
+				violations = append(violations, jsonpath.At(
+					dashboardJSON, nestedPaths[j]+".targets", fmt.Errorf(
+						"panel %s has %d queries (max %d)",
+						nested.name(), len(nested.Targets), cfg.MaxQueriesPerPanel,
+					),
+				).Error())
+			}
+		}
+	}
+
+	if cfg.MaxPanels > 0 && count > cfg.MaxPanels {
+		violations = append(violations, fmt.Sprintf(
+			"%d panels (max %d)", count, cfg.MaxPanels,
+		))
+	}
+
+	return violations, nil
+}
+
+// name returns a human-readable name for the panel.
+func (p panel) name() string {
+	if len(p.Title) > 0 {
+		return fmt.Sprintf("%q", p.Title)
+	}
+
+	return fmt.Sprintf("#%d", p.ID)
+}
@@ -0,0 +1,47 @@
+// Package buildinfo describes the build of the manager's binaries. Its
+// variables are set at build time by the Makefile, using the linker's -X flag,
+// e.g. go build -ldflags "-X github.com/babolivier/grafana-dashboards-manager/src/buildinfo.Version=1.2.0".
+package buildinfo
+
+import (
+	"fmt"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// Version is the semantic version of the manager.
+	Version = "0.0.0-dev"
+	// Commit is the hash of the Git commit the binaries were built from.
+	Commit = "unknown"
+	// BuildDate is the date the binaries were built at, in the RFC 3339
+	// format.
+	BuildDate = "unknown"
+)
+
+// String returns a human-readable description of the build of the binary with
+// the given name, including the range of Grafana versions the manager
+// supports.
+func String(binary string) string {
+	oldest, newest := grafana.SupportedVersions()
+	return fmt.Sprintf(
+		"%s %s (commit %s, built %s, supports Grafana %s to %s)",
+		binary, Version, Commit, BuildDate, oldest, newest,
+	)
+}
+
+// LogStartup logs the build information of the binary with the given name, so
+// the version running can be found in the logs.
+func LogStartup(binary string) {
+	oldest, newest := grafana.SupportedVersions()
+	logrus.WithFields(logrus.Fields{
+		"binary":         binary,
+		"version":        Version,
+		"commit":         Commit,
+		"build_date":     BuildDate,
+		"grafana_oldest": oldest,
+		"grafana_newest": newest,
+	}).Info("Starting")
+}
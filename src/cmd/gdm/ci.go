@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/budget"
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/datasources"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/jsonpath"
+	"github.com/babolivier/grafana-dashboards-manager/src/migrate"
+	"github.com/babolivier/grafana-dashboards-manager/src/plan"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+	"github.com/babolivier/grafana-dashboards-manager/src/tags"
+	"github.com/babolivier/grafana-dashboards-manager/src/templating"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Exit codes of the ci subcommands, on top of 0 (success) and 1 (error).
+const (
+	// exitChanges is returned by "ci plan" when the plan isn't empty, if the
+	// --detailed-exitcode flag is set.
+	exitChanges exitCode = 2
+)
+
+// runCI runs one of the subcommands of the ci command group, which mirror
+// Terraform's workflow: "validate" checks the dashboards in the repository,
+// "plan" computes the changes needed for Grafana to match the repository and
+// writes them to a plan file, and "apply" applies the changes from a plan file.
+// Returns an error if the subcommand is unknown or failed.
+func runCI(ctx context.Context, cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("Missing ci subcommand (validate, plan or apply)")
+	}
+
+	switch args[0] {
+	case "validate":
+		return runCIValidate(ctx, cfg, args[1:])
+	case "plan":
+		return runCIPlan(ctx, cfg, args[1:])
+	case "apply":
+		return runCIApply(ctx, cfg, args[1:])
+	default:
+		return fmt.Errorf("Unknown ci subcommand: %s", args[0])
+	}
+}
+
+// runCIValidate checks that all the dashboards in the repository have a valid
+// JSON description with a title, that no two dashboards share the same slug,
+// and that they respect the budgets if any. Prints each problem found, along
+// with the path and position of the part of the file it's about, if any.
+// Returns an error if there was an issue reading the dashboards, or if at
+// least one problem was found.
+func runCIValidate(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("ci validate", flag.ExitOnError)
+	flags.Parse(args)
+
+	contents, err := readRepoDashboards(cfg)
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	slugs := make(map[string]string)
+	for filename, content := range contents {
+		var dashboard struct {
+			Title string `json:"title"`
+		}
+
+		if err = json.Unmarshal(content, &dashboard); err != nil {
+			fmt.Printf("%s: invalid JSON: %v\n", filename, jsonpath.Annotate(content, err))
+			problems++
+			continue
+		}
+
+		if len(dashboard.Title) == 0 {
+			fmt.Printf("%s: missing title\n", filename)
+			problems++
+			continue
+		}
+
+		slug, _ := helpers.GetDashboardSlug(content)
+		if other, ok := slugs[slug]; ok {
+			fmt.Printf("%s: same slug (%s) as %s\n", filename, slug, other)
+			problems++
+		}
+		slugs[slug] = filename
+
+		if cfg.Budgets != nil {
+			violations, _ := budget.Check(content, cfg.Budgets)
+			for _, violation := range violations {
+				fmt.Printf("%s: exceeded budget: %s\n", filename, violation)
+				problems++
+			}
+		}
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"dashboards": len(contents),
+		"problems":   problems,
+	}).Info("Validation done")
+
+	if problems > 0 {
+		return exitCode(1)
+	}
+
+	return nil
+}
+
+// runCIPlan computes the changes needed for Grafana to match the dashboards in
+// the repository, prints a summary of them and writes them to a plan file.
+// Returns an error if there was an issue reading the dashboards, computing the
+// plan or writing it, or exitChanges if the plan isn't empty and the
+// --detailed-exitcode flag is set.
+func runCIPlan(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("ci plan", flag.ExitOnError)
+	out := flags.String("out", "plan.json", "Path to the plan file to write")
+	deleteRemoved := flags.Bool("delete-removed", false, "Plan the deletion of dashboards that aren't in the repository")
+	detailedExitCode := flags.Bool("detailed-exitcode", false, "Exit with code 2 if the plan isn't empty")
+	flags.Parse(args)
+
+	contents, err := readRepoDashboards(cfg)
+	if err != nil {
+		return err
+	}
+
+	client := grafana.NewClientFromConfig(cfg.Grafana.ForPuller())
+	p, err := plan.Compute(ctx, client, contents, *deleteRemoved, cfg.Grafana.IgnorePrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, action := range p.Actions {
+		fmt.Printf("%-7s %s\n", action.Action, action.Slug)
+	}
+	fmt.Printf("Plan: %d change(s)\n", len(p.Actions))
+
+	if err = p.Write(*out); err != nil {
+		return err
+	}
+
+	if *detailedExitCode && len(p.Actions) > 0 {
+		return exitChanges
+	}
+
+	return nil
+}
+
+// runCIApply reads a plan file and applies its changes to Grafana, after having
+// checked that the state of Grafana didn't change since the plan was generated.
+// Returns an error if there was an issue reading or checking the plan, or if at
+// least one of its actions failed.
+func runCIApply(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("ci apply", flag.ExitOnError)
+	in := flags.String("plan", "plan.json", "Path to the plan file to apply")
+	flags.Parse(args)
+
+	p, err := plan.Read(*in)
+	if err != nil {
+		return err
+	}
+
+	// Refuse to apply the plan if Grafana changed since it was generated, as
+	// the plan would overwrite these changes.
+	client := grafana.NewClientFromConfig(cfg.Grafana.ForPusher())
+	if err = p.Check(ctx, client); err != nil {
+		return err
+	}
+
+	if failed := p.Apply(ctx, client); failed > 0 {
+		return fmt.Errorf("%d action(s) out of %d failed", failed, len(p.Actions))
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"actions": len(p.Actions),
+	}).Info("Plan applied")
+
+	return nil
+}
+
+// readRepoDashboards reads the dashboards from the clone path (or the sync path
+// in "simple sync" mode), and prepares them to be pushed (see
+// prepareDashboards).
+// Returns an error if there was an issue reading, filtering or rendering the
+// files.
+func readRepoDashboards(cfg *config.Config) (map[string][]byte, error) {
+	contents, err := plan.ReadDashboardFiles(syncPath(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	return prepareDashboards(contents, cfg)
+}
+
+// prepareDashboards takes a map mapping files' names to their contents, filters
+// out the files the manager must ignore (along with the alert notification
+// channels' files, the files describing permissions and the folders' metadata
+// files), migrates the dashboards if requested, enforces the managed
+// dashboards' tags if any, renders them with the main
+// Grafana instance's variables if templating is enabled, and remaps their data
+// sources to the main instance's if requested.
+// Returns an error if there was an issue filtering or rendering the files.
+func prepareDashboards(contents map[string][]byte, cfg *config.Config) (map[string][]byte, error) {
+	if err := common.FilterIgnored(&contents, cfg); err != nil {
+		return nil, err
+	}
+
+	for filename := range contents {
+		if cfg.IsAlertNotificationFile(filename) || common.HoldsPermissions(filename, cfg) ||
+			common.IsFolderFile(filename, cfg) {
+			delete(contents, filename)
+		}
+	}
+
+	if cfg.Pusher != nil && len(cfg.Pusher.Migrations) > 0 {
+		contents = migrate.MigrateAll(contents, cfg.Pusher.Migrations)
+	}
+
+	if cfg.Tags != nil {
+		contents = tags.EnforceAll(contents, cfg.Tags)
+	}
+
+	if cfg.Pusher != nil && cfg.Pusher.Templating != nil {
+		var err error
+		if contents, err = templating.RenderAll(
+			contents, cfg.Pusher.Templating.Variables, cfg.Pusher.Templating,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Pusher != nil && len(cfg.Pusher.DatasourceMapping) > 0 {
+		contents = datasources.RemapAll(contents, cfg.Pusher.DatasourceMapping)
+	}
+
+	return contents, nil
+}
+
+// syncPath returns the path the dashboards are read from: the clone path (or
+// the repository's subdirectory in it, if set), or the sync path in "simple
+// sync" mode.
+func syncPath(cfg *config.Config) string {
+	if cfg.Git != nil {
+		return cfg.Git.SyncPath()
+	}
+
+	return cfg.SimpleSync.SyncPath
+}
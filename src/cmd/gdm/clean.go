@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/puller"
+)
+
+// runClean removes the files from the sync path that don't match any dashboard
+// on Grafana anymore (e.g. left behind by renames), then prints them. In Git
+// mode, the removal is committed and pushed. With the "--dry-run" flag, the
+// files are only printed.
+// Returns an error if there was an issue looking for or removing the files, or
+// committing and pushing their removal.
+func runClean(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("clean", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Only list the orphaned files, without removing them")
+	flags.Parse(args)
+
+	orphans, err := puller.Clean(ctx, grafana.NewClientFromConfig(cfg.Grafana.ForPuller()), cfg, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if len(orphans) == 0 {
+		fmt.Println("No orphaned files.")
+		return nil
+	}
+
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+
+	for _, filename := range orphans {
+		fmt.Printf("%s %s\n", verb, filename)
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/diff"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/semantic"
+)
+
+// runDiff compares the dashboards on Grafana with the ones in the repository
+// (read as the pusher would push them), and prints the semantic differences
+// between them: the dashboards only in the repository ("added"), the ones only
+// on Grafana ("removed", unless their slug starts with the ignore prefix), and,
+// for the others, the settings, panels and queries which differ, from
+// Grafana's version to the repository's. Dashboards are matched by UID, or by
+// slug if they don't have one. The fields stripped by the puller, and the
+// panels' layout if layout changes are ignored, are left out of the comparison.
+// The diff can be restricted to the dashboards with the given slugs or UIDs,
+// and printed as JSON with "--format json".
+// Returns an error if the format is unknown, if there was an issue reading the
+// dashboards from the repository or retrieving them from Grafana, or comparing
+// them, or exitChanges if there are differences and the --detailed-exitcode
+// flag is set.
+func runDiff(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	format := flags.String("format", "text", "Format of the output (text|json)")
+	detailedExitCode := flags.Bool("detailed-exitcode", false, "Exit with code 2 if there are differences")
+	flags.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("Unknown format: %s", *format)
+	}
+
+	only := make(map[string]bool)
+	for _, id := range flags.Args() {
+		only[id] = true
+	}
+
+	contents, err := readRepoDashboards(cfg)
+	if err != nil {
+		return err
+	}
+
+	live, err := liveDashboards(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	diffs := make([]diff.DashboardDiff, 0)
+	matched := make(map[*grafana.Dashboard]bool)
+	for filename, content := range contents {
+		uid, _ := helpers.GetDashboardUID(content)
+		slug, err := helpers.GetDashboardSlug(content)
+		if err != nil {
+			return err
+		}
+
+		if len(only) > 0 && !only[uid] && !only[slug] {
+			continue
+		}
+
+		if content, err = stripDashboard(content, cfg); err != nil {
+			return err
+		}
+
+		dashboard := live["slug:"+slug]
+		if len(uid) > 0 {
+			dashboard = live["uid:"+uid]
+		}
+
+		if dashboard == nil {
+			var db struct {
+				Title string `json:"title"`
+			}
+			if err = json.Unmarshal(content, &db); err != nil {
+				return err
+			}
+
+			diffs = append(diffs, diff.DashboardDiff{
+				Slug:     slug,
+				Title:    db.Title,
+				Filename: filename,
+				Status:   diff.StatusAdded,
+			})
+			continue
+		}
+
+		matched[dashboard] = true
+
+		liveContent, err := stripDashboard(dashboard.RawJSON, cfg)
+		if err != nil {
+			return err
+		}
+
+		d, err := diff.Compare(liveContent, content, cfg.IgnoreLayoutChanges)
+		if err != nil {
+			return err
+		}
+
+		if !d.Empty() {
+			d.Slug = slug
+			d.Filename = filename
+			diffs = append(diffs, d)
+		}
+	}
+
+	for _, dashboard := range live {
+		if matched[dashboard] || (len(only) > 0 && !only[dashboard.UID] && !only[dashboard.Slug]) {
+			continue
+		}
+
+		prefix := cfg.Grafana.IgnorePrefix
+		if len(prefix) > 0 && strings.HasPrefix(dashboard.Slug, prefix) {
+			continue
+		}
+
+		// Dashboards with a UID are indexed twice.
+		matched[dashboard] = true
+
+		diffs = append(diffs, diff.DashboardDiff{
+			Slug:   dashboard.Slug,
+			Title:  dashboard.Name,
+			Status: diff.StatusRemoved,
+		})
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Slug < diffs[j].Slug
+	})
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		if err = encoder.Encode(diffs); err != nil {
+			return err
+		}
+	} else {
+		fmt.Print(diff.Text(diffs))
+	}
+
+	if *detailedExitCode && len(diffs) > 0 {
+		return exitChanges
+	}
+
+	return nil
+}
+
+// liveDashboards retrieves all the dashboards from Grafana, and returns them
+// mapped to both their UID (prefixed with "uid:"), if they have one, and their
+// slug (prefixed with "slug:").
+// Returns an error if there was an issue retrieving the dashboards.
+func liveDashboards(ctx context.Context, cfg *config.Config) (map[string]*grafana.Dashboard, error) {
+	client := grafana.NewClientFromConfig(cfg.Grafana.ForPuller())
+
+	refs, err := client.GetDashboardsRefs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	live := make(map[string]*grafana.Dashboard, 2*len(refs))
+	for _, ref := range refs {
+		dashboard, err := client.GetDashboardByRef(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(dashboard.UID) > 0 {
+			live["uid:"+dashboard.UID] = dashboard
+		}
+		live["slug:"+dashboard.Slug] = dashboard
+	}
+
+	return live, nil
+}
+
+// stripDashboard removes the fields the puller strips from the dashboards'
+// descriptions, if any, from the given description.
+// Returns an error if the description couldn't be parsed or re-encoded.
+func stripDashboard(content []byte, cfg *config.Config) ([]byte, error) {
+	if len(cfg.StripFields) == 0 {
+		return content, nil
+	}
+
+	return semantic.StripFields(content, cfg.StripFields)
+}
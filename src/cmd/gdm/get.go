@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+)
+
+// runGet prints the JSON description of the dashboard with the given slug or
+// UID to the standard output, indented the same way as in the repository, so
+// it can be piped to other tools (e.g. jq). The dashboard is retrieved from
+// Grafana, or, with "--from repo", read from the clone path (or the sync path
+// in "simple sync" mode) as the pusher would push it, i.e. migrated and
+// rendered if the pusher's settings require it. The sync path is never
+// modified.
+// Returns an error if the source is unknown, if no dashboard or several
+// dashboards match, or if there was an issue retrieving or reading the
+// dashboard.
+func runGet(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	from := flags.String("from", "grafana", "Where to read the dashboard from (grafana|repo)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("Expected a single dashboard slug or UID")
+	}
+
+	var content []byte
+	var err error
+	switch *from {
+	case "grafana":
+		content, err = getFromGrafana(ctx, cfg, flags.Arg(0))
+	case "repo":
+		content, err = getFromRepo(cfg, flags.Arg(0))
+	default:
+		return fmt.Errorf("Unknown source: %s", *from)
+	}
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	if err = json.Indent(buf, content, "", "\t"); err != nil {
+		return err
+	}
+	buf.WriteString("\n")
+
+	_, err = buf.WriteTo(os.Stdout)
+	return err
+}
+
+// getFromGrafana retrieves from Grafana the JSON description of the dashboard
+// with the given UID or slug.
+// Returns an error if no dashboard matches, or if there was an issue
+// retrieving the dashboards.
+func getFromGrafana(ctx context.Context, cfg *config.Config, id string) ([]byte, error) {
+	client := grafana.NewClientFromConfig(cfg.Grafana.ForPuller())
+
+	refs, err := client.GetDashboardsRefs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range refs {
+		if ref.UID == id || ref.URI == "db/"+id {
+			dashboard, err := client.GetDashboardByRef(ctx, ref)
+			if err != nil {
+				return nil, err
+			}
+
+			return dashboard.RawJSON, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No dashboard with the UID or slug %s on Grafana", id)
+}
+
+// getFromRepo reads from the repository the JSON description of the dashboard
+// with the given UID or slug, the slug being either the one computed from the
+// dashboard's title or the name of its file.
+// Returns an error if no dashboard or several dashboards match, or if there
+// was an issue reading the dashboards.
+func getFromRepo(cfg *config.Config, id string) ([]byte, error) {
+	contents, err := readRepoDashboards(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]string, 0)
+	for filename, content := range contents {
+		uid, _ := helpers.GetDashboardUID(content)
+		slug, _ := helpers.GetDashboardSlug(content)
+		if uid == id || slug == id || path.Base(filename) == id+".json" {
+			matches = append(matches, filename)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("No dashboard with the UID or slug %s in the repository", id)
+	case 1:
+		return contents[matches[0]], nil
+	default:
+		sort.Strings(matches)
+		return nil, fmt.Errorf(
+			"Several dashboards match %s in the repository: %s",
+			id, strings.Join(matches, ", "),
+		)
+	}
+}
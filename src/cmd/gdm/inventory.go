@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+)
+
+// inventoryEntry describes a dashboard managed by the manager, as listed by the
+// inventory command. Version and Updated are the dashboard's version and the
+// time of its last update on Grafana, and are left empty if it isn't on
+// Grafana. Owners are the owners of the dashboard's file, if ownership is
+// configured.
+type inventoryEntry struct {
+	UID      string     `json:"uid"`
+	Title    string     `json:"title"`
+	Folder   string     `json:"folder"`
+	Tags     []string   `json:"tags"`
+	Version  int        `json:"version,omitempty"`
+	Updated  *time.Time `json:"updated,omitempty"`
+	Owners   []string   `json:"owners"`
+	Filename string     `json:"file"`
+}
+
+// runInventory lists the dashboards managed by the manager, i.e. the ones from
+// the repository (read as the pusher would push them), along with their UID,
+// title, folder, tags, version and last update on Grafana, and owners, as CSV
+// (the default) or JSON, for asset registers and audits. The folder is the
+// dashboard's folder on Grafana, or the one it would be pushed to if it isn't
+// on Grafana. The owners are the ones of the deepest owned directory
+// containing the dashboard's file (see common.LoadOwners).
+// Returns an error if the format is unknown, or if there was an issue reading
+// the dashboards or the owners from the repository, retrieving the dashboards
+// from Grafana, or writing the listing.
+func runInventory(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("inventory", flag.ExitOnError)
+	format := flags.String("format", "csv", "Format of the output (csv|json)")
+	flags.Parse(args)
+
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("Unknown format: %s", *format)
+	}
+
+	contents, err := readRepoDashboards(cfg)
+	if err != nil {
+		return err
+	}
+
+	owners, err := common.LoadOwners(cfg)
+	if err != nil {
+		return err
+	}
+
+	live, err := liveDashboards(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]inventoryEntry, 0, len(contents))
+	for filename, content := range contents {
+		var db struct {
+			Title string   `json:"title"`
+			Tags  []string `json:"tags"`
+		}
+		if err = json.Unmarshal(content, &db); err != nil {
+			return err
+		}
+
+		uid, _ := helpers.GetDashboardUID(content)
+		slug, err := helpers.GetDashboardSlug(content)
+		if err != nil {
+			return err
+		}
+
+		entry := inventoryEntry{
+			UID:      uid,
+			Title:    db.Title,
+			Tags:     db.Tags,
+			Filename: filename,
+		}
+		if entry.Tags == nil {
+			entry.Tags = []string{}
+		}
+		if entry.Owners, _ = common.FileOwners(filename, owners); entry.Owners == nil {
+			entry.Owners = []string{}
+		}
+
+		dashboard := live["slug:"+slug]
+		if len(uid) > 0 {
+			dashboard = live["uid:"+uid]
+		}
+
+		if dashboard != nil {
+			entry.Folder = dashboard.FolderTitle
+			entry.Version = dashboard.Version
+			if !dashboard.Updated.IsZero() {
+				entry.Updated = &dashboard.Updated
+			}
+		} else if cfg.Pusher != nil {
+			entry.Folder = common.TargetFolder(filename, "", cfg)
+		}
+
+		// Dashboards which aren't in a folder are in the "General" one.
+		if len(entry.Folder) == 0 {
+			entry.Folder = "General"
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Filename < entries[j].Filename
+	})
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		return encoder.Encode(entries)
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"uid", "title", "folder", "tags", "version", "updated", "owners", "file"})
+	for _, entry := range entries {
+		var version, updated string
+		if entry.Version > 0 {
+			version = strconv.Itoa(entry.Version)
+		}
+		if entry.Updated != nil {
+			updated = entry.Updated.UTC().Format(time.RFC3339)
+		}
+
+		w.Write([]string{
+			entry.UID,
+			entry.Title,
+			entry.Folder,
+			strings.Join(entry.Tags, ";"),
+			version,
+			updated,
+			strings.Join(entry.Owners, ";"),
+			entry.Filename,
+		})
+	}
+
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/puller"
+)
+
+// runMigrateLayout moves the files of the dashboards laid out with the "flat"
+// layout to the directories of their folders, as expected by the "folders"
+// layout, then prints the moves. In Git mode, the moves are committed and
+// pushed. With the "--dry-run" flag, the moves are only printed.
+// Returns an error if the configuration doesn't use the "folders" layout, or if
+// there was an issue moving the files, or committing and pushing the moves.
+func runMigrateLayout(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("migrate-layout", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Only list the files to move, without moving them")
+	flags.Parse(args)
+
+	moves, err := puller.MigrateLayout(ctx, grafana.NewClientFromConfig(cfg.Grafana.ForPuller()), cfg, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if len(moves) == 0 {
+		fmt.Println("No file to move.")
+		return nil
+	}
+
+	verb := "Moved"
+	if *dryRun {
+		verb = "Would move"
+	}
+
+	for _, move := range moves {
+		fmt.Printf("%s %s to %s\n", verb, move.From, move.To)
+	}
+
+	return nil
+}
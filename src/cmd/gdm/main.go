@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/buildinfo"
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+// command describes a subcommand of the manager's command-line tool. If
+// standalone is true, the subcommand doesn't need the configuration, which
+// isn't loaded, and is run with a nil one. If daemon is true, the subcommand
+// keeps running and starts its own sync runs, so it isn't run as a single one.
+type command struct {
+	description string
+	run         func(ctx context.Context, cfg *config.Config, args []string) error
+	standalone  bool
+	daemon      bool
+}
+
+// commands maps the name of each subcommand to its description and the
+// function running it.
+var commands = map[string]command{
+	"ci": {
+		description: "Validate dashboards, plan changes to Grafana or apply a plan (validate|plan|apply)",
+		run:         runCI,
+	},
+	"clean": {
+		description: "Remove the files which don't match any dashboard on Grafana anymore",
+		run:         runClean,
+	},
+	"diff": {
+		description: "Print the semantic differences between the dashboards on Grafana and the ones in the repository",
+		run:         runDiff,
+	},
+	"get": {
+		description: "Print the JSON description of a dashboard, from Grafana or from the repository",
+		run:         runGet,
+	},
+	"inventory": {
+		description: "List the managed dashboards with their folder, tags, version, last update and owners, as CSV or JSON",
+		run:         runInventory,
+	},
+	"migrate-layout": {
+		description: "Move the dashboards' files from the flat layout to the directories of their folders",
+		run:         runMigrateLayout,
+	},
+	"push": {
+		description: "Push a single dashboard to Grafana, from a file or from the standard input (-)",
+		run:         runPush,
+	},
+	"restore": {
+		description: "Restore the folders and dashboards from the repository on Grafana",
+		run:         runRestore,
+	},
+	"serve": {
+		description: "Run the puller at a regular interval and/or the pusher in a single process (--pull|--push)",
+		run:         runServe,
+		daemon:      true,
+	},
+	"selftest": {
+		description: "Check the manager works with Grafana by creating, updating and deleting a scratch dashboard",
+		run:         runSelftest,
+	},
+	"simulate": {
+		description: "Generate synthetic dashboards on Grafana or in a directory and measure the throughput of pushes and pulls",
+		run:         runSimulate,
+	},
+	"version": {
+		description: "Print the version and build information",
+		run:         runVersion,
+	},
+	"versions-merge": {
+		description: "Merge two versions of the versions file, as a Git merge driver (base current other)",
+		run:         runVersionsMerge,
+		standalone:  true,
+	},
+	"status": {
+		description: "Print the drift state of each dashboard between the repository and Grafana, without changing anything",
+		run:         runStatus,
+	},
+	"stale": {
+		description: "List the dashboards which have been viewed the least recently, if Grafana provides usage insights",
+		run:         runStale,
+	},
+	"report": {
+		description: "Generate a Markdown report of the dashboard changes between two commits",
+		run:         runReport,
+	},
+}
+
+func main() {
+	configFile := flag.String("config", "config.yaml", "Path to the configuration file")
+	errorPolicy := flag.String("error-policy", "", "How to handle errors on individual dashboards (continue|fail-fast), overriding the configuration file")
+	showVersion := flag.Bool("version", false, "Print the version and build information, then exit")
+	flag.Usage = usage
+	flag.Parse()
+
+	ctx := context.Background()
+
+	// The version doesn't depend on the configuration, so it's printed
+	// without loading it.
+	if *showVersion || flag.Arg(0) == "version" {
+		runVersion(ctx, nil, nil)
+		return
+	}
+
+	// Load the logger's configuration.
+	logger.LogConfig()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if cmd.standalone {
+		if err := cmd.run(ctx, nil, flag.Args()[1:]); err != nil {
+			logrus.Error(err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	// Load the configuration.
+	cfg, err := config.Load(*configFile)
+	if err == nil {
+		err = cfg.SetErrorPolicy(*errorPolicy)
+	}
+	if err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+
+	// Make sure the secrets from the configuration don't leak into the logs.
+	logger.Redact(cfg.Secrets())
+
+	// Send the logs to the outputs from the configuration, if any.
+	if err = logger.ConfigureOutputs(cfg.Logging); err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+
+	buildinfo.LogStartup("gdm")
+
+	// Tag the logs of the command with a run ID, unless it starts its own runs.
+	if !cmd.daemon {
+		ctx = logger.StartRun(ctx)
+	}
+
+	err = cmd.run(ctx, cfg, flag.Args()[1:])
+
+	// Exit with a non-zero code if the command failed, so that CI pipelines can
+	// rely on it.
+	if err != nil {
+		if code, ok := err.(exitCode); ok {
+			os.Exit(int(code))
+		}
+
+		logrus.WithContext(ctx).Error(err)
+		os.Exit(1)
+	}
+}
+
+// runVersion prints the version and build information of the tool.
+func runVersion(ctx context.Context, cfg *config.Config, args []string) error {
+	fmt.Println(buildinfo.String("gdm"))
+	return nil
+}
+
+// exitCode is an error carrying the code the tool must exit with. It is
+// returned by commands which exit code carries information (e.g. whether a plan
+// contains changes) without anything to log.
+type exitCode int
+
+// Error implements error.Error().
+func (e exitCode) Error() string {
+	return fmt.Sprintf("exit code %d", int(e))
+}
+
+// usage prints the tool's usage, including the list of available subcommands.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] <command> [command flags]\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].description)
+	}
+}
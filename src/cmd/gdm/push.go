@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+	"github.com/babolivier/grafana-dashboards-manager/src/textfile"
+)
+
+// stdinFilename is the name given to the dashboard read from the standard
+// input, which must look like a dashboard's file to go through the same
+// filters as the files from the repository.
+const stdinFilename = "stdin.json"
+
+// runPush pushes a single dashboard to Grafana, read from the given file or,
+// if the file is "-", from the standard input. The dashboard goes through the
+// same pipeline as the ones the pusher pushes from the repository: it's
+// filtered out if ignored, migrated and rendered if the pusher's settings
+// require it, checked against the budgets and Grafana's version, then pushed
+// and verified if requested. It's pushed to the folder with the title given
+// with "--folder", or else to master's folder. The version of the pushed
+// dashboard is then printed.
+// Returns an error if there was an issue reading the dashboard, if it's
+// ignored, or if it couldn't be pushed or failed verification.
+func runPush(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("push", flag.ExitOnError)
+	folder := flags.String("folder", "", "Title of the Grafana folder to push the dashboard to (defaults to master's folder)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("Expected a single file to push, or - to read the dashboard from the standard input")
+	}
+
+	filename := stdinFilename
+	var content []byte
+	var err error
+	if flags.Arg(0) == "-" {
+		if content, err = ioutil.ReadAll(os.Stdin); err == nil {
+			content = textfile.Normalise(content)
+		}
+	} else {
+		filename = path.Base(flags.Arg(0))
+		content, err = textfile.Read(flags.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+
+	contents, err := prepareDashboards(map[string][]byte{filename: content}, cfg)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := contents[filename]; !ok {
+		return errors.New("The dashboard is ignored by the manager, not pushing it")
+	}
+
+	if len(*folder) == 0 && cfg.Pusher != nil {
+		*folder = cfg.Pusher.Branches["master"]
+	}
+
+	client := grafana.NewClientFromConfig(cfg.Grafana.ForPusher())
+	folderID, err := client.GetFolderID(ctx, *folder)
+	if err != nil {
+		return err
+	}
+
+	versions, err := common.RecordedVersions(cfg)
+	if err != nil {
+		return err
+	}
+
+	report := common.PushFiles(ctx, []string{filename}, contents, folderID, client, versions, cfg)
+	for _, errs := range []map[string]error{report.Rejected, report.Failed, report.Unhealthy} {
+		if err, ok := errs[filename]; ok {
+			return err
+		}
+	}
+
+	// The versions are mapped to the dashboards' UIDs (see grafana.VersionKey),
+	// so the version is printed along with the dashboard's slug.
+	slug, _ := helpers.GetDashboardSlug(contents[filename])
+	for _, version := range report.Versions {
+		fmt.Printf("%s: version %d\n", slug, version)
+	}
+
+	return nil
+}
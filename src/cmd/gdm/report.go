@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/forge"
+	"github.com/babolivier/grafana-dashboards-manager/src/git"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+	"github.com/babolivier/grafana-dashboards-manager/src/report"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runReport generates a Markdown report of the dashboard changes between two
+// commits, prints it, and posts it on a merge request if requested. If
+// requested, and if Grafana provides usage insights, the report includes the
+// number of views of the changed dashboards.
+// Returns an error if the Git settings are missing, if there was an issue
+// loading the repository or the commits, computing the changes, retrieving the
+// dashboards' usage, or posting the report.
+func runReport(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	from := flags.String("from", "", "Hash of the commit to compare from (required)")
+	to := flags.String("to", "", "Hash of the commit to compare to (defaults to the latest commit)")
+	mr := flags.Int("merge-request", 0, "Number of the merge request (or pull request) to post the report on")
+	usage := flags.Bool("usage", false, "Include the number of views of the dashboards, if Grafana provides usage insights")
+	flags.Parse(args)
+
+	if len(*from) == 0 {
+		return errors.New("The --from flag is required")
+	}
+
+	if *mr > 0 && cfg.Forge == nil {
+		return errors.New("Posting the report requires the forge settings")
+	}
+
+	changes, err := computeChanges(ctx, cfg, *from, *to, *usage)
+	if err != nil {
+		return err
+	}
+
+	markdown := report.Markdown(changes)
+	fmt.Print(markdown)
+
+	if *mr > 0 {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"merge_request": *mr,
+		}).Info("Posting the report on the merge request")
+
+		return forge.NewClient(cfg.Forge).CommentOnMergeRequest(*mr, markdown)
+	}
+
+	return nil
+}
+
+// computeChanges loads the Git repository and computes the changes made to the
+// dashboards between the two given commits. If no hash is provided for the
+// most recent commit, the latest commit of the repository is used. If
+// withUsage is true, the changes include the dashboards' usage, unless Grafana
+// doesn't provide usage insights.
+// Returns an error if the Git settings are missing, or if there was an issue
+// loading the repository, the commits or the files' contents, computing the
+// changes, or retrieving the dashboards' usage.
+func computeChanges(
+	ctx context.Context, cfg *config.Config, fromHash string, toHash string,
+	withUsage bool,
+) ([]report.DashboardChange, error) {
+	if cfg.Git == nil {
+		return nil, errors.New("The Git settings are required")
+	}
+
+	repo, _, err := git.NewRepository(cfg.Git)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = repo.Sync(false); err != nil {
+		return nil, err
+	}
+
+	fromCommit, err := repo.GetCommit(fromHash)
+	if err != nil {
+		return nil, err
+	}
+
+	toCommit, err := repo.GetLatestCommit()
+	if len(toHash) > 0 {
+		toCommit, err = repo.GetCommit(toHash)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	oldContents, err := repo.GetFilesContentsAtCommit(fromCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	newContents, err := repo.GetFilesContentsAtCommit(toCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	modified, removed, err := repo.GetModifiedAndRemovedFiles(fromCommit, toCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only keep the files describing dashboards the manager doesn't ignore
+	// (and not e.g. alert notification channels).
+	merged := make(map[string][]byte)
+	for _, filename := range modified {
+		merged[filename] = newContents[filename]
+	}
+	for _, filename := range removed {
+		merged[filename] = oldContents[filename]
+	}
+
+	if err = common.FilterIgnored(&merged, cfg); err != nil {
+		return nil, err
+	}
+
+	for filename := range merged {
+		if cfg.IsAlertNotificationFile(filename) || common.HoldsPermissions(filename, cfg) ||
+			common.IsFolderFile(filename, cfg) {
+			delete(merged, filename)
+		}
+	}
+
+	var screenshotsPath string
+	if cfg.Screenshots != nil {
+		screenshotsPath = cfg.Screenshots.Path
+	}
+
+	changes, err := report.Compute(
+		filterNames(modified, merged), filterNames(removed, merged),
+		oldContents, newContents, screenshotsPath, cfg.IgnoreLayoutChanges,
+	)
+	if err != nil || !withUsage {
+		return changes, err
+	}
+
+	usage, err := grafana.NewClientFromConfig(cfg.Grafana.ForPuller()).GetDashboardsUsage(ctx)
+	if err == grafana.ErrUsageUnavailable {
+		logrus.WithContext(ctx).Warn("Grafana doesn't provide usage insights, leaving the dashboards' views out of the report")
+		return changes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = report.AddUsage(changes, usage, oldContents, newContents)
+	return changes, err
+}
+
+// filterNames returns the names from the given slice that are keys of the
+// given map, without duplicates.
+func filterNames(names []string, contents map[string][]byte) []string {
+	filtered := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if _, ok := contents[name]; ok && !seen[name] {
+			filtered = append(filtered, name)
+			seen[name] = true
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"path"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/plan"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+	"github.com/babolivier/grafana-dashboards-manager/src/restore"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runRestore restores the resources described by the files of the repository
+// on Grafana, one kind of resource after the other so that each resource can
+// rely on the ones it depends on (see restore.Apply): the folders described by
+// the folders' metadata files, parents first, along with their permissions,
+// then the legacy alert notification channels and the library panels if
+// they're synced, then all the dashboards from the repository, then the
+// dashboards' permissions if they're synced, then the playlists if they're
+// synced. Dashboards outside of any directory with a metadata file are pushed
+// to the folder the pusher would push them to. Running it again on the same
+// instance updates the existing resources rather than duplicating them. With
+// the "fail-fast" error policy, the restore stops after the first kind of
+// resource that failed to be applied.
+// With "--uid", only the dashboard with the given UID is restored, to its state
+// at the Git commit given with "--commit" or at the Grafana version given with
+// "--version" (see restoreDashboard).
+// Returns an error if there was an issue reading the repository, or if at
+// least one resource failed to be applied.
+func runRestore(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	uid := flags.String("uid", "", "UID of a single dashboard to restore to a previous state")
+	commit := flags.String("commit", "", "Hash of the Git commit to restore the dashboard from, with --uid")
+	version := flags.Int("version", 0, "Number of the Grafana version to restore the dashboard to, with --uid")
+	flags.Parse(args)
+
+	if len(*uid) > 0 {
+		return restoreDashboard(ctx, *uid, *commit, *version, cfg)
+	}
+
+	if len(*commit) > 0 || *version != 0 {
+		return errors.New("--commit and --version require a dashboard UID given with --uid")
+	}
+
+	client := grafana.NewClientFromConfig(cfg.Grafana.ForPusher())
+
+	files, err := plan.ReadDashboardFiles(syncPath(cfg))
+	if err != nil {
+		return err
+	}
+
+	folderFiles := make(map[string][]byte)
+	channelFiles := make([]string, 0)
+	libraryPanelFiles := make([]string, 0)
+	permissionsFiles := make([]string, 0)
+	playlistFiles := make([]string, 0)
+	for filename, content := range files {
+		if path.Base(filename) == cfg.Metadata.FolderFile {
+			folderFiles[filename] = content
+		}
+
+		if cfg.IsAlertNotificationFile(filename) {
+			channelFiles = append(channelFiles, filename)
+		}
+
+		if cfg.IsLibraryPanelFile(filename) {
+			libraryPanelFiles = append(libraryPanelFiles, filename)
+		}
+
+		if cfg.SyncPermissions && cfg.IsPermissionsFile(filename) {
+			permissionsFiles = append(permissionsFiles, filename)
+		}
+
+		if cfg.IsPlaylistFile(filename) {
+			playlistFiles = append(playlistFiles, filename)
+		}
+	}
+
+	folders, err := restore.ReadFolders(folderFiles)
+	if err != nil {
+		return err
+	}
+
+	contents, err := readRepoDashboards(cfg)
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[string]int)
+	stages := []restore.Stage{
+		{
+			Kind:     restore.KindFolders,
+			Total:    len(folders),
+			Required: true,
+			Apply: func(ctx context.Context) map[string]error {
+				failed := make(map[string]error)
+				for _, folder := range folders {
+					restored, err := restore.RestoreFolders(ctx, client, []restore.Folder{folder})
+					if err != nil {
+						failed[path.Join(folder.Dir, cfg.Metadata.FolderFile)] = err
+						continue
+					}
+
+					ids[folder.Dir] = restored[folder.Dir]
+				}
+
+				return failed
+			},
+		},
+		{
+			Kind:  restore.KindAlertNotifications,
+			Total: len(channelFiles),
+			Apply: func(ctx context.Context) map[string]error {
+				return common.PushAlertNotifications(ctx, channelFiles, files, client)
+			},
+		},
+		{
+			Kind:  restore.KindLibraryPanels,
+			Total: len(libraryPanelFiles),
+			Apply: func(ctx context.Context) map[string]error {
+				return common.PushLibraryPanels(ctx, libraryPanelFiles, files, client)
+			},
+		},
+		{
+			Kind:  restore.KindDashboards,
+			Total: len(contents),
+			Apply: func(ctx context.Context) map[string]error {
+				return restoreDashboards(ctx, contents, ids, client, cfg)
+			},
+		},
+		{
+			// The folders' permissions were restored along with the folders.
+			Kind:  restore.KindPermissions,
+			Total: len(permissionsFiles),
+			Apply: func(ctx context.Context) map[string]error {
+				return common.PushPermissions(ctx, permissionsFiles, files, client, cfg)
+			},
+		},
+		{
+			Kind:  restore.KindPlaylists,
+			Total: len(playlistFiles),
+			Apply: func(ctx context.Context) map[string]error {
+				return common.PushPlaylists(ctx, playlistFiles, files, client)
+			},
+		},
+	}
+
+	reports := restore.Apply(ctx, stages, cfg.FailFast())
+
+	fields := logrus.Fields{}
+	for _, report := range reports {
+		fields[strings.Replace(report.Kind, " ", "_", -1)] = report.Applied
+	}
+	logrus.WithContext(ctx).WithFields(fields).Info("Restore done")
+
+	return restore.Summary(reports)
+}
+
+// restoreDashboards pushes the given dashboards, mapped to the names of their
+// files, to the folders mapped to the deepest directories containing them,
+// from the given IDs of the restored folders mapped to directories, or else to
+// the folder the pusher would push them to. Each folder's dashboards are pushed
+// at once. With the "fail-fast" error policy, the dashboards of the remaining
+// folders aren't pushed after a failure.
+// Returns the errors encountered, mapped to the files' names. The dashboards
+// which weren't pushed are counted as failed.
+func restoreDashboards(
+	ctx context.Context, contents map[string][]byte, ids map[string]int,
+	client *grafana.Client, cfg *config.Config,
+) map[string]error {
+	failed := make(map[string]error)
+
+	// Group the dashboards by folder, so each folder's dashboards are pushed
+	// at once.
+	byFolder := make(map[int][]string)
+	for filename := range contents {
+		folderID, ok := restore.FolderID(filename, ids)
+		if !ok {
+			var defaultFolder string
+			if cfg.Pusher != nil {
+				defaultFolder = common.TargetFolder(filename, cfg.Pusher.Branches["master"], cfg)
+			}
+
+			var err error
+			if folderID, err = client.GetFolderID(ctx, defaultFolder); err != nil {
+				failed[filename] = err
+				continue
+			}
+		}
+
+		byFolder[folderID] = append(byFolder[folderID], filename)
+	}
+
+	for folderID, filenames := range byFolder {
+		if len(failed) > 0 && cfg.FailFast() {
+			for _, filename := range filenames {
+				failed[filename] = errors.New("not pushed because of a previous failure")
+			}
+
+			continue
+		}
+
+		report := common.PushFiles(ctx, filenames, contents, folderID, client, nil, cfg)
+		for _, errs := range []map[string]error{report.Failed, report.Rejected} {
+			for filename, err := range errs {
+				failed[filename] = err
+			}
+		}
+		for _, filename := range report.Skipped {
+			failed[filename] = errors.New("not pushed because of a previous failure")
+		}
+	}
+
+	return failed
+}
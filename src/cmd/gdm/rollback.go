@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/git"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+)
+
+// restoreDashboard pushes back to Grafana the state of the dashboard with the
+// given UID at either the given Git commit or the given Grafana version (only
+// one of them must be set), so a dashboard can be rolled back to a point in
+// time in a single step. A dashboard restored from a commit goes through the
+// same pipeline as the ones the pusher pushes (migrations, templating, budgets,
+// etc.), and is pushed to the folder the pusher would push its file to. A
+// dashboard restored from a Grafana version is pushed as Grafana stored it, to
+// its current folder. The new version of the dashboard is then printed. The
+// repository isn't modified, so the puller commits the restored state on its
+// next run like any other change made on Grafana.
+// Returns an error if both or none of the commit and the version are set, if
+// the dashboard couldn't be found at the given commit or version, or if it
+// couldn't be pushed.
+func restoreDashboard(
+	ctx context.Context, uid string, commit string, version int,
+	cfg *config.Config,
+) error {
+	if (len(commit) > 0) == (version != 0) {
+		return errors.New("Either a Git commit (--commit) or a Grafana version (--version) to restore the dashboard from must be given")
+	}
+
+	client := grafana.NewClientFromConfig(cfg.Grafana.ForPusher())
+
+	var filename, folder string
+	var contents map[string][]byte
+	var err error
+	if len(commit) > 0 {
+		filename, contents, err = dashboardAtCommit(uid, commit, cfg)
+		if err != nil {
+			return err
+		}
+
+		if cfg.Pusher != nil {
+			folder = common.TargetFolder(filename, cfg.Pusher.Branches["master"], cfg)
+		}
+	} else {
+		current, err := client.GetDashboardByUID(ctx, uid)
+		if err != nil {
+			return err
+		}
+
+		revision, err := client.GetDashboardRevision(ctx, uid, version)
+		if err != nil {
+			return err
+		}
+
+		filename = uid + ".json"
+		contents = map[string][]byte{filename: revision.Data}
+		folder = current.FolderTitle
+	}
+
+	folderID, err := client.GetFolderID(ctx, folder)
+	if err != nil {
+		return err
+	}
+
+	report := common.PushFiles(ctx, []string{filename}, contents, folderID, client, nil, cfg)
+	for _, errs := range []map[string]error{report.Rejected, report.Failed, report.Unhealthy} {
+		if err, ok := errs[filename]; ok {
+			return err
+		}
+	}
+
+	// The versions are mapped to the dashboards' UIDs (see grafana.VersionKey),
+	// so the version is printed along with the dashboard's slug.
+	slug, _ := helpers.GetDashboardSlug(contents[filename])
+	for _, version := range report.Versions {
+		fmt.Printf("%s: version %d\n", slug, version)
+	}
+
+	return nil
+}
+
+// dashboardAtCommit looks for the file describing the dashboard with the given
+// UID in the repository at the given commit, and prepares it to be pushed (see
+// prepareDashboards). Returns the file's name, and a map mapping it to the
+// prepared content.
+// Returns an error if the repository couldn't be loaded or synchronised, if
+// the commit doesn't exist, or if the dashboard isn't in the repository at this
+// commit (or is ignored by the manager).
+func dashboardAtCommit(
+	uid string, hash string, cfg *config.Config,
+) (string, map[string][]byte, error) {
+	if cfg.Git == nil {
+		return "", nil, errors.New("Restoring a dashboard from a Git commit requires the Git settings")
+	}
+
+	repo, _, err := git.NewRepository(cfg.Git)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Synchronise the repository, so recent commits can be found.
+	if err = repo.Sync(false); err != nil {
+		return "", nil, err
+	}
+
+	commit, err := repo.GetCommit(hash)
+	if err != nil {
+		return "", nil, err
+	}
+
+	files, err := repo.GetFilesContentsAtCommit(commit)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Only consider the files the pusher would push, i.e. leave out the
+	// metadata, permissions, snapshots, etc.
+	files, err = prepareDashboards(files, cfg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for filename, content := range files {
+		fileUID, err := helpers.GetDashboardUID(content)
+		if err != nil || fileUID != uid {
+			continue
+		}
+
+		return filename, map[string][]byte{filename: content}, nil
+	}
+
+	return "", nil, fmt.Errorf("No dashboard with the UID %s at commit %s", uid, hash)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+
+	"github.com/sirupsen/logrus"
+)
+
+// selftestFolder is the title of the folder the self-test works in if none is
+// given with "--folder".
+const selftestFolder = "gdm-selftest"
+
+// runSelftest checks that the manager can work with the Grafana instance from
+// the configuration, without touching any existing dashboard: it creates a
+// scratch dashboard in the folder with the title given with "--folder" (which
+// is created if it doesn't exist), checks that Grafana stores it as it was
+// pushed, updates it, then deletes it. Each successful step is printed.
+// Returns an error if a step failed. The scratch dashboard is deleted even if a
+// step failed after its creation.
+func runSelftest(ctx context.Context, cfg *config.Config, args []string) (err error) {
+	flags := flag.NewFlagSet("selftest", flag.ExitOnError)
+	folder := flags.String("folder", selftestFolder, "Title of the scratch Grafana folder to run the self-test in")
+	flags.Parse(args)
+
+	client := grafana.NewClientFromConfig(cfg.Grafana.ForPusher())
+
+	version, err := client.GetVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("Couldn't reach the Grafana API: %v", err)
+	}
+	fmt.Printf("ok: reached Grafana %s\n", version)
+
+	folderID, err := client.GetFolderID(ctx, *folder)
+	if err != nil {
+		return fmt.Errorf("Couldn't get or create the folder %s: %v", *folder, err)
+	}
+	fmt.Printf("ok: using the folder %s\n", *folder)
+
+	uid, err := selftestUID()
+	if err != nil {
+		return err
+	}
+
+	content, err := selftestDashboard(uid, "created")
+	if err != nil {
+		return err
+	}
+
+	created, err := client.CreateOrUpdateDashboardInFolder(ctx, content, folderID)
+	if err != nil {
+		return fmt.Errorf("Couldn't create the scratch dashboard: %v", err)
+	}
+	fmt.Printf("ok: created the scratch dashboard %s (version %d)\n", created.Slug, created.Version)
+
+	// Don't leave the scratch dashboard behind, whatever happens.
+	defer func() {
+		if delErr := client.DeleteDashboardByUID(ctx, uid); delErr != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error": delErr,
+				"uid":   uid,
+			}).Error("Failed to delete the scratch dashboard")
+
+			if err == nil {
+				err = delErr
+			}
+			return
+		}
+
+		if _, getErr := client.GetDashboardByUID(ctx, uid); !grafana.IsNotFound(getErr) {
+			if err == nil {
+				err = fmt.Errorf("The scratch dashboard %s still exists after being deleted", uid)
+			}
+			return
+		}
+
+		if err == nil {
+			fmt.Println("ok: deleted the scratch dashboard")
+		}
+	}()
+
+	if err = checkSelftestRoundTrip(ctx, content, client); err != nil {
+		return
+	}
+	fmt.Println("ok: Grafana stored the scratch dashboard as it was pushed")
+
+	if content, err = selftestDashboard(uid, "updated"); err != nil {
+		return
+	}
+
+	updated, err := client.CreateOrUpdateDashboardInFolder(ctx, content, folderID)
+	if err != nil {
+		return fmt.Errorf("Couldn't update the scratch dashboard: %v", err)
+	}
+
+	if updated.Version <= created.Version {
+		return fmt.Errorf(
+			"Updating the scratch dashboard didn't increase its version (%d, was %d)",
+			updated.Version, created.Version,
+		)
+	}
+
+	if err = checkSelftestRoundTrip(ctx, content, client); err != nil {
+		return
+	}
+	fmt.Printf("ok: updated the scratch dashboard (version %d)\n", updated.Version)
+
+	return nil
+}
+
+// checkSelftestRoundTrip checks that the scratch dashboard described by the
+// given JSON content is stored by Grafana as it was pushed, and that it can be
+// loaded.
+// Returns an error if the dashboard couldn't be retrieved or loaded, or if
+// Grafana rewrote some of its fields.
+func checkSelftestRoundTrip(ctx context.Context, content []byte, client *grafana.Client) error {
+	discrepancies, err := common.CheckRoundTrip(ctx, content, client)
+	if err != nil {
+		return err
+	}
+
+	if len(discrepancies) > 0 {
+		return fmt.Errorf(
+			"Grafana rewrote the scratch dashboard's fields: %s",
+			strings.Join(discrepancies, ", "),
+		)
+	}
+
+	ref, err := grafana.RefFromJSON(content)
+	if err != nil {
+		return err
+	}
+
+	return client.VerifyDashboard(ctx, ref)
+}
+
+// selftestUID generates a random UID for the scratch dashboard, so it can't
+// collide with an existing dashboard (nor with the scratch dashboard of another
+// self-test).
+// Returns an error if the random bytes couldn't be generated.
+func selftestUID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return selftestFolder + "-" + hex.EncodeToString(b), nil
+}
+
+// selftestDashboard generates the JSON description of the scratch dashboard
+// with the given UID, which single text panel shows the given state.
+// Returns an error if the description couldn't be generated.
+func selftestDashboard(uid string, state string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"uid":           uid,
+		"title":         "Manager self-test " + uid,
+		"tags":          []string{selftestFolder},
+		"editable":      true,
+		"schemaVersion": 16,
+		"panels": []interface{}{
+			map[string]interface{}{
+				"id":      1,
+				"type":    "text",
+				"title":   "Self-test",
+				"mode":    "markdown",
+				"content": "This dashboard was " + state + " by the manager's self-test, and will be deleted shortly.",
+				"gridPos": map[string]int{"x": 0, "y": 0, "w": 12, "h": 4},
+			},
+		},
+	})
+}
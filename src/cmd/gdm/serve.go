@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/admin"
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/git"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/logger"
+	"github.com/babolivier/grafana-dashboards-manager/src/puller"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/pause"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/poller"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/webhook"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runServe runs the puller at a regular interval and/or the pusher (with the
+// webhook or the poller, depending on the pusher's settings) in the same
+// process, as a daemon. Since they run in the same process, the pulls and the
+// pusher's operations on the clone are serialised by the clone's lock (see
+// git.LockClone), and the updates of the state file by the state package, so
+// they don't step on each other's toes. Their sync runs can be ongoing at the
+// same time, since each run's logs are tagged from its own context (see
+// logger.StartRun). They also share the Grafana API client, unless the puller and
+// the pusher use distinct credentials. A failed pull is logged, and the puller
+// tries again at the next interval.
+// Returns an error if neither the puller nor the pusher is enabled, if the
+// pusher is enabled without the git and pusher settings, if Grafana isn't
+// healthy, or if the pusher or the admin API stopped because of an error.
+func runServe(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	pull := flags.Bool("pull", false, "Run the puller at a regular interval")
+	push := flags.Bool("push", false, "Run the pusher")
+	pullInterval := flags.Duration("pull-interval", 5*time.Minute, "Interval between two pulls")
+	deleteRemoved := flags.Bool("delete-removed", false, "For each file removed from Git, delete the corresponding dashboard on the Grafana API")
+	allowAlertRemoval := flags.Bool("allow-alert-removal", false, "Push or delete dashboards even if this removes legacy alerts from Grafana, overriding the configuration file")
+	flags.Parse(args)
+
+	if !*pull && !*push {
+		return errors.New("At least one of --pull and --push is required")
+	}
+
+	if *push && (cfg.Git == nil || cfg.Pusher == nil) {
+		return errors.New("The pusher requires both the git and the pusher settings")
+	}
+
+	if *pullInterval <= 0 {
+		return errors.New("The interval between two pulls must be positive")
+	}
+
+	if *allowAlertRemoval && cfg.Pusher != nil {
+		cfg.Pusher.AllowAlertRemoval = true
+	}
+
+	// Initialise the Grafana API client, and make sure Grafana can be synced
+	// with before starting. The puller only needs a client of its own if it
+	// doesn't use the same credentials as the pusher.
+	client := grafana.NewClientFromConfig(cfg.Grafana.ForPusher())
+	pullClient := client
+	if cfg.Grafana.PullerCredentials != nil || cfg.Grafana.PusherCredentials != nil {
+		pullClient = grafana.NewClientFromConfig(cfg.Grafana.ForPuller())
+	}
+
+	if err := client.CheckHealth(ctx); err != nil {
+		return err
+	}
+
+	// Warn about API keys that are about to expire, and keep checking every
+	// day since this runs as a daemon.
+	go func() {
+		for {
+			runCtx := logger.StartRun(ctx)
+			client.CheckAPIKeysExpiry(runCtx, cfg.Grafana.KeyExpiryWarning)
+			if pullClient != client {
+				pullClient.CheckAPIKeysExpiry(runCtx, cfg.Grafana.KeyExpiryWarning)
+			}
+
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+
+	errs := make(chan error, 2)
+
+	// The pushes to Grafana can be paused and resumed with the admin API and
+	// with signals.
+	paused := pause.NewSwitch()
+
+	// Expose the admin API if requested.
+	if cfg.Pusher != nil && cfg.Pusher.Admin != nil {
+		go func() {
+			errs <- admin.Serve(cfg.Pusher.Admin, cfg.State.Path, paused)
+		}()
+	}
+
+	if *pull {
+		go pullEvery(ctx, pullClient, cfg, *pullInterval)
+	}
+
+	if !*push {
+		return <-errs
+	}
+
+	// Let the pushes to Grafana be paused and resumed with signals.
+	paused.HandleSignals()
+
+	// Set up either a webhook or a poller depending on the mode specified in
+	// the configuration file.
+	go func() {
+		switch cfg.Pusher.Mode {
+		case "webhook":
+			errs <- webhook.Setup(cfg, client, *deleteRemoved, paused)
+		case "git-pull":
+			errs <- poller.Setup(cfg, client, *deleteRemoved, paused)
+		}
+	}()
+
+	return <-errs
+}
+
+// pullEvery pulls the dashboards from Grafana with the given client at the
+// given interval, starting immediately. Each pull is a sync run, during which
+// the clone's lock is held if the dashboards are synchronised with a Git
+// repository. Errors are logged.
+func pullEvery(
+	ctx context.Context, client *grafana.Client, cfg *config.Config,
+	interval time.Duration,
+) {
+	for {
+		runCtx := logger.StartRun(ctx)
+
+		var unlock func()
+		if cfg.Git != nil {
+			unlock = git.LockClone(cfg.Git.ClonePath)
+		}
+
+		if err := puller.PullGrafanaAndCommit(runCtx, client, cfg); err != nil {
+			logrus.WithContext(runCtx).WithFields(logrus.Fields{
+				"error": err,
+			}).Error("Failed to pull the dashboards from Grafana")
+		}
+
+		if unlock != nil {
+			unlock()
+		}
+
+		time.Sleep(interval)
+	}
+}
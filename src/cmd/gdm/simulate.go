@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+
+	"github.com/sirupsen/logrus"
+)
+
+// simulateFolder is the title of the folder the synthetic dashboards are pushed
+// to if none is given with "--folder".
+const simulateFolder = "gdm-simulate"
+
+// runSimulate generates synthetic dashboards, which number and size are given
+// with "--count", "--panels" and "--queries", to measure the throughput of the
+// manager with the current settings (e.g. the rate limit), e.g. to plan the
+// capacity needed by a large Grafana instance. With "--target grafana" (the
+// default), the dashboards are pushed to the folder given with "--folder" the
+// same way the pusher pushes them, pulled back the same way the puller pulls
+// them, then deleted (unless "--keep" is set). With "--target repo", they're
+// written to the directory given with "--dir", then read and prepared the same
+// way the pusher and "gdm ci" do. The throughput of each step is printed.
+// Returns an error if the flags are invalid, or if a step failed entirely.
+func runSimulate(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	count := flags.Int("count", 100, "Number of synthetic dashboards to generate")
+	panels := flags.Int("panels", 10, "Number of panels in each synthetic dashboard")
+	queries := flags.Int("queries", 2, "Number of queries in each panel")
+	target := flags.String("target", "grafana", "Where to generate the synthetic dashboards (grafana|repo)")
+	folder := flags.String("folder", simulateFolder, "Title of the Grafana folder to push the synthetic dashboards to, with --target grafana")
+	dir := flags.String("dir", "", "Directory to write the synthetic dashboards to, with --target repo")
+	keep := flags.Bool("keep", false, "Don't delete the synthetic dashboards from Grafana afterwards, with --target grafana")
+	flags.Parse(args)
+
+	if *count <= 0 || *panels < 0 || *queries < 0 {
+		return errors.New("The number of dashboards must be positive, and the numbers of panels and queries can't be negative")
+	}
+
+	contents, err := simulatedDashboards(*count, *panels, *queries)
+	if err != nil {
+		return err
+	}
+
+	switch *target {
+	case "grafana":
+		return simulateGrafana(ctx, contents, *folder, *keep, cfg)
+	case "repo":
+		if len(*dir) == 0 {
+			return errors.New("A directory must be given with --dir when the target is the repository")
+		}
+
+		return simulateRepo(contents, *dir, cfg)
+	default:
+		return fmt.Errorf("Unknown target %s, expected grafana or repo", *target)
+	}
+}
+
+// simulateGrafana pushes the given synthetic dashboards to the Grafana folder
+// with the given title, pulls them back, and deletes them unless keep is true,
+// printing the throughput of each step.
+// Returns an error if the folder couldn't be retrieved or created, if none of
+// the dashboards could be pushed, or if the list of dashboards couldn't be
+// retrieved from Grafana.
+func simulateGrafana(
+	ctx context.Context, contents map[string][]byte, folder string, keep bool,
+	cfg *config.Config,
+) error {
+	client := grafana.NewClientFromConfig(cfg.Grafana.ForPusher())
+
+	folderID, err := client.GetFolderID(ctx, folder)
+	if err != nil {
+		return err
+	}
+
+	filenames := sortedFilenames(contents)
+	uids := make([]string, 0, len(filenames))
+	for _, filename := range filenames {
+		uids = append(uids, strings.TrimSuffix(filename, ".json"))
+	}
+
+	// Delete the dashboards even if a later step failed, so they don't pile up
+	// on Grafana.
+	if !keep {
+		defer simulateDelete(ctx, uids, client)
+	}
+
+	start := time.Now()
+	report := common.PushFiles(ctx, filenames, contents, folderID, client, nil, cfg)
+	printThroughput("push", len(report.Pushed), contentsSize(contents, report.Pushed), time.Since(start))
+
+	if failed := len(filenames) - len(report.Pushed); failed > 0 {
+		fmt.Printf(
+			"      %d dashboards weren't pushed (rejected: %d, failed: %d, skipped: %d), see the logs\n",
+			failed, len(report.Rejected), len(report.Failed), len(report.Skipped),
+		)
+	}
+
+	if len(report.Pushed) == 0 {
+		return errors.New("None of the synthetic dashboards could be pushed")
+	}
+
+	// Pull the dashboards back the way the puller does, i.e. by listing the
+	// dashboards then retrieving each of them.
+	start = time.Now()
+	refs, err := client.GetDashboardsRefs(ctx)
+	if err != nil {
+		return err
+	}
+	printThroughput("search", len(refs), 0, time.Since(start))
+
+	simulated := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		simulated[uid] = true
+	}
+
+	var pulled, size int
+	start = time.Now()
+	for _, ref := range refs {
+		if !simulated[ref.UID] {
+			continue
+		}
+
+		db, err := client.GetDashboardByRef(ctx, ref)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error": err,
+				"ref":   ref,
+			}).Error("Failed to pull a synthetic dashboard")
+
+			continue
+		}
+
+		pulled++
+		size += len(db.RawJSON)
+	}
+	printThroughput("pull", pulled, size, time.Since(start))
+
+	return nil
+}
+
+// simulateDelete deletes the synthetic dashboards with the given UIDs from
+// Grafana, and prints the throughput of the deletion. Dashboards which don't
+// exist (e.g. because they couldn't be pushed) are skipped.
+func simulateDelete(ctx context.Context, uids []string, client *grafana.Client) {
+	var deleted int
+	start := time.Now()
+	for _, uid := range uids {
+		if err := client.DeleteDashboardByUID(ctx, uid); err != nil {
+			if !grafana.IsNotFound(err) {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error": err,
+					"uid":   uid,
+				}).Error("Failed to delete a synthetic dashboard")
+			}
+
+			continue
+		}
+
+		deleted++
+	}
+	printThroughput("delete", deleted, 0, time.Since(start))
+}
+
+// simulateRepo writes the given synthetic dashboards to the given directory,
+// then reads them back and prepares them to be pushed, printing the throughput
+// of each step. The files are left in the directory.
+// Returns an error if there was an issue writing, reading or preparing a file.
+func simulateRepo(contents map[string][]byte, dir string, cfg *config.Config) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	filenames := sortedFilenames(contents)
+
+	start := time.Now()
+	for _, filename := range filenames {
+		if err := ioutil.WriteFile(filepath.Join(dir, filename), contents[filename], 0644); err != nil {
+			return err
+		}
+	}
+	printThroughput("write", len(filenames), contentsSize(contents, filenames), time.Since(start))
+
+	start = time.Now()
+	read := make(map[string][]byte, len(filenames))
+	for _, filename := range filenames {
+		content, err := ioutil.ReadFile(filepath.Join(dir, filename))
+		if err != nil {
+			return err
+		}
+
+		read[filename] = content
+	}
+
+	prepared, err := prepareDashboards(read, cfg)
+	if err != nil {
+		return err
+	}
+	printThroughput("prepare", len(prepared), contentsSize(read, filenames), time.Since(start))
+
+	return nil
+}
+
+// simulatedDashboards generates the given number of synthetic dashboards, each
+// with the given number of panels, themselves with the given number of
+// queries. Returns a map mapping the dashboards' files' names (their UIDs
+// followed by ".json") to their JSON descriptions, indented the same way as in
+// the repository.
+// Returns an error if a description couldn't be generated.
+func simulatedDashboards(count int, panels int, queries int) (map[string][]byte, error) {
+	contents := make(map[string][]byte, count)
+	width := len(fmt.Sprint(count))
+
+	for i := 1; i <= count; i++ {
+		uid := fmt.Sprintf("%s-%0*d", simulateFolder, width, i)
+
+		dashboardPanels := make([]interface{}, 0, panels)
+		for j := 0; j < panels; j++ {
+			targets := make([]interface{}, 0, queries)
+			for k := 0; k < queries; k++ {
+				targets = append(targets, map[string]interface{}{
+					"refId": fmt.Sprintf("Q%d", k),
+					"expr":  fmt.Sprintf("sum(rate(simulated_metric_%d_%d[5m])) by (instance)", j, k),
+				})
+			}
+
+			dashboardPanels = append(dashboardPanels, map[string]interface{}{
+				"id":      j + 1,
+				"type":    "graph",
+				"title":   fmt.Sprintf("Panel %d", j+1),
+				"gridPos": map[string]int{"x": 12 * (j % 2), "y": 8 * (j / 2), "w": 12, "h": 8},
+				"targets": targets,
+			})
+		}
+
+		content, err := json.MarshalIndent(map[string]interface{}{
+			"uid":           uid,
+			"title":         "Simulated dashboard " + uid,
+			"tags":          []string{simulateFolder},
+			"schemaVersion": 16,
+			"panels":        dashboardPanels,
+		}, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+
+		contents[uid+".json"] = content
+	}
+
+	return contents, nil
+}
+
+// printThroughput prints the number of dashboards (and their total size, if
+// not 0) processed by the given step in the given duration, and the resulting
+// throughput.
+func printThroughput(step string, count int, size int, elapsed time.Duration) {
+	line := fmt.Sprintf("%-8s %d dashboards", step+":", count)
+	if size > 0 {
+		line += fmt.Sprintf(" (%.1f KiB)", float64(size)/1024)
+	}
+
+	line += fmt.Sprintf(" in %s", elapsed.Round(time.Millisecond))
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		line += fmt.Sprintf(", %.1f dashboards/s", float64(count)/seconds)
+	}
+
+	fmt.Println(line)
+}
+
+// contentsSize returns the total size of the contents of the given files.
+func contentsSize(contents map[string][]byte, filenames []string) (size int) {
+	for _, filename := range filenames {
+		size += len(contents[filename])
+	}
+
+	return
+}
+
+// sortedFilenames returns the names of the files from the given map, sorted.
+func sortedFilenames(contents map[string][]byte) []string {
+	filenames := make([]string, 0, len(contents))
+	for filename := range contents {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	return filenames
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	"github.com/gosimple/slug"
+)
+
+// runStale lists the dashboards which have been viewed at most a given number
+// of times during the last 30 days, according to Grafana's usage insights, least
+// viewed first, so they can be considered for cleanup. Dashboards which slug
+// starts with the ignore prefix aren't listed.
+// Returns an error if Grafana doesn't provide usage insights, or if there was
+// an issue retrieving the dashboards' usage.
+func runStale(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("stale", flag.ExitOnError)
+	maxViews := flags.Int("max-views", 0, "Maximum number of views during the last 30 days for a dashboard to be considered stale")
+	flags.Parse(args)
+
+	usage, err := grafana.NewClientFromConfig(cfg.Grafana.ForPuller()).GetDashboardsUsage(ctx)
+	if err != nil {
+		return err
+	}
+
+	stale := make([]grafana.DashboardUsage, 0)
+	for _, u := range usage {
+		prefix := cfg.Grafana.IgnorePrefix
+		if len(prefix) > 0 && strings.HasPrefix(slug.Make(u.Title), prefix) {
+			continue
+		}
+
+		if u.RecentViews <= *maxViews {
+			stale = append(stale, u)
+		}
+	}
+
+	sort.SliceStable(stale, func(i, j int) bool {
+		if stale[i].RecentViews != stale[j].RecentViews {
+			return stale[i].RecentViews < stale[j].RecentViews
+		}
+
+		return stale[i].Views < stale[j].Views
+	})
+
+	if len(stale) == 0 {
+		fmt.Println("No stale dashboards.")
+		return nil
+	}
+
+	for _, u := range stale {
+		fmt.Printf(
+			"%s (%s): %d views during the last 30 days, %d in total\n",
+			u.Title, u.Ref, u.RecentViews, u.Views,
+		)
+	}
+
+	return nil
+}
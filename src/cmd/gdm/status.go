@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/diff"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/plan"
+	"github.com/babolivier/grafana-dashboards-manager/src/puller"
+)
+
+// Drift states of a dashboard, as printed by the status command.
+const (
+	driftInSync       = "in-sync"
+	driftRepoAhead    = "repo-ahead"
+	driftGrafanaAhead = "grafana-ahead"
+	driftMissing      = "missing-either-side"
+)
+
+// dashboardStatus describes the state of a dashboard in the repository and on
+// Grafana. Hash is the short hash of the dashboard's description in the
+// repository, and RepoVersion the version recorded for it in the versions file
+// (0 if there's none). Either is empty if the dashboard only exists on one
+// side.
+type dashboardStatus struct {
+	Slug           string
+	Hash           string
+	RepoVersion    int
+	GrafanaVersion int
+	State          string
+}
+
+// runStatus compares the dashboards in the repository (read as the pusher
+// would push them) with the ones on Grafana, without changing anything, and
+// prints a table of their states: the hash of each dashboard's description in
+// the repository along with the version recorded for it in the versions file,
+// its version on Grafana, and whether it has drifted. A dashboard which differs
+// from Grafana's is "grafana-ahead" if it was modified on Grafana since the
+// recorded version, and "repo-ahead" otherwise (i.e. the repository's changes
+// weren't pushed yet). Dashboards are matched by UID, or by slug if they don't
+// have one. The table can be restricted to the dashboards with the given slugs
+// or UIDs.
+// Returns an error if there was an issue reading the dashboards or the versions
+// file from the repository, retrieving the dashboards from Grafana, or
+// comparing them, or exitChanges if a dashboard isn't in sync and the
+// --detailed-exitcode flag is set.
+func runStatus(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	detailedExitCode := flags.Bool("detailed-exitcode", false, "Exit with code 2 if a dashboard isn't in sync")
+	flags.Parse(args)
+
+	only := make(map[string]bool)
+	for _, id := range flags.Args() {
+		only[id] = true
+	}
+
+	contents, err := readRepoDashboards(cfg)
+	if err != nil {
+		return err
+	}
+
+	versions, err := puller.ReadVersionsFile(
+		filepath.Join(syncPath(cfg), cfg.Metadata.VersionsFile),
+	)
+	if err != nil {
+		return err
+	}
+
+	live, err := liveDashboards(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	statuses := make([]dashboardStatus, 0)
+	matched := make(map[*grafana.Dashboard]bool)
+	for _, content := range contents {
+		uid, _ := helpers.GetDashboardUID(content)
+		slug, err := helpers.GetDashboardSlug(content)
+		if err != nil {
+			return err
+		}
+
+		if len(only) > 0 && !only[uid] && !only[slug] {
+			continue
+		}
+
+		if content, err = stripDashboard(content, cfg); err != nil {
+			return err
+		}
+
+		hash, err := plan.Hash(content)
+		if err != nil {
+			return err
+		}
+
+		status := dashboardStatus{
+			Slug:  slug,
+			Hash:  hash[:12],
+			State: driftMissing,
+		}
+		status.RepoVersion, _ = grafana.RecordedVersion(versions, uid, slug)
+
+		dashboard := live["slug:"+slug]
+		if len(uid) > 0 {
+			dashboard = live["uid:"+uid]
+		}
+
+		if dashboard != nil {
+			matched[dashboard] = true
+			status.GrafanaVersion = dashboard.Version
+			if status.State, err = driftState(content, dashboard, status.RepoVersion, cfg); err != nil {
+				return err
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	for _, dashboard := range live {
+		if matched[dashboard] || (len(only) > 0 && !only[dashboard.UID] && !only[dashboard.Slug]) {
+			continue
+		}
+
+		prefix := cfg.Grafana.IgnorePrefix
+		if len(prefix) > 0 && strings.HasPrefix(dashboard.Slug, prefix) {
+			continue
+		}
+
+		// Dashboards with a UID are indexed twice.
+		matched[dashboard] = true
+
+		status := dashboardStatus{
+			Slug:           dashboard.Slug,
+			GrafanaVersion: dashboard.Version,
+			State:          driftMissing,
+		}
+		status.RepoVersion, _ = grafana.RecordedVersion(versions, dashboard.UID, dashboard.Slug)
+
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Slug < statuses[j].Slug
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "DASHBOARD\tREPOSITORY\tGRAFANA\tSTATE")
+
+	drifted := false
+	for _, status := range statuses {
+		repo := "-"
+		if len(status.Hash) > 0 {
+			repo = status.Hash
+			if status.RepoVersion > 0 {
+				repo += " (v" + strconv.Itoa(status.RepoVersion) + ")"
+			}
+		}
+
+		grafanaVersion := "-"
+		if status.GrafanaVersion > 0 {
+			grafanaVersion = "v" + strconv.Itoa(status.GrafanaVersion)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", status.Slug, repo, grafanaVersion, status.State)
+		drifted = drifted || status.State != driftInSync
+	}
+
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	if *detailedExitCode && drifted {
+		return exitChanges
+	}
+
+	return nil
+}
+
+// driftState compares the given description of a dashboard from the repository,
+// which version recorded in the versions file is the given one, with the
+// dashboard on Grafana, and returns the dashboard's drift state.
+// Returns an error if there was an issue comparing the descriptions.
+func driftState(
+	content []byte, dashboard *grafana.Dashboard, recorded int, cfg *config.Config,
+) (string, error) {
+	liveContent, err := stripDashboard(dashboard.RawJSON, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	d, err := diff.Compare(liveContent, content, cfg.IgnoreLayoutChanges)
+	if err != nil {
+		return "", err
+	}
+
+	if d.Empty() {
+		return driftInSync, nil
+	}
+
+	if dashboard.Version > recorded && recorded > 0 {
+		return driftGrafanaAhead, nil
+	}
+
+	return driftRepoAhead, nil
+}
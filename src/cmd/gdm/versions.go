@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io/ioutil"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/puller"
+	"github.com/babolivier/grafana-dashboards-manager/src/textfile"
+)
+
+// runVersionsMerge merges two versions of the versions file, as a Git merge
+// driver: it takes the paths of the files of the merge base, of the current
+// branch and of the other branch (i.e. "%O %A %B" in the driver's command),
+// and writes the merge of the files (see puller.MergeVersions) in the current
+// branch's file, which Git then uses as the result, keeping the line endings
+// of the current branch's file. It doesn't need the configuration, so it can be
+// set up on the developers' workstations, e.g. with the following in
+// .gitattributes:
+//
+//	versions.json merge=gdm-versions
+//
+// and in the Git configuration:
+//
+//	[merge "gdm-versions"]
+//		driver = gdm versions-merge %O %A %B
+//
+// Returns an error if there was an issue reading, parsing or writing the
+// files, in which case Git leaves the conflict to be resolved manually.
+func runVersionsMerge(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("versions-merge", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 3 {
+		return errors.New("Usage: versions-merge <base file> <current file> <other file>")
+	}
+
+	base, err := puller.ReadVersionsFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	ours, err := puller.ReadVersionsFile(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	theirs, err := puller.ReadVersionsFile(flags.Arg(2))
+	if err != nil {
+		return err
+	}
+
+	current, err := ioutil.ReadFile(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	_, err = puller.WriteVersionsFile(
+		flags.Arg(1), puller.MergeVersions(base, ours, theirs),
+		textfile.LineEndings(current),
+	)
+	return err
+}
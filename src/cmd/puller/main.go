@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/buildinfo"
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/logger"
+	"github.com/babolivier/grafana-dashboards-manager/src/puller"
+
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	// Define this flag in the main function because else it would cause a
+	// conflict with the one in the pusher.
+	configFile := flag.String("config", "config.yaml", "Path to the configuration file")
+	errorPolicy := flag.String("error-policy", "", "How to handle errors on individual dashboards (continue|fail-fast), overriding the configuration file")
+	showVersion := flag.Bool("version", false, "Print the version and build information, then exit")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(buildinfo.String("puller"))
+		return
+	}
+
+	// Load the logger's configuration.
+	logger.LogConfig()
+
+	// Load the configuration.
+	cfg, err := config.Load(*configFile)
+	if err == nil {
+		err = cfg.SetErrorPolicy(*errorPolicy)
+	}
+	if err != nil {
+		logrus.Panic(err)
+	}
+
+	// Make sure the secrets from the configuration don't leak into the logs.
+	logger.Redact(cfg.Secrets())
+
+	// Send the logs to the outputs from the configuration, if any.
+	if err = logger.ConfigureOutputs(cfg.Logging); err != nil {
+		logrus.Panic(err)
+	}
+
+	buildinfo.LogStartup("puller")
+
+	// Tell the user which sync mode we use.
+	var syncMode string
+	if cfg.Git != nil {
+		syncMode = "git"
+	} else {
+		syncMode = "simple"
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"sync_mode": syncMode,
+	}).Info("Sync mode set")
+
+	// Tag the logs of the pull with a run ID.
+	ctx := logger.StartRun(context.Background())
+
+	// Initialise the Grafana API client, and make sure Grafana can be synced
+	// with before starting.
+	client := grafana.NewClientFromConfig(cfg.Grafana.ForPuller())
+	if err = client.CheckHealth(ctx); err != nil {
+		logrus.WithContext(ctx).Fatal(err)
+	}
+
+	// Warn about API keys that are about to expire.
+	client.CheckAPIKeysExpiry(ctx, cfg.Grafana.KeyExpiryWarning)
+	// Run the puller.
+	if err := puller.PullGrafanaAndCommit(ctx, client, cfg); err != nil {
+		logrus.WithContext(ctx).Panic(err)
+	}
+}
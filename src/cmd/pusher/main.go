@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/admin"
+	"github.com/babolivier/grafana-dashboards-manager/src/buildinfo"
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/logger"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/pause"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/poller"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/webhook"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	deleteRemoved     = flag.Bool("delete-removed", false, "For each file removed from Git, delete the corresponding dashboard on the Grafana API")
+	allowAlertRemoval = flag.Bool("allow-alert-removal", false, "Push or delete dashboards even if this removes legacy alerts from Grafana, overriding the configuration file")
+)
+
+func main() {
+	var err error
+
+	// Define this flag in the main function because else it would cause a
+	// conflict with the one in the puller.
+	configFile := flag.String("config", "config.yaml", "Path to the configuration file")
+	errorPolicy := flag.String("error-policy", "", "How to handle errors on individual dashboards (continue|fail-fast), overriding the configuration file")
+	showVersion := flag.Bool("version", false, "Print the version and build information, then exit")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(buildinfo.String("pusher"))
+		return
+	}
+
+	// Load the logger's configuration.
+	logger.LogConfig()
+
+	// Load the configuration.
+	cfg, err := config.Load(*configFile)
+	if err == nil {
+		err = cfg.SetErrorPolicy(*errorPolicy)
+	}
+	if err != nil {
+		logrus.Panic(err)
+	}
+
+	// Make sure the secrets from the configuration don't leak into the logs.
+	logger.Redact(cfg.Secrets())
+
+	// Send the logs to the outputs from the configuration, if any.
+	if err = logger.ConfigureOutputs(cfg.Logging); err != nil {
+		logrus.Panic(err)
+	}
+
+	buildinfo.LogStartup("pusher")
+
+	if cfg.Git == nil || cfg.Pusher == nil {
+		logrus.Info("The git configuration or the pusher configuration (or both) is not defined in the configuration file. The pusher cannot start unless both are defined.")
+		os.Exit(0)
+	}
+
+	if *allowAlertRemoval {
+		cfg.Pusher.AllowAlertRemoval = true
+	}
+
+	ctx := context.Background()
+
+	// Initialise the Grafana API client, and make sure Grafana can be synced
+	// with before starting.
+	grafanaClient := grafana.NewClientFromConfig(cfg.Grafana.ForPusher())
+	if err = grafanaClient.CheckHealth(ctx); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// Warn about API keys that are about to expire, and keep checking every day
+	// since the pusher runs as a daemon.
+	go func() {
+		for {
+			grafanaClient.CheckAPIKeysExpiry(
+				logger.StartRun(ctx), cfg.Grafana.KeyExpiryWarning,
+			)
+
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+
+	// Let the pushes to Grafana be paused and resumed with signals.
+	paused := pause.NewSwitch()
+	paused.HandleSignals()
+
+	// Expose the admin API if requested.
+	if cfg.Pusher.Admin != nil {
+		go func() {
+			if err := admin.Serve(cfg.Pusher.Admin, cfg.State.Path, paused); err != nil {
+				logrus.Panic(err)
+			}
+		}()
+	}
+
+	// Set up either a webhook or a poller depending on the mode specified in the
+	// configuration file.
+	switch cfg.Pusher.Mode {
+	case "webhook":
+		err = webhook.Setup(cfg, grafanaClient, *deleteRemoved, paused)
+		break
+	case "git-pull":
+		err = poller.Setup(cfg, grafanaClient, *deleteRemoved, paused)
+	}
+
+	if err != nil {
+		logrus.Panic(err)
+	}
+}
@@ -2,7 +2,15 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
@@ -11,25 +19,736 @@ import (
 )
 
 var (
-	ErrPusherInvalidSyncMode   = errors.New("Invalid sync mode in the pusher settings")
-	ErrPusherConfigNotMatching = errors.New("The pusher config doesn't match with the one expected from the pusher sync mode")
-	ErrNoSyncSettings          = errors.New("At least one of the simple_sync or the git settings must be set")
+	ErrPusherInvalidSyncMode    = errors.New("Invalid sync mode in the pusher settings")
+	ErrPusherConfigNotMatching  = errors.New("The pusher config doesn't match with the one expected from the pusher sync mode")
+	ErrNoSyncSettings           = errors.New("At least one of the simple_sync or the git settings must be set")
+	ErrForgeInvalidType         = errors.New("Invalid forge type in the forge settings")
+	ErrGitInvalidManagerCommits = errors.New("Invalid handling of the manager's commits in the git settings")
+	ErrGitInvalidTransfer       = errors.New("The transfer rate limit and timeout in the git settings must be positive")
+	ErrGitHostKeyConflict       = errors.New("Only one of known_hosts, host_key and insecure_ignore_host_key can be set in the git settings")
+	ErrGitReviewNoForge         = errors.New("The review settings in the git settings require the forge settings")
+	ErrGitInvalidCloneDepth     = errors.New("The clone depth in the git settings must be positive")
+	ErrGitInvalidSubdirectory   = errors.New("The subdirectory in the git settings must be a relative path inside the repository")
+	ErrGitInvalidCommitMessage  = errors.New("Invalid template of the puller's commit messages in the git settings")
+	ErrGitInvalidAttribution    = errors.New("Invalid attribution of the puller's commits in the git settings")
+	ErrGitSyncTagsWithReview    = errors.New("The sync tags and the review settings can't be both set in the git settings")
+	ErrGitInvalidOnDivergence   = errors.New("Invalid handling of a diverged clone in the git settings")
+	ErrPusherInvalidMigration   = errors.New("Unknown migration in the pusher settings")
+	ErrPusherInvalidConflicts   = errors.New("Invalid handling of conflicts in the pusher settings")
+	ErrPusherInvalidCollisions  = errors.New("Invalid handling of title collisions in the pusher settings")
+	ErrTagsConflict             = errors.New("A tag can't be both added and removed in the tags settings")
+	ErrPusherInvalidFolderPath  = errors.New("Invalid template of folder titles in the pusher settings")
+	ErrInvalidLayout            = errors.New("Invalid layout")
+	ErrInvalidLineEndings       = errors.New("Invalid line endings")
+	ErrTargetInvalid            = errors.New("Every push target must have a name and a Grafana base URL")
+	ErrAdminWithoutState        = errors.New("The admin API requires the state settings")
+	ErrFailureIssuesRequirement = errors.New("Opening issues on push failures requires the forge and state settings")
+	ErrInvalidFailureIssues     = errors.New("The threshold of the failure issues in the pusher settings must be positive")
+	ErrProvisioningDirNoPath    = errors.New("The provisioning directory settings in the pusher settings must include a path")
+	ErrStateNoPath              = errors.New("The state settings must include a path")
+	ErrCacheNoPath              = errors.New("The cache settings must include a path")
+	ErrInvalidErrorPolicy       = errors.New("Invalid error policy")
+	ErrGrafanaBasicAuth         = errors.New("Basic auth requires both a username and a password in the Grafana settings")
+	ErrGrafanaAuthConflict      = errors.New("Basic auth and OAuth2 can't be used along with each other or with API keys or a service account token in the Grafana settings")
+	ErrGrafanaOAuth2            = errors.New("OAuth2 requires a token URL, a client ID and a client secret in the Grafana settings")
+	ErrGrafanaDialerConflict    = errors.New("A Unix socket and an SSH tunnel can't be used along with each other in the Grafana settings")
+	ErrGrafanaSSHTunnel         = errors.New("An SSH tunnel requires an address, a user and a private key in the Grafana settings")
+	ErrGrafanaInvalidRateLimit  = errors.New("The rate limit in the Grafana settings must allow a positive number of requests per second, with a positive burst")
+	ErrGrafanaInvalidTimeout    = errors.New("The timeout in the Grafana settings must be positive")
+	ErrLoggingNoOutput          = errors.New("The standard error output can't be disabled without another logging output")
+	ErrHistoryInvalidVersions   = errors.New("The number of versions in the history settings can't be negative")
+	ErrProvisioningNoPath       = errors.New("The provisioning settings must include the path at which Grafana can read the repository")
 )
 
 // Config is the Go representation of the configuration file. It is filled when
-// parsing the said file.
+// parsing the said file. Layout is how dashboards are laid out in the
+// repository: either all at its root ("flat", the default), or in a directory
+// per Grafana folder ("folders"). Budgets, if set, are the limits dashboards
+// must respect to be pushed. AlertNotifications, if set, makes the manager sync
+// Grafana's legacy alert notification channels along with the dashboards.
+// State, if set, makes the manager keep track of the latest syncs of each
+// dashboard. ErrorPolicy is how the puller and the pusher handle errors on
+// individual dashboards: either by logging them and carrying on with the other
+// dashboards ("continue", the default), or by aborting ("fail-fast").
+// SyncPermissions, if true, makes the manager sync the dashboards' permissions
+// along with the dashboards. Logging, if set, configures additional outputs for
+// the logs. Snapshots, if set, makes the puller export Grafana's dashboard
+// snapshots along with the dashboards. Annotations, if set, makes the puller
+// export Grafana's annotations along with the dashboards. History, if set,
+// makes the puller export the latest versions of each dashboard. CleanOrphans,
+// if true, makes the puller remove the files that don't match any dashboard on
+// Grafana anymore at the end of each pull. Features turns behaviours of the
+// manager on or off (see Feature). Provisioning, if set, makes the puller write
+// a Grafana provisioning file, so the repository can be provisioned as is.
+// IgnoreLayoutChanges, if true, makes the manager ignore the changes which only
+// move, resize or renumber panels when deciding whether a dashboard changed.
+// LegacyAlerts, if set, makes the puller export the legacy alerts defined in
+// the dashboards' panels to separate files. StripFields lists the paths of the
+// volatile fields the puller removes from the dashboards before writing them
+// (see semantic.StripFields for their syntax). LibraryPanels and Playlists, if
+// set, make the puller export Grafana's library panels and playlists, which
+// the restore command restores along with the dashboards. Cache, if set, makes
+// the puller keep the dashboards it retrieves, so it doesn't download them again
+// until their version changes. Tags, if set, are the tags the pusher enforces
+// on the dashboards it pushes, and the puller checks on the ones it pulls.
+// LineEndings is the line endings of the files the puller writes: either "lf"
+// (the default) or "crlf".
 type Config struct {
-	Grafana    GrafanaSettings     `yaml:"grafana"`
-	SimpleSync *SimpleSyncSettings `yaml:"simple_sync,omitempty"`
-	Git        *GitSettings        `yaml:"git,omitempty"`
-	Pusher     *PusherSettings     `yaml:"pusher,omitempty"`
+	Grafana             GrafanaSettings             `yaml:"grafana"`
+	SimpleSync          *SimpleSyncSettings         `yaml:"simple_sync,omitempty"`
+	Git                 *GitSettings                `yaml:"git,omitempty"`
+	Pusher              *PusherSettings             `yaml:"pusher,omitempty"`
+	Screenshots         *ScreenshotsSettings        `yaml:"screenshots,omitempty"`
+	Forge               *ForgeSettings              `yaml:"forge,omitempty"`
+	Metadata            MetadataSettings            `yaml:"metadata,omitempty"`
+	Layout              string                      `yaml:"layout,omitempty"`
+	Budgets             *BudgetsSettings            `yaml:"budgets,omitempty"`
+	AlertNotifications  *AlertNotificationsSettings `yaml:"alert_notifications,omitempty"`
+	State               *StateSettings              `yaml:"state,omitempty"`
+	ErrorPolicy         string                      `yaml:"error_policy,omitempty"`
+	SyncPermissions     bool                        `yaml:"sync_permissions,omitempty"`
+	Logging             *LoggingSettings            `yaml:"logging,omitempty"`
+	CleanOrphans        bool                        `yaml:"clean_orphans,omitempty"`
+	Snapshots           *SnapshotsSettings          `yaml:"snapshots,omitempty"`
+	Annotations         *AnnotationsSettings        `yaml:"annotations,omitempty"`
+	History             *HistorySettings            `yaml:"history,omitempty"`
+	Features            map[string]bool             `yaml:"features,omitempty"`
+	Provisioning        *ProvisioningSettings       `yaml:"provisioning,omitempty"`
+	IgnoreLayoutChanges bool                        `yaml:"ignore_layout_changes,omitempty"`
+	LegacyAlerts        *LegacyAlertsSettings       `yaml:"legacy_alerts,omitempty"`
+	StripFields         []string                    `yaml:"strip_fields,omitempty"`
+	LibraryPanels       *LibraryPanelsSettings      `yaml:"library_panels,omitempty"`
+	Playlists           *PlaylistsSettings          `yaml:"playlists,omitempty"`
+	Cache               *CacheSettings              `yaml:"cache,omitempty"`
+	Tags                *TagsSettings               `yaml:"tags,omitempty"`
+	LineEndings         string                      `yaml:"line_endings,omitempty"`
+}
+
+// ProvisioningSettings contains the settings of the Grafana dashboards
+// provisioning file the puller writes. File is the path of the file, relative
+// to the clone path (or sync path). Name is the name of the dashboards
+// provider. DashboardsPath is the path at which Grafana can read the repository
+// (e.g. where it's mounted in Grafana's container). Folder is the title of the
+// folder the dashboards are provisioned to with the "flat" layout; with the
+// "folders" layout, each directory is provisioned to the folder named after it.
+// UpdateInterval is how often Grafana looks for changes in the files, and
+// AllowUIUpdates whether the provisioned dashboards can be changed from
+// Grafana's UI.
+type ProvisioningSettings struct {
+	File           string        `yaml:"file,omitempty"`
+	Name           string        `yaml:"name,omitempty"`
+	DashboardsPath string        `yaml:"dashboards_path"`
+	Folder         string        `yaml:"folder,omitempty"`
+	UpdateInterval time.Duration `yaml:"update_interval,omitempty"`
+	AllowUIUpdates bool          `yaml:"allow_ui_updates,omitempty"`
+}
+
+// Names of the features which can be turned on or off in the "features"
+// settings. FeatureFolderSync makes the pusher apply the changes to the
+// folders' metadata files (i.e. rename and move folders). FeatureRenameTracking
+// makes the puller move the file of a dashboard that was renamed (or moved to
+// another folder) rather than write a new file alongside the previous one.
+// FeatureFlatLayoutCompat makes the pusher, with the "folders" layout, push the
+// dashboards which files are still at the root of the repository (i.e. laid out
+// with the "flat" layout) to their current folder rather than to the default
+// one, while the repository is being migrated.
+const (
+	FeatureFolderSync       = "folder_sync"
+	FeatureRenameTracking   = "rename_tracking"
+	FeatureFlatLayoutCompat = "flat_layout_compat"
+)
+
+// defaultFeatures maps the name of each feature to whether it's enabled if the
+// "features" settings don't mention it.
+var defaultFeatures = map[string]bool{
+	FeatureFolderSync:       true,
+	FeatureRenameTracking:   true,
+	FeatureFlatLayoutCompat: false,
+}
+
+// Feature checks whether the feature with the given name is enabled, either
+// explicitly in the "features" settings or by default. Features allow
+// upgrading the manager without changing how it syncs dashboards at the same
+// time, by turning new behaviours off until the deployment is ready for them.
+func (c *Config) Feature(name string) bool {
+	if enabled, ok := c.Features[name]; ok {
+		return enabled
+	}
+
+	return defaultFeatures[name]
+}
+
+// LoggingSettings contains the settings of additional outputs for the logs.
+// Syslog, if set, sends them to a syslog daemon. Journald, if true, sends them
+// to the systemd journal. DisableStderr, if true, stops writing them on the
+// standard error output, e.g. to avoid duplicates when it's already collected.
+type LoggingSettings struct {
+	Syslog        *SyslogSettings `yaml:"syslog,omitempty"`
+	Journald      bool            `yaml:"journald,omitempty"`
+	DisableStderr bool            `yaml:"disable_stderr,omitempty"`
+}
+
+// SyslogSettings contains the settings to send the logs to a syslog daemon.
+// Network and Address are the network ("udp", "tcp"...) and address of the
+// daemon, which are left empty to use the local daemon. Tag is the tag of the
+// messages, and defaults to the program's name.
+type SyslogSettings struct {
+	Network string `yaml:"network,omitempty"`
+	Address string `yaml:"address,omitempty"`
+	Tag     string `yaml:"tag,omitempty"`
+}
+
+// PermissionsFileSuffix is the suffix of the files describing the permissions
+// of a dashboard, stored alongside the dashboard's file.
+const PermissionsFileSuffix = ".permissions.json"
+
+// PermissionsFile returns the path of the file describing the permissions of
+// the dashboard described in the file at the given path.
+func PermissionsFile(dashboardFile string) string {
+	return strings.TrimSuffix(dashboardFile, ".json") + PermissionsFileSuffix
+}
+
+// IsPermissionsFile checks whether the file at the given path describes the
+// permissions of a dashboard rather than a dashboard. Since slugs can't contain
+// dots, no dashboard file can be mistaken for a permissions file, so these
+// files are recognised even if the permissions aren't synced.
+func (c *Config) IsPermissionsFile(filename string) bool {
+	return strings.HasSuffix(filename, PermissionsFileSuffix)
+}
+
+// Error policies, i.e. ways of handling errors on individual dashboards.
+const (
+	ErrorPolicyContinue = "continue"
+	ErrorPolicyFailFast = "fail-fast"
+)
+
+// FailFast checks whether the error policy requires aborting on the first
+// error.
+func (c *Config) FailFast() bool {
+	return c.ErrorPolicy == ErrorPolicyFailFast
+}
+
+// SetErrorPolicy overrides the error policy from the configuration file with
+// the given one (e.g. from a command-line flag), unless it's empty.
+// Returns an error if the policy isn't a valid one.
+func (c *Config) SetErrorPolicy(policy string) error {
+	switch policy {
+	case "":
+	case ErrorPolicyContinue, ErrorPolicyFailFast:
+		c.ErrorPolicy = policy
+	default:
+		return ErrInvalidErrorPolicy
+	}
+
+	return nil
+}
+
+// StateSettings contains the settings of the state the manager keeps across
+// runs, such as the time of the latest syncs of each dashboard. Path is the
+// file the state is stored in, which must be outside of the repository.
+type StateSettings struct {
+	Path string `yaml:"path"`
+}
+
+// CacheSettings contains the settings of the cache of the dashboards retrieved
+// by the puller. Path is the directory the cache is stored in, which must be
+// outside of the repository.
+type CacheSettings struct {
+	Path string `yaml:"path"`
+}
+
+// AdminSettings contains the settings of the admin API exposed by the pusher.
+// Address is the address (interface:port) the API listens on.
+type AdminSettings struct {
+	Address string `yaml:"address"`
+}
+
+// ProvisioningDirSettings contains the settings of the provisioning directory
+// the pusher writes the dashboards to, instead of pushing them to the main
+// Grafana instance using its API. Path is the path of the symbolic link to the
+// directory's current version, which Grafana's provisioner must watch.
+type ProvisioningDirSettings struct {
+	Path string `yaml:"path"`
+}
+
+// FailureIssuesSettings contains the settings of the issues the pusher opens on
+// the forge for the dashboards which fail to be pushed Threshold consecutive
+// times (retries included), with the given labels.
+type FailureIssuesSettings struct {
+	Threshold int      `yaml:"threshold,omitempty"`
+	Labels    []string `yaml:"labels,omitempty"`
+}
+
+// AlertNotificationsSettings contains the settings to sync Grafana's legacy
+// alert notification channels. Path is the directory, relative to the clone
+// path (or sync path), in which each channel is stored in a file named after
+// its UID.
+type AlertNotificationsSettings struct {
+	Path string `yaml:"path,omitempty"`
+}
+
+// IsAlertNotificationFile checks whether the file at the given path (relative
+// to the root of the repository or of the sync path) describes an alert
+// notification channel rather than a dashboard, i.e. whether it's a JSON file
+// in the alert notification channels' directory.
+func (c *Config) IsAlertNotificationFile(filename string) bool {
+	if c.AlertNotifications == nil || !strings.HasSuffix(filename, ".json") {
+		return false
+	}
+
+	return isInDir(filename, c.AlertNotifications.Path)
+}
+
+// SnapshotsSettings contains the settings to export Grafana's dashboard
+// snapshots. Path is the directory, relative to the clone path (or sync path),
+// in which each snapshot is stored in a file named after its key.
+type SnapshotsSettings struct {
+	Path string `yaml:"path,omitempty"`
+}
+
+// IsSnapshotFile checks whether the file at the given path (relative to the
+// root of the repository or of the sync path) describes a dashboard snapshot
+// rather than a dashboard, i.e. whether it's a JSON file in the snapshots'
+// directory.
+func (c *Config) IsSnapshotFile(filename string) bool {
+	if c.Snapshots == nil || !strings.HasSuffix(filename, ".json") {
+		return false
+	}
+
+	return isInDir(filename, c.Snapshots.Path)
+}
+
+// LibraryPanelsSettings contains the settings to export Grafana's library
+// panels. Path is the directory, relative to the clone path (or sync path), in
+// which each library panel is stored in a file named after its UID.
+type LibraryPanelsSettings struct {
+	Path string `yaml:"path,omitempty"`
+}
+
+// IsLibraryPanelFile checks whether the file at the given path (relative to
+// the root of the repository or of the sync path) describes a library panel
+// rather than a dashboard, i.e. whether it's a JSON file in the library
+// panels' directory.
+func (c *Config) IsLibraryPanelFile(filename string) bool {
+	if c.LibraryPanels == nil || !strings.HasSuffix(filename, ".json") {
+		return false
+	}
+
+	return isInDir(filename, c.LibraryPanels.Path)
+}
+
+// PlaylistsSettings contains the settings to export Grafana's playlists. Path
+// is the directory, relative to the clone path (or sync path), in which each
+// playlist is stored in a file named after its UID.
+type PlaylistsSettings struct {
+	Path string `yaml:"path,omitempty"`
+}
+
+// IsPlaylistFile checks whether the file at the given path (relative to the
+// root of the repository or of the sync path) describes a playlist rather than
+// a dashboard, i.e. whether it's a JSON file in the playlists' directory.
+func (c *Config) IsPlaylistFile(filename string) bool {
+	if c.Playlists == nil || !strings.HasSuffix(filename, ".json") {
+		return false
+	}
+
+	return isInDir(filename, c.Playlists.Path)
+}
+
+// AnnotationsSettings contains the settings to export Grafana's annotations.
+// Path is the directory, relative to the clone path (or sync path), in which
+// the annotations of each day are stored in a file named after the day (e.g.
+// "2006-01-02.json"). Window is how far back the annotations are exported on
+// each run of the puller, the first day of the window being exported whole.
+type AnnotationsSettings struct {
+	Path   string        `yaml:"path,omitempty"`
+	Window time.Duration `yaml:"window,omitempty"`
+}
+
+// IsAnnotationsFile checks whether the file at the given path (relative to the
+// root of the repository or of the sync path) holds exported annotations
+// rather than a dashboard, i.e. whether it's a JSON file in the annotations'
+// directory.
+func (c *Config) IsAnnotationsFile(filename string) bool {
+	if c.Annotations == nil || !strings.HasSuffix(filename, ".json") {
+		return false
+	}
+
+	return isInDir(filename, c.Annotations.Path)
+}
+
+// HistorySettings contains the settings to export the history of the
+// dashboards. Path is the directory, relative to the clone path (or sync path),
+// containing a directory per dashboard, named after the dashboard's UID, in
+// which each version of the dashboard is stored in a file named after its
+// number. Versions is the number of most recent versions kept per dashboard.
+type HistorySettings struct {
+	Path     string `yaml:"path,omitempty"`
+	Versions int    `yaml:"versions,omitempty"`
+}
+
+// IsHistoryFile checks whether the file at the given path (relative to the
+// root of the repository or of the sync path) describes a past version of a
+// dashboard rather than a dashboard, i.e. whether it's a JSON file in the
+// history's directory (or one of its subdirectories).
+func (c *Config) IsHistoryFile(filename string) bool {
+	if c.History == nil || !strings.HasSuffix(filename, ".json") {
+		return false
+	}
+
+	dir := path.Clean(filepath.ToSlash(c.History.Path))
+	return strings.HasPrefix(path.Clean(filepath.ToSlash(filename)), dir+"/")
+}
+
+// LegacyAlertsSettings contains the settings to export the legacy alerts
+// defined in the dashboards' panels. Path is the directory, relative to the
+// clone path (or sync path), in which the alerts of each dashboard are stored
+// in a file named after the dashboard's slug.
+type LegacyAlertsSettings struct {
+	Path string `yaml:"path,omitempty"`
+}
+
+// IsLegacyAlertsFile checks whether the file at the given path (relative to
+// the root of the repository or of the sync path) holds the exported legacy
+// alerts of a dashboard rather than a dashboard, i.e. whether it's a JSON file
+// in the legacy alerts' directory.
+func (c *Config) IsLegacyAlertsFile(filename string) bool {
+	if c.LegacyAlerts == nil || !strings.HasSuffix(filename, ".json") {
+		return false
+	}
+
+	return isInDir(filename, c.LegacyAlerts.Path)
+}
+
+// isInDir checks whether the file at the given path is directly in the given
+// directory, both paths being relative to the same root.
+func isInDir(filename string, dir string) bool {
+	dir = path.Clean(filepath.ToSlash(dir))
+	return path.Dir(path.Clean(filepath.ToSlash(filename))) == dir
+}
+
+// BudgetsSettings contains the limits dashboards must respect to be pushed to
+// Grafana: the maximum number of panels per dashboard, of queries per panel,
+// and the maximum size of a dashboard's JSON description (in bytes). A limit
+// set to 0 isn't enforced.
+type BudgetsSettings struct {
+	MaxPanels          int `yaml:"max_panels,omitempty"`
+	MaxQueriesPerPanel int `yaml:"max_queries_per_panel,omitempty"`
+	MaxSize            int `yaml:"max_size,omitempty"`
+}
+
+// TagsSettings contains the tags the manager enforces on the dashboards it
+// manages, so they can be identified in Grafana's UI: the tags to add to each
+// dashboard, and the tags to remove from them. The pusher enforces them before
+// pushing the dashboards, and the puller warns about the pulled dashboards which
+// don't respect them.
+type TagsSettings struct {
+	Add    []string `yaml:"add,omitempty"`
+	Remove []string `yaml:"remove,omitempty"`
+}
+
+// Layouts of the dashboards in the repository.
+const (
+	LayoutFlat    = "flat"
+	LayoutFolders = "folders"
+)
+
+// Line endings of the files written by the puller.
+const (
+	LineEndingsLF   = "lf"
+	LineEndingsCRLF = "crlf"
+)
+
+// MetadataSettings lists the files, at the root of the repository (or of the
+// sync path), that hold metadata rather than describe dashboards, and must
+// therefore never be pushed to Grafana. VersionsFile is the name of the file in
+// which the puller stores the versions of the dashboards, and Files lists other
+// such files. FolderFile is the name of the files, in the directories mapped to
+// Grafana folders, describing these folders. It must not be a name a
+// dashboard's file can have, since it's matched in any directory.
+type MetadataSettings struct {
+	VersionsFile string   `yaml:"versions_file,omitempty"`
+	FolderFile   string   `yaml:"folder_file,omitempty"`
+	Files        []string `yaml:"files,omitempty"`
+}
+
+// IsMetadataFile checks whether the file at the given path (relative to the
+// root of the repository or of the sync path) is a metadata file. Only exact
+// paths are matched, so e.g. a dashboard file named "old-versions.json" isn't
+// mistaken for the versions file. Folder files are matched in any directory.
+func (m MetadataSettings) IsMetadataFile(path string) bool {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == m.VersionsFile || filepath.Base(path) == m.FolderFile {
+		return true
+	}
+
+	for _, file := range m.Files {
+		if path == filepath.ToSlash(filepath.Clean(file)) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // GrafanaSettings contains the data required to talk to the Grafana HTTP API.
+// The manager authenticates either with a service account token and/or API
+// keys, or with a username and password (using HTTP basic auth).
+// APIKeys lists additional API keys to fail over to if the service account
+// token or the API key is rejected by the API (e.g. because it expired).
+// KeyExpiryWarning is how long before an API key expires the manager starts
+// warning about it. Maintenance, if set, makes the manager pause while Grafana is
+// under maintenance. RateLimit, if set, limits the rate of the requests the
+// manager sends to the API. Timeout is the maximum duration of a request to the
+// API, after which it is cancelled. OAuth2, if set, makes the manager
+// authenticate with an OAuth2 access token instead (e.g. when Grafana is behind
+// an OAuth2 proxy). APIPath is the path of the HTTP API relative to BaseURL,
+// which defaults to "/api". PullerCredentials and PusherCredentials, if set,
+// replace the credentials above when reading from Grafana (e.g. by the puller)
+// and writing to it (e.g. by the pusher) respectively, so that reading can be
+// done with a viewer-level credential (see ForPuller and ForPusher).
 type GrafanaSettings struct {
-	BaseURL      string `yaml:"base_url"`
-	APIKey       string `yaml:"api_key"`
-	IgnorePrefix string `yaml:"ignore_prefix,omitempty"`
+	BaseURL             string               `yaml:"base_url"`
+	APIKey              string               `yaml:"api_key,omitempty"`
+	APIKeys             []string             `yaml:"api_keys,omitempty"`
+	ServiceAccountToken string               `yaml:"service_account_token,omitempty"`
+	Username            string               `yaml:"username,omitempty"`
+	Password            string               `yaml:"password,omitempty"`
+	KeyExpiryWarning    time.Duration        `yaml:"key_expiry_warning,omitempty"`
+	Maintenance         *MaintenanceSettings `yaml:"maintenance,omitempty"`
+	RateLimit           *RateLimitSettings   `yaml:"rate_limit,omitempty"`
+	Timeout             time.Duration        `yaml:"timeout,omitempty"`
+	OAuth2              *OAuth2Settings      `yaml:"oauth2,omitempty"`
+	APIPath             string               `yaml:"api_path,omitempty"`
+	Socket              string               `yaml:"socket,omitempty"`
+	SSHTunnel           *SSHTunnelSettings   `yaml:"ssh_tunnel,omitempty"`
+	IgnorePrefix        string               `yaml:"ignore_prefix,omitempty"`
+	PullerCredentials   *GrafanaCredentials  `yaml:"puller_credentials,omitempty"`
+	PusherCredentials   *GrafanaCredentials  `yaml:"pusher_credentials,omitempty"`
+}
+
+// GrafanaCredentials contains credentials to authenticate on the Grafana HTTP
+// API with, using one of the authentication methods of the Grafana settings.
+type GrafanaCredentials struct {
+	APIKey              string          `yaml:"api_key,omitempty"`
+	APIKeys             []string        `yaml:"api_keys,omitempty"`
+	ServiceAccountToken string          `yaml:"service_account_token,omitempty"`
+	Username            string          `yaml:"username,omitempty"`
+	Password            string          `yaml:"password,omitempty"`
+	OAuth2              *OAuth2Settings `yaml:"oauth2,omitempty"`
+}
+
+// ForPuller returns the Grafana settings to use when only reading from
+// Grafana (e.g. in the puller): the settings themselves, with the puller's
+// credentials instead of the default ones if they're set.
+func (g *GrafanaSettings) ForPuller() *GrafanaSettings {
+	return g.withCredentials(g.PullerCredentials)
+}
+
+// ForPusher returns the Grafana settings to use when writing to Grafana (e.g.
+// in the pusher): the settings themselves, with the pusher's credentials
+// instead of the default ones if they're set.
+func (g *GrafanaSettings) ForPusher() *GrafanaSettings {
+	return g.withCredentials(g.PusherCredentials)
+}
+
+// withCredentials returns a copy of the settings using the given credentials
+// instead of their own, or the settings themselves if there are no
+// credentials.
+func (g *GrafanaSettings) withCredentials(creds *GrafanaCredentials) *GrafanaSettings {
+	if creds == nil {
+		return g
+	}
+
+	settings := *g
+	settings.APIKey = creds.APIKey
+	settings.APIKeys = creds.APIKeys
+	settings.ServiceAccountToken = creds.ServiceAccountToken
+	settings.Username = creds.Username
+	settings.Password = creds.Password
+	settings.OAuth2 = creds.OAuth2
+	settings.PullerCredentials = nil
+	settings.PusherCredentials = nil
+
+	return &settings
+}
+
+// RateLimitSettings contains the settings of the client-side rate limiting of
+// the requests to the Grafana API. RequestsPerSecond is the sustained rate of
+// requests, and Burst the number of requests that can be sent at once after a
+// quiet period, which defaults to 1.
+type RateLimitSettings struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst,omitempty"`
+}
+
+// OAuth2Settings contains the settings to obtain OAuth2 access tokens using the
+// client credentials grant. TokenURL is the URL of the authorization server's
+// token endpoint, which is requested with the client ID and secret. Scopes and
+// Audience, if set, are sent along with the token requests.
+type OAuth2Settings struct {
+	TokenURL     string   `yaml:"token_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes,omitempty"`
+	Audience     string   `yaml:"audience,omitempty"`
+}
+
+// SSHTunnelSettings contains the settings of the SSH tunnel to reach the
+// Grafana API through, for when it isn't exposed over a routable network.
+// Address is the host and port of the SSH server, which the connections to the
+// Grafana host are opened from, User the user to log in as and PrivateKeyPath
+// the path to the private key to authenticate with. KnownHostsPath is the path
+// to the file listing the keys of the known SSH servers, which defaults to
+// ~/.ssh/known_hosts.
+type SSHTunnelSettings struct {
+	Address        string `yaml:"address"`
+	User           string `yaml:"user"`
+	PrivateKeyPath string `yaml:"private_key"`
+	KnownHostsPath string `yaml:"known_hosts,omitempty"`
+}
+
+// UsesBasicAuth checks whether the manager must authenticate on the Grafana
+// API using HTTP basic auth rather than tokens.
+func (g *GrafanaSettings) UsesBasicAuth() bool {
+	return len(g.Username) > 0
+}
+
+// validate checks that the Grafana settings (and the puller's and the
+// pusher's credentials, if any) use a single authentication method and a
+// single way to reach Grafana, and that the rate limit, if any, is valid.
+// Returns an error if basic auth is used without a username or a password, or
+// OAuth2 without a token URL, a client ID or a client secret, or if either is
+// used along with another authentication method, or if the rate limit or the
+// timeout isn't positive, or if both a Unix socket and an SSH tunnel are set, or
+// if the SSH tunnel lacks an address, a user or a private key.
+func (g *GrafanaSettings) validate() error {
+	if g.Timeout < 0 {
+		return ErrGrafanaInvalidTimeout
+	}
+
+	if g.SSHTunnel != nil {
+		if len(g.Socket) > 0 {
+			return ErrGrafanaDialerConflict
+		}
+
+		if len(g.SSHTunnel.Address) == 0 || len(g.SSHTunnel.User) == 0 ||
+			len(g.SSHTunnel.PrivateKeyPath) == 0 {
+			return ErrGrafanaSSHTunnel
+		}
+	}
+
+	if g.RateLimit != nil {
+		if g.RateLimit.RequestsPerSecond <= 0 || g.RateLimit.Burst < 0 {
+			return ErrGrafanaInvalidRateLimit
+		}
+
+		if g.RateLimit.Burst == 0 {
+			g.RateLimit.Burst = 1
+		}
+	}
+
+	if err := g.validateAuth(); err != nil {
+		return err
+	}
+
+	for _, creds := range []*GrafanaCredentials{g.PullerCredentials, g.PusherCredentials} {
+		if creds == nil {
+			continue
+		}
+
+		if err := g.withCredentials(creds).validateAuth(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateAuth checks that the Grafana settings use a single authentication
+// method.
+// Returns an error if basic auth is used without a username or a password, or
+// OAuth2 without a token URL, a client ID or a client secret, or if either is
+// used along with another authentication method.
+func (g *GrafanaSettings) validateAuth() error {
+	hasTokens := len(g.APIKey) > 0 || len(g.APIKeys) > 0 || len(g.ServiceAccountToken) > 0
+
+	if g.OAuth2 != nil {
+		if len(g.OAuth2.TokenURL) == 0 || len(g.OAuth2.ClientID) == 0 ||
+			len(g.OAuth2.ClientSecret) == 0 {
+			return ErrGrafanaOAuth2
+		}
+
+		if hasTokens || len(g.Username) > 0 || len(g.Password) > 0 {
+			return ErrGrafanaAuthConflict
+		}
+	}
+
+	if len(g.Username) == 0 && len(g.Password) == 0 {
+		return nil
+	}
+
+	if len(g.Username) == 0 || len(g.Password) == 0 {
+		return ErrGrafanaBasicAuth
+	}
+
+	if hasTokens {
+		return ErrGrafanaAuthConflict
+	}
+
+	return nil
+}
+
+// Secrets returns the secret values from the configuration (API keys, webhook
+// secret, forge token...), which must never appear in logs.
+func (c *Config) Secrets() []string {
+	secrets := c.Grafana.secrets()
+
+	if c.Forge != nil {
+		secrets = append(secrets, c.Forge.Token)
+	}
+
+	if c.Pusher != nil {
+		secrets = append(secrets, c.Pusher.Config.Secret)
+
+		for _, target := range c.Pusher.Targets {
+			secrets = append(secrets, target.Grafana.secrets()...)
+		}
+	}
+
+	return secrets
+}
+
+// secrets returns the credentials from the Grafana settings, including the
+// puller's and the pusher's.
+func (g *GrafanaSettings) secrets() []string {
+	secrets := []string{g.APIKey, g.ServiceAccountToken, g.Password}
+	if g.OAuth2 != nil {
+		secrets = append(secrets, g.OAuth2.ClientSecret)
+	}
+
+	secrets = append(secrets, g.APIKeys...)
+
+	for _, creds := range []*GrafanaCredentials{g.PullerCredentials, g.PusherCredentials} {
+		if creds != nil {
+			secrets = append(secrets, g.withCredentials(creds).secrets()...)
+		}
+	}
+
+	return secrets
+}
+
+// MaintenanceSettings contains the settings to handle Grafana maintenance
+// windows, during which syncs are paused instead of failing. Grafana is
+// considered under maintenance if it responds with a 503 status code, or with
+// a body containing Marker (if set). Requests are then retried with an
+// exponential backoff, from InitialBackoff to MaxBackoff, until Grafana is back
+// or MaxWait is reached (if set).
+type MaintenanceSettings struct {
+	Marker         string        `yaml:"marker,omitempty"`
+	InitialBackoff time.Duration `yaml:"initial_backoff,omitempty"`
+	MaxBackoff     time.Duration `yaml:"max_backoff,omitempty"`
+	MaxWait        time.Duration `yaml:"max_wait,omitempty"`
 }
 
 // SimpleSyncSettings contains minimal data on the synchronisation process. It is
@@ -40,13 +759,286 @@ type SimpleSyncSettings struct {
 	SyncPath string `yaml:"sync_path"`
 }
 
+// ScreenshotsSettings contains the settings to render images of the dashboards
+// retrieved by the puller, and store them alongside the dashboards. Path is
+// relative to the sync path (or clone path), and Width and Height are the
+// dimensions of the images, in pixels.
+type ScreenshotsSettings struct {
+	Path   string `yaml:"path,omitempty"`
+	Width  int    `yaml:"width,omitempty"`
+	Height int    `yaml:"height,omitempty"`
+}
+
+// ScreenshotPath returns the path, relative to the sync path (or clone path),
+// of the screenshot of the dashboard described by the file at the given path.
+// Screenshots mirror the directories of the dashboards' files under the
+// screenshots' path, so dashboards with the same slug in different folders
+// don't share a screenshot.
+func (s *ScreenshotsSettings) ScreenshotPath(dashboardFile string) string {
+	return path.Join(s.Path, strings.TrimSuffix(dashboardFile, ".json")+".png")
+}
+
+// ForgeSettings contains the data required to talk to the API of the forge
+// (GitLab or GitHub) hosting the Git repository. Project is the full path of
+// the repository on the forge (e.g. "it/grafana-dashboards").
+type ForgeSettings struct {
+	Type    string `yaml:"type"`
+	BaseURL string `yaml:"base_url,omitempty"`
+	Token   string `yaml:"token"`
+	Project string `yaml:"project"`
+}
+
 // GitSettings contains the data required to interact with the Git repository.
+// ManagerCommits is how the pusher handles commits that look like they were
+// created by the manager: either skip them (the default) or inspect them, i.e.
+// only push the files they change which content differs from Grafana's.
+// Maintenance, if set, makes the pusher run maintenance operations on the clone
+// at a regular interval. The key of the remote's SSH server is verified against
+// HostKey (in the authorized_keys format) if set, else against the keys listed
+// in the file at KnownHostsPath, which defaults to ~/.ssh/known_hosts, unless
+// InsecureIgnoreHostKey is true. Subdirectory, if set, is the directory of the
+// repository the dashboards are synchronised with, relative to its root (see
+// SyncPath). CloneDepth, if positive, limits the clone, and the fetches and
+// pulls following it, to the given number of latest commits of each branch.
+// CommitMessage, if set, is the Go template (see text/template) of the messages
+// of the commits the puller creates when retrieving new versions of dashboards.
+// Attribution, if set, makes the puller attribute its commits to the Grafana
+// users who changed the dashboards. Review, if set, makes the puller push its
+// commits to a dedicated branch and open a merge request for them, rather than
+// pushing them to the checked out branch. SyncTags, if set, makes the puller
+// tag the repository's state after each successful run. OnDivergence is how the
+// clone is recovered when it diverged from the remote, e.g. because the
+// remote's history was rewritten (see the OnDivergence* constants).
 type GitSettings struct {
-	URL            string              `yaml:"url"`
-	User           string              `yaml:"user"`
-	PrivateKeyPath string              `yaml:"private_key"`
-	ClonePath      string              `yaml:"clone_path"`
-	CommitsAuthor  CommitsAuthorConfig `yaml:"commits_author"`
+	URL                   string                  `yaml:"url"`
+	User                  string                  `yaml:"user"`
+	PrivateKeyPath        string                  `yaml:"private_key"`
+	KnownHostsPath        string                  `yaml:"known_hosts,omitempty"`
+	HostKey               string                  `yaml:"host_key,omitempty"`
+	InsecureIgnoreHostKey bool                    `yaml:"insecure_ignore_host_key,omitempty"`
+	ClonePath             string                  `yaml:"clone_path"`
+	Subdirectory          string                  `yaml:"subdirectory,omitempty"`
+	CloneDepth            int                     `yaml:"clone_depth,omitempty"`
+	CommitsAuthor         CommitsAuthorConfig     `yaml:"commits_author"`
+	CommitMessage         string                  `yaml:"commit_message,omitempty"`
+	ManagerCommits        string                  `yaml:"manager_commits,omitempty"`
+	Maintenance           *GitMaintenanceSettings `yaml:"maintenance,omitempty"`
+	Transfer              *GitTransferSettings    `yaml:"transfer,omitempty"`
+	Attribution           *GitAttributionSettings `yaml:"attribution,omitempty"`
+	Review                *GitReviewSettings      `yaml:"review,omitempty"`
+	SyncTags              *GitSyncTagsSettings    `yaml:"sync_tags,omitempty"`
+	OnDivergence          string                  `yaml:"on_divergence,omitempty"`
+}
+
+// GitSyncTagsSettings contains the settings of the tags the puller creates
+// after each successful run. Name is the name of the tags, in which "{time}" is
+// replaced with the time of the run, in UTC (e.g. "sync-{time}", the default).
+type GitSyncTagsSettings struct {
+	Name string `yaml:"name,omitempty"`
+}
+
+// TagName returns the name of the tag created after a run of the puller at the
+// given time. Since Git doesn't allow colons in the names of references, the
+// time's hours, minutes and seconds aren't separated (e.g.
+// "2024-05-01T120000Z").
+func (s *GitSyncTagsSettings) TagName(t time.Time) string {
+	return strings.Replace(s.Name, "{time}", t.UTC().Format("2006-01-02T150405Z"), -1)
+}
+
+// GitAttributionSettings contains the settings of the attribution of the
+// puller's commits to the Grafana users who last changed the dashboards they
+// include. Mode is either "author" (the default), which makes the user the
+// commit's author if there's only one (the manager staying its committer), or
+// "co-authored-by", which keeps the manager as the author and credits the
+// users in Co-authored-by trailers (as does "author" when there are several
+// users). The users' email addresses are looked up on Grafana, and default to
+// their login followed by "@" and EmailDomain, if set, when this fails.
+type GitAttributionSettings struct {
+	Mode        string `yaml:"mode,omitempty"`
+	EmailDomain string `yaml:"email_domain,omitempty"`
+}
+
+// Ways of attributing the puller's commits to Grafana users.
+const (
+	AttributionAuthor    = "author"
+	AttributionCoAuthors = "co-authored-by"
+)
+
+// GitReviewSettings contains the settings of the review of the puller's
+// changes. Branch is the name of the branch the changes are pushed to, in which
+// "{date}" is replaced with the current date (e.g. "grafana-sync/{date}", the
+// default), and which the merge request (or pull request on GitHub) is opened
+// from, to the branch checked out in the clone.
+type GitReviewSettings struct {
+	Branch string `yaml:"branch,omitempty"`
+}
+
+// ReviewBranch returns the name of the branch the puller's changes are pushed
+// to for review on the given date.
+func (r *GitReviewSettings) ReviewBranch(t time.Time) string {
+	return strings.Replace(r.Branch, "{date}", t.Format("2006-01-02"), -1)
+}
+
+// GitMaintenanceSettings contains the settings of the maintenance of the
+// clone, which consists in collecting garbage every Interval, then re-cloning
+// the repository if the clone is still bigger than MaxSize (in bytes, 0 meaning
+// no limit).
+type GitMaintenanceSettings struct {
+	Interval time.Duration `yaml:"interval,omitempty"`
+	MaxSize  int64         `yaml:"max_size,omitempty"`
+}
+
+// GitTransferSettings contains the settings of the transfers with the Git
+// remote (clones, fetches, pulls and pushes). RateLimit is the maximum number
+// of bytes per second to transfer (0 meaning no limit), and Timeout the time
+// after which a transfer is cancelled.
+type GitTransferSettings struct {
+	RateLimit int64         `yaml:"rate_limit,omitempty"`
+	Timeout   time.Duration `yaml:"timeout,omitempty"`
+}
+
+// FolderDir returns the directory of the repository the dashboards from the
+// Grafana folder with the given title are stored in: the directory mapped to the
+// folder in the pusher's settings, if any, else (with the "folders" layout) a
+// directory named after the folder. Returns "." (the root of the repository)
+// for the "General" folder, or with the "flat" layout.
+func (c *Config) FolderDir(title string) string {
+	if len(title) == 0 {
+		return "."
+	}
+
+	if c.Pusher != nil {
+		for dir, target := range c.Pusher.Dirs {
+			if target.Folder == title {
+				return path.Clean(dir)
+			}
+		}
+	}
+
+	if c.Layout != LayoutFolders {
+		return "."
+	}
+
+	// Folders' titles can contain slashes, which can't be used in directories'
+	// names.
+	return strings.Replace(title, "/", "-", -1)
+}
+
+// folderPathPlaceholder matches the placeholders of a template of folder
+// titles: "{dir}" for the whole directory, or "{dir[N]}" for its Nth component
+// (starting from 0, or from the end if N is negative).
+var folderPathPlaceholder = regexp.MustCompile(`\{dir(?:\[(-?[0-9]+)\])?\}`)
+
+// PathFolder returns the title of the Grafana folder the dashboard described
+// in the file with the given name (relative to the root of the repository)
+// must be pushed to according to the pusher's folder_from_path template, i.e.
+// the template with its placeholders replaced with the file's directory or
+// the matching components of it. For example, with the template
+// "Teams / {dir[1]}", the dashboards in "teams/payments/api" are pushed to the
+// "Teams / payments" folder. Returns false if there's no template, if the file
+// is at the root of the repository, if the template references a component
+// the directory doesn't have, or if the directory is mapped to a folder in the
+// pusher's settings, which takes precedence.
+func (c *Config) PathFolder(filename string) (string, bool) {
+	if c.Pusher == nil || len(c.Pusher.FolderFromPath) == 0 {
+		return "", false
+	}
+
+	dir := path.Dir(path.Clean(filepath.ToSlash(filename)))
+	if dir == "." {
+		return "", false
+	}
+
+	for mapped := range c.Pusher.Dirs {
+		if strings.HasPrefix(dir+"/", strings.Trim(mapped, "/")+"/") {
+			return "", false
+		}
+	}
+
+	components := strings.Split(dir, "/")
+	ok := true
+	title := folderPathPlaceholder.ReplaceAllStringFunc(
+		c.Pusher.FolderFromPath, func(placeholder string) string {
+			index := folderPathPlaceholder.FindStringSubmatch(placeholder)[1]
+			if len(index) == 0 {
+				return dir
+			}
+
+			// The index is guaranteed to be a valid integer by the regular
+			// expression.
+			i, _ := strconv.Atoi(index)
+			if i < 0 {
+				i += len(components)
+			}
+
+			if i < 0 || i >= len(components) {
+				ok = false
+				return ""
+			}
+
+			return components[i]
+		},
+	)
+
+	if !ok {
+		return "", false
+	}
+
+	return strings.TrimSpace(title), true
+}
+
+// Ways of handling commits created by the manager.
+const (
+	ManagerCommitsSkip    = "skip"
+	ManagerCommitsInspect = "inspect"
+)
+
+// Ways of recovering a clone which diverged from the remote: failing to pull
+// (the default), resetting the clone to the remote's branch, or removing the
+// clone and cloning the repository again.
+const (
+	OnDivergenceFail    = "fail"
+	OnDivergenceReset   = "reset"
+	OnDivergenceReclone = "reclone"
+)
+
+// SyncPath returns the path of the directory the dashboards are synchronised
+// with: the clone path, or its subdirectory if one is set.
+func (g *GitSettings) SyncPath() string {
+	return filepath.Join(g.ClonePath, filepath.FromSlash(g.Subdirectory))
+}
+
+// SubdirectoryPath returns the path, relative to the subdirectory, of the file
+// of the repository with the given path relative to the repository's root,
+// along with a boolean set to false if the file isn't in the subdirectory.
+// Paths are returned as is if no subdirectory is set.
+func (g *GitSettings) SubdirectoryPath(filename string) (string, bool) {
+	if len(g.Subdirectory) == 0 {
+		return filename, true
+	}
+
+	if !strings.HasPrefix(filename, g.Subdirectory+"/") {
+		return "", false
+	}
+
+	return strings.TrimPrefix(filename, g.Subdirectory+"/"), true
+}
+
+// RepositoryPath returns the path, relative to the repository's root, of the
+// file with the given path relative to the subdirectory, e.g. to stage it in the
+// git index. Paths are returned as is if no subdirectory is set.
+func (g *GitSettings) RepositoryPath(filename string) string {
+	if len(g.Subdirectory) == 0 {
+		return filename
+	}
+
+	return path.Join(g.Subdirectory, filepath.ToSlash(filename))
+}
+
+// InspectsManagerCommits checks whether the pusher must inspect the content of
+// the commits created by the manager instead of skipping them.
+func (g *GitSettings) InspectsManagerCommits() bool {
+	return g != nil && g.ManagerCommits == ManagerCommitsInspect
 }
 
 // CommitsAuthorConfig contains the configuration (name + email address) to use
@@ -70,9 +1062,234 @@ type PusherConfig struct {
 }
 
 // PusherSettings contains the settings to configure the Git->Grafana pusher.
+// Branches maps the name of each branch the pusher watches to the title of the
+// Grafana folder the dashboards from this branch are pushed to (an empty title
+// meaning the "General" folder). Dirs maps directories of the repository to
+// the target of the dashboards they contain, which takes precedence over the
+// branch's folder. Targets lists additional Grafana instances changes are
+// pushed to, concurrently with the main one. Retries is the number of times
+// pushing or deleting a dashboard is retried on a target before giving up.
+// Templating, if set, renders the dashboards as templates before pushing them.
+// BlockIncompatible prevents pushing dashboards which schema is too recent for
+// the Grafana instance, instead of only warning about them. BlockReferenced
+// prevents deleting dashboards which are still referenced by alert rules,
+// playlists, library panels or other dashboards, instead of only warning about
+// them. CheckAlertRules makes the pusher check that the unified alert rules
+// attached to pushed dashboards still point to the right panels, and update
+// them if the panels' IDs changed. AllowAlertRemoval allows pushing or deleting
+// dashboards when this removes legacy alerts from Grafana, which is otherwise
+// refused. Conflicts is the handling of dashboards modified on Grafana since
+// they were last pulled (see the Conflicts* constants). TitleCollisions is the
+// handling of dashboards which title is already used by another dashboard in
+// the folder they're pushed to (see the TitleCollisions* constants).
+// FolderFromPath, if set, is the template of the title of the folder dashboards
+// are pushed to, derived from their files' directories (see PathFolder).
+// Migrations lists the migrations to apply to dashboards before pushing them.
+// DatasourceMapping maps the names or UIDs of data sources referenced by the
+// dashboards to the ones to reference instead when pushing them to the main
+// Grafana instance. Admin, if set, makes the pusher expose an admin API.
+// FailureIssues, if set, makes the pusher open an issue on the forge for the
+// dashboards which repeatedly fail to be pushed. ProvisioningDir, if set, makes
+// the pusher write the dashboards to a provisioning directory instead of
+// pushing them to the main Grafana instance. PruneFolders, if set, makes the
+// pusher delete the folders left empty by the deletion of dashboards.
+// PostPushPull, if false, makes the pusher leave the versions of the dashboards
+// it pushes out of the repository (see PullsAfterPush).
 type PusherSettings struct {
-	Mode   string       `yaml:"sync_mode"`
-	Config PusherConfig `yaml:"config"`
+	Mode              string                   `yaml:"sync_mode"`
+	Config            PusherConfig             `yaml:"config"`
+	Branches          map[string]string        `yaml:"branches,omitempty"`
+	Dirs              map[string]DirTarget     `yaml:"dirs,omitempty"`
+	Ownership         *OwnershipSettings       `yaml:"ownership,omitempty"`
+	Freeze            *FreezeSettings          `yaml:"freeze,omitempty"`
+	Verify            *VerifySettings          `yaml:"verify,omitempty"`
+	Targets           []TargetSettings         `yaml:"targets,omitempty"`
+	Retries           *int                     `yaml:"retries,omitempty"`
+	Templating        *TemplatingSettings      `yaml:"templating,omitempty"`
+	BlockIncompatible bool                     `yaml:"block_incompatible,omitempty"`
+	BlockReferenced   bool                     `yaml:"block_referenced,omitempty"`
+	CheckAlertRules   bool                     `yaml:"check_alert_rules,omitempty"`
+	PruneFolders      *PruneFoldersSettings    `yaml:"prune_folders,omitempty"`
+	AllowAlertRemoval bool                     `yaml:"allow_alert_removal,omitempty"`
+	Conflicts         string                   `yaml:"conflicts,omitempty"`
+	TitleCollisions   string                   `yaml:"title_collisions,omitempty"`
+	FolderFromPath    string                   `yaml:"folder_from_path,omitempty"`
+	Migrations        []string                 `yaml:"migrations,omitempty"`
+	DatasourceMapping map[string]string        `yaml:"datasource_mapping,omitempty"`
+	Admin             *AdminSettings           `yaml:"admin,omitempty"`
+	FailureIssues     *FailureIssuesSettings   `yaml:"failure_issues,omitempty"`
+	ProvisioningDir   *ProvisioningDirSettings `yaml:"provisioning_dir,omitempty"`
+	PostPushPull      *bool                    `yaml:"post_push_pull,omitempty"`
+}
+
+// PullsAfterPush checks whether the pusher must record the versions Grafana
+// returned for the dashboards it pushed in the versions file, and commit and
+// push it, as the puller would, so the puller doesn't consider these versions
+// as changes made on Grafana. This is the default, and can be turned off if the
+// puller isn't used along with the pusher, or runs elsewhere.
+func (p *PusherSettings) PullsAfterPush() bool {
+	return p.PostPushPull == nil || *p.PostPushPull
+}
+
+// Handlings of the dashboards modified on Grafana since they were last pulled,
+// i.e. which version on Grafana is more recent than the one recorded in the
+// versions file, when pushing them: overwriting them without checking (the
+// default), overwriting them with a warning, or refusing to push them.
+const (
+	ConflictsOverwrite = "overwrite"
+	ConflictsWarn      = "warn"
+	ConflictsBlock     = "block"
+)
+
+// Handlings of the dashboards which title is already used by another dashboard
+// (i.e. with another UID) in the folder they're pushed to, which Grafana either
+// rejects or resolves by replacing the other dashboard, depending on its
+// version: pushing them as updates of the other dashboard, pushing them with
+// their UID as a suffix of their title, or refusing to push them (the
+// default).
+const (
+	TitleCollisionsReuse  = "reuse-existing"
+	TitleCollisionsRename = "rename-with-suffix"
+	TitleCollisionsFail   = "fail"
+)
+
+// Migrations that can be applied to dashboards before pushing them, to upgrade
+// legacy dashboards: replacing rows with a grid of panels, graph panels with
+// time series panels, and singlestat panels with stat panels.
+const (
+	MigrationRows       = "rows"
+	MigrationGraph      = "graph"
+	MigrationSinglestat = "singlestat"
+)
+
+// PruneFoldersSettings contains the settings of the deletion of the folders
+// left empty by the deletion of their last dashboard. Keep lists the titles of
+// the folders which must never be deleted, even if they're empty.
+type PruneFoldersSettings struct {
+	Keep []string `yaml:"keep,omitempty"`
+}
+
+// Keeps checks whether the folder with the given title must never be deleted.
+func (p *PruneFoldersSettings) Keeps(title string) bool {
+	for _, kept := range p.Keep {
+		if kept == title {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TemplatingSettings contains the settings to render the JSON descriptions of
+// dashboards as Go templates before pushing them. LeftDelimiter and
+// RightDelimiter delimit the template actions, and default to "{{" and "}}".
+// Variables are the ones used when pushing to the main Grafana instance.
+type TemplatingSettings struct {
+	LeftDelimiter  string            `yaml:"left_delimiter,omitempty"`
+	RightDelimiter string            `yaml:"right_delimiter,omitempty"`
+	Variables      TemplateVariables `yaml:"variables,omitempty"`
+}
+
+// TemplateVariables contains the variables a dashboard's template is rendered
+// with for a given Grafana instance. Env is the name of the environment, which
+// defaults to the name of the target. Datasources maps generic names of data
+// sources to their names on the instance, and Vars contains arbitrary
+// variables. Values contains arbitrary values, which templates can access
+// directly (e.g. "{{ .cluster }}"), and ValuesFile is the path of a YAML file
+// holding more values, which are loaded along with the configuration (the
+// values from Values taking precedence).
+type TemplateVariables struct {
+	Env         string                 `yaml:"env,omitempty"`
+	Datasources map[string]string      `yaml:"datasources,omitempty"`
+	Vars        map[string]string      `yaml:"vars,omitempty"`
+	Values      map[string]interface{} `yaml:"values,omitempty"`
+	ValuesFile  string                 `yaml:"values_file,omitempty"`
+}
+
+// loadValues reads the values from the values file, if any, and merges them
+// with the values from the configuration, which take precedence.
+// Returns an error if the file couldn't be read or parsed.
+func (v *TemplateVariables) loadValues() error {
+	if len(v.ValuesFile) == 0 {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(v.ValuesFile)
+	if err != nil {
+		return fmt.Errorf("Couldn't read the values file %s: %v", v.ValuesFile, err)
+	}
+
+	values := make(map[string]interface{})
+	if err = yaml.Unmarshal(raw, &values); err != nil {
+		return fmt.Errorf("Couldn't parse the values file %s: %v", v.ValuesFile, err)
+	}
+
+	for key, value := range v.Values {
+		values[key] = value
+	}
+
+	v.Values = values
+	return nil
+}
+
+// TargetSettings describes an additional Grafana instance the pusher pushes
+// changes to. Name identifies the target in logs and reports. Variables are
+// the ones dashboards are rendered with when pushing to this target, if
+// templating is enabled. DatasourceMapping maps the names or UIDs of data
+// sources referenced by the dashboards to the ones to reference instead when
+// pushing them to this target.
+type TargetSettings struct {
+	Name              string            `yaml:"name"`
+	Grafana           GrafanaSettings   `yaml:"grafana"`
+	Variables         TemplateVariables `yaml:"variables,omitempty"`
+	DatasourceMapping map[string]string `yaml:"datasource_mapping,omitempty"`
+}
+
+// VerifySettings contains the settings to verify that dashboards pushed to
+// Grafana load correctly. If Render is true, the verification also includes
+// rendering the dashboard as a PNG image with the given dimensions. If
+// RoundTrip is true, it also includes comparing the dashboard as retrieved from
+// Grafana with the pushed one.
+type VerifySettings struct {
+	Render    bool `yaml:"render"`
+	Width     int  `yaml:"width,omitempty"`
+	Height    int  `yaml:"height,omitempty"`
+	RoundTrip bool `yaml:"round_trip,omitempty"`
+}
+
+// DirTarget describes where the dashboards from a directory of the repository
+// are pushed to. Folder is the title of the target Grafana folder. Owners is
+// the list of email addresses of the people allowed to change the dashboards
+// in the directory, if ownership is enforced.
+type DirTarget struct {
+	Folder string   `yaml:"folder"`
+	Owners []string `yaml:"owners,omitempty"`
+}
+
+// OwnershipSettings contains the settings to enforce the ownership of the
+// repository's directories, i.e. to only push changes to dashboards made by
+// their owners. CodeownersFile is the path (relative to the clone path) of an
+// optional CODEOWNERS file to read owners from, in addition to the ones from
+// the directories mapping.
+type OwnershipSettings struct {
+	CodeownersFile string `yaml:"codeowners_file,omitempty"`
+}
+
+// FreezeSettings contains the windows during which the pusher must not apply
+// any change to Grafana. Changes detected during a freeze are queued and
+// applied once the freeze lifts.
+type FreezeSettings struct {
+	Windows []FreezeWindow `yaml:"windows"`
+}
+
+// FreezeWindow describes a period of time during which changes to Grafana are
+// frozen. If Days is empty, Start and End are RFC 3339 timestamps delimiting a
+// one-off window. Else, Start and End are UTC times of day (formatted as
+// "15:04") and the window recurs on each of the given days of the week.
+type FreezeWindow struct {
+	Start string   `yaml:"start"`
+	End   string   `yaml:"end"`
+	Days  []string `yaml:"days,omitempty"`
 }
 
 // Load opens a given configuration file and parses it into an instance of the
@@ -99,9 +1316,331 @@ func Load(filename string) (cfg *Config, err error) {
 		return
 	}
 
+	// Skip the commits created by the manager by default.
+	if cfg.Git != nil {
+		switch cfg.Git.ManagerCommits {
+		case "":
+			cfg.Git.ManagerCommits = ManagerCommitsSkip
+		case ManagerCommitsSkip, ManagerCommitsInspect:
+		default:
+			err = ErrGitInvalidManagerCommits
+			return
+		}
+
+		// Fail to pull into a diverged clone by default.
+		switch cfg.Git.OnDivergence {
+		case "":
+			cfg.Git.OnDivergence = OnDivergenceFail
+		case OnDivergenceFail, OnDivergenceReset, OnDivergenceReclone:
+		default:
+			err = ErrGitInvalidOnDivergence
+			return
+		}
+
+		// Run the maintenance of the clone daily by default.
+		if cfg.Git.Maintenance != nil && cfg.Git.Maintenance.Interval == 0 {
+			cfg.Git.Maintenance.Interval = 24 * time.Hour
+		}
+
+		if t := cfg.Git.Transfer; t != nil && (t.RateLimit < 0 || t.Timeout < 0) {
+			err = ErrGitInvalidTransfer
+			return
+		}
+
+		if cfg.Git.CloneDepth < 0 {
+			err = ErrGitInvalidCloneDepth
+			return
+		}
+
+		// Normalise the subdirectory, so the paths of the files in the
+		// repository can be matched against it.
+		if len(cfg.Git.Subdirectory) > 0 {
+			subdirectory := path.Clean(filepath.ToSlash(cfg.Git.Subdirectory))
+			if path.IsAbs(subdirectory) || subdirectory == ".." || strings.HasPrefix(subdirectory, "../") {
+				err = ErrGitInvalidSubdirectory
+				return
+			}
+
+			if subdirectory == "." {
+				subdirectory = ""
+			}
+
+			cfg.Git.Subdirectory = subdirectory
+		}
+
+		if len(cfg.Git.CommitMessage) > 0 {
+			if _, err = template.New("commit_message").Parse(cfg.Git.CommitMessage); err != nil {
+				err = ErrGitInvalidCommitMessage
+				return
+			}
+		}
+
+		// Make the Grafana users the authors of the puller's commits by
+		// default.
+		if a := cfg.Git.Attribution; a != nil {
+			switch a.Mode {
+			case "":
+				a.Mode = AttributionAuthor
+			case AttributionAuthor, AttributionCoAuthors:
+			default:
+				err = ErrGitInvalidAttribution
+				return
+			}
+		}
+
+		// Opening merge requests requires talking to the forge.
+		if cfg.Git.Review != nil {
+			if cfg.Forge == nil {
+				err = ErrGitReviewNoForge
+				return
+			}
+
+			if len(cfg.Git.Review.Branch) == 0 {
+				cfg.Git.Review.Branch = "grafana-sync/{date}"
+			}
+		}
+
+		// The puller's changes aren't part of the checked out branch until
+		// they're reviewed, so there's no known-good state to tag.
+		if cfg.Git.SyncTags != nil {
+			if cfg.Git.Review != nil {
+				err = ErrGitSyncTagsWithReview
+				return
+			}
+
+			if len(cfg.Git.SyncTags.Name) == 0 {
+				cfg.Git.SyncTags.Name = "sync-{time}"
+			}
+		}
+
+		// The remote's host key can only be verified one way.
+		verifications := 0
+		for _, set := range []bool{
+			len(cfg.Git.KnownHostsPath) > 0,
+			len(cfg.Git.HostKey) > 0,
+			cfg.Git.InsecureIgnoreHostKey,
+		} {
+			if set {
+				verifications++
+			}
+		}
+		if verifications > 1 {
+			err = ErrGitHostKeyConflict
+			return
+		}
+	}
+
+	// Lay the dashboards out at the root of the repository by default.
+	switch cfg.Layout {
+	case "":
+		cfg.Layout = LayoutFlat
+	case LayoutFlat, LayoutFolders:
+	default:
+		err = ErrInvalidLayout
+		return
+	}
+
+	// Write the files with Unix line endings by default.
+	switch cfg.LineEndings {
+	case "":
+		cfg.LineEndings = LineEndingsLF
+	case LineEndingsLF, LineEndingsCRLF:
+	default:
+		err = ErrInvalidLineEndings
+		return
+	}
+
+	// Carry on with the other dashboards after an error by default.
+	switch cfg.ErrorPolicy {
+	case "":
+		cfg.ErrorPolicy = ErrorPolicyContinue
+	case ErrorPolicyContinue, ErrorPolicyFailFast:
+	default:
+		err = ErrInvalidErrorPolicy
+		return
+	}
+
+	// The logs must be written somewhere.
+	if l := cfg.Logging; l != nil && l.DisableStderr && l.Syslog == nil && !l.Journald {
+		err = ErrLoggingNoOutput
+		return
+	}
+
+	// Make sure a single authentication method is used with Grafana.
+	if err = cfg.Grafana.validate(); err != nil {
+		return
+	}
+
+	// Warn about API keys expiring within a week by default.
+	if cfg.Grafana.KeyExpiryWarning == 0 {
+		cfg.Grafana.KeyExpiryWarning = 7 * 24 * time.Hour
+	}
+
+	// Set the default name for the versions file.
+	if len(cfg.Metadata.VersionsFile) == 0 {
+		cfg.Metadata.VersionsFile = "versions.json"
+	}
+
+	// Set the default name for the folders' metadata files. Dashboards' files
+	// are named after their slugs, which never start with a dot, so a
+	// dashboard can't be mistaken for a folder (e.g. if it's titled "Folder").
+	if len(cfg.Metadata.FolderFile) == 0 {
+		cfg.Metadata.FolderFile = ".folder.json"
+	}
+
+	// Set the default path for alert notification channels if they're synced.
+	if cfg.AlertNotifications != nil && len(cfg.AlertNotifications.Path) == 0 {
+		cfg.AlertNotifications.Path = "alert-notifications"
+	}
+
+	// Set the default path for snapshots if they're exported.
+	if cfg.Snapshots != nil && len(cfg.Snapshots.Path) == 0 {
+		cfg.Snapshots.Path = "snapshots"
+	}
+
+	// Set the default paths for library panels and playlists if they're
+	// exported.
+	if cfg.LibraryPanels != nil && len(cfg.LibraryPanels.Path) == 0 {
+		cfg.LibraryPanels.Path = "library-panels"
+	}
+
+	if cfg.Playlists != nil && len(cfg.Playlists.Path) == 0 {
+		cfg.Playlists.Path = "playlists"
+	}
+
+	// Set the default path and number of versions for the history if it's
+	// exported.
+	if cfg.History != nil {
+		if cfg.History.Versions < 0 {
+			err = ErrHistoryInvalidVersions
+			return
+		}
+
+		if len(cfg.History.Path) == 0 {
+			cfg.History.Path = "history"
+		}
+
+		if cfg.History.Versions == 0 {
+			cfg.History.Versions = 10
+		}
+	}
+
+	// Set the default path for legacy alerts if they're exported.
+	if cfg.LegacyAlerts != nil && len(cfg.LegacyAlerts.Path) == 0 {
+		cfg.LegacyAlerts.Path = "alerts"
+	}
+
+	// Set the default path and window for annotations if they're exported.
+	if cfg.Annotations != nil {
+		if len(cfg.Annotations.Path) == 0 {
+			cfg.Annotations.Path = "annotations"
+		}
+
+		if cfg.Annotations.Window == 0 {
+			cfg.Annotations.Window = 7 * 24 * time.Hour
+		}
+	}
+
+	// Paths of fields to strip must not contain empty keys, which would
+	// otherwise silently match nothing.
+	for _, field := range cfg.StripFields {
+		for _, key := range strings.Split(field, ".") {
+			if len(strings.TrimSuffix(key, "[]")) == 0 {
+				err = fmt.Errorf("Invalid field path %q in the strip_fields settings", field)
+				return
+			}
+		}
+	}
+
+	// Only known features can be turned on or off, so a typo doesn't silently
+	// leave a feature in its default state.
+	for name, enabled := range cfg.Features {
+		if _, ok := defaultFeatures[name]; !ok {
+			err = fmt.Errorf("Unknown feature %s in the features settings", name)
+			return
+		}
+
+		if enabled != defaultFeatures[name] {
+			logrus.WithFields(logrus.Fields{
+				"feature": name,
+				"enabled": enabled,
+			}).Info("Feature turned on or off")
+		}
+	}
+
+	// Set the defaults of the provisioning file if it's written, which must
+	// point to the repository.
+	if p := cfg.Provisioning; p != nil {
+		if len(p.DashboardsPath) == 0 {
+			err = ErrProvisioningNoPath
+			return
+		}
+
+		if len(p.File) == 0 {
+			p.File = "provisioning.yaml"
+		}
+
+		if len(p.Name) == 0 {
+			p.Name = "grafana-dashboards-manager"
+		}
+
+		if p.UpdateInterval == 0 {
+			p.UpdateInterval = 30 * time.Second
+		}
+	}
+
+	// Set the default path for screenshots if they're enabled.
+	if cfg.Screenshots != nil && len(cfg.Screenshots.Path) == 0 {
+		cfg.Screenshots.Path = "screenshots"
+	}
+
 	// Since we always compare the prefix against a slug, we need to make sure
 	// the prefix is a slug itself.
 	cfg.Grafana.IgnorePrefix = slug.Make(cfg.Grafana.IgnorePrefix)
+	// Make sure the forge's type is supported.
+	if cfg.Forge != nil && cfg.Forge.Type != "gitlab" && cfg.Forge.Type != "github" {
+		err = ErrForgeInvalidType
+		return
+	}
+
+	// The state must be stored somewhere.
+	if cfg.State != nil && len(cfg.State.Path) == 0 {
+		err = ErrStateNoPath
+		return
+	}
+
+	// Likewise for the cache.
+	if cfg.Cache != nil && len(cfg.Cache.Path) == 0 {
+		err = ErrCacheNoPath
+		return
+	}
+
+	// A tag can't be both added to and removed from the dashboards.
+	if cfg.Tags != nil {
+		for _, tag := range cfg.Tags.Add {
+			for _, removed := range cfg.Tags.Remove {
+				if tag == removed {
+					err = ErrTagsConflict
+					return
+				}
+			}
+		}
+	}
+
+	// The admin API exposes the state, so it can't work without it.
+	if cfg.Pusher != nil && cfg.Pusher.Admin != nil && cfg.State == nil {
+		err = ErrAdminWithoutState
+		return
+	}
+
+	// The consecutive push failures are counted in the state, and the issues
+	// are opened on the forge.
+	if cfg.Pusher != nil && cfg.Pusher.FailureIssues != nil &&
+		(cfg.State == nil || cfg.Forge == nil) {
+		err = ErrFailureIssuesRequirement
+		return
+	}
+
 	// Make sure the pusher's config is valid, as the parser can't do it.
 	err = validatePusherSettings(cfg.Pusher)
 	return
@@ -113,6 +1652,105 @@ func Load(filename string) (cfg *Config, err error) {
 // one of the fields expected to hold a non-zero-value holds the zero-value for
 // its type.
 func validatePusherSettings(cfg *PusherSettings) error {
+	// The pusher's settings are optional.
+	if cfg == nil {
+		return nil
+	}
+
+	// If no branch is specified, only watch master and push its dashboards to
+	// the "General" folder.
+	if len(cfg.Branches) == 0 {
+		cfg.Branches = map[string]string{"master": ""}
+	}
+
+	// Retry failed pushes and deletions twice by default.
+	if cfg.Retries == nil {
+		retries := 2
+		cfg.Retries = &retries
+	}
+
+	if cfg.ProvisioningDir != nil && len(cfg.ProvisioningDir.Path) == 0 {
+		return ErrProvisioningDirNoPath
+	}
+
+	// Open an issue after 3 consecutive failures by default.
+	if cfg.FailureIssues != nil {
+		if cfg.FailureIssues.Threshold == 0 {
+			cfg.FailureIssues.Threshold = 3
+		}
+
+		if cfg.FailureIssues.Threshold < 0 {
+			return ErrInvalidFailureIssues
+		}
+	}
+
+	// Each target must be identifiable in the reports.
+	for i, target := range cfg.Targets {
+		if len(target.Name) == 0 || len(target.Grafana.BaseURL) == 0 {
+			return ErrTargetInvalid
+		}
+
+		if err := cfg.Targets[i].Grafana.validate(); err != nil {
+			return err
+		}
+
+		// The environment of a target defaults to its name.
+		if len(target.Variables.Env) == 0 {
+			cfg.Targets[i].Variables.Env = target.Name
+		}
+
+		if err := cfg.Targets[i].Variables.loadValues(); err != nil {
+			return err
+		}
+	}
+
+	for _, migration := range cfg.Migrations {
+		switch migration {
+		case MigrationRows, MigrationGraph, MigrationSinglestat:
+		default:
+			return ErrPusherInvalidMigration
+		}
+	}
+
+	// Overwrite the dashboards modified on Grafana by default.
+	switch cfg.Conflicts {
+	case "":
+		cfg.Conflicts = ConflictsOverwrite
+	case ConflictsOverwrite, ConflictsWarn, ConflictsBlock:
+	default:
+		return ErrPusherInvalidConflicts
+	}
+
+	// Refuse to push dashboards which title collides with another's by
+	// default.
+	switch cfg.TitleCollisions {
+	case "":
+		cfg.TitleCollisions = TitleCollisionsFail
+	case TitleCollisionsReuse, TitleCollisionsRename, TitleCollisionsFail:
+	default:
+		return ErrPusherInvalidCollisions
+	}
+
+	// Make sure the template of folder titles includes at least one
+	// placeholder, and only ones PathFolder knows about.
+	if len(cfg.FolderFromPath) > 0 {
+		rest := folderPathPlaceholder.ReplaceAllString(cfg.FolderFromPath, "")
+		if !folderPathPlaceholder.MatchString(cfg.FolderFromPath) ||
+			strings.ContainsAny(rest, "{}") {
+			return ErrPusherInvalidFolderPath
+		}
+	}
+
+	if cfg.Templating != nil {
+		if len(cfg.Templating.Variables.Env) == 0 {
+			cfg.Templating.Variables.Env = "default"
+		}
+
+		if err := cfg.Templating.Variables.loadValues(); err != nil {
+			return err
+		}
+	}
+
 	config := cfg.Config
 	var configValid bool
 	switch cfg.Mode {
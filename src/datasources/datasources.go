@@ -0,0 +1,145 @@
+package datasources
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Remap rewrites the references to data sources in a dashboard's JSON
+// description using the given mapping, which maps names or UIDs of data
+// sources to the ones to use instead (e.g. "prod-prometheus" to
+// "staging-prometheus"). References are rewritten in the panels (including the
+// ones in rows and in collapsed row panels) and their queries, in the
+// templating variables and in the annotations. A reference is either a data
+// source's name, or an object identifying it by its UID (Grafana 8.3 and
+// later). References which aren't in the mapping, such as variables (e.g.
+// "${datasource}"), are left alone.
+// Returns the rewritten description along with a boolean set to true if it
+// changed. If it didn't, or if the content isn't a JSON object (e.g. because it
+// describes permissions), the content is returned as is.
+// Returns an error if the content couldn't be parsed or re-encoded.
+func Remap(dashboardJSON []byte, mapping map[string]string) ([]byte, bool, error) {
+	// Decode numbers as json.Number so they're re-encoded as they were,
+	// rather than as floats.
+	decoder := json.NewDecoder(bytes.NewReader(dashboardJSON))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, false, err
+	}
+
+	dashboard, ok := decoded.(map[string]interface{})
+	if !ok {
+		return dashboardJSON, false, nil
+	}
+
+	changed := false
+	for _, holder := range holders(dashboard) {
+		changed = remapReference(holder, mapping) || changed
+	}
+
+	if !changed {
+		return dashboardJSON, false, nil
+	}
+
+	remapped, err := json.Marshal(dashboard)
+	return remapped, true, err
+}
+
+// RemapAll rewrites the references to data sources in the dashboards in the
+// given map, mapping files' names to their contents, using the given mapping,
+// and returns the rewritten dashboards mapped to their files' names.
+// Dashboards that fail to be rewritten are kept unchanged.
+// Logs the dashboards which were rewritten, and the errors encountered.
+func RemapAll(contents map[string][]byte, mapping map[string]string) map[string][]byte {
+	remapped := make(map[string][]byte, len(contents))
+	for filename, content := range contents {
+		r, changed, err := Remap(content, mapping)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("Failed to remap the dashboard's data sources, pushing it as is")
+
+			remapped[filename] = content
+			continue
+		}
+
+		if changed {
+			logrus.WithFields(logrus.Fields{
+				"filename": filename,
+			}).Info("Remapped the dashboard's data sources")
+		}
+
+		remapped[filename] = r
+	}
+
+	return remapped
+}
+
+// holders returns the objects of a decoded dashboard which can hold a
+// reference to a data source in their "datasource" field: its panels, their
+// queries, its templating variables and its annotations.
+func holders(dashboard map[string]interface{}) []map[string]interface{} {
+	panels := objects(dashboard["panels"])
+	for _, row := range objects(dashboard["rows"]) {
+		panels = append(panels, objects(row["panels"])...)
+	}
+
+	for _, panel := range objects(dashboard["panels"]) {
+		panels = append(panels, objects(panel["panels"])...)
+	}
+
+	found := make([]map[string]interface{}, 0, len(panels))
+	for _, panel := range panels {
+		found = append(found, panel)
+		found = append(found, objects(panel["targets"])...)
+	}
+
+	for _, key := range []string{"templating", "annotations"} {
+		if section, ok := dashboard[key].(map[string]interface{}); ok {
+			found = append(found, objects(section["list"])...)
+		}
+	}
+
+	return found
+}
+
+// remapReference rewrites the reference to a data source in the "datasource"
+// field of the given object, in place, if it's in the given mapping.
+// Returns true if the reference was rewritten.
+func remapReference(holder map[string]interface{}, mapping map[string]string) bool {
+	switch ref := holder["datasource"].(type) {
+	case string:
+		if to, ok := mapping[ref]; ok && to != ref {
+			holder["datasource"] = to
+			return true
+		}
+	case map[string]interface{}:
+		uid, _ := ref["uid"].(string)
+		if to, ok := mapping[uid]; ok && to != uid {
+			ref["uid"] = to
+			return true
+		}
+	}
+
+	return false
+}
+
+// objects returns the JSON objects contained in the given decoded JSON array,
+// ignoring other values. Returns an empty slice if the value isn't an array.
+func objects(value interface{}) []map[string]interface{} {
+	array, _ := value.([]interface{})
+
+	objs := make([]map[string]interface{}, 0, len(array))
+	for _, item := range array {
+		if obj, ok := item.(map[string]interface{}); ok {
+			objs = append(objs, obj)
+		}
+	}
+
+	return objs
+}
@@ -0,0 +1,406 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/semantic"
+)
+
+// Statuses of a dashboard in a diff, from the point of view of the first
+// version of the dashboard being replaced by the second one.
+const (
+	StatusAdded   = "added"
+	StatusRemoved = "removed"
+	StatusChanged = "changed"
+)
+
+// ignoredFields lists the fields of a dashboard's JSON description which are
+// left out of the comparison of its settings, either because Grafana sets them
+// itself or because the panels they hold are compared separately.
+var ignoredFields = []string{"id", "version", "panels", "rows"}
+
+// queryFields lists the fields of a query's JSON description which can hold
+// its expression, depending on the type of its data source, in the order in
+// which they're looked up.
+var queryFields = []string{"expr", "rawSql", "query", "target", "expression"}
+
+// DashboardDiff describes the differences between two versions of a
+// dashboard's JSON description. Fields lists the dashboard's settings (i.e. its
+// top-level fields, other than its panels) which differ.
+type DashboardDiff struct {
+	Slug          string      `json:"slug"`
+	Title         string      `json:"title"`
+	Filename      string      `json:"filename,omitempty"`
+	Status        string      `json:"status"`
+	Fields        []string    `json:"fields,omitempty"`
+	PanelsAdded   []string    `json:"panelsAdded,omitempty"`
+	PanelsRemoved []string    `json:"panelsRemoved,omitempty"`
+	PanelsChanged []PanelDiff `json:"panelsChanged,omitempty"`
+}
+
+// PanelDiff describes the differences between two versions of a panel. Fields
+// lists the panel's fields which differ, other than its queries, which are
+// described separately.
+type PanelDiff struct {
+	Panel   string      `json:"panel"`
+	Fields  []string    `json:"fields,omitempty"`
+	Queries []QueryDiff `json:"queries,omitempty"`
+}
+
+// QueryDiff describes a query which was added to, removed from, or changed in
+// a panel, identified by its reference ID. Before and After are the query's
+// expressions (or their whole JSON descriptions if their expression couldn't be
+// found) in each version.
+type QueryDiff struct {
+	RefID  string `json:"refId"`
+	Status string `json:"status"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// Empty checks whether the diff doesn't contain any difference.
+func (d *DashboardDiff) Empty() bool {
+	return d.Status == StatusChanged && len(d.Fields) == 0 && len(d.PanelsAdded) == 0 &&
+		len(d.PanelsRemoved) == 0 && len(d.PanelsChanged) == 0
+}
+
+// Compare computes the semantic differences between two versions of a
+// dashboard's JSON description: the settings which differ, the panels which
+// were added, removed or changed (including the ones nested in collapsed rows
+// or in the rows of older dashboards), and, for changed panels, their fields
+// and queries which differ. Panels are matched by ID, or by title if they don't
+// have an ID or if ignoreLayout is true, in which case the panels which were
+// only moved, resized or renumbered aren't considered changed. The diff's
+// status is StatusChanged, and its other metadata (e.g. slug) is left for the
+// caller to fill.
+// Returns an error if one of the descriptions couldn't be parsed.
+func Compare(before []byte, after []byte, ignoreLayout bool) (d DashboardDiff, err error) {
+	d.Status = StatusChanged
+
+	var oldDashboard, newDashboard map[string]interface{}
+	if err = json.Unmarshal(before, &oldDashboard); err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(after, &newDashboard); err != nil {
+		return
+	}
+
+	d.Title, _ = newDashboard["title"].(string)
+	d.Fields = changedFields(oldDashboard, newDashboard, ignoredFields)
+
+	oldPanels := panels(oldDashboard, ignoreLayout)
+	newPanels := panels(newDashboard, ignoreLayout)
+
+	// Panels which couldn't be matched by key (e.g. because one version
+	// doesn't have IDs) are matched by title, if it's unique.
+	oldByTitle := byTitle(oldPanels, newPanels)
+	newByTitle := byTitle(newPanels, oldPanels)
+	for title, p := range newByTitle {
+		if oldPanel, ok := oldByTitle[title]; ok {
+			delete(oldPanels, oldPanel.key)
+			delete(newPanels, p.key)
+			oldPanels["title:"+title] = oldPanel.panel
+			newPanels["title:"+title] = p.panel
+		}
+	}
+
+	for key, p := range newPanels {
+		oldPanel, ok := oldPanels[key]
+		if !ok {
+			d.PanelsAdded = append(d.PanelsAdded, panelName(p))
+			continue
+		}
+
+		// Queries and nested panels are compared separately.
+		oldFields, newFields := oldPanel, p
+		if ignoreLayout {
+			oldFields, newFields = withoutLayout(oldPanel), withoutLayout(p)
+		}
+
+		panelDiff := PanelDiff{
+			Panel:   panelName(p),
+			Fields:  changedFields(oldFields, newFields, []string{"targets", "panels"}),
+			Queries: diffQueries(oldPanel, p),
+		}
+
+		if len(panelDiff.Fields) > 0 || len(panelDiff.Queries) > 0 {
+			d.PanelsChanged = append(d.PanelsChanged, panelDiff)
+		}
+	}
+
+	for key, p := range oldPanels {
+		if _, ok := newPanels[key]; !ok {
+			d.PanelsRemoved = append(d.PanelsRemoved, panelName(p))
+		}
+	}
+
+	sort.Strings(d.PanelsAdded)
+	sort.Strings(d.PanelsRemoved)
+	sort.Slice(d.PanelsChanged, func(i, j int) bool {
+		return d.PanelsChanged[i].Panel < d.PanelsChanged[j].Panel
+	})
+
+	return
+}
+
+// Text generates a human-readable summary of the given diffs, one dashboard
+// after the other: "+" marks the dashboards, panels and queries only in the
+// second version, "-" the ones only in the first one, and "~" the ones which
+// changed.
+func Text(diffs []DashboardDiff) string {
+	if len(diffs) == 0 {
+		return "No differences.\n"
+	}
+
+	marks := map[string]string{
+		StatusAdded:   "+",
+		StatusRemoved: "-",
+		StatusChanged: "~",
+	}
+
+	var b strings.Builder
+	for _, d := range diffs {
+		fmt.Fprintf(&b, "%s %s (%s)", marks[d.Status], d.Slug, d.Title)
+		if len(d.Filename) > 0 {
+			fmt.Fprintf(&b, " [%s]", d.Filename)
+		}
+		b.WriteString("\n")
+
+		if len(d.Fields) > 0 {
+			fmt.Fprintf(&b, "    settings: %s\n", strings.Join(d.Fields, ", "))
+		}
+
+		for _, p := range d.PanelsAdded {
+			fmt.Fprintf(&b, "    + panel %q\n", p)
+		}
+
+		for _, p := range d.PanelsRemoved {
+			fmt.Fprintf(&b, "    - panel %q\n", p)
+		}
+
+		for _, p := range d.PanelsChanged {
+			fmt.Fprintf(&b, "    ~ panel %q", p.Panel)
+			if len(p.Fields) > 0 {
+				fmt.Fprintf(&b, ": %s", strings.Join(p.Fields, ", "))
+			}
+			b.WriteString("\n")
+
+			for _, q := range p.Queries {
+				switch q.Status {
+				case StatusAdded:
+					fmt.Fprintf(&b, "        + query %s: %s\n", q.RefID, q.After)
+				case StatusRemoved:
+					fmt.Fprintf(&b, "        - query %s: %s\n", q.RefID, q.Before)
+				default:
+					fmt.Fprintf(&b, "        ~ query %s: %s => %s\n", q.RefID, q.Before, q.After)
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(&b, "%d dashboard(s) differ\n", len(diffs))
+	return b.String()
+}
+
+// panels extracts the panels from a decoded dashboard, at its root, nested in
+// its collapsed rows, or inside its rows (for older dashboards), and returns
+// them mapped to their ID (or title if the panel doesn't have an ID, or if
+// ignoreLayout is true, since Grafana can renumber panels when a dashboard is
+// saved). Row panels themselves are included, without the panels they hold.
+func panels(dashboard map[string]interface{}, ignoreLayout bool) map[string]map[string]interface{} {
+	found := objects(dashboard["panels"])
+	for _, row := range objects(dashboard["rows"]) {
+		found = append(found, objects(row["panels"])...)
+	}
+
+	for _, panel := range objects(dashboard["panels"]) {
+		found = append(found, objects(panel["panels"])...)
+	}
+
+	mapped := make(map[string]map[string]interface{}, len(found))
+	for _, panel := range found {
+		id, _ := panel["id"].(float64)
+		title, _ := panel["title"].(string)
+
+		key := fmt.Sprintf("id:%d", int(id))
+		if id == 0 || (ignoreLayout && len(title) > 0) {
+			key = "title:" + title
+		}
+
+		mapped[key] = panel
+	}
+
+	return mapped
+}
+
+// keyedPanel is a decoded panel along with its key in the map it was
+// extracted into.
+type keyedPanel struct {
+	key   string
+	panel map[string]interface{}
+}
+
+// byTitle returns the panels from the given map which key isn't in the other
+// map, mapped to their titles. Panels without a title, or which title isn't
+// unique among these panels, are left out.
+func byTitle(
+	panels map[string]map[string]interface{}, other map[string]map[string]interface{},
+) map[string]keyedPanel {
+	mapped := make(map[string]keyedPanel)
+	duplicates := make(map[string]bool)
+	for key, panel := range panels {
+		title, _ := panel["title"].(string)
+		if _, ok := other[key]; ok || len(title) == 0 {
+			continue
+		}
+
+		if _, ok := mapped[title]; ok {
+			duplicates[title] = true
+		}
+
+		mapped[title] = keyedPanel{key: key, panel: panel}
+	}
+
+	for title := range duplicates {
+		delete(mapped, title)
+	}
+
+	return mapped
+}
+
+// withoutLayout returns a shallow copy of a decoded panel without the fields
+// describing its layout, so the panel itself isn't modified.
+func withoutLayout(panel map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(panel))
+	for field, value := range panel {
+		copied[field] = value
+	}
+
+	semantic.StripLayout(copied)
+	return copied
+}
+
+// diffQueries compares the queries of two versions of a panel, matched by
+// their reference IDs (or their positions if they don't have one).
+func diffQueries(before map[string]interface{}, after map[string]interface{}) []QueryDiff {
+	oldQueries := queries(before)
+	newQueries := queries(after)
+
+	diffs := make([]QueryDiff, 0)
+	for refID, q := range newQueries {
+		oldQuery, ok := oldQueries[refID]
+		if !ok {
+			diffs = append(diffs, QueryDiff{RefID: refID, Status: StatusAdded, After: expression(q)})
+		} else if canonical(oldQuery) != canonical(q) {
+			diffs = append(diffs, QueryDiff{
+				RefID:  refID,
+				Status: StatusChanged,
+				Before: expression(oldQuery),
+				After:  expression(q),
+			})
+		}
+	}
+
+	for refID, q := range oldQueries {
+		if _, ok := newQueries[refID]; !ok {
+			diffs = append(diffs, QueryDiff{RefID: refID, Status: StatusRemoved, Before: expression(q)})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].RefID < diffs[j].RefID
+	})
+
+	return diffs
+}
+
+// queries returns the queries of a decoded panel mapped to their reference IDs,
+// or to their positions (e.g. "#0") if they don't have one.
+func queries(panel map[string]interface{}) map[string]map[string]interface{} {
+	mapped := make(map[string]map[string]interface{})
+	for i, query := range objects(panel["targets"]) {
+		refID, _ := query["refId"].(string)
+		if len(refID) == 0 {
+			refID = fmt.Sprintf("#%d", i)
+		}
+
+		mapped[refID] = query
+	}
+
+	return mapped
+}
+
+// expression returns the expression of a decoded query, or its canonical JSON
+// description if it doesn't have any.
+func expression(query map[string]interface{}) string {
+	for _, field := range queryFields {
+		if expr, ok := query[field].(string); ok && len(expr) > 0 {
+			return expr
+		}
+	}
+
+	return canonical(query)
+}
+
+// changedFields returns the sorted list of the fields which differ between two
+// decoded JSON objects, i.e. which are only in one of them or have different
+// values, leaving out the given fields.
+func changedFields(before map[string]interface{}, after map[string]interface{}, ignored []string) []string {
+	skip := make(map[string]bool, len(ignored))
+	for _, field := range ignored {
+		skip[field] = true
+	}
+
+	fields := make([]string, 0)
+	for field, value := range after {
+		oldValue, ok := before[field]
+		if !skip[field] && (!ok || canonical(oldValue) != canonical(value)) {
+			fields = append(fields, field)
+		}
+	}
+
+	for field := range before {
+		if _, ok := after[field]; !ok && !skip[field] {
+			fields = append(fields, field)
+		}
+	}
+
+	sort.Strings(fields)
+	return fields
+}
+
+// canonical returns the canonical JSON representation of a decoded value.
+// encoding/json sorts the keys of maps.
+func canonical(value interface{}) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// panelName returns a human-readable name for a decoded panel.
+func panelName(panel map[string]interface{}) string {
+	if title, ok := panel["title"].(string); ok && len(title) > 0 {
+		return title
+	}
+
+	id, _ := panel["id"].(float64)
+	return fmt.Sprintf("Panel #%d", int(id))
+}
+
+// objects returns the JSON objects contained in the given decoded JSON array,
+// ignoring other values. Returns an empty slice if the value isn't an array.
+func objects(value interface{}) []map[string]interface{} {
+	array, _ := value.([]interface{})
+
+	objs := make([]map[string]interface{}, 0, len(array))
+	for _, item := range array {
+		if obj, ok := item.(map[string]interface{}); ok {
+			objs = append(objs, obj)
+		}
+	}
+
+	return objs
+}
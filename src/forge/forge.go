@@ -0,0 +1,262 @@
+package forge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Supported forges.
+const (
+	TypeGitLab = "gitlab"
+	TypeGitHub = "github"
+)
+
+// Default base URLs for the forges' APIs, used if none is provided in the
+// configuration.
+const (
+	defaultGitLabBaseURL = "https://gitlab.com"
+	defaultGitHubBaseURL = "https://api.github.com"
+)
+
+// Client implements a client for the API of the forge hosting the Git
+// repository (GitLab or GitHub).
+type Client struct {
+	cfg        *config.ForgeSettings
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient returns a new forge API client from the given settings.
+func NewClient(cfg *config.ForgeSettings) *Client {
+	baseURL := strings.TrimSuffix(cfg.BaseURL, "/")
+	if len(baseURL) == 0 {
+		if cfg.Type == TypeGitHub {
+			baseURL = defaultGitHubBaseURL
+		} else {
+			baseURL = defaultGitLabBaseURL
+		}
+	}
+
+	return &Client{
+		cfg:        cfg,
+		baseURL:    baseURL,
+		httpClient: new(http.Client),
+	}
+}
+
+// CommentOnMergeRequest posts a comment with the given (Markdown) body on the
+// merge request (or pull request on GitHub) identified by the given number.
+// Returns an error if there was an issue performing the request.
+func (c *Client) CommentOnMergeRequest(number int, body string) error {
+	var route string
+	switch c.cfg.Type {
+	case TypeGitLab:
+		route = fmt.Sprintf(
+			"/api/v4/projects/%s/merge_requests/%d/notes",
+			url.PathEscape(c.cfg.Project), number,
+		)
+	case TypeGitHub:
+		route = fmt.Sprintf(
+			"/repos/%s/issues/%d/comments", c.cfg.Project, number,
+		)
+	}
+
+	_, err := c.request("POST", route, map[string]string{"body": body})
+	return err
+}
+
+// OpenMergeRequest opens a merge request (or pull request on GitHub) with the
+// given title and (Markdown) description, to merge the given source branch into
+// the given target branch, unless one is already open for the source branch.
+// Returns the URL of the merge request, and whether it was opened (rather than
+// already open).
+// Returns an error if there was an issue performing the requests or parsing the
+// responses.
+func (c *Client) OpenMergeRequest(
+	source string, target string, title string, description string,
+) (mrURL string, opened bool, err error) {
+	var listRoute, createRoute string
+	var body map[string]interface{}
+	switch c.cfg.Type {
+	case TypeGitLab:
+		project := url.PathEscape(c.cfg.Project)
+		listRoute = fmt.Sprintf(
+			"/api/v4/projects/%s/merge_requests?state=opened&source_branch=%s",
+			project, url.QueryEscape(source),
+		)
+		createRoute = fmt.Sprintf("/api/v4/projects/%s/merge_requests", project)
+		body = map[string]interface{}{
+			"source_branch":        source,
+			"target_branch":        target,
+			"title":                title,
+			"description":          description,
+			"remove_source_branch": true,
+		}
+	case TypeGitHub:
+		// Pull requests are listed by "owner:branch".
+		owner := strings.SplitN(c.cfg.Project, "/", 2)[0]
+		listRoute = fmt.Sprintf(
+			"/repos/%s/pulls?state=open&head=%s",
+			c.cfg.Project, url.QueryEscape(owner+":"+source),
+		)
+		createRoute = fmt.Sprintf("/repos/%s/pulls", c.cfg.Project)
+		body = map[string]interface{}{
+			"head":  source,
+			"base":  target,
+			"title": title,
+			"body":  description,
+		}
+	}
+
+	resp, err := c.request("GET", listRoute, nil)
+	if err != nil {
+		return
+	}
+
+	var open []mergeRequest
+	if err = json.Unmarshal(resp, &open); err != nil {
+		return
+	}
+
+	if len(open) > 0 {
+		return open[0].link(), false, nil
+	}
+
+	if resp, err = c.request("POST", createRoute, body); err != nil {
+		return
+	}
+
+	var created mergeRequest
+	if err = json.Unmarshal(resp, &created); err != nil {
+		return
+	}
+
+	return created.link(), true, nil
+}
+
+// OpenIssue opens an issue with the given title, (Markdown) description and
+// labels.
+// Returns the URL of the issue.
+// Returns an error if there was an issue performing the request or parsing the
+// response.
+func (c *Client) OpenIssue(
+	title string, description string, labels []string,
+) (string, error) {
+	var route string
+	var body map[string]interface{}
+	switch c.cfg.Type {
+	case TypeGitLab:
+		route = fmt.Sprintf(
+			"/api/v4/projects/%s/issues", url.PathEscape(c.cfg.Project),
+		)
+		body = map[string]interface{}{
+			"title":       title,
+			"description": description,
+		}
+		if len(labels) > 0 {
+			body["labels"] = strings.Join(labels, ",")
+		}
+	case TypeGitHub:
+		route = fmt.Sprintf("/repos/%s/issues", c.cfg.Project)
+		body = map[string]interface{}{
+			"title": title,
+			"body":  description,
+		}
+		if len(labels) > 0 {
+			body["labels"] = labels
+		}
+	}
+
+	resp, err := c.request("POST", route, body)
+	if err != nil {
+		return "", err
+	}
+
+	// Issues are described with the same fields as merge requests.
+	var created mergeRequest
+	if err = json.Unmarshal(resp, &created); err != nil {
+		return "", err
+	}
+
+	return created.link(), nil
+}
+
+// mergeRequest represents a merge request (or pull request on GitHub), as
+// returned by the forge's API. GitLab and GitHub respectively describe its URL
+// in the "web_url" and "html_url" fields.
+type mergeRequest struct {
+	WebURL  string `json:"web_url"`
+	HTMLURL string `json:"html_url"`
+}
+
+// link returns the URL of the merge request.
+func (m mergeRequest) link() string {
+	if len(m.WebURL) > 0 {
+		return m.WebURL
+	}
+
+	return m.HTMLURL
+}
+
+// request performs an HTTP request on the forge's API, with a given method,
+// route and body. The body, if not nil, is encoded as JSON.
+// Returns the response body.
+// Returns an error if there was an issue encoding the body, performing the
+// request or reading the response, or if the forge responded with a non-2xx
+// status code.
+func (c *Client) request(method string, route string, body interface{}) ([]byte, error) {
+	logrus.WithFields(logrus.Fields{
+		"forge":  c.cfg.Type,
+		"route":  route,
+		"method": method,
+	}).Info("Querying the forge API")
+
+	// Requests without a body (e.g. GET requests) are sent with an empty one.
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+route, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	if c.cfg.Type == TypeGitHub {
+		req.Header.Add("Authorization", "token "+c.cfg.Token)
+	} else {
+		req.Header.Add("PRIVATE-TOKEN", c.cfg.Token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return respBody, fmt.Errorf(
+			"%s %s returned %d: %s", method, route, resp.StatusCode, respBody,
+		)
+	}
+
+	return respBody, nil
+}
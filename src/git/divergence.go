@@ -0,0 +1,91 @@
+package git
+
+import (
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sirupsen/logrus"
+)
+
+// isDiverged checks whether the given error from pulling from the remote means
+// that the clone diverged from the remote, i.e. that its branch can't be
+// fast-forwarded to the remote's (e.g. because the remote's history was
+// rewritten, or because of local commits which failed to be pushed), or that
+// its work tree contains changes left behind by an interrupted run.
+func isDiverged(err error) bool {
+	return err == gogit.ErrNonFastForwardUpdate || err == gogit.ErrUnstagedChanges
+}
+
+// recoverDivergence recovers the clone from its divergence from the remote,
+// which caused the given error when pulling, as the Git settings require: by
+// resetting it to the remote's branch, or by cloning the repository again.
+// Commits which weren't pushed to the remote, and changes which weren't
+// committed, are lost in both cases. If the settings don't allow recovering
+// the clone, the error is returned as is.
+// Returns an error if there was an issue resetting the clone or cloning the
+// repository again.
+func (r *Repository) recoverDivergence(err error) error {
+	fields := logrus.Fields{
+		"repo":       r.cfg.User + "@" + r.cfg.URL,
+		"clone_path": r.cfg.ClonePath,
+		"error":      err,
+		"recovery":   r.cfg.OnDivergence,
+	}
+
+	switch r.cfg.OnDivergence {
+	case config.OnDivergenceReset:
+		logrus.WithFields(fields).Warn("Clone diverged from the remote, resetting it to the remote's branch")
+		return r.resetToRemote()
+
+	case config.OnDivergenceReclone:
+		logrus.WithFields(fields).Warn("Clone diverged from the remote, cloning the repository again")
+		return r.Reclone()
+
+	default:
+		return err
+	}
+}
+
+// resetToRemote resets the branch checked out in the clone, and its work tree,
+// to the state of the matching branch of the remote, as last fetched, and
+// removes the untracked files from the work tree.
+// Returns an error if there was an issue resolving the branches, resetting the
+// work tree or removing the untracked files.
+func (r *Repository) resetToRemote() error {
+	head, err := r.Repo.Head()
+	if err != nil {
+		return err
+	}
+
+	remote, err := r.Repo.Reference(
+		plumbing.NewRemoteReferenceName("origin", head.Name().Short()), true,
+	)
+	if err != nil {
+		return err
+	}
+
+	w, err := r.Repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	if err = w.Reset(&gogit.ResetOptions{
+		Commit: remote.Hash(),
+		Mode:   gogit.HardReset,
+	}); err != nil {
+		return err
+	}
+
+	if err = w.Clean(&gogit.CleanOptions{Dir: true}); err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"clone_path": r.cfg.ClonePath,
+		"previous":   head.Hash().String(),
+		"head":       remote.Hash().String(),
+	}).Info("Reset the clone to the remote's branch")
+
+	return nil
+}
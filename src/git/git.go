@@ -5,16 +5,18 @@ import (
 	"io/ioutil"
 	"os"
 
-	"config"
-
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/textfile"
+
+	gogit "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
-	gogit "gopkg.in/src-d/go-git.v4"
-	"gopkg.in/src-d/go-git.v4/plumbing"
-	"gopkg.in/src-d/go-git.v4/plumbing/object"
-	"gopkg.in/src-d/go-git.v4/plumbing/storer"
-	"gopkg.in/src-d/go-git.v4/plumbing/transport"
-	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
 )
 
 // Repository represents a Git repository, as an abstraction layer above the
@@ -50,6 +52,12 @@ func NewRepository(cfg *config.GitSettings) (r *Repository, invalidRepo bool, er
 		cfg:  cfg,
 	}
 
+	var rateLimit int64
+	if cfg.Transfer != nil {
+		rateLimit = cfg.Transfer.RateLimit
+	}
+	installTransport(r.transferTimeout(), rateLimit)
+
 	// Load authentication data in the structure instance.
 	err = r.getAuth()
 	return
@@ -102,7 +110,9 @@ func (r *Repository) Sync(dontClone bool) (err error) {
 
 // Push uses a given repository and configuration to push the local history of
 // the said repository to the remote, using an authentication structure instance
-// created from the configuration to authenticate on the remote.
+// created from the configuration to authenticate on the remote. The push is
+// cancelled if it takes longer than the transfer timeout, in which case a
+// TimeoutError is returned.
 // Returns with an error if there was an issue creating the authentication
 // structure instance or pushing to the remote. In the latter case, if the error
 // is a known non-error, doesn't return any error.
@@ -112,8 +122,11 @@ func (r *Repository) Push() (err error) {
 		"clone_path": r.cfg.ClonePath,
 	}).Info("Pushing to the remote")
 
+	ctx, cancel := r.transferContext()
+	defer cancel()
+
 	// Push to remote.
-	if err = r.Repo.Push(&gogit.PushOptions{
+	if err = r.Repo.PushContext(ctx, &gogit.PushOptions{
 		Auth: r.auth,
 	}); err != nil {
 		// Check error against known non-errors.
@@ -124,7 +137,7 @@ func (r *Repository) Push() (err error) {
 		})
 	}
 
-	return err
+	return r.transferError(ctx, "push", err)
 }
 
 // GetLatestCommit retrieves the latest commit from the local Git repository and
@@ -149,6 +162,54 @@ func (r *Repository) GetLatestCommit() (*object.Commit, error) {
 	return r.Repo.CommitObject(hash)
 }
 
+// GetBranchHead fetches the given branch from the remote, and returns the
+// latest commit of the branch as known by the remote. The fetch is cancelled if
+// it takes longer than the transfer timeout, in which case a TimeoutError is
+// returned.
+// Returns an error if there was an issue fetching the branch, or loading its
+// reference or latest commit.
+func (r *Repository) GetBranchHead(branch string) (*object.Commit, error) {
+	refName := plumbing.ReferenceName("refs/remotes/origin/" + branch)
+	refSpec := gitconfig.RefSpec("+refs/heads/" + branch + ":" + refName.String())
+
+	ctx, cancel := r.transferContext()
+	defer cancel()
+
+	// Fetch the branch from the remote.
+	if err := r.Repo.FetchContext(ctx, &gogit.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []gitconfig.RefSpec{refSpec},
+		Auth:       r.auth,
+		Depth:      r.cfg.CloneDepth,
+	}); err != nil {
+		// Check error against known non-errors.
+		err = checkRemoteErrors(err, logrus.Fields{
+			"clone_path": r.cfg.ClonePath,
+			"branch":     branch,
+			"error":      err,
+		})
+
+		if err = r.transferError(ctx, "fetch", err); err != nil {
+			return nil, err
+		}
+	}
+
+	// Load the commit the branch's reference points to.
+	ref, err := r.Repo.Reference(refName, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Repo.CommitObject(ref.Hash())
+}
+
+// GetCommit retrieves the commit with the given hash from the local Git
+// repository and returns it.
+// Returns an error if the commit couldn't be found or loaded.
+func (r *Repository) GetCommit(hash string) (*object.Commit, error) {
+	return r.Repo.CommitObject(plumbing.NewHash(hash))
+}
+
 // Log loads the Git repository's log, with the most recent commit having the
 // given hash.
 // Returns an error if the log couldn't be loaded.
@@ -165,11 +226,12 @@ func (r *Repository) Log(fromHash string) (object.CommitIter, error) {
 // the added/modified files and the removed files are returned in two separated
 // slices, mainly because some features using this function need to load the
 // files' contents afterwards, and this is done differently depending on whether
-// the file was removed or not.
+// the file was removed or not. If the repository's subdirectory is set, only
+// the files it contains are returned, with paths relative to it.
 // "from" refers to the oldest commit of both, and "to" to the latest one.
 // Returns empty slices and no error if both commits have the same hash.
-// Returns an error if there was an issue loading the repository's log, the
-// commits' stats, or retrieving a file from the repository.
+// Returns an error if there was an issue loading the repository's log or the
+// commits' changes (see commitChanges).
 func (r *Repository) GetModifiedAndRemovedFiles(
 	from *object.Commit, to *object.Commit,
 ) (modified []string, removed []string, err error) {
@@ -188,37 +250,85 @@ func (r *Repository) GetModifiedAndRemovedFiles(
 
 	// Iterate over the commits contained in the commit's log.
 	err = iter.ForEach(func(commit *object.Commit) error {
+		// If the current commit is the oldest one requested, break the loop.
+		// This must be checked first, since the oldest commit can have been
+		// made by the manager.
+		if commit.Hash.String() == from.Hash.String() {
+			return storer.ErrStop
+		}
+
 		// If the commit was done by the manager, go to the next iteration.
-		if commit.Author.Email == r.cfg.CommitsAuthor.Email {
+		if SkipsCommit(commit.Message, commit.Author.Email, r.cfg) {
 			return nil
 		}
 
+		// Load the changes made by the current commit.
+		changes, err := commitChanges(commit)
+		if err != nil {
+			return err
+		}
+
+		// Iterate over the files changed by the commit.
+		for _, change := range changes {
+			// Ignore the files outside of the repository's subdirectory.
+			filename, ok := r.cfg.SubdirectoryPath(change.name)
+			if !ok {
+				continue
+			}
+
+			if change.removed {
+				removed = append(removed, filename)
+			} else {
+				modified = append(modified, filename)
+			}
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// GetFilesAuthors takes two commits and returns the email addresses of the
+// authors of the changes made to each file between these two commits, mapped to
+// the files' names (relative to the repository's subdirectory, if set, outside
+// of which files are ignored). Commits made by the manager are ignored.
+// "from" refers to the oldest commit of both, and "to" to the latest one.
+// Returns an error if there was an issue loading the repository's log or the
+// commits' changes (see commitChanges).
+func (r *Repository) GetFilesAuthors(
+	from *object.Commit, to *object.Commit,
+) (authors map[string][]string, err error) {
+	authors = make(map[string][]string)
+
+	iter, err := r.Log(to.Hash.String())
+	if err != nil {
+		return
+	}
+
+	err = iter.ForEach(func(commit *object.Commit) error {
 		// If the current commit is the oldest one requested, break the loop.
+		// This must be checked first, since the oldest commit can have been
+		// made by the manager.
 		if commit.Hash.String() == from.Hash.String() {
 			return storer.ErrStop
 		}
 
-		// Load stats from the current commit.
-		stats, err := commit.Stats()
+		// If the commit was done by the manager, go to the next iteration. This
+		// is the case even if the manager's commits are inspected, since the
+		// manager isn't the author of the changes it commits.
+		if IsManagerCommit(commit.Message, commit.Author.Email, r.cfg) {
+			return nil
+		}
+
+		changes, err := commitChanges(commit)
 		if err != nil {
 			return err
 		}
 
-		// Iterate over the files contained in the commit's stats.
-		for _, stat := range stats {
-			// Try to access the file's content.
-			_, err := commit.File(stat.Name)
-			if err != nil && err != object.ErrFileNotFound {
-				return err
-			}
-
-			// If the content couldn't be retrieved, it means the file was
-			// removed in this commit, else it means that it was either added or
-			// modified.
-			if err == object.ErrFileNotFound {
-				removed = append(removed, stat.Name)
-			} else {
-				modified = append(modified, stat.Name)
+		for _, change := range changes {
+			if filename, ok := r.cfg.SubdirectoryPath(change.name); ok {
+				authors[filename] = append(authors[filename], commit.Author.Email)
 			}
 		}
 
@@ -228,9 +338,64 @@ func (r *Repository) GetModifiedAndRemovedFiles(
 	return
 }
 
+// fileChange describes a file added, modified or removed by a commit.
+type fileChange struct {
+	// Path of the file, relative to the repository's root.
+	name string
+	// Whether the file was removed by the commit.
+	removed bool
+}
+
+// commitChanges returns the files added, modified or removed by the given
+// commit, compared to its first parent (or to an empty tree if it has none).
+// Renames aren't detected (unlike in the commit's stats, which report them
+// with a single "old => new" path), so a renamed file is reported as the
+// removal of its old path and the addition of its new one.
+// Returns an error if there was an issue loading the trees of the commit or of
+// its parent, or comparing them.
+func commitChanges(commit *object.Commit) ([]fileChange, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var parentTree *object.Tree
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+
+		if parentTree, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	diff, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]fileChange, 0, len(diff))
+	for _, change := range diff {
+		// Without rename detection, a change is either an insertion (without
+		// a "from" side), a deletion (without a "to" side), or a modification
+		// of a file at the same path.
+		if len(change.To.Name) == 0 {
+			changes = append(changes, fileChange{name: change.From.Name, removed: true})
+		} else {
+			changes = append(changes, fileChange{name: change.To.Name})
+		}
+	}
+
+	return changes, nil
+}
+
 // GetFilesContentsAtCommit retrieves the state of the repository at a given
 // commit, and returns a map contaning the contents of all files in the repository
-// at this time.
+// at this time, or in its subdirectory if one is set, in which case the files'
+// names are relative to it. The contents are normalised (see
+// textfile.Normalise).
 // Returns an error if there was an issue loading the commit's tree, or loading
 // a file's content.
 func (r *Repository) GetFilesContentsAtCommit(commit *object.Commit) (map[string][]byte, error) {
@@ -249,14 +414,21 @@ func (r *Repository) GetFilesContentsAtCommit(commit *object.Commit) (map[string
 
 	// Iterate over the files.
 	err = files.ForEach(func(file *object.File) error {
+		// Ignore the files outside of the repository's subdirectory.
+		filename, ok := r.cfg.SubdirectoryPath(file.Name)
+		if !ok {
+			return nil
+		}
+
 		// Try to access the file's content at the given commit.
 		content, err = file.Contents()
 		if err != nil {
 			return err
 		}
 
-		// Append the content to the map.
-		filesContents[file.Name] = []byte(content)
+		// Append the content to the map, normalised so files edited on
+		// Windows can be parsed.
+		filesContents[filename] = textfile.Normalise([]byte(content))
 
 		return nil
 	})
@@ -265,9 +437,10 @@ func (r *Repository) GetFilesContentsAtCommit(commit *object.Commit) (map[string
 }
 
 // getAuth returns the authentication structure instance needed to authenticate
-// on the remote, using a given user and private key path.
+// on the remote, using a given user and private key path, and to verify the
+// remote's host key (see setHostKeyCallback).
 // Returns an error if there was an issue reading the private key file or
-// parsing it.
+// parsing it, or setting up the host key verification.
 func (r *Repository) getAuth() error {
 	// Load the private key.
 	privateKey, err := ioutil.ReadFile(r.cfg.PrivateKeyPath)
@@ -282,27 +455,44 @@ func (r *Repository) getAuth() error {
 	}
 
 	r.auth = &gitssh.PublicKeys{User: r.cfg.User, Signer: signer}
-	return nil
+	return setHostKeyCallback(r.auth, r.cfg)
 }
 
-// clone clones a Git repository into a given path, using a given auth.
+// clone clones a Git repository into a given path, using a given auth. The
+// clone is cancelled if it takes longer than the transfer timeout, in which case
+// a TimeoutError is returned.
 // Returns the go-git representation of the Git repository.
 // Returns an error if there was an issue cloning the repository.
 func (r *Repository) clone() (err error) {
-	r.Repo, err = gogit.PlainClone(r.cfg.ClonePath, false, &gogit.CloneOptions{
-		URL:  r.cfg.URL,
-		Auth: r.auth,
+	ctx, cancel := r.transferContext()
+	defer cancel()
+
+	r.Repo, err = gogit.PlainCloneContext(ctx, r.cfg.ClonePath, false, &gogit.CloneOptions{
+		URL:   r.cfg.URL,
+		Auth:  r.auth,
+		Depth: r.cfg.CloneDepth,
 	})
 
+	// Don't leave a partial clone behind, so the next sync clones the
+	// repository again rather than pulling into it.
+	if err = r.transferError(ctx, "clone", err); err != nil {
+		if _, ok := err.(*TimeoutError); ok {
+			os.RemoveAll(r.cfg.ClonePath)
+		}
+	}
+
 	return err
 }
 
 // pull opens the repository located at a given path, and pulls it from the
-// remote using a given auth, in order to be up to date with the remote.
+// remote using a given auth, in order to be up to date with the remote. The
+// pull is cancelled if it takes longer than the transfer timeout, in which case
+// a TimeoutError is returned. If the clone diverged from the remote, it's
+// recovered as the Git settings require (see recoverDivergence).
 // Returns with the go-git representation of the repository.
 // Returns an error if there was an issue opening the repo, getting its work
-// tree or pulling from the remote. In the latter case, if the error is a known
-// non-error, doesn't return any error.
+// tree, pulling from the remote or recovering the clone. In the case of
+// pulling, if the error is a known non-error, doesn't return any error.
 func (r *Repository) pull() error {
 	// Open the repository.
 	repo, err := gogit.PlainOpen(r.cfg.ClonePath)
@@ -316,10 +506,14 @@ func (r *Repository) pull() error {
 		return err
 	}
 
+	ctx, cancel := r.transferContext()
+	defer cancel()
+
 	// Pull from remote.
-	if err = w.Pull(&gogit.PullOptions{
+	if err = w.PullContext(ctx, &gogit.PullOptions{
 		RemoteName: "origin",
 		Auth:       r.auth,
+		Depth:      r.cfg.CloneDepth,
 	}); err != nil {
 		// Check error against known non-errors.
 		err = checkRemoteErrors(err, logrus.Fields{
@@ -328,8 +522,14 @@ func (r *Repository) pull() error {
 		})
 	}
 
+	err = r.transferError(ctx, "pull", err)
+
 	r.Repo = repo
 
+	if isDiverged(err) {
+		return r.recoverDivergence(err)
+	}
+
 	return err
 }
 
@@ -0,0 +1,115 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestGetModifiedAndRemovedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gdm-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, err := gogit.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// commit writes the given files (or removes them if their content is
+	// empty) and commits them as a human would.
+	commit := func(files map[string]string) *object.Commit {
+		for filename, content := range files {
+			if len(content) == 0 {
+				if _, err := w.Remove(filename); err != nil {
+					t.Fatal(err)
+				}
+
+				continue
+			}
+
+			p := filepath.Join(dir, filename)
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Add(filename); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		hash, err := w.Commit("Change dashboards", &gogit.CommitOptions{
+			Author: &object.Signature{Name: "Alice", Email: "alice@example.com", When: time.Now()},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := repo.CommitObject(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return c
+	}
+
+	from := commit(map[string]string{
+		"dashboards/latency.json": `{"title": "Latency"}`,
+		"dashboards/errors.json":  `{"title": "Errors"}`,
+	})
+	// Renaming a file without changing its content.
+	to := commit(map[string]string{
+		"dashboards/latency.json":     "",
+		"dashboards/latency-old.json": `{"title": "Latency"}`,
+	})
+
+	r := &Repository{
+		Repo: repo,
+		cfg: &config.GitSettings{
+			Subdirectory:  "dashboards",
+			CommitsAuthor: config.CommitsAuthorConfig{Email: "gdm@example.com"},
+		},
+	}
+
+	modified, removed, err := r.GetModifiedAndRemovedFiles(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"latency-old.json"}; !reflect.DeepEqual(modified, expected) {
+		t.Errorf("expected modified files %v, got %v", expected, modified)
+	}
+	if expected := []string{"latency.json"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("expected removed files %v, got %v", expected, removed)
+	}
+
+	authors, err := r.GetFilesAuthors(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"latency-old.json": {"alice@example.com"},
+		"latency.json":     {"alice@example.com"},
+	}
+	if !reflect.DeepEqual(authors, expected) {
+		t.Errorf("expected authors %v, got %v", expected, authors)
+	}
+}
@@ -0,0 +1,76 @@
+package git
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// setHostKeyCallback sets how the given authentication structure verifies the
+// key of the Git remote's SSH server, as required by the given settings: the
+// key must be either the pinned one, or one of the remote's keys listed in the
+// known_hosts file (~/.ssh/known_hosts by default), unless the settings
+// explicitly disable the verification. The host key algorithms are restricted
+// to the ones of the keys which are accepted, so that the server doesn't
+// present a key of another type than the one that's known.
+// Returns an error if there was an issue parsing the pinned key or the
+// remote's URL, or reading the known_hosts file.
+func setHostKeyCallback(auth *gitssh.PublicKeys, cfg *config.GitSettings) error {
+	if cfg.InsecureIgnoreHostKey {
+		logrus.WithFields(logrus.Fields{
+			"repo": cfg.User + "@" + cfg.URL,
+		}).Warn("Not verifying the key of the Git remote's SSH server")
+
+		auth.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		return nil
+	}
+
+	if len(cfg.HostKey) > 0 {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cfg.HostKey))
+		if err != nil {
+			return fmt.Errorf("invalid pinned host key: %v", err)
+		}
+
+		auth.HostKeyCallback = ssh.FixedHostKey(key)
+		auth.HostKeyAlgorithms = []string{key.Type()}
+		// RSA keys can be used with signatures using SHA-2 hashes, which are
+		// negotiated as distinct algorithms.
+		if key.Type() == ssh.KeyAlgoRSA {
+			auth.HostKeyAlgorithms = []string{
+				ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA,
+			}
+		}
+
+		return nil
+	}
+
+	knownHostsPath := cfg.KnownHostsPath
+	if len(knownHostsPath) == 0 {
+		knownHostsPath = filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+	}
+
+	db, err := gitssh.NewKnownHostsDb(knownHostsPath)
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := transport.NewEndpoint(cfg.User + "@" + cfg.URL)
+	if err != nil {
+		return err
+	}
+
+	auth.HostKeyCallback = db.HostKeyCallback()
+	auth.HostKeyAlgorithms = db.HostKeyAlgorithms(
+		net.JoinHostPort(endpoint.Host, strconv.Itoa(endpoint.Port)),
+	)
+	return nil
+}
@@ -0,0 +1,37 @@
+package git
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// cloneLocks maps the paths of the local clones to the mutexes serialising the
+// operations on them, since several instances of Repository can be working on
+// the same clone at once (e.g. a webhook handling a push event while the
+// versions of the dashboards pushed when a freeze lifted are committed).
+var (
+	cloneLocks      = make(map[string]*sync.Mutex)
+	cloneLocksMutex sync.Mutex
+)
+
+// LockClone waits until no other operation holds the lock of the local clone
+// at the given path, then takes it, so that operations synchronising the clone,
+// reading its files and committing to it don't interleave with each other's.
+// The lock isn't reentrant, so it must be taken by the callers which start
+// these operations (e.g. a push event's handler) rather than by the functions
+// they call.
+// Returns the function to call to release the lock.
+func LockClone(clonePath string) (unlock func()) {
+	clonePath = filepath.Clean(clonePath)
+
+	cloneLocksMutex.Lock()
+	mutex, ok := cloneLocks[clonePath]
+	if !ok {
+		mutex = new(sync.Mutex)
+		cloneLocks[clonePath] = mutex
+	}
+	cloneLocksMutex.Unlock()
+
+	mutex.Lock()
+	return mutex.Unlock
+}
@@ -0,0 +1,157 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// gcGracePeriod is the minimum age of the objects and packs the garbage
+// collection can remove, so that objects being written aren't removed.
+const gcGracePeriod = time.Hour
+
+// Maintainer runs maintenance operations (garbage collection and, if needed,
+// re-cloning) on the local clone of the repository at a regular interval, so
+// that the clone doesn't fill the disk of long-running deployments.
+type Maintainer struct {
+	cfg     *config.GitMaintenanceSettings
+	lastRun time.Time
+	mutex   sync.Mutex
+}
+
+// NewMaintainer creates a new instance of the Maintainer structure using the
+// maintenance settings from the given Git settings. Returns nil if the settings
+// don't enable maintenance.
+func NewMaintainer(cfg *config.GitSettings) *Maintainer {
+	if cfg.Maintenance == nil {
+		return nil
+	}
+
+	return &Maintainer{
+		cfg:     cfg.Maintenance,
+		lastRun: time.Now(),
+	}
+}
+
+// RunIfDue runs the maintenance operations on the given repository if the
+// interval from the maintenance settings has elapsed since the last run. It is
+// safe to call it on a nil Maintainer, in which case it does nothing.
+// Logs the disk usage of the clone before and after the maintenance.
+// Returns an error if there was an issue computing the disk usage of the
+// clone, collecting garbage or re-cloning the repository.
+func (m *Maintainer) RunIfDue(r *Repository) error {
+	if m == nil {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if time.Since(m.lastRun) < m.cfg.Interval {
+		return nil
+	}
+
+	m.lastRun = time.Now()
+
+	before, err := r.Size()
+	if err != nil {
+		return err
+	}
+
+	if err = r.GC(); err != nil {
+		return err
+	}
+
+	after, err := r.Size()
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"clone_path":   r.cfg.ClonePath,
+		"size_before":  before,
+		"size_after":   after,
+		"max_size":     m.cfg.MaxSize,
+		"next_run_due": m.lastRun.Add(m.cfg.Interval),
+	}).Info("Collected garbage in the Git repository")
+
+	// Garbage collection isn't always enough (e.g. if large files were added
+	// to the history), in which case we start over from a fresh clone.
+	if m.cfg.MaxSize > 0 && after > m.cfg.MaxSize {
+		if err = r.Reclone(); err != nil {
+			return err
+		}
+
+		size, err := r.Size()
+		if err != nil {
+			return err
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"clone_path": r.cfg.ClonePath,
+			"size":       size,
+			"max_size":   m.cfg.MaxSize,
+		}).Warn("Git repository exceeded its maximum size and was cloned again")
+	}
+
+	return nil
+}
+
+// Size computes the disk usage of the clone path, in bytes.
+// Returns an error if there was an issue walking the clone path.
+func (r *Repository) Size() (size int64, err error) {
+	err = filepath.Walk(r.cfg.ClonePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// GC packs the objects reachable from the repository's references, then
+// removes the unreachable loose objects, ignoring objects and packs created
+// during the grace period.
+// Returns an error if there was an issue repacking or pruning the objects.
+func (r *Repository) GC() error {
+	threshold := time.Now().Add(-gcGracePeriod)
+
+	if err := r.Repo.RepackObjects(&gogit.RepackConfig{
+		OnlyDeletePacksOlderThan: threshold,
+	}); err != nil {
+		return err
+	}
+
+	return r.Repo.Prune(gogit.PruneOptions{
+		OnlyObjectsOlderThan: threshold,
+		Handler:              r.Repo.DeleteObject,
+	})
+}
+
+// Reclone removes the clone path and clones the repository into it again.
+// Returns an error if there was an issue removing the clone path or cloning the
+// repository.
+func (r *Repository) Reclone() error {
+	logrus.WithFields(logrus.Fields{
+		"repo":       r.cfg.User + "@" + r.cfg.URL,
+		"clone_path": r.cfg.ClonePath,
+	}).Info("Cloning the Git repository again")
+
+	if err := os.RemoveAll(r.cfg.ClonePath); err != nil {
+		return err
+	}
+
+	return r.clone()
+}
@@ -0,0 +1,121 @@
+package git
+
+import (
+	gogit "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
+)
+
+// CurrentBranch returns the name of the branch checked out in the clone.
+// Returns an error if the clone's HEAD couldn't be resolved.
+func (r *Repository) CurrentBranch() (string, error) {
+	head, err := r.Repo.Head()
+	if err != nil {
+		return "", err
+	}
+
+	return head.Name().Short(), nil
+}
+
+// GetHeadCommit returns the commit the clone's HEAD points to.
+// Returns an error if the clone's HEAD couldn't be resolved, or its commit
+// loaded.
+func (r *Repository) GetHeadCommit() (*object.Commit, error) {
+	head, err := r.Repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Repo.CommitObject(head.Hash())
+}
+
+// IsAheadOfRemote checks whether the branch checked out in the clone has
+// commits which weren't pushed to the remote, i.e. whether it differs from the
+// branch as known by the remote when the clone was last synchronised.
+// Returns an error if the references of the branch couldn't be resolved.
+func (r *Repository) IsAheadOfRemote() (bool, error) {
+	head, err := r.Repo.Head()
+	if err != nil {
+		return false, err
+	}
+
+	remote, err := r.Repo.Reference(
+		plumbing.NewRemoteReferenceName("origin", head.Name().Short()), true,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return head.Hash() != remote.Hash(), nil
+}
+
+// PushToBranch pushes the clone's HEAD to the given branch of the remote,
+// overwriting the branch if it already exists, since it only holds the
+// manager's changes. The push is cancelled if it takes longer than the transfer
+// timeout, in which case a TimeoutError is returned.
+// Returns an error if there was an issue resolving HEAD or pushing to the
+// remote. If the error is a known non-error, doesn't return any error.
+func (r *Repository) PushToBranch(branch string) (err error) {
+	head, err := r.Repo.Head()
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"repo":       r.cfg.User + "@" + r.cfg.URL,
+		"clone_path": r.cfg.ClonePath,
+		"branch":     branch,
+	}).Info("Pushing to a branch of the remote")
+
+	ctx, cancel := r.transferContext()
+	defer cancel()
+
+	refSpec := gitconfig.RefSpec(
+		"+" + head.Name().String() + ":" + plumbing.NewBranchReferenceName(branch).String(),
+	)
+	if err = r.Repo.PushContext(ctx, &gogit.PushOptions{
+		RefSpecs: []gitconfig.RefSpec{refSpec},
+		Auth:     r.auth,
+	}); err != nil {
+		// Check error against known non-errors.
+		err = checkRemoteErrors(err, logrus.Fields{
+			"repo":       r.cfg.User + "@" + r.cfg.URL,
+			"clone_path": r.cfg.ClonePath,
+			"branch":     branch,
+			"error":      err,
+		})
+	}
+
+	return r.transferError(ctx, "push", err)
+}
+
+// ResetToRemote resets the branch checked out in the clone, along with its
+// work tree, to the branch as known by the remote when the clone was last
+// synchronised, dropping the commits which weren't pushed to it.
+// Returns an error if the references of the branch couldn't be resolved, or if
+// there was an issue resetting the work tree.
+func (r *Repository) ResetToRemote() error {
+	head, err := r.Repo.Head()
+	if err != nil {
+		return err
+	}
+
+	remote, err := r.Repo.Reference(
+		plumbing.NewRemoteReferenceName("origin", head.Name().Short()), true,
+	)
+	if err != nil {
+		return err
+	}
+
+	w, err := r.Repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	return w.Reset(&gogit.ResetOptions{
+		Commit: remote.Hash(),
+		Mode:   gogit.HardReset,
+	})
+}
@@ -0,0 +1,32 @@
+package git
+
+import (
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+)
+
+// SyncTrailer is the trailer the manager adds to the message of every commit
+// it creates, so that the pusher doesn't push these commits back to Grafana.
+const SyncTrailer = "Gdm-Sync: true"
+
+// IsManagerCommit checks whether a commit, identified by its message and its
+// author's email address, was created by the manager. This is the case if the
+// message contains the sync trailer, or if the commit's author is the one the
+// manager uses (for commits created before the trailer was introduced).
+func IsManagerCommit(message string, authorEmail string, cfg *config.GitSettings) bool {
+	for _, line := range strings.Split(message, "\n") {
+		if strings.TrimSpace(line) == SyncTrailer {
+			return true
+		}
+	}
+
+	return authorEmail == cfg.CommitsAuthor.Email
+}
+
+// SkipsCommit checks whether the pusher must skip a commit, identified by its
+// message and its author's email address, i.e. whether it was created by the
+// manager and the settings don't require such commits to be inspected.
+func SkipsCommit(message string, authorEmail string, cfg *config.GitSettings) bool {
+	return !cfg.InspectsManagerCommits() && IsManagerCommit(message, authorEmail, cfg)
+}
@@ -0,0 +1,93 @@
+package git
+
+import (
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
+)
+
+// TagHead creates an annotated tag with the given name and message on the
+// commit the clone's HEAD points to, with the manager as its tagger, then
+// pushes it to the remote. The sync trailer is added to the tag's message, so
+// the manager's tags can be told apart. No tag is created if HEAD is already
+// tagged by the manager, since the state of the repository is then already
+// tagged. The push is cancelled if it takes longer than the transfer timeout,
+// in which case a TimeoutError is returned.
+// Returns whether a tag was created.
+// Returns an error if there was an issue resolving HEAD, listing the existing
+// tags, creating the tag or pushing it to the remote. If the error is a known
+// non-error, doesn't return any error.
+func (r *Repository) TagHead(name string, message string) (created bool, err error) {
+	head, err := r.Repo.Head()
+	if err != nil {
+		return
+	}
+
+	tagged, err := r.isTaggedByManager(head.Hash())
+	if err != nil || tagged {
+		return
+	}
+
+	if _, err = r.Repo.CreateTag(name, head.Hash(), &gogit.CreateTagOptions{
+		Tagger: &object.Signature{
+			Name:  r.cfg.CommitsAuthor.Name,
+			Email: r.cfg.CommitsAuthor.Email,
+			When:  time.Now(),
+		},
+		Message: message + "\n\n" + SyncTrailer + "\n",
+	}); err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"repo":       r.cfg.User + "@" + r.cfg.URL,
+		"clone_path": r.cfg.ClonePath,
+		"tag":        name,
+	}).Info("Pushing a tag to the remote")
+
+	ctx, cancel := r.transferContext()
+	defer cancel()
+
+	refName := plumbing.NewTagReferenceName(name).String()
+	if err = r.Repo.PushContext(ctx, &gogit.PushOptions{
+		RefSpecs: []gitconfig.RefSpec{gitconfig.RefSpec(refName + ":" + refName)},
+		Auth:     r.auth,
+	}); err != nil {
+		// Check error against known non-errors.
+		err = checkRemoteErrors(err, logrus.Fields{
+			"repo":       r.cfg.User + "@" + r.cfg.URL,
+			"clone_path": r.cfg.ClonePath,
+			"tag":        name,
+			"error":      err,
+		})
+	}
+
+	return true, r.transferError(ctx, "push", err)
+}
+
+// isTaggedByManager checks whether the commit with the given hash is the target
+// of an annotated tag created by the manager, i.e. which message contains the
+// sync trailer.
+// Returns an error if there was an issue listing the tags.
+func (r *Repository) isTaggedByManager(hash plumbing.Hash) (bool, error) {
+	tags, err := r.Repo.TagObjects()
+	if err != nil {
+		return false, err
+	}
+
+	tagged := false
+	err = tags.ForEach(func(tag *object.Tag) error {
+		if tag.Target == hash && strings.Contains(tag.Message, SyncTrailer) {
+			tagged = true
+		}
+
+		return nil
+	})
+
+	return tagged, err
+}
@@ -0,0 +1,356 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/state"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultTransferTimeout is the time after which a transfer with the remote
+// (clone, fetch, pull or push) is cancelled if the settings don't specify one.
+const defaultTransferTimeout = 10 * time.Minute
+
+// TimeoutError is the error returned when a transfer with the remote was
+// cancelled because it took longer than the transfer timeout. Operation is the
+// Git operation which timed out (e.g. "fetch").
+type TimeoutError struct {
+	Operation string
+	Timeout   time.Duration
+}
+
+// Error implements error.Error().
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("git %s timed out after %s", e.Operation, e.Timeout)
+}
+
+// RecordTimeout records the given error in the state file from the given
+// configuration, if it's a TimeoutError and the state settings are set, so the
+// admin API exposes it in its metrics. Failures to record it are logged.
+func RecordTimeout(cfg *config.Config, err error) {
+	timeoutErr, ok := err.(*TimeoutError)
+	if !ok || cfg.State == nil {
+		return
+	}
+
+	if err = state.RecordGitTimeout(cfg.State.Path, timeoutErr.Operation, time.Now()); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"state": cfg.State.Path,
+		}).Error("Failed to record the Git timeout in the state file")
+	}
+}
+
+// transferTimeout returns the time after which a transfer with the remote is
+// cancelled.
+func (r *Repository) transferTimeout() time.Duration {
+	if r.cfg.Transfer != nil && r.cfg.Transfer.Timeout > 0 {
+		return r.cfg.Transfer.Timeout
+	}
+
+	return defaultTransferTimeout
+}
+
+// transferContext returns a context which is done when the transfer timeout
+// expires, along with the function to call to release its resources once the
+// transfer is over.
+func (r *Repository) transferContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), r.transferTimeout())
+}
+
+// transferError returns a TimeoutError if the given context's deadline was
+// exceeded, whatever the error returned by go-git (which can be e.g. an I/O
+// error from the interrupted connection), and logs it, else returns the given
+// error as is.
+func (r *Repository) transferError(ctx context.Context, operation string, err error) error {
+	if err == nil || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"error":      err,
+		"operation":  operation,
+		"timeout":    r.transferTimeout(),
+		"repo":       r.cfg.User + "@" + r.cfg.URL,
+		"clone_path": r.cfg.ClonePath,
+	}).Error("Transfer with the Git remote timed out")
+
+	return &TimeoutError{Operation: operation, Timeout: r.transferTimeout()}
+}
+
+// errConnectTimeout is returned when connecting to the remote took longer than
+// the transfer timeout.
+var errConnectTimeout = errors.New("Connecting to the Git remote timed out")
+
+// installTransport replaces the transport go-git uses for SSH remotes with one
+// which gives up connecting to the remote (and retrieving its references) after
+// the given timeout, since go-git's contexts only apply once connected, so a
+// hung SSH handshake doesn't block the manager forever. If bytesPerSecond is
+// positive, the transport also limits the rate of the transfers to this number
+// of bytes per second, for both the packfiles received (when cloning, fetching
+// or pulling) and the ones sent (when pushing). go-git doesn't allow the
+// transport to be set per operation, so this applies to all the SSH remotes.
+func installTransport(timeout time.Duration, bytesPerSecond int64) {
+	t := &remoteTransport{Transport: gitssh.DefaultClient, timeout: timeout}
+	if bytesPerSecond > 0 {
+		t.limiter = newByteLimiter(bytesPerSecond)
+	}
+
+	client.InstallProtocol("ssh", t)
+}
+
+// remoteTransport wraps a go-git transport so that connecting to the remote
+// times out, and, if there's a limiter, so that the packfiles of its sessions
+// are read at the rate allowed by the limiter.
+type remoteTransport struct {
+	transport.Transport
+	timeout time.Duration
+	limiter *byteLimiter
+}
+
+// NewUploadPackSession implements transport.Transport.NewUploadPackSession().
+func (t *remoteTransport) NewUploadPackSession(
+	ep *transport.Endpoint, auth transport.AuthMethod,
+) (transport.UploadPackSession, error) {
+	s, err := t.connect(func() (transport.Session, error) {
+		return t.Transport.NewUploadPackSession(ep, auth)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &uploadPackSession{
+		UploadPackSession: s.(transport.UploadPackSession),
+		timeout:           t.timeout,
+		limiter:           t.limiter,
+	}, nil
+}
+
+// NewReceivePackSession implements transport.Transport.NewReceivePackSession().
+func (t *remoteTransport) NewReceivePackSession(
+	ep *transport.Endpoint, auth transport.AuthMethod,
+) (transport.ReceivePackSession, error) {
+	s, err := t.connect(func() (transport.Session, error) {
+		return t.Transport.NewReceivePackSession(ep, auth)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &receivePackSession{
+		ReceivePackSession: s.(transport.ReceivePackSession),
+		timeout:            t.timeout,
+		limiter:            t.limiter,
+	}, nil
+}
+
+// connect opens a session with the remote using the given function, and
+// returns it, unless it takes longer than the timeout, in which case
+// errConnectTimeout is returned and the session is closed once (if ever) it's
+// opened.
+// Returns an error if the session couldn't be opened.
+func (t *remoteTransport) connect(
+	open func() (transport.Session, error),
+) (transport.Session, error) {
+	type result struct {
+		s   transport.Session
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		s, err := open()
+		done <- result{s, err}
+	}()
+
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.s, r.err
+	case <-timer.C:
+		go func() {
+			if r := <-done; r.err == nil {
+				r.s.Close()
+			}
+		}()
+
+		return nil, errConnectTimeout
+	}
+}
+
+// advertisedReferences retrieves the references advertised by the remote
+// through the given session, closing the session if it takes longer than the
+// given timeout, since go-git doesn't take a context for it.
+// Returns an error if the references couldn't be retrieved in time.
+func advertisedReferences(s transport.Session, timeout time.Duration) (*packp.AdvRefs, error) {
+	timer := time.AfterFunc(timeout, func() { s.Close() })
+	refs, err := s.AdvertisedReferences()
+	if !timer.Stop() {
+		return nil, errConnectTimeout
+	}
+
+	return refs, err
+}
+
+// uploadPackSession wraps a git-upload-pack session so that retrieving the
+// remote's references times out, and, if there's a limiter, so that the
+// packfile it receives is read at the rate allowed by the limiter.
+type uploadPackSession struct {
+	transport.UploadPackSession
+	timeout time.Duration
+	limiter *byteLimiter
+}
+
+// AdvertisedReferences implements transport.Session.AdvertisedReferences().
+func (s *uploadPackSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return advertisedReferences(s.UploadPackSession, s.timeout)
+}
+
+// UploadPack implements transport.UploadPackSession.UploadPack().
+func (s *uploadPackSession) UploadPack(
+	ctx context.Context, req *packp.UploadPackRequest,
+) (*packp.UploadPackResponse, error) {
+	resp, err := s.UploadPackSession.UploadPack(ctx, req)
+	if err != nil || s.limiter == nil {
+		return resp, err
+	}
+
+	throttled := packp.NewUploadPackResponseWithPackfile(req, &throttledReader{
+		ctx:     ctx,
+		r:       resp,
+		limiter: s.limiter,
+	})
+	throttled.ShallowUpdate = resp.ShallowUpdate
+	throttled.ServerResponse = resp.ServerResponse
+
+	return throttled, nil
+}
+
+// receivePackSession wraps a git-receive-pack session so that retrieving the
+// remote's references times out, and, if there's a limiter, so that the
+// packfile it sends is read at the rate allowed by the limiter.
+type receivePackSession struct {
+	transport.ReceivePackSession
+	timeout time.Duration
+	limiter *byteLimiter
+}
+
+// AdvertisedReferences implements transport.Session.AdvertisedReferences().
+func (s *receivePackSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return advertisedReferences(s.ReceivePackSession, s.timeout)
+}
+
+// ReceivePack implements transport.ReceivePackSession.ReceivePack().
+func (s *receivePackSession) ReceivePack(
+	ctx context.Context, req *packp.ReferenceUpdateRequest,
+) (*packp.ReportStatus, error) {
+	if req.Packfile != nil && s.limiter != nil {
+		req.Packfile = &throttledReader{
+			ctx:     ctx,
+			r:       req.Packfile,
+			limiter: s.limiter,
+		}
+	}
+
+	return s.ReceivePackSession.ReceivePack(ctx, req)
+}
+
+// throttledReader reads from another reader at the rate allowed by a limiter.
+// Since the data isn't read from the connection faster than that, the remote
+// can't send it faster either.
+type throttledReader struct {
+	ctx     context.Context
+	r       io.ReadCloser
+	limiter *byteLimiter
+}
+
+// Read implements io.Reader.Read(). It reads at most a second's worth of data
+// at once, then waits until the limiter allows the bytes read.
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > t.limiter.rate {
+		p = p[:t.limiter.rate]
+	}
+
+	n, err := t.r.Read(p)
+	if waitErr := t.limiter.wait(t.ctx, n); waitErr != nil && err == nil {
+		err = waitErr
+	}
+
+	return n, err
+}
+
+// Close implements io.Closer.Close().
+func (t *throttledReader) Close() error {
+	return t.r.Close()
+}
+
+// byteLimiter limits the rate of transfers using a token bucket, each token
+// being a byte: the bucket holds up to a second's worth of bytes and is
+// refilled at the given rate.
+type byteLimiter struct {
+	mutex  sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// newByteLimiter returns a new limiter allowing the given number of bytes per
+// second, with a full bucket.
+func newByteLimiter(bytesPerSecond int64) *byteLimiter {
+	return &byteLimiter{
+		rate:   bytesPerSecond,
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until the limiter allows the given number of bytes to be
+// transferred. The bytes are taken from the bucket right away, so concurrent
+// transfers share the rate.
+// Returns an error if the given context is done before the bytes are allowed.
+func (l *byteLimiter) wait(ctx context.Context, n int) error {
+	l.mutex.Lock()
+
+	now := time.Now()
+	rate := float64(l.rate)
+	l.tokens += now.Sub(l.last).Seconds() * rate
+	if l.tokens > rate {
+		l.tokens = rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / rate * float64(time.Second))
+	}
+
+	l.mutex.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
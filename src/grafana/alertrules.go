@@ -0,0 +1,144 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+// Annotations attaching a unified alert rule to a dashboard and one of its
+// panels, so that the alert's state is displayed on it.
+const (
+	AnnotationDashboardUID = "__dashboardUid__"
+	AnnotationPanelID      = "__panelId__"
+)
+
+// AlertRule describes a unified alert rule (Grafana 9 and later), as returned
+// by the alerting provisioning API. RawJSON is the rule's full JSON
+// description, which is sent back as is, except for the annotations, when
+// updating the rule.
+type AlertRule struct {
+	RawJSON     []byte
+	UID         string
+	Title       string
+	Annotations map[string]string
+}
+
+// UnmarshalJSON tells the JSON parser how to unmarshal JSON data into an
+// instance of the AlertRule structure, keeping the rule's full description.
+// Returns an error if there was an issue unmarshalling the JSON.
+func (r *AlertRule) UnmarshalJSON(b []byte) (err error) {
+	var body struct {
+		UID         string            `json:"uid"`
+		Title       string            `json:"title"`
+		Annotations map[string]string `json:"annotations"`
+	}
+	if err = json.Unmarshal(b, &body); err != nil {
+		return
+	}
+
+	r.RawJSON = append([]byte(nil), b...)
+	r.UID = body.UID
+	r.Title = body.Title
+	r.Annotations = body.Annotations
+	return
+}
+
+// DashboardUID returns the UID of the dashboard the alert rule is attached to,
+// or an empty string if it isn't attached to any.
+func (r AlertRule) DashboardUID() string {
+	return r.Annotations[AnnotationDashboardUID]
+}
+
+// PanelID returns the ID of the panel the alert rule is attached to, and false
+// if it isn't attached to any (or the annotation isn't a number).
+func (r AlertRule) PanelID() (int, bool) {
+	id, err := strconv.Atoi(r.Annotations[AnnotationPanelID])
+	return id, err == nil
+}
+
+// Panel describes a panel of a dashboard, as identified by alert rules.
+type Panel struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Type  string `json:"type"`
+}
+
+// GetAlertRules requests the Grafana API for all of the unified alert rules,
+// using the alerting provisioning API (Grafana 9 and later).
+// Returns an error if there was an issue requesting the alert rules or parsing
+// the response body.
+func (c *Client) GetAlertRules(ctx context.Context) (rules []AlertRule, err error) {
+	resp, err := c.request(ctx, "GET", "v1/provisioning/alert-rules", nil)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(resp, &rules)
+	return
+}
+
+// AttachAlertRuleToPanel updates the given alert rule on Grafana so that it's
+// attached to the panel with the given ID, leaving the rest of the rule
+// untouched.
+// Returns an error if there was an issue parsing or encoding the rule's JSON
+// description, or requesting the API.
+func (c *Client) AttachAlertRuleToPanel(
+	ctx context.Context, rule AlertRule, panelID int,
+) error {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(rule.RawJSON, &decoded); err != nil {
+		return err
+	}
+
+	annotations, ok := decoded["annotations"].(map[string]interface{})
+	if !ok {
+		annotations = make(map[string]interface{})
+		decoded["annotations"] = annotations
+	}
+	annotations[AnnotationPanelID] = strconv.Itoa(panelID)
+
+	body, err := json.Marshal(decoded)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.request(
+		ctx, "PUT", "v1/provisioning/alert-rules/"+url.PathEscape(rule.UID), body,
+	)
+	return err
+}
+
+// DashboardPanels returns the panels of the given JSON description of a
+// dashboard, including the ones nested in collapsed rows or in the rows of
+// older dashboards.
+// Returns an error if the description couldn't be parsed.
+func DashboardPanels(dashboardJSON []byte) ([]Panel, error) {
+	type nestingPanel struct {
+		Panel
+		Panels []Panel `json:"panels"`
+	}
+
+	var dashboard struct {
+		Panels []nestingPanel `json:"panels"`
+		Rows   []struct {
+			Panels []Panel `json:"panels"`
+		} `json:"rows"`
+	}
+	if err := json.Unmarshal(dashboardJSON, &dashboard); err != nil {
+		return nil, err
+	}
+
+	panels := make([]Panel, 0)
+	for _, panel := range dashboard.Panels {
+		panels = append(panels, panel.Panel)
+		panels = append(panels, panel.Panels...)
+	}
+
+	for _, row := range dashboard.Rows {
+		panels = append(panels, row.Panels...)
+	}
+
+	return panels, nil
+}
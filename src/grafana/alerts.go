@@ -0,0 +1,138 @@
+package grafana
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// PanelAlert describes a legacy alert (i.e. an alert defined in a dashboard's
+// panel, before Grafana 8's unified alerting), along with the ID and title of
+// the panel it's defined in.
+type PanelAlert struct {
+	PanelID    int             `json:"panelId"`
+	PanelTitle string          `json:"panelTitle"`
+	Alert      json.RawMessage `json:"alert"`
+}
+
+// ExtractLegacyAlerts returns the legacy alerts defined in the panels of the
+// given JSON description of a dashboard, in the order of the panels. This
+// includes the alerts of the panels nested in collapsed rows or in the rows of
+// older dashboards.
+// Returns an error if the description couldn't be parsed.
+func ExtractLegacyAlerts(dashboardJSON []byte) ([]PanelAlert, error) {
+	var dashboard struct {
+		Panels []legacyPanel `json:"panels"`
+		Rows   []struct {
+			Panels []legacyPanel `json:"panels"`
+		} `json:"rows"`
+	}
+	if err := json.Unmarshal(dashboardJSON, &dashboard); err != nil {
+		return nil, err
+	}
+
+	alerts := make([]PanelAlert, 0)
+	collect := func(panels []legacyPanel) {
+		for _, panel := range panels {
+			alerts = append(alerts, panel.alerts()...)
+		}
+	}
+
+	collect(dashboard.Panels)
+	for _, row := range dashboard.Rows {
+		collect(row.Panels)
+	}
+
+	return alerts, nil
+}
+
+// LegacyAlerts returns the names of the legacy alerts defined in the given JSON
+// description of a dashboard, sorted by name. Alerts without a name are named
+// after their panel's title.
+// Returns an error if the description couldn't be parsed.
+func LegacyAlerts(dashboardJSON []byte) ([]string, error) {
+	alerts, err := ExtractLegacyAlerts(dashboardJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(alerts))
+	for _, alert := range alerts {
+		var definition struct {
+			Name string `json:"name"`
+		}
+		if err = json.Unmarshal(alert.Alert, &definition); err != nil {
+			return nil, err
+		}
+
+		if len(definition.Name) > 0 {
+			names = append(names, definition.Name)
+		} else {
+			names = append(names, alert.PanelTitle)
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// DroppedAlerts compares two JSON descriptions of a dashboard, and returns the
+// names of the legacy alerts defined in the first one which aren't defined in
+// the second one anymore, sorted by name. A nil second description means the
+// dashboard is deleted, in which case all of its alerts are dropped.
+// Returns an error if one of the descriptions couldn't be parsed.
+func DroppedAlerts(before []byte, after []byte) ([]string, error) {
+	old, err := LegacyAlerts(before)
+	if err != nil || after == nil {
+		return old, err
+	}
+
+	kept, err := LegacyAlerts(after)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := make(map[string]int)
+	for _, name := range kept {
+		remaining[name]++
+	}
+
+	dropped := make([]string, 0)
+	for _, name := range old {
+		if remaining[name] > 0 {
+			remaining[name]--
+			continue
+		}
+
+		dropped = append(dropped, name)
+	}
+
+	return dropped, nil
+}
+
+// legacyPanel represents the parts of a panel's JSON description describing its
+// legacy alert, if any, and the panels nested in it if it's a collapsed row.
+type legacyPanel struct {
+	ID     int             `json:"id"`
+	Title  string          `json:"title"`
+	Alert  json.RawMessage `json:"alert"`
+	Panels []legacyPanel   `json:"panels"`
+}
+
+// alerts returns the legacy alerts defined in the panel and in the panels
+// nested in it.
+func (p legacyPanel) alerts() []PanelAlert {
+	alerts := make([]PanelAlert, 0)
+	if len(p.Alert) > 0 && string(p.Alert) != "null" {
+		alerts = append(alerts, PanelAlert{
+			PanelID:    p.ID,
+			PanelTitle: p.Title,
+			Alert:      p.Alert,
+		})
+	}
+
+	for _, nested := range p.Panels {
+		alerts = append(alerts, nested.alerts()...)
+	}
+
+	return alerts
+}
@@ -0,0 +1,32 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// GetAnnotations requests the Grafana API for the annotations (of all
+// dashboards, and organisation-wide ones) which time is between the two given
+// times, returning at most the given number of them, and returns their JSON
+// descriptions.
+// Returns an error if there was an issue requesting the annotations or parsing
+// the response body.
+func (c *Client) GetAnnotations(
+	ctx context.Context, from time.Time, to time.Time, limit int,
+) (annotations []json.RawMessage, err error) {
+	query := url.Values{}
+	query.Set("from", strconv.FormatInt(from.UnixNano()/int64(time.Millisecond), 10))
+	query.Set("to", strconv.FormatInt(to.UnixNano()/int64(time.Millisecond), 10))
+	query.Set("limit", strconv.Itoa(limit))
+
+	resp, err := c.request(ctx, "GET", "annotations?"+query.Encode(), nil)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(resp, &annotations)
+	return
+}
@@ -2,125 +2,324 @@ package grafana
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
 
 	"github.com/sirupsen/logrus"
 )
 
 // Client implements a Grafana API client, and contains the instance's base URL
-// and API key, along with an HTTP client used to request the API.
+// and API key (or service account token), along with an HTTP client used to
+// request the API. If several API keys are known, the client fails over to the
+// next one when the current one is rejected by the API. If a username is set,
+// the client authenticates using HTTP basic auth instead, and if OAuth2
+// settings are set, it authenticates with an access token obtained using the
+// client credentials grant, which it renews when it expires.
+// The methods requesting the API take a context, which cancels the ongoing
+// request when it's done. A request is also cancelled if it takes longer than
+// the client's timeout, so a hung connection doesn't block a sync forever.
 type Client struct {
-	BaseURL    string
-	APIKey     string
-	httpClient *http.Client
+	BaseURL      string
+	APIKey       string
+	apiKeys      []string
+	username     string
+	password     string
+	apiPath      string
+	oauth        *tokenSource
+	keyMutex     sync.Mutex
+	maintenance  *config.MaintenanceSettings
+	limiter      *rateLimiter
+	version      string
+	versionMutex sync.Mutex
+	httpClient   *http.Client
 }
 
+// defaultTimeout is the default maximum duration of a request to the Grafana
+// API, including reading the response body.
+const defaultTimeout = time.Minute
+
+// defaultAPIPath is the default path of the HTTP API, relative to the base URL
+// of the Grafana instance.
+const defaultAPIPath = "/api"
+
 // NewClient returns a new Grafana API client from a given base URL and API key.
 func NewClient(baseURL string, apiKey string) (c *Client) {
+	return NewClientWithKeys(baseURL, []string{apiKey})
+}
+
+// NewClientWithKeys returns a new Grafana API client from a given base URL and
+// list of API keys. The first key is used until it is rejected by the API, in
+// which case the client fails over to the next one.
+func NewClientWithKeys(baseURL string, apiKeys []string) (c *Client) {
 	// Grafana doesn't support double slashes in the API routes, so we strip the
-	// last slash if there's one, because request() will append one anyway.
-	if strings.HasSuffix(baseURL, "/") {
-		baseURL = baseURL[:len(baseURL)-1]
+	// trailing slashes if there are any, because the routes requested start
+	// with one anyway. This also applies to instances served under a sub-path
+	// (e.g. "https://host/monitoring/grafana/").
+	baseURL = strings.TrimRight(baseURL, "/")
+
+	var apiKey string
+	if len(apiKeys) > 0 {
+		apiKey = apiKeys[0]
 	}
 
 	return &Client{
 		BaseURL:    baseURL,
 		APIKey:     apiKey,
-		httpClient: new(http.Client),
+		apiKeys:    apiKeys,
+		apiPath:    defaultAPIPath,
+		httpClient: &http.Client{Timeout: defaultTimeout},
 	}
 }
 
+// NewClientWithBasicAuth returns a new Grafana API client from a given base URL,
+// username and password, which authenticates using HTTP basic auth.
+func NewClientWithBasicAuth(baseURL string, username string, password string) (c *Client) {
+	c = NewClientWithKeys(baseURL, nil)
+	c.username = username
+	c.password = password
+	return
+}
+
+// NewClientFromConfig returns a new Grafana API client from the given Grafana
+// settings. The service account token, if any, is used first, then the API
+// keys, unless basic auth or OAuth2 is configured.
+func NewClientFromConfig(cfg *config.GrafanaSettings) (c *Client) {
+	if cfg.UsesBasicAuth() {
+		c = NewClientWithBasicAuth(cfg.BaseURL, cfg.Username, cfg.Password)
+	} else if cfg.OAuth2 != nil {
+		c = NewClientWithKeys(cfg.BaseURL, nil)
+		c.oauth = newTokenSource(cfg.OAuth2, c.httpClient)
+	} else {
+		apiKeys := make([]string, 0)
+		for _, key := range []string{cfg.ServiceAccountToken, cfg.APIKey} {
+			if len(key) > 0 {
+				apiKeys = append(apiKeys, key)
+			}
+		}
+
+		apiKeys = append(apiKeys, cfg.APIKeys...)
+		c = NewClientWithKeys(cfg.BaseURL, apiKeys)
+	}
+
+	if cfg.Timeout > 0 {
+		c.httpClient.Timeout = cfg.Timeout
+	}
+
+	if len(cfg.Socket) > 0 || cfg.SSHTunnel != nil {
+		// Use a separate HTTP client so the OAuth2 token requests, if any,
+		// still reach the authorization server directly.
+		c.httpClient = &http.Client{
+			Timeout:   c.httpClient.Timeout,
+			Transport: newTransport(cfg, c.httpClient.Timeout),
+		}
+	}
+
+	if len(cfg.APIPath) > 0 {
+		c.apiPath = ""
+		if apiPath := strings.Trim(cfg.APIPath, "/"); len(apiPath) > 0 {
+			c.apiPath = "/" + apiPath
+		}
+	}
+
+	c.maintenance = cfg.Maintenance
+	if cfg.RateLimit != nil {
+		c.limiter = newRateLimiter(cfg.RateLimit)
+	}
+
+	return
+}
+
 // request preforms an HTTP request on a given endpoint, with a given method and
 // body. The endpoint is the Grafana API route to request, without the "/api/"
-// part. If the request doesn't require a body, the function has to be called
-// with "nil" as the "body" parameter.
+// part (or the client's API path, if it was changed). If the request doesn't
+// require a body, the function has to be called with "nil" as the "body"
+// parameter. The request is cancelled if the given context is done, or if it
+// takes longer than the client's timeout.
 // Returns the response body (as a []byte containing JSON data).
 // Returns an error if there was an issue initialising the request, performing
 // it or reading the response body. Also returns an error on non-200 response
-// status codes. If the status code is 404, a standard error is returned, if the
-// status code is neither 200 nor 404 an error of type httpUnkownError is
-// returned.
-func (c *Client) request(method string, endpoint string, body []byte) ([]byte, error) {
-	route := "/api/" + endpoint
+// status codes, typed after the status code (e.g. NotFoundError for a 404, see
+// newAPIError).
+func (c *Client) request(
+	ctx context.Context, method string, endpoint string, body []byte,
+) ([]byte, error) {
+	return c.requestRoute(ctx, method, c.apiPath+"/"+endpoint, body)
+}
 
-	logrus.WithFields(logrus.Fields{
+// requestRoute works the same way as request, except the route it is given is
+// the full path to request on the Grafana instance, relative to its base URL
+// (e.g. "/api/search"). This is useful to request routes that aren't part of
+// the HTTP API, such as the rendering ones.
+func (c *Client) requestRoute(
+	ctx context.Context, method string, route string, body []byte,
+) ([]byte, error) {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"route":  route,
 		"method": method,
 	}).Info("Querying the Grafana HTTP API")
 
 	url := c.BaseURL + route
 
-	// Create the request
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+	statusCode, respBody, err := c.do(ctx, method, url, route, body)
 	if err != nil {
 		return nil, err
 	}
 
-	// Add the API key to the request as an Authorization HTTP header
-	authHeader := fmt.Sprintf("Bearer %s", c.APIKey)
-	req.Header.Add("Authorization", authHeader)
-
-	// If the request isn't a GET, the body will be sent as JSON, so we need to
-	// append the appropriate header
-	if method != "GET" {
-		req.Header.Add("Content-Type", "application/json")
+	// If Grafana is under maintenance, wait for it to be back before
+	// continuing.
+	if c.maintenance != nil && c.inMaintenance(statusCode, respBody) {
+		if statusCode, respBody, err = c.waitForMaintenance(ctx, method, url, route, body); err != nil {
+			return nil, err
+		}
 	}
 
-	// Perform the request
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
+	// Return an error if the Grafana API responded with a non-200 status code,
+	// typed after the status code (see newAPIError). We perform this here
+	// because http.Client.Do() doesn't return with an error on non-200 status
+	// codes.
+	if statusCode != http.StatusOK {
+		err = newAPIError(url, statusCode, respBody)
 	}
 
-	logrus.WithFields(logrus.Fields{
-		"route":  route,
-		"method": method,
-		"code":   resp.StatusCode,
-	}).Info("The Grafana HTTP API responded")
+	// Return the response body along with the error. This allows callers to
+	// process the errors using fields of the response body which aren't part
+	// of the error (e.g. the status of a dashboard update).
+	return respBody, err
+}
 
-	// Read the response body
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+// do performs an HTTP request on a given URL, with a given method and body,
+// failing over to the next API key if the current one is rejected by the API,
+// or retrying once with a new OAuth2 access token if the current one is.
+// Returns the response's status code and body.
+// Returns an error if there was an issue initialising the request, performing
+// it or reading the response body.
+func (c *Client) do(
+	ctx context.Context, method string, url string, route string, body []byte,
+) (statusCode int, respBody []byte, err error) {
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		apiKey := c.currentAPIKey()
+		if c.oauth != nil {
+			if apiKey, err = c.oauth.get(ctx); err != nil {
+				return 0, nil, fmt.Errorf("failed to obtain an OAuth2 access token: %v", err)
+			}
+		}
+
+		// Wait for the rate limit, if any, to allow the request.
+		if c.limiter != nil {
+			if err = c.limiter.wait(ctx); err != nil {
+				return 0, nil, err
+			}
+		}
+
+		// Create the request
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(body))
+		if err != nil {
+			return 0, nil, err
+		}
 
-	// Return an error if the Grafana API responded with a non-200 status code.
-	// We perform this here because http.Client.Do() doesn't return with an
-	// error on non-200 status codes.
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusNotFound {
-			err = fmt.Errorf("%s not found (404)", url)
+		// Add the credentials to the request, either as basic auth or with
+		// the API key (or OAuth2 access token) as an Authorization HTTP header
+		if len(c.username) > 0 {
+			req.SetBasicAuth(c.username, c.password)
 		} else {
-			// Return an httpUnkownError error if the status code is neither 200
-			// nor 404
-			err = newHttpUnknownError(resp.StatusCode)
+			authHeader := fmt.Sprintf("Bearer %s", apiKey)
+			req.Header.Add("Authorization", authHeader)
 		}
+
+		// If the request isn't a GET, the body will be sent as JSON, so we need to
+		// append the appropriate header
+		if method != "GET" {
+			req.Header.Add("Content-Type", "application/json")
+		}
+
+		// Objects changed through the alerting provisioning API can't be
+		// edited from Grafana's UI anymore, unless provenance is disabled.
+		if strings.HasPrefix(route, c.apiPath+"/v1/provisioning/") {
+			req.Header.Add("X-Disable-Provenance", "true")
+		}
+
+		// Perform the request
+		resp, err = c.httpClient.Do(req)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"route":  route,
+			"method": method,
+			"code":   resp.StatusCode,
+		}).Info("The Grafana HTTP API responded")
+
+		rejected := resp.StatusCode == http.StatusUnauthorized ||
+			resp.StatusCode == http.StatusForbidden
+
+		// The access token might have been revoked before its expiry, in
+		// which case a new one is requested.
+		if rejected && c.oauth != nil && attempt == 0 {
+			c.oauth.invalidate(apiKey)
+			resp.Body.Close()
+			continue
+		}
+
+		if !rejected || attempt >= len(c.apiKeys)-1 || !c.failOver(ctx, apiKey) {
+			break
+		}
+
+		resp.Body.Close()
 	}
+	defer resp.Body.Close()
 
-	// Return the response body along with the error. This allows callers to
-	// process httpUnkownError errors by displaying an error message located in
-	// the response body along with the data contained in the error.
-	return respBody, err
+	// Read the response body
+	respBody, err = ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, respBody, err
 }
 
-// httpUnkownError represents an HTTP error, created from an HTTP response where
-// the status code is neither 200 nor 404.
-type httpUnkownError struct {
-	StatusCode int
+// currentAPIKey returns the API key currently in use.
+func (c *Client) currentAPIKey() string {
+	c.keyMutex.Lock()
+	defer c.keyMutex.Unlock()
+
+	return c.APIKey
 }
 
-// newHttpUnknownError creates and returns a new httpUnkownError error using
-// the provided status code.
-func newHttpUnknownError(statusCode int) *httpUnkownError {
-	return &httpUnkownError{
-		StatusCode: statusCode,
+// failOver switches to the API key following the given one (which has been
+// rejected by the API) in the list of known keys, and logs a warning about it.
+// If another request already switched to another key in the meantime, it
+// doesn't switch again.
+// Returns false if there is no other key to switch to.
+func (c *Client) failOver(ctx context.Context, rejectedKey string) bool {
+	c.keyMutex.Lock()
+	defer c.keyMutex.Unlock()
+
+	if len(c.apiKeys) < 2 {
+		return false
 	}
-}
 
-// Error implements error.Error().
-func (e *httpUnkownError) Error() string {
-	return fmt.Sprintf("Unknown HTTP error: %d", e.StatusCode)
+	if c.APIKey != rejectedKey {
+		return true
+	}
+
+	var index int
+	for i, key := range c.apiKeys {
+		if key == rejectedKey {
+			index = i
+		}
+	}
+
+	c.APIKey = c.apiKeys[(index+1)%len(c.apiKeys)]
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"rejected_key_index": index,
+		"new_key_index":      (index + 1) % len(c.apiKeys),
+	}).Warn("API key rejected by Grafana, failing over to the next one")
+
+	return true
 }
@@ -1,16 +1,34 @@
 package grafana
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
-	"grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+
+	"github.com/sirupsen/logrus"
 )
 
+// ErrVersionMismatch is the error returned when a dashboard isn't updated
+// because its version on Grafana isn't the one expected.
+var ErrVersionMismatch = errors.New("The dashboard's version on Grafana changed")
+
+// searchPageSize is the number of results requested per page when searching
+// for dashboards, which is Grafana's default limit.
+const searchPageSize = 1000
+
 // dbSearchResponse represents an element of the response to a dashboard search
 // query
 type dbSearchResponse struct {
 	ID      int      `json:"id"`
+	UID     string   `json:"uid"`
 	Title   string   `json:"title"`
 	URI     string   `json:"uri"`
 	Type    string   `json:"type"`
@@ -22,6 +40,7 @@ type dbSearchResponse struct {
 // dashboard
 type dbCreateOrUpdateRequest struct {
 	Dashboard rawJSON `json:"dashboard"`
+	FolderID  int     `json:"folderId"`
 	Overwrite bool    `json:"overwrite"`
 }
 
@@ -30,20 +49,104 @@ type dbCreateOrUpdateRequest struct {
 // documentation aren't located in this structure because there are some we
 // don't need.
 type dbCreateOrUpdateResponse struct {
-	Status  string `json:"success"`
+	Status  string `json:"status"`
+	Slug    string `json:"slug,omitempty"`
+	UID     string `json:"uid,omitempty"`
 	Version int    `json:"version,omitempty"`
 	Message string `json:"message,omitempty"`
 }
 
-// Dashboard represents a Grafana dashboard, with its JSON definition, slug and
-// current version.
-type Dashboard struct {
-	RawJSON []byte
-	Name    string
+// DashboardVersion identifies a version of a dashboard, by the dashboard's
+// slug and UID (empty on Grafana versions older than 5.0) and the version's
+// number.
+type DashboardVersion struct {
 	Slug    string
+	UID     string
 	Version int
 }
 
+// VersionKey returns the key the version of the dashboard with the given UID
+// and slug is recorded under in the versions file: its UID, which doesn't
+// change when the dashboard is renamed and can't be shared with another
+// dashboard, or its slug if it hasn't any (i.e. on Grafana versions older than
+// 5.0).
+func VersionKey(uid string, slug string) string {
+	if len(uid) > 0 {
+		return uid
+	}
+
+	return slug
+}
+
+// RecordedVersion returns the version recorded in the given versions (read
+// from the versions file) for the dashboard with the given UID and slug (see
+// VersionKey), and whether there's one. Versions files written by older
+// releases record all the versions under the dashboards' slugs, so the slug is
+// looked up if there's no version recorded under the UID.
+func RecordedVersion(versions map[string]int, uid string, slug string) (int, bool) {
+	if version, ok := versions[VersionKey(uid, slug)]; ok {
+		return version, true
+	}
+
+	version, ok := versions[slug]
+	return version, ok
+}
+
+// Dashboard represents a Grafana dashboard, with its JSON definition, slug,
+// UID (empty on Grafana versions older than 5.0), current version, the ID and
+// title of the folder it's in (0 and empty for the "General" folder), the time
+// of its last update (zero if unknown) and the login of the user who made it,
+// and the path of its page in Grafana's UI (empty if unknown, see
+// Client.DashboardURL).
+type Dashboard struct {
+	RawJSON     []byte
+	Name        string
+	Slug        string
+	UID         string
+	Version     int
+	FolderID    int
+	FolderTitle string
+	Updated     time.Time
+	UpdatedBy   string
+	URL         string
+}
+
+// DashboardRef identifies a dashboard on the Grafana instance, either by its
+// UID, or, if it hasn't any (i.e. on Grafana versions older than 5.0), by its
+// URI (which looks like "db/[dashboard slug]").
+type DashboardRef struct {
+	UID string
+	URI string
+}
+
+// RefFromJSON returns a reference to the dashboard described by the given JSON
+// content, using the UID from the description if there's one, else the URI
+// computed from the dashboard's slug.
+// Returns an error if there was an issue parsing the JSON description.
+func RefFromJSON(contentJSON []byte) (ref DashboardRef, err error) {
+	if ref.UID, err = helpers.GetDashboardUID(contentJSON); err != nil {
+		return
+	}
+
+	if len(ref.UID) > 0 {
+		return
+	}
+
+	slug, err := helpers.GetDashboardSlug(contentJSON)
+	ref.URI = "db/" + slug
+	return
+}
+
+// String returns a human-readable representation of the reference, for
+// logging.
+func (r DashboardRef) String() string {
+	if len(r.UID) > 0 {
+		return "uid/" + r.UID
+	}
+
+	return r.URI
+}
+
 // UnmarshalJSON tells the JSON parser how to unmarshal JSON data into an
 // instance of the Dashboard structure.
 // Returns an error if there was an issue unmarshalling the JSON.
@@ -52,8 +155,13 @@ func (d *Dashboard) UnmarshalJSON(b []byte) (err error) {
 	var body struct {
 		Dashboard rawJSON `json:"dashboard"`
 		Meta      struct {
-			Slug    string `json:"slug"`
-			Version int    `json:"version"`
+			Slug        string `json:"slug"`
+			Version     int    `json:"version"`
+			FolderID    int    `json:"folderId"`
+			FolderTitle string `json:"folderTitle"`
+			Updated     string `json:"updated"`
+			UpdatedBy   string `json:"updatedBy"`
+			URL         string `json:"url"`
 		} `json:"meta"`
 	}
 
@@ -63,14 +171,50 @@ func (d *Dashboard) UnmarshalJSON(b []byte) (err error) {
 	}
 	// Define all fields with their corresponding value.
 	d.Slug = body.Meta.Slug
+	if d.UID, err = helpers.GetDashboardUID(body.Dashboard); err != nil {
+		return
+	}
 	d.Version = body.Meta.Version
+	// The time of the last update isn't essential, so it's left zero rather
+	// than failing if it can't be parsed.
+	d.Updated, _ = time.Parse(time.RFC3339, body.Meta.Updated)
+	d.UpdatedBy = body.Meta.UpdatedBy
+	d.URL = body.Meta.URL
 	d.RawJSON = body.Dashboard
+	// Grafana sets the folder's title to "General" for dashboards which aren't
+	// in a folder.
+	d.FolderID = body.Meta.FolderID
+	if body.Meta.FolderID != 0 {
+		d.FolderTitle = body.Meta.FolderTitle
+	}
 
 	// Define the dashboard's name from the previously extracted JSON description
 	err = d.setDashboardNameFromRawJSON()
 	return
 }
 
+// DashboardURL returns the absolute URL of the given dashboard's page in
+// Grafana's UI, or an empty string if Grafana didn't provide its path (i.e. on
+// versions older than 5.0). The path already includes the sub-path Grafana is
+// served from, if any, so only the scheme and host of the base URL are used.
+func (c *Client) DashboardURL(d *Dashboard) string {
+	if len(d.URL) == 0 {
+		return ""
+	}
+
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return d.URL
+	}
+
+	ref, err := url.Parse(d.URL)
+	if err != nil {
+		return d.URL
+	}
+
+	return base.ResolveReference(ref).String()
+}
+
 // setDashboardNameFromJSON finds a dashboard's name from the content of its
 // RawJSON field
 func (d *Dashboard) setDashboardNameFromRawJSON() (err error) {
@@ -91,32 +235,163 @@ func (d *Dashboard) setDashboardNameFromRawJSON() (err error) {
 // then returns the dashboards' URIs. An URI will look like "db/[dashboard slug]".
 // Returns an error if there was an issue requesting the URIs or parsing the
 // response body.
-func (c *Client) GetDashboardsURIs() (URIs []string, err error) {
-	resp, err := c.request("GET", "search", nil)
+func (c *Client) GetDashboardsURIs(ctx context.Context) (URIs []string, err error) {
+	respBody, err := c.searchDashboards(ctx)
 	if err != nil {
 		return
 	}
 
-	var respBody []dbSearchResponse
-	if err = json.Unmarshal(resp, &respBody); err != nil {
+	URIs = make([]string, 0)
+	for _, db := range respBody {
+		URIs = append(URIs, db.URI)
+	}
+
+	return
+}
+
+// GetDashboardsRefs requests the Grafana API for the list of all dashboards,
+// then returns references to them. Folders, which are also returned by the
+// search on Grafana 5.0 and later, are left out.
+// Returns an error if there was an issue requesting the dashboards or parsing
+// the response body.
+func (c *Client) GetDashboardsRefs(ctx context.Context) (refs []DashboardRef, err error) {
+	respBody, err := c.searchDashboards(ctx)
+	if err != nil {
 		return
 	}
 
-	URIs = make([]string, 0)
+	refs = make([]DashboardRef, 0)
 	for _, db := range respBody {
-		URIs = append(URIs, db.URI)
+		if db.Type == "dash-folder" {
+			continue
+		}
+
+		refs = append(refs, DashboardRef{UID: db.UID, URI: db.URI})
 	}
 
 	return
 }
 
+// searchDashboards requests the Grafana API for the list of all dashboards (and
+// folders), one page at a time, since a single search returns a limited number
+// of results. Grafana versions which don't support pagination ignore the page
+// and return the first one again, so the search stops at the first page that
+// doesn't bring any new result.
+// Returns an error if there was an issue requesting a page or parsing the
+// response body.
+func (c *Client) searchDashboards(ctx context.Context) ([]dbSearchResponse, error) {
+	results := make([]dbSearchResponse, 0)
+	seen := make(map[int]bool)
+
+	page := 1
+	for ; ; page++ {
+		query := url.Values{}
+		query.Set("limit", strconv.Itoa(searchPageSize))
+		query.Set("page", strconv.Itoa(page))
+
+		resp, err := c.request(ctx, "GET", "search?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var pageResults []dbSearchResponse
+		if err = json.Unmarshal(resp, &pageResults); err != nil {
+			return nil, err
+		}
+
+		added := 0
+		for _, result := range pageResults {
+			if !seen[result.ID] {
+				seen[result.ID] = true
+				results = append(results, result)
+				added++
+			}
+		}
+
+		if len(pageResults) < searchPageSize || added == 0 {
+			break
+		}
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"results": len(results),
+		"pages":   page,
+	}).Info("Retrieved the list of dashboards from Grafana")
+
+	return results, nil
+}
+
+// FindDashboardByTitle requests the Grafana API for the dashboard with the given
+// title (ignoring case, like Grafana does when checking titles are unique) in
+// the folder with the given ID (0 being the "General" folder), and returns a
+// reference to it, or nil if there's none.
+// Returns an error if there was an issue searching the dashboards or parsing
+// the response body.
+func (c *Client) FindDashboardByTitle(
+	ctx context.Context, title string, folderID int,
+) (*DashboardRef, error) {
+	query := url.Values{}
+	query.Set("query", title)
+	query.Set("folderIds", strconv.Itoa(folderID))
+	query.Set("type", "dash-db")
+
+	resp, err := c.request(ctx, "GET", "search?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []dbSearchResponse
+	if err = json.Unmarshal(resp, &results); err != nil {
+		return nil, err
+	}
+
+	// The search matches the dashboards which title contains the query, and
+	// older Grafana versions don't filter the results by type.
+	for _, result := range results {
+		if result.Type != "dash-folder" && strings.EqualFold(result.Title, title) {
+			return &DashboardRef{UID: result.UID, URI: result.URI}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// GetDashboardByRef requests the Grafana API for the dashboard identified by a
+// given reference, using its UID if it has one, else its URI.
+// Returns the dashboard as an instance of the Dashboard structure.
+// Returns an error if there was an issue requesting the dashboard or parsing
+// the response body.
+func (c *Client) GetDashboardByRef(ctx context.Context, ref DashboardRef) (*Dashboard, error) {
+	if len(ref.UID) > 0 {
+		return c.GetDashboardByUID(ctx, ref.UID)
+	}
+
+	return c.GetDashboard(ctx, ref.URI)
+}
+
+// GetDashboardByUID requests the Grafana API for the dashboard identified by a
+// given UID.
+// Returns the dashboard as an instance of the Dashboard structure.
+// Returns an error if there was an issue requesting the dashboard or parsing
+// the response body.
+func (c *Client) GetDashboardByUID(ctx context.Context, uid string) (db *Dashboard, err error) {
+	body, err := c.request(ctx, "GET", "dashboards/uid/"+uid, nil)
+	if err != nil {
+		return
+	}
+
+	db = new(Dashboard)
+	err = json.Unmarshal(body, db)
+	return
+}
+
 // GetDashboard requests the Grafana API for a dashboard identified by a given
 // URI (using the same format as GetDashboardsURIs).
 // Returns the dashboard as an instance of the Dashboard structure.
 // Returns an error if there was an issue requesting the dashboard or parsing
 // the response body.
-func (c *Client) GetDashboard(URI string) (db *Dashboard, err error) {
-	body, err := c.request("GET", "dashboards/"+URI, nil)
+func (c *Client) GetDashboard(ctx context.Context, URI string) (db *Dashboard, err error) {
+	body, err := c.request(ctx, "GET", "dashboards/"+URI, nil)
 	if err != nil {
 		return
 	}
@@ -130,13 +405,71 @@ func (c *Client) GetDashboard(URI string) (db *Dashboard, err error) {
 // dashboard if it doesn't exist on the Grafana instance, else updates the
 // existing one. The Grafana API decides whether to create or update based on the
 // "id" attribute in the dashboard's JSON: If it's unkown or null, it's a
-// creation, else it's an update.
+// creation, else it's an update. If the dashboard's JSON has an "uid"
+// attribute, the "id" one is dropped so the Grafana API identifies the
+// dashboard by its UID instead, which doesn't change when the dashboard is
+// renamed, and is the same on all instances the dashboard is pushed to.
+// The dashboard is created in (or moved to) the "General" folder.
+// Returns the version of the dashboard created by the request.
+// Returns an error if there was an issue generating the request body, performing
+// the request or decoding the response's body.
+func (c *Client) CreateOrUpdateDashboard(
+	ctx context.Context, contentJSON []byte,
+) (*DashboardVersion, error) {
+	return c.CreateOrUpdateDashboardInFolder(ctx, contentJSON, 0)
+}
+
+// CreateOrUpdateDashboardInFolder works the same way as CreateOrUpdateDashboard,
+// except the dashboard is created in (or moved to) the folder with the given ID.
+// Returns the version of the dashboard created by the request.
 // Returns an error if there was an issue generating the request body, performing
 // the request or decoding the response's body.
-func (c *Client) CreateOrUpdateDashboard(contentJSON []byte) (err error) {
+func (c *Client) CreateOrUpdateDashboardInFolder(
+	ctx context.Context, contentJSON []byte, folderID int,
+) (*DashboardVersion, error) {
+	return c.pushDashboard(ctx, contentJSON, folderID, 0)
+}
+
+// UpdateDashboardFromVersion works the same way as
+// CreateOrUpdateDashboardInFolder, except an existing dashboard is only updated
+// if its version on Grafana is the given one, rather than being overwritten, so
+// changes made on Grafana in the meantime aren't lost.
+// Returns the version of the dashboard created by the request.
+// Returns ErrVersionMismatch if the dashboard's version on Grafana isn't the
+// given one, or an error if there was an issue generating the request body,
+// performing the request or decoding the response's body.
+func (c *Client) UpdateDashboardFromVersion(
+	ctx context.Context, contentJSON []byte, folderID int, version int,
+) (*DashboardVersion, error) {
+	return c.pushDashboard(ctx, contentJSON, folderID, version)
+}
+
+// pushDashboard creates or updates the dashboard described by the given JSON
+// content in the folder with the given ID. If the given version is 0, the
+// dashboard is overwritten, else it's only updated if its version on Grafana is
+// the given one.
+// Returns the version of the dashboard created by the request.
+// Returns ErrVersionMismatch if the dashboard's version on Grafana isn't the
+// given one, or an error if there was an issue generating the request body,
+// performing the request or decoding the response's body.
+func (c *Client) pushDashboard(
+	ctx context.Context, contentJSON []byte, folderID int, fromVersion int,
+) (version *DashboardVersion, err error) {
+	dashboardJSON, err := identifyByUID(contentJSON)
+	if err != nil {
+		return
+	}
+
+	if fromVersion > 0 {
+		if dashboardJSON, err = setVersion(dashboardJSON, fromVersion); err != nil {
+			return
+		}
+	}
+
 	reqBody := dbCreateOrUpdateRequest{
-		Dashboard: rawJSON(contentJSON),
-		Overwrite: true,
+		Dashboard: rawJSON(dashboardJSON),
+		FolderID:  folderID,
+		Overwrite: fromVersion == 0,
 	}
 
 	// Generate the request body's JSON
@@ -145,18 +478,22 @@ func (c *Client) CreateOrUpdateDashboard(contentJSON []byte) (err error) {
 		return
 	}
 
-	var httpError *httpUnkownError
-	var isHttpUnknownError bool
 	// Send the request
-	respBodyJSON, err := c.request("POST", "dashboards/db", reqBodyJSON)
+	respBodyJSON, err := c.request(ctx, "POST", "dashboards/db", reqBodyJSON)
 	if err != nil {
-		// Check the error against the httpUnkownError type in order to decide
-		// how to process the error
-		httpError, isHttpUnknownError = err.(*httpUnkownError)
-		// We process httpUnkownError errors below, after we decoded the body
-		if !isHttpUnknownError {
-			return
+		var apiErr *ConflictError
+		if errors.As(err, &apiErr) && apiErr.Status == "version-mismatch" && fromVersion > 0 {
+			return nil, ErrVersionMismatch
+		}
+
+		// Get the dashboard's slug for logging, keeping the error's type so
+		// callers can handle it depending on its class.
+		slug, slugErr := helpers.GetDashboardSlug(contentJSON)
+		if slugErr != nil {
+			return nil, err
 		}
+
+		return nil, fmt.Errorf("Failed to update dashboard %s: %w", slug, err)
 	}
 
 	// Decode the response body
@@ -165,27 +502,117 @@ func (c *Client) CreateOrUpdateDashboard(contentJSON []byte) (err error) {
 		return
 	}
 
-	if respBody.Status != "success" && isHttpUnknownError {
-		// Get the dashboard's slug for logging
-		var slug string
-		slug, err = helpers.GetDashboardSlug(contentJSON)
-		if err != nil {
-			return
-		}
-
-		return fmt.Errorf(
-			"Failed to update dashboard %s (%d %s): %s",
-			slug, httpError.StatusCode, respBody.Status, respBody.Message,
-		)
-	}
+	return &DashboardVersion{
+		Slug:    respBody.Slug,
+		UID:     respBody.UID,
+		Version: respBody.Version,
+	}, nil
+}
 
+// DeleteDashboard deletes the dashboard identified by a given slug on the
+// Grafana API.
+// Returns an error if the process failed.
+func (c *Client) DeleteDashboard(ctx context.Context, slug string) (err error) {
+	_, err = c.request(ctx, "DELETE", "dashboards/db/"+slug, nil)
 	return
 }
 
-// DeleteDashboard deletes the dashboard identified by a given slug on the
+// DeleteDashboardByUID deletes the dashboard identified by a given UID on the
 // Grafana API.
 // Returns an error if the process failed.
-func (c *Client) DeleteDashboard(slug string) (err error) {
-	_, err = c.request("DELETE", "dashboards/db/"+slug, nil)
+func (c *Client) DeleteDashboardByUID(ctx context.Context, uid string) (err error) {
+	_, err = c.request(ctx, "DELETE", "dashboards/uid/"+uid, nil)
 	return
 }
+
+// DeleteDashboardByRef deletes the dashboard identified by a given reference on
+// the Grafana API, using its UID if it has one, else its URI.
+// Returns an error if the process failed.
+func (c *Client) DeleteDashboardByRef(ctx context.Context, ref DashboardRef) (err error) {
+	if len(ref.UID) > 0 {
+		return c.DeleteDashboardByUID(ctx, ref.UID)
+	}
+
+	_, err = c.request(ctx, "DELETE", "dashboards/"+ref.URI, nil)
+	return
+}
+
+// VerifyDashboard requests the Grafana API for the dashboard identified by a
+// given reference, and checks that its JSON description can be loaded, i.e.
+// that it has a title and that its panels and rows (if any) are lists.
+// Returns an error if the dashboard couldn't be retrieved or if its JSON
+// description isn't valid.
+func (c *Client) VerifyDashboard(ctx context.Context, ref DashboardRef) error {
+	db, err := c.GetDashboardByRef(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	var dashboard struct {
+		Title  string            `json:"title"`
+		Panels []json.RawMessage `json:"panels"`
+		Rows   []json.RawMessage `json:"rows"`
+	}
+
+	if err = json.Unmarshal(db.RawJSON, &dashboard); err != nil {
+		return fmt.Errorf("Invalid JSON description for dashboard %s: %v", ref, err)
+	}
+
+	if len(dashboard.Title) == 0 {
+		return fmt.Errorf("Dashboard %s has no title", ref)
+	}
+
+	return nil
+}
+
+// identifyByUID removes the "id" attribute from the given JSON description of
+// a dashboard if it has a non-empty "uid" attribute, so the Grafana API
+// identifies the dashboard by its UID. Descriptions without an UID are
+// returned unchanged.
+// Returns an error if there was an issue parsing or re-encoding the JSON
+// description.
+func identifyByUID(contentJSON []byte) ([]byte, error) {
+	uid, err := helpers.GetDashboardUID(contentJSON)
+	if err != nil || len(uid) == 0 {
+		return contentJSON, err
+	}
+
+	dashboard, err := decodeDashboard(contentJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := dashboard["id"]; !ok {
+		return contentJSON, nil
+	}
+
+	delete(dashboard, "id")
+	return json.Marshal(dashboard)
+}
+
+// setVersion sets the version in the given dashboard's JSON description, which
+// Grafana compares with the version of the existing dashboard when it isn't
+// asked to overwrite it.
+// Returns an error if the description couldn't be parsed or re-encoded.
+func setVersion(contentJSON []byte, version int) ([]byte, error) {
+	dashboard, err := decodeDashboard(contentJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	dashboard["version"] = version
+	return json.Marshal(dashboard)
+}
+
+// decodeDashboard decodes the given JSON description of a dashboard into a
+// map, with its numbers decoded as json.Number so they're re-encoded as they
+// were, rather than as floats which would lose the precision of large integers.
+// Returns an error if the description couldn't be parsed.
+func decodeDashboard(contentJSON []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(contentJSON))
+	decoder.UseNumber()
+
+	var dashboard map[string]interface{}
+	err := decoder.Decode(&dashboard)
+	return dashboard, err
+}
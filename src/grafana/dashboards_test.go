@@ -0,0 +1,87 @@
+package grafana
+
+import (
+	"testing"
+)
+
+func TestIdentifyByUID(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "uid",
+			content:  `{"id": 12, "uid": "abc", "title": "Foo"}`,
+			expected: `{"title":"Foo","uid":"abc"}`,
+		},
+		{
+			name:     "large integers",
+			content:  `{"id": 12, "uid": "abc", "panels": [{"id": 9007199254740993, "span": 1.50}]}`,
+			expected: `{"panels":[{"id":9007199254740993,"span":1.50}],"uid":"abc"}`,
+		},
+		{
+			name:     "no uid",
+			content:  `{"id": 12, "title": "Foo"}`,
+			expected: `{"id": 12, "title": "Foo"}`,
+		},
+		{
+			name:     "no id",
+			content:  `{"uid": "abc", "title": "Foo"}`,
+			expected: `{"uid": "abc", "title": "Foo"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content, err := identifyByUID([]byte(test.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(content) != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, content)
+			}
+		})
+	}
+}
+
+func TestSetVersion(t *testing.T) {
+	content, err := setVersion(
+		[]byte(`{"uid": "abc", "version": 3, "panels": [{"id": 9007199254740993}]}`), 4,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"panels":[{"id":9007199254740993}],"uid":"abc","version":4}`
+	if string(content) != expected {
+		t.Errorf("expected %s, got %s", expected, content)
+	}
+}
+
+func TestRecordedVersion(t *testing.T) {
+	versions := map[string]int{"abc": 3, "latency": 2, "legacy": 5}
+
+	tests := []struct {
+		name     string
+		uid      string
+		slug     string
+		expected int
+		ok       bool
+	}{
+		{name: "uid", uid: "abc", slug: "latency", expected: 3, ok: true},
+		{name: "no uid", slug: "latency", expected: 2, ok: true},
+		{name: "recorded under the slug", uid: "def", slug: "legacy", expected: 5, ok: true},
+		{name: "not recorded", uid: "def", slug: "errors"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			version, ok := RecordedVersion(versions, test.uid, test.slug)
+			if version != test.expected || ok != test.ok {
+				t.Errorf("expected %d (%v), got %d (%v)", test.expected, test.ok, version, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package grafana
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newTransport returns the HTTP transport to reach the Grafana instance with,
+// depending on the given settings: over a Unix domain socket, through an SSH
+// tunnel, or over TCP (the default transport). The given timeout applies to
+// establishing the SSH connection, if any.
+func newTransport(cfg *config.GrafanaSettings, timeout time.Duration) http.RoundTripper {
+	var dial func(ctx context.Context, network string, addr string) (net.Conn, error)
+	if len(cfg.Socket) > 0 {
+		socket := cfg.Socket
+		dial = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	} else if cfg.SSHTunnel != nil {
+		dial = (&sshTunnel{cfg: cfg.SSHTunnel, timeout: timeout}).dial
+	} else {
+		return http.DefaultTransport
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dial
+	return transport
+}
+
+// sshTunnel dials connections to the Grafana instance through an SSH server.
+// The connection to the SSH server is established on the first dial, and
+// reused until it breaks.
+type sshTunnel struct {
+	cfg     *config.SSHTunnelSettings
+	timeout time.Duration
+	client  *ssh.Client
+	mutex   sync.Mutex
+}
+
+// dial opens a connection to the given address from the SSH server,
+// (re)connecting to the server if needed.
+// Returns an error if there was an issue connecting to the SSH server, or
+// opening the connection from it.
+func (t *sshTunnel) dial(ctx context.Context, network string, addr string) (net.Conn, error) {
+	client, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.Dial(network, addr)
+	if err == nil {
+		return conn, nil
+	}
+
+	// The connection to the SSH server might have been closed (e.g. after a
+	// network issue), in which case we try again with a new one.
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"error":  err,
+		"server": t.cfg.Address,
+	}).Warn("Failed to open a connection through the SSH tunnel, reconnecting")
+
+	t.reset(client)
+	if client, err = t.connect(); err != nil {
+		return nil, err
+	}
+
+	return client.Dial(network, addr)
+}
+
+// connect returns the connection to the SSH server, establishing it if there's
+// none.
+// Returns an error if there was an issue reading the private key or the known
+// hosts file, or connecting to the server.
+func (t *sshTunnel) connect() (*ssh.Client, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.client != nil {
+		return t.client, nil
+	}
+
+	privateKey, err := ioutil.ReadFile(t.cfg.PrivateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	knownHostsPath := t.cfg.KnownHostsPath
+	if len(knownHostsPath) == 0 {
+		knownHostsPath = filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+	}
+
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	t.client, err = ssh.Dial("tcp", t.cfg.Address, &ssh.ClientConfig{
+		User:            t.cfg.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         t.timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"server": t.cfg.Address,
+	}).Info("Connected to the SSH server to tunnel requests to Grafana through")
+
+	return t.client, nil
+}
+
+// reset closes the given connection to the SSH server and forgets it, unless
+// another dial already replaced it.
+func (t *sshTunnel) reset(client *ssh.Client) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.client == client {
+		t.client.Close()
+		t.client = nil
+	}
+}
@@ -0,0 +1,133 @@
+package grafana
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// APIError is the error returned when the Grafana API responds with a status
+// code other than 200, along with the message and status Grafana includes in
+// the response's body, if any. It's returned as is for the status codes which
+// don't have a more specific error type (e.g. 400), else it's embedded in the
+// error of the matching type (e.g. NotFoundError), so callers can decide how to
+// handle the error depending on its type (see IsRetryable).
+type APIError struct {
+	URL        string
+	StatusCode int
+	Message    string
+	Status     string
+}
+
+// Error implements error.Error().
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s returned %d", e.URL, e.StatusCode)
+	if len(e.Status) > 0 {
+		msg += " (" + e.Status + ")"
+	}
+	if len(e.Message) > 0 {
+		msg += ": " + e.Message
+	}
+
+	return msg
+}
+
+// NotFoundError is returned when the Grafana API responds with a 404 status
+// code, i.e. the requested resource doesn't exist.
+type NotFoundError struct{ APIError }
+
+// UnauthorizedError is returned when the Grafana API responds with a 401
+// status code, i.e. none of the credentials from the configuration were
+// accepted, which is the case for every request until they're fixed.
+type UnauthorizedError struct{ APIError }
+
+// ForbiddenError is returned when the Grafana API responds with a 403 status
+// code, i.e. the credentials aren't allowed to perform the request, which can
+// depend on the resource (e.g. on a folder's permissions).
+type ForbiddenError struct{ APIError }
+
+// ConflictError is returned when the Grafana API responds with a 409 or 412
+// status code, i.e. the request conflicts with the current state of the
+// resource (e.g. a dashboard with the same title already exists in the
+// folder, or the dashboard's version changed).
+type ConflictError struct{ APIError }
+
+// RateLimitedError is returned when the Grafana API (or a proxy in front of it)
+// responds with a 429 status code, i.e. too many requests were sent.
+type RateLimitedError struct{ APIError }
+
+// ServerError is returned when the Grafana API (or a proxy in front of it)
+// responds with a 5xx status code.
+type ServerError struct{ APIError }
+
+// newAPIError returns the error matching the given status code, for a response
+// with the given body from the given URL. Grafana's error bodies are JSON
+// objects with a message and sometimes a status, which are included in the
+// error if the body can be parsed.
+func newAPIError(url string, statusCode int, respBody []byte) error {
+	apiErr := APIError{URL: url, StatusCode: statusCode}
+
+	var body struct {
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	}
+	if json.Unmarshal(respBody, &body) == nil {
+		apiErr.Message = body.Message
+		apiErr.Status = body.Status
+	}
+
+	switch {
+	case statusCode == http.StatusNotFound:
+		return &NotFoundError{apiErr}
+	case statusCode == http.StatusUnauthorized:
+		return &UnauthorizedError{apiErr}
+	case statusCode == http.StatusForbidden:
+		return &ForbiddenError{apiErr}
+	case statusCode == http.StatusConflict || statusCode == http.StatusPreconditionFailed:
+		return &ConflictError{apiErr}
+	case statusCode == http.StatusTooManyRequests:
+		return &RateLimitedError{apiErr}
+	case statusCode >= 500:
+		return &ServerError{apiErr}
+	default:
+		return &apiErr
+	}
+}
+
+// IsNotFound checks whether the given error was returned because the Grafana
+// API responded with a 404 status code.
+func IsNotFound(err error) bool {
+	var notFound *NotFoundError
+	return errors.As(err, &notFound)
+}
+
+// IsUnauthorized checks whether the given error was returned because the
+// Grafana API rejected the credentials from the configuration, in which case
+// the following requests are bound to fail too.
+func IsUnauthorized(err error) bool {
+	var unauthorized *UnauthorizedError
+	return errors.As(err, &unauthorized)
+}
+
+// IsRetryable checks whether the request which returned the given error might
+// succeed if it's sent again later, i.e. whether the error is transient: the
+// Grafana API was rate limited or failed on its end, or the request didn't get
+// a response from it (e.g. because of a network issue). Other responses from
+// the API (e.g. a 404 or a 403) would be the same if the request was sent again.
+func IsRetryable(err error) bool {
+	var rateLimited *RateLimitedError
+	var serverErr *ServerError
+	if errors.As(err, &rateLimited) || errors.As(err, &serverErr) {
+		return true
+	}
+
+	var notFound *NotFoundError
+	var unauthorized *UnauthorizedError
+	var forbidden *ForbiddenError
+	var conflict *ConflictError
+	var apiErr *APIError
+	return !errors.As(err, &notFound) && !errors.As(err, &unauthorized) &&
+		!errors.As(err, &forbidden) && !errors.As(err, &conflict) &&
+		!errors.As(err, &apiErr)
+}
@@ -0,0 +1,271 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+)
+
+// Folder represents a Grafana folder. ParentUID is the UID of the folder's
+// parent, if the folder is nested in another one.
+type Folder struct {
+	ID        int    `json:"id"`
+	UID       string `json:"uid"`
+	Title     string `json:"title"`
+	ParentUID string `json:"parentUid,omitempty"`
+}
+
+// GetFolders requests the Grafana API for the list of all folders.
+// Returns an error if there was an issue requesting the folders or parsing the
+// response body.
+func (c *Client) GetFolders(ctx context.Context) (folders []Folder, err error) {
+	resp, err := c.request(ctx, "GET", "folders", nil)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(resp, &folders)
+	return
+}
+
+// CreateFolder creates a folder with the given title on the Grafana instance.
+// Returns the created folder.
+// Returns an error if there was an issue generating the request body,
+// performing the request or decoding the response's body.
+func (c *Client) CreateFolder(ctx context.Context, title string) (folder *Folder, err error) {
+	reqBody, err := json.Marshal(map[string]string{"title": title})
+	if err != nil {
+		return
+	}
+
+	resp, err := c.request(ctx, "POST", "folders", reqBody)
+	if err != nil {
+		return
+	}
+
+	folder = new(Folder)
+	err = json.Unmarshal(resp, folder)
+	return
+}
+
+// GetFolderID returns the ID of the folder with the given title, creating the
+// folder if it doesn't exist on the Grafana instance. An empty title refers to
+// the "General" folder, which ID is 0.
+// Returns an error if there was an issue retrieving or creating the folder.
+func (c *Client) GetFolderID(ctx context.Context, title string) (int, error) {
+	if len(title) == 0 {
+		return 0, nil
+	}
+
+	folders, err := c.GetFolders(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, folder := range folders {
+		if folder.Title == title {
+			return folder.ID, nil
+		}
+	}
+
+	folder, err := c.CreateFolder(ctx, title)
+	if err != nil {
+		return 0, err
+	}
+
+	return folder.ID, nil
+}
+
+// FolderMetadata describes a Grafana folder as stored in the Git repository:
+// its UID, title, parent's UID (if any) and permissions.
+type FolderMetadata struct {
+	UID         string       `json:"uid"`
+	Title       string       `json:"title"`
+	ParentUID   string       `json:"parentUid,omitempty"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// GetFolderPermissions requests the Grafana API for the permissions of the
+// folder with the given UID. Permissions inherited from elsewhere (e.g. from
+// the instance's settings) aren't returned.
+// Returns an error if there was an issue requesting the permissions or parsing
+// the response body.
+func (c *Client) GetFolderPermissions(ctx context.Context, uid string) ([]Permission, error) {
+	return c.getPermissions(ctx, "folders/"+uid+"/permissions")
+}
+
+// GetFolderMetadata retrieves the UID, title and permissions of the folder
+// with the given title from the Grafana API. Returns nil if there's no such
+// folder.
+// Returns an error if there was an issue retrieving the folders or the
+// permissions.
+func (c *Client) GetFolderMetadata(ctx context.Context, title string) (*FolderMetadata, error) {
+	folders, err := c.GetFolders(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, folder := range folders {
+		if folder.Title != title {
+			continue
+		}
+
+		permissions, err := c.GetFolderPermissions(ctx, folder.UID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &FolderMetadata{
+			UID:         folder.UID,
+			Title:       folder.Title,
+			ParentUID:   folder.ParentUID,
+			Permissions: permissions,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// GetFolderByUID requests the Grafana API for the folder with the given UID.
+// Returns nil if there's no such folder.
+// Returns an error if there was an issue requesting the folder or parsing the
+// response body.
+func (c *Client) GetFolderByUID(ctx context.Context, uid string) (folder *Folder, err error) {
+	resp, err := c.request(ctx, "GET", "folders/"+uid, nil)
+	if err != nil {
+		if IsNotFound(err) {
+			err = nil
+		}
+
+		return
+	}
+
+	folder = new(Folder)
+	err = json.Unmarshal(resp, folder)
+	return
+}
+
+// CreateFolderFromMetadata creates a folder on the Grafana instance with the
+// UID, title and parent from the given metadata. Permissions aren't applied.
+// Returns the created folder.
+// Returns an error if there was an issue generating the request body,
+// performing the request or decoding the response's body.
+func (c *Client) CreateFolderFromMetadata(
+	ctx context.Context, metadata *FolderMetadata,
+) (folder *Folder, err error) {
+	body := map[string]string{
+		"uid":   metadata.UID,
+		"title": metadata.Title,
+	}
+	if len(metadata.ParentUID) > 0 {
+		body["parentUid"] = metadata.ParentUID
+	}
+
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return
+	}
+
+	resp, err := c.request(ctx, "POST", "folders", reqBody)
+	if err != nil {
+		return
+	}
+
+	folder = new(Folder)
+	err = json.Unmarshal(resp, folder)
+	return
+}
+
+// UpdateFolderPermissions replaces the permissions of the folder with the given
+// UID with the given ones.
+// Returns an error if there was an issue generating the request body or
+// performing the request.
+func (c *Client) UpdateFolderPermissions(
+	ctx context.Context, uid string, permissions []Permission,
+) error {
+	return c.updatePermissions(ctx, "folders/"+uid+"/permissions", permissions)
+}
+
+// RenameFolder changes the title of the folder with the given UID to the given
+// one, overwriting any concurrent change to the folder.
+// Returns the updated folder.
+// Returns an error if there was an issue generating the request body,
+// performing the request or decoding the response's body.
+func (c *Client) RenameFolder(
+	ctx context.Context, uid string, title string,
+) (folder *Folder, err error) {
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"title":     title,
+		"overwrite": true,
+	})
+	if err != nil {
+		return
+	}
+
+	resp, err := c.request(ctx, "PUT", "folders/"+uid, reqBody)
+	if err != nil {
+		return
+	}
+
+	folder = new(Folder)
+	err = json.Unmarshal(resp, folder)
+	return
+}
+
+// MoveFolder moves the folder with the given UID into the folder with the given
+// parent UID, along with its content. This requires the nested folders feature
+// of Grafana.
+// Returns the moved folder.
+// Returns an error if there was an issue generating the request body,
+// performing the request or decoding the response's body.
+func (c *Client) MoveFolder(
+	ctx context.Context, uid string, parentUID string,
+) (folder *Folder, err error) {
+	reqBody, err := json.Marshal(map[string]string{"parentUid": parentUID})
+	if err != nil {
+		return
+	}
+
+	resp, err := c.request(ctx, "POST", "folders/"+uid+"/move", reqBody)
+	if err != nil {
+		return
+	}
+
+	folder = new(Folder)
+	err = json.Unmarshal(resp, folder)
+	return
+}
+
+// IsFolderEmpty checks whether the folder with the given UID is empty, i.e.
+// doesn't contain any dashboard or, with Grafana's nested folders, any other
+// folder.
+// Returns an error if there was an issue searching the folder or parsing the
+// response body.
+func (c *Client) IsFolderEmpty(ctx context.Context, uid string) (bool, error) {
+	query := url.Values{}
+	query.Set("folderUIDs", uid)
+	query.Set("limit", "1")
+
+	resp, err := c.request(ctx, "GET", "search?"+query.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	var results []dbSearchResponse
+	if err = json.Unmarshal(resp, &results); err != nil {
+		return false, err
+	}
+
+	return len(results) == 0, nil
+}
+
+// DeleteFolder deletes the folder with the given UID from the Grafana
+// instance. The alert rules in the folder, if any, aren't deleted along with
+// it, and make Grafana refuse to delete the folder instead.
+// Returns an error if there was an issue performing the request.
+func (c *Client) DeleteFolder(ctx context.Context, uid string) error {
+	_, err := c.request(
+		ctx, "DELETE", "folders/"+url.PathEscape(uid)+"?forceDeleteRules=false", nil,
+	)
+	return err
+}
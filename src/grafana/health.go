@@ -0,0 +1,47 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// CheckHealth checks that the Grafana instance is reachable and healthy, by
+// requesting its health endpoint, then that it accepts the credentials from
+// the configuration, with a cheap authenticated request. It is meant to be
+// called on startup, so a misconfiguration is reported clearly instead of
+// failing in the middle of a sync.
+// Returns an error describing the issue if one of the checks failed.
+func (c *Client) CheckHealth(ctx context.Context) error {
+	resp, err := c.request(ctx, "GET", "health", nil)
+	if err != nil {
+		return fmt.Errorf("Couldn't reach Grafana at %s: %v", c.BaseURL, err)
+	}
+
+	var health struct {
+		Database string `json:"database"`
+	}
+
+	if err = json.Unmarshal(resp, &health); err != nil {
+		return fmt.Errorf("Unexpected response from the health endpoint of Grafana at %s, check the base URL: %v", c.BaseURL, err)
+	}
+
+	if len(health.Database) > 0 && health.Database != "ok" {
+		return fmt.Errorf("Grafana at %s isn't healthy (database: %s)", c.BaseURL, health.Database)
+	}
+
+	if _, err = c.request(ctx, "GET", "org", nil); err != nil {
+		var forbidden *ForbiddenError
+		if IsUnauthorized(err) || errors.As(err, &forbidden) {
+			return fmt.Errorf(
+				"Grafana at %s rejected the credentials from the configuration (%v), check the API key, service account token or password",
+				c.BaseURL, err,
+			)
+		}
+
+		return fmt.Errorf("Couldn't check the credentials on Grafana at %s: %v", c.BaseURL, err)
+	}
+
+	return nil
+}
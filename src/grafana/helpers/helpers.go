@@ -2,6 +2,7 @@ package helpers
 
 import (
 	"encoding/json"
+	"strings"
 
 	"github.com/gosimple/slug"
 )
@@ -20,3 +21,39 @@ func GetDashboardSlug(dbJSONDescription []byte) (dbSlug string, err error) {
 	dbSlug = slug.Make(dashboardTitle.Title)
 	return
 }
+
+// GetDashboardUID reads the JSON description of a dashboard and returns its
+// UID, or an empty string if it doesn't have any (e.g. if it was exported from
+// a Grafana version older than 5.0).
+// Returns an error if there was an issue parsing the dashboard JSON description.
+func GetDashboardUID(dbJSONDescription []byte) (uid string, err error) {
+	var dashboard struct {
+		UID string `json:"uid"`
+	}
+
+	err = json.Unmarshal(dbJSONDescription, &dashboard)
+	return dashboard.UID, err
+}
+
+// GetDashboardSchemaVersion reads the JSON description of a dashboard and
+// returns its schema version. v2 is true if the description uses the v2
+// dashboard schema introduced with Grafana 12 (either as a resource, with an
+// "apiVersion" such as "dashboard.grafana.app/v2alpha1", or as a bare spec with
+// "elements" and a "layout"), in which case there's no schema version number.
+// Returns an error if there was an issue parsing the dashboard JSON description.
+func GetDashboardSchemaVersion(dbJSONDescription []byte) (schemaVersion int, v2 bool, err error) {
+	var dashboard struct {
+		SchemaVersion int                        `json:"schemaVersion"`
+		APIVersion    string                     `json:"apiVersion"`
+		Elements      map[string]json.RawMessage `json:"elements"`
+		Layout        json.RawMessage            `json:"layout"`
+	}
+
+	if err = json.Unmarshal(dbJSONDescription, &dashboard); err != nil {
+		return
+	}
+
+	v2 = strings.Contains(dashboard.APIVersion, "/v2") ||
+		(dashboard.Elements != nil && dashboard.Layout != nil)
+	return dashboard.SchemaVersion, v2, nil
+}
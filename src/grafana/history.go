@@ -0,0 +1,72 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// DashboardRevision represents a past version of a dashboard, as stored in the
+// dashboard's history on Grafana: its number, when and by whom it was saved,
+// and the message attached to the change. Data is the JSON description of the
+// dashboard at this version, and is only set when a single version is
+// retrieved.
+type DashboardRevision struct {
+	Version   int             `json:"version"`
+	Created   string          `json:"created"`
+	CreatedBy string          `json:"createdBy"`
+	Message   string          `json:"message"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// GetDashboardRevisions requests the Grafana API for the given number of most
+// recent versions of the dashboard with the given UID, most recent first. The
+// versions' JSON descriptions aren't included.
+// Returns an error if there was an issue requesting the versions or parsing the
+// response body.
+func (c *Client) GetDashboardRevisions(
+	ctx context.Context, uid string, limit int,
+) ([]DashboardRevision, error) {
+	resp, err := c.request(
+		ctx, "GET", fmt.Sprintf("dashboards/uid/%s/versions?limit=%d", url.PathEscape(uid), limit), nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Recent Grafana versions wrap the list of versions in an object, so the
+	// list can be paginated.
+	var revisions []DashboardRevision
+	if err = json.Unmarshal(resp, &revisions); err == nil {
+		return revisions, nil
+	}
+
+	var page struct {
+		Versions []DashboardRevision `json:"versions"`
+	}
+	if err = json.Unmarshal(resp, &page); err != nil {
+		return nil, err
+	}
+
+	return page.Versions, nil
+}
+
+// GetDashboardRevision requests the Grafana API for the given version of the
+// dashboard with the given UID, including its JSON description.
+// Returns an error if there was an issue requesting the version or parsing the
+// response body.
+func (c *Client) GetDashboardRevision(
+	ctx context.Context, uid string, version int,
+) (*DashboardRevision, error) {
+	resp, err := c.request(
+		ctx, "GET", fmt.Sprintf("dashboards/uid/%s/versions/%d", url.PathEscape(uid), version), nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	revision := new(DashboardRevision)
+	err = json.Unmarshal(resp, revision)
+	return revision, err
+}
@@ -1,5 +1,10 @@
 package grafana
 
+import (
+	"bytes"
+	"encoding/json"
+)
+
 // Taken from https://github.com/matrix-org/gomatrixserverlib/blob/7d789f4fb6fa1624901abf391426c5560d76793f/redactevent.go#L39-L51
 type rawJSON []byte
 
@@ -14,3 +19,15 @@ func (r *rawJSON) UnmarshalJSON(data []byte) error {
 	*r = rawJSON(data)
 	return nil
 }
+
+// unmarshalObject parses the given JSON object into a map, decoding numbers as
+// json.Number so they're re-encoded as they were, rather than as floats.
+// Returns an error if the object couldn't be parsed.
+func unmarshalObject(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var object map[string]interface{}
+	err := decoder.Decode(&object)
+	return object, err
+}
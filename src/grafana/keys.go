@@ -0,0 +1,114 @@
+package grafana
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// APIKey represents a Grafana API key, as described by the Grafana API.
+// Expiration is nil if the key never expires.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Role       string     `json:"role"`
+	Expiration *time.Time `json:"expiration,omitempty"`
+}
+
+// GetAPIKeys requests the Grafana API for the list of all API keys (including
+// expired ones) of the current organisation. This requires the API key in use
+// to have the admin role.
+// Returns an error if there was an issue requesting the keys or parsing the
+// response body.
+func (c *Client) GetAPIKeys(ctx context.Context) (keys []APIKey, err error) {
+	resp, err := c.request(ctx, "GET", "auth/keys?includeExpired=true", nil)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(resp, &keys)
+	return
+}
+
+// CheckAPIKeysExpiry looks up the expiration date of each API key known by the
+// client, and logs a warning for each key that expired or will expire within
+// the given duration. A key's expiration date can only be looked up if the key
+// is a legacy API key (service account tokens can't be matched against the
+// keys listed by the API), and if the API key in use has the admin role.
+// Does nothing if the client uses basic auth.
+// Logs any error encountered instead of returning it, since failing to check
+// the keys' expiry mustn't prevent the manager from running.
+func (c *Client) CheckAPIKeysExpiry(ctx context.Context, warnBefore time.Duration) {
+	// There's nothing to check if the client uses basic auth.
+	if len(c.apiKeys) == 0 {
+		return
+	}
+
+	keys, err := c.GetAPIKeys(ctx)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("Couldn't retrieve the API keys to check their expiry")
+
+		return
+	}
+
+	// Map the keys' names to their expiration dates.
+	expirations := make(map[string]*time.Time)
+	for _, key := range keys {
+		expirations[key.Name] = key.Expiration
+	}
+
+	for index, apiKey := range c.apiKeys {
+		name, ok := apiKeyName(apiKey)
+		if !ok {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"key_index": index,
+			}).Debug("Key isn't a legacy API key, can't check its expiry")
+
+			continue
+		}
+
+		expiration, ok := expirations[name]
+		if !ok || expiration == nil {
+			continue
+		}
+
+		remaining := time.Until(*expiration)
+		fields := logrus.Fields{
+			"key_index":  index,
+			"key_name":   name,
+			"expiration": expiration.UTC().Format(time.RFC3339),
+		}
+
+		if remaining <= 0 {
+			logrus.WithContext(ctx).WithFields(fields).Warn("API key has expired")
+		} else if remaining <= warnBefore {
+			logrus.WithContext(ctx).WithFields(fields).Warn("API key will expire soon")
+		}
+	}
+}
+
+// apiKeyName extracts the name of a legacy Grafana API key from the key itself,
+// which is a base64-encoded JSON object containing, among other things, the
+// key's name.
+// Returns false if the key isn't a legacy API key.
+func apiKeyName(apiKey string) (string, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(apiKey)
+	if err != nil {
+		return "", false
+	}
+
+	var content struct {
+		Name string `json:"n"`
+	}
+
+	if err = json.Unmarshal(decoded, &content); err != nil || len(content.Name) == 0 {
+		return "", false
+	}
+
+	return content.Name, true
+}
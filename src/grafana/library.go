@@ -0,0 +1,136 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// libraryPanelGeneratedFields lists the fields of a library panel's JSON
+// description which are set by Grafana itself, and therefore aren't stored in
+// the repository nor sent when pushing the library panel.
+var libraryPanelGeneratedFields = []string{
+	"id", "orgId", "folderId", "version", "meta",
+}
+
+// LibraryPanel represents a library panel (Grafana 8 and later), with its UID,
+// name and JSON description (without the fields Grafana sets itself).
+type LibraryPanel struct {
+	UID     string
+	Name    string
+	RawJSON []byte
+}
+
+// GetLibraryPanels requests the Grafana API for the list of all library panels.
+// Returns an error if there was an issue requesting a page of library panels
+// or parsing the response body.
+func (c *Client) GetLibraryPanels(ctx context.Context) ([]LibraryPanel, error) {
+	panels := make([]LibraryPanel, 0)
+
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("kind", "1")
+		query.Set("perPage", strconv.Itoa(libraryPanelsPageSize))
+		query.Set("page", strconv.Itoa(page))
+
+		resp, err := c.request(ctx, "GET", "library-elements?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var body struct {
+			Result struct {
+				Elements []json.RawMessage `json:"elements"`
+			} `json:"result"`
+		}
+		if err = json.Unmarshal(resp, &body); err != nil {
+			return nil, err
+		}
+
+		for _, element := range body.Result.Elements {
+			panel, err := unmarshalObject(element)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, field := range libraryPanelGeneratedFields {
+				delete(panel, field)
+			}
+
+			rawJSON, err := json.Marshal(panel)
+			if err != nil {
+				return nil, err
+			}
+
+			uid, _ := panel["uid"].(string)
+			name, _ := panel["name"].(string)
+			panels = append(panels, LibraryPanel{
+				UID:     uid,
+				Name:    name,
+				RawJSON: rawJSON,
+			})
+		}
+
+		if len(body.Result.Elements) < libraryPanelsPageSize {
+			return panels, nil
+		}
+	}
+}
+
+// CreateOrUpdateLibraryPanel takes the JSON description of a library panel and
+// updates the library panel with the same UID on the Grafana instance, or
+// creates it if it doesn't exist.
+// Returns an error if the description couldn't be parsed or doesn't have an
+// UID, or if there was an issue looking the library panel up or performing the
+// request.
+func (c *Client) CreateOrUpdateLibraryPanel(ctx context.Context, contentJSON []byte) error {
+	panel, err := unmarshalObject(contentJSON)
+	if err != nil {
+		return err
+	}
+
+	uid, _ := panel["uid"].(string)
+	if len(uid) == 0 {
+		return fmt.Errorf("Library panel %v has no UID", panel["name"])
+	}
+
+	for _, field := range libraryPanelGeneratedFields {
+		delete(panel, field)
+	}
+
+	resp, err := c.request(ctx, "GET", "library-elements/"+url.PathEscape(uid), nil)
+	if IsNotFound(err) {
+		reqBody, err := json.Marshal(panel)
+		if err != nil {
+			return err
+		}
+
+		_, err = c.request(ctx, "POST", "library-elements", reqBody)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// Grafana only updates a library panel if the request includes its
+	// current version.
+	var existing struct {
+		Result struct {
+			Version int `json:"version"`
+		} `json:"result"`
+	}
+	if err = json.Unmarshal(resp, &existing); err != nil {
+		return err
+	}
+
+	panel["version"] = existing.Result.Version
+	reqBody, err := json.Marshal(panel)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.request(ctx, "PATCH", "library-elements/"+url.PathEscape(uid), reqBody)
+	return err
+}
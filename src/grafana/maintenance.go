@@ -0,0 +1,86 @@
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Default values for the maintenance settings.
+const (
+	defaultMaintenanceInitialBackoff = 30 * time.Second
+	defaultMaintenanceMaxBackoff     = 10 * time.Minute
+)
+
+// inMaintenance checks whether a response from Grafana, described by its status
+// code and body, indicates that the Grafana instance is under maintenance,
+// i.e. if the status code is 503 or if the body contains the maintenance
+// marker from the configuration.
+func (c *Client) inMaintenance(statusCode int, respBody []byte) bool {
+	if statusCode == http.StatusServiceUnavailable {
+		return true
+	}
+
+	marker := c.maintenance.Marker
+	return len(marker) > 0 && bytes.Contains(respBody, []byte(marker))
+}
+
+// waitForMaintenance retries a request with an exponential backoff for as long
+// as Grafana is under maintenance, which pauses the current sync. It only logs
+// once when the maintenance starts and once when it ends, to avoid flooding the
+// logs with errors.
+// Returns the status code and body of the first response that doesn't
+// indicate a maintenance.
+// Returns an error if there was an issue performing the request, if the
+// maintenance lasted longer than the maximum wait from the configuration, or if
+// the given context is done before Grafana is back.
+func (c *Client) waitForMaintenance(
+	ctx context.Context, method string, url string, route string, body []byte,
+) (statusCode int, respBody []byte, err error) {
+	backoff := c.maintenance.InitialBackoff
+	if backoff == 0 {
+		backoff = defaultMaintenanceInitialBackoff
+	}
+
+	maxBackoff := c.maintenance.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = defaultMaintenanceMaxBackoff
+	}
+
+	start := time.Now()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"route": route,
+	}).Warn("Grafana is under maintenance, pausing until it is back")
+
+	for {
+		if c.maintenance.MaxWait > 0 && time.Since(start)+backoff > c.maintenance.MaxWait {
+			return 0, nil, fmt.Errorf(
+				"Grafana still under maintenance after %s", time.Since(start),
+			)
+		}
+
+		if err = sleep(ctx, backoff); err != nil {
+			return
+		}
+
+		if statusCode, respBody, err = c.do(ctx, method, url, route, body); err != nil {
+			return
+		}
+
+		if !c.inMaintenance(statusCode, respBody) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"duration": time.Since(start).String(),
+			}).Info("Grafana is back from maintenance, resuming")
+
+			return
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
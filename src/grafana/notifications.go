@@ -0,0 +1,107 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// alertNotificationGeneratedFields lists the fields of an alert notification
+// channel's JSON description which are set by Grafana itself, and therefore
+// aren't stored in the repository nor sent when pushing the channel.
+var alertNotificationGeneratedFields = []string{
+	"id", "created", "updated", "secureFields",
+}
+
+// AlertNotification represents a legacy alert notification channel, with its
+// UID, name and JSON description (without the fields Grafana sets itself).
+type AlertNotification struct {
+	UID     string
+	Name    string
+	RawJSON []byte
+}
+
+// GetAlertNotifications requests the Grafana API for the list of all legacy
+// alert notification channels.
+// Returns an error if there was an issue requesting the channels or parsing
+// the response body.
+func (c *Client) GetAlertNotifications(ctx context.Context) ([]AlertNotification, error) {
+	resp, err := c.request(ctx, "GET", "alert-notifications", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var channels []map[string]interface{}
+	if err = json.Unmarshal(resp, &channels); err != nil {
+		return nil, err
+	}
+
+	notifications := make([]AlertNotification, 0, len(channels))
+	for _, channel := range channels {
+		for _, field := range alertNotificationGeneratedFields {
+			delete(channel, field)
+		}
+
+		rawJSON, err := json.Marshal(channel)
+		if err != nil {
+			return nil, err
+		}
+
+		uid, _ := channel["uid"].(string)
+		name, _ := channel["name"].(string)
+		notifications = append(notifications, AlertNotification{
+			UID:     uid,
+			Name:    name,
+			RawJSON: rawJSON,
+		})
+	}
+
+	return notifications, nil
+}
+
+// CreateOrUpdateAlertNotification takes the JSON description of a legacy alert
+// notification channel and updates the channel with the same UID on the
+// Grafana instance, or creates it if it doesn't exist.
+// Returns an error if the description couldn't be parsed or doesn't have an
+// UID, or if there was an issue looking the channel up or performing the
+// request.
+func (c *Client) CreateOrUpdateAlertNotification(ctx context.Context, contentJSON []byte) error {
+	var channel map[string]interface{}
+	if err := json.Unmarshal(contentJSON, &channel); err != nil {
+		return err
+	}
+
+	uid, _ := channel["uid"].(string)
+	if len(uid) == 0 {
+		return fmt.Errorf("Alert notification channel %v has no UID", channel["name"])
+	}
+
+	for _, field := range alertNotificationGeneratedFields {
+		delete(channel, field)
+	}
+
+	reqBody, err := json.Marshal(channel)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.request(ctx, "GET", "alert-notifications/uid/"+uid, nil)
+	if IsNotFound(err) {
+		_, err = c.request(ctx, "POST", "alert-notifications", reqBody)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = c.request(ctx, "PUT", "alert-notifications/uid/"+uid, reqBody)
+	return err
+}
+
+// DeleteAlertNotification deletes the legacy alert notification channel with
+// the given UID on the Grafana API.
+// Returns an error if the process failed.
+func (c *Client) DeleteAlertNotification(ctx context.Context, uid string) (err error) {
+	_, err = c.request(ctx, "DELETE", "alert-notifications/uid/"+uid, nil)
+	return
+}
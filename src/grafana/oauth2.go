@@ -0,0 +1,130 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tokenExpiryMargin is how long before an OAuth2 access token expires the
+// client requests a new one, so a token doesn't expire while a request using
+// it is in flight.
+const tokenExpiryMargin = 30 * time.Second
+
+// tokenSource obtains OAuth2 access tokens using the client credentials grant,
+// and caches them until they expire.
+type tokenSource struct {
+	cfg        *config.OAuth2Settings
+	httpClient *http.Client
+	mutex      sync.Mutex
+	token      string
+	expiry     time.Time
+}
+
+// tokenResponse represents the response of the token endpoint to a token
+// request. ExpiresIn is the token's lifetime in seconds, 0 meaning it wasn't
+// provided.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// newTokenSource returns a new token source using the given OAuth2 settings,
+// which requests the token endpoint with the given HTTP client.
+func newTokenSource(cfg *config.OAuth2Settings, httpClient *http.Client) *tokenSource {
+	return &tokenSource{cfg: cfg, httpClient: httpClient}
+}
+
+// get returns the cached access token if it's still valid, otherwise it
+// requests a new one from the token endpoint and caches it.
+// Returns an error if there was an issue requesting the token endpoint or
+// parsing its response.
+func (s *tokenSource) get(ctx context.Context) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.token) > 0 && (s.expiry.IsZero() || time.Now().Add(tokenExpiryMargin).Before(s.expiry)) {
+		return s.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(s.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.cfg.Scopes, " "))
+	}
+	if len(s.cfg.Audience) > 0 {
+		form.Set("audience", s.cfg.Audience)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, "POST", s.cfg.TokenURL, strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	req.SetBasicAuth(url.QueryEscape(s.cfg.ClientID), url.QueryEscape(s.cfg.ClientSecret))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf(
+			"token endpoint responded with status code %d: %s",
+			resp.StatusCode, strings.TrimSpace(string(body)),
+		)
+	}
+
+	var token tokenResponse
+	if err = json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+
+	if len(token.AccessToken) == 0 {
+		return "", fmt.Errorf("token endpoint didn't return an access token")
+	}
+
+	s.token = token.AccessToken
+	s.expiry = time.Time{}
+	if token.ExpiresIn > 0 {
+		s.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"expiry": s.expiry,
+	}).Info("Obtained a new OAuth2 access token")
+
+	return s.token, nil
+}
+
+// invalidate drops the given access token from the cache (e.g. because it was
+// rejected), so the next call to get requests a new one. If another request
+// already replaced it in the meantime, the cache is left alone.
+func (s *tokenSource) invalidate(token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token == token {
+		s.token = ""
+	}
+}
@@ -0,0 +1,92 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Permission represents an entry of the permissions of a Grafana folder or
+// dashboard, which grants a given permission (1 for view, 2 for edit, 4 for
+// admin) to either a role, a team or a user.
+type Permission struct {
+	Role       string `json:"role,omitempty"`
+	TeamID     int    `json:"teamId,omitempty"`
+	UserID     int    `json:"userId,omitempty"`
+	Permission int    `json:"permission"`
+}
+
+// DashboardPermissions describes the permissions of a Grafana dashboard as
+// stored in the Git repository, along with the UID of the dashboard they apply
+// to, so they can be applied without reading the dashboard's file.
+type DashboardPermissions struct {
+	UID         string       `json:"uid"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// GetDashboardPermissions requests the Grafana API for the permissions of the
+// dashboard with the given UID. Permissions inherited from elsewhere (e.g. from
+// the dashboard's folder) aren't returned.
+// Returns an error if there was an issue requesting the permissions or parsing
+// the response body.
+func (c *Client) GetDashboardPermissions(ctx context.Context, uid string) ([]Permission, error) {
+	return c.getPermissions(ctx, "dashboards/uid/"+uid+"/permissions")
+}
+
+// UpdateDashboardPermissions replaces the permissions of the dashboard with the
+// given UID with the given ones.
+// Returns an error if there was an issue generating the request body or
+// performing the request.
+func (c *Client) UpdateDashboardPermissions(
+	ctx context.Context, uid string, permissions []Permission,
+) error {
+	return c.updatePermissions(ctx, "dashboards/uid/"+uid+"/permissions", permissions)
+}
+
+// getPermissions requests the permissions at the given endpoint of the Grafana
+// API, and returns the ones that aren't inherited.
+// Returns an error if there was an issue requesting the permissions or parsing
+// the response body.
+func (c *Client) getPermissions(
+	ctx context.Context, endpoint string,
+) (permissions []Permission, err error) {
+	resp, err := c.request(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return
+	}
+
+	var items []struct {
+		Permission
+		Inherited bool `json:"inherited"`
+	}
+
+	if err = json.Unmarshal(resp, &items); err != nil {
+		return
+	}
+
+	permissions = make([]Permission, 0, len(items))
+	for _, item := range items {
+		if !item.Inherited {
+			permissions = append(permissions, item.Permission)
+		}
+	}
+
+	return
+}
+
+// updatePermissions replaces the permissions at the given endpoint of the
+// Grafana API with the given ones.
+// Returns an error if there was an issue generating the request body or
+// performing the request.
+func (c *Client) updatePermissions(
+	ctx context.Context, endpoint string, permissions []Permission,
+) error {
+	reqBody, err := json.Marshal(map[string][]Permission{
+		"items": permissions,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.request(ctx, "POST", endpoint, reqBody)
+	return err
+}
@@ -0,0 +1,128 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// playlistGeneratedFields lists the fields of a playlist's JSON description,
+// and of its items, which are set by Grafana itself, and therefore aren't
+// stored in the repository nor sent when pushing the playlist.
+var playlistGeneratedFields = []string{"id", "orgId", "playlistId"}
+
+// Playlist represents a playlist, with its UID, name and JSON description
+// (without the fields Grafana sets itself), including its items.
+type Playlist struct {
+	UID     string
+	Name    string
+	RawJSON []byte
+}
+
+// GetPlaylists requests the Grafana API for the list of all playlists, along
+// with their items. Playlists are only identified by UIDs on Grafana 9 and
+// later, so the ones without a UID are returned without their items.
+// Returns an error if there was an issue requesting the playlists or their
+// items, or parsing a response body.
+func (c *Client) GetPlaylists(ctx context.Context) ([]Playlist, error) {
+	resp, err := c.request(ctx, "GET", "playlists", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []struct {
+		UID  string `json:"uid"`
+		Name string `json:"name"`
+	}
+	if err = json.Unmarshal(resp, &list); err != nil {
+		return nil, err
+	}
+
+	playlists := make([]Playlist, 0, len(list))
+	for _, p := range list {
+		if len(p.UID) == 0 {
+			playlists = append(playlists, Playlist{Name: p.Name})
+			continue
+		}
+
+		resp, err = c.request(ctx, "GET", "playlists/"+url.PathEscape(p.UID), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		playlist, err := unmarshalObject(resp)
+		if err != nil {
+			return nil, err
+		}
+
+		stripPlaylist(playlist)
+
+		rawJSON, err := json.Marshal(playlist)
+		if err != nil {
+			return nil, err
+		}
+
+		playlists = append(playlists, Playlist{
+			UID:     p.UID,
+			Name:    p.Name,
+			RawJSON: rawJSON,
+		})
+	}
+
+	return playlists, nil
+}
+
+// CreateOrUpdatePlaylist takes the JSON description of a playlist and updates
+// the playlist with the same UID on the Grafana instance, or creates it if it
+// doesn't exist.
+// Returns an error if the description couldn't be parsed or doesn't have an
+// UID, or if there was an issue looking the playlist up or performing the
+// request.
+func (c *Client) CreateOrUpdatePlaylist(ctx context.Context, contentJSON []byte) error {
+	playlist, err := unmarshalObject(contentJSON)
+	if err != nil {
+		return err
+	}
+
+	uid, _ := playlist["uid"].(string)
+	if len(uid) == 0 {
+		return fmt.Errorf("Playlist %v has no UID", playlist["name"])
+	}
+
+	stripPlaylist(playlist)
+
+	reqBody, err := json.Marshal(playlist)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.request(ctx, "GET", "playlists/"+url.PathEscape(uid), nil)
+	if IsNotFound(err) {
+		_, err = c.request(ctx, "POST", "playlists", reqBody)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = c.request(ctx, "PUT", "playlists/"+url.PathEscape(uid), reqBody)
+	return err
+}
+
+// stripPlaylist removes the fields Grafana sets itself from the given
+// playlist's description and from its items'.
+func stripPlaylist(playlist map[string]interface{}) {
+	for _, field := range playlistGeneratedFields {
+		delete(playlist, field)
+	}
+
+	items, _ := playlist["items"].([]interface{})
+	for _, item := range items {
+		if item, ok := item.(map[string]interface{}); ok {
+			for _, field := range playlistGeneratedFields {
+				delete(item, field)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package grafana
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+)
+
+// rateLimiter limits the rate of the requests sent to the Grafana API using a
+// token bucket: the bucket holds up to burst tokens, is refilled at the given
+// rate, and each request takes a token, waiting for one if the bucket is
+// empty. Requests waiting concurrently are allowed in the order they arrived.
+type rateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a new rate limiter from the given settings, with a full
+// bucket.
+func newRateLimiter(cfg *config.RateLimitSettings) *rateLimiter {
+	burst := float64(cfg.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:   cfg.RequestsPerSecond,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until the rate limit allows a request to be sent. The token is
+// taken right away, so the bucket can hold a negative number of tokens, which
+// is the number of requests waiting for one.
+// Returns an error if the given context is done before the request is allowed.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mutex.Lock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+
+	l.mutex.Unlock()
+
+	return sleep(ctx, delay)
+}
+
+// sleep pauses the current goroutine for the given duration, or until the given
+// context is done.
+// Returns the context's error if it is done before the end of the duration.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,201 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// Kinds of objects which can reference a dashboard.
+const (
+	ReferenceAlertRule    = "alert rule"
+	ReferencePlaylist     = "playlist"
+	ReferenceLibraryPanel = "library panel"
+	ReferenceDashboard    = "dashboard"
+)
+
+// libraryPanelsPageSize is the number of library panels requested per page
+// when listing them.
+const libraryPanelsPageSize = 500
+
+// DashboardReference describes an object referencing a dashboard, which would
+// be left with a broken reference if the dashboard was deleted. Kind is the
+// kind of the object, and Name its human-readable name.
+type DashboardReference struct {
+	Kind string
+	Name string
+}
+
+// String implements fmt.Stringer.
+func (r DashboardReference) String() string {
+	return fmt.Sprintf("%s %q", r.Kind, r.Name)
+}
+
+// GetDashboardReferences requests the Grafana API for the alert rules attached
+// to the dashboard with the given UID, the playlists including it, and the
+// library panels linking to it. The objects which the Grafana instance doesn't
+// support (e.g. alert rules before Grafana 9) are left out.
+// Returns an error if there was an issue requesting the API or parsing the
+// responses.
+func (c *Client) GetDashboardReferences(
+	ctx context.Context, uid string,
+) (refs []DashboardReference, err error) {
+	for _, get := range []func(context.Context, string) ([]DashboardReference, error){
+		c.alertRulesReferencing,
+		c.playlistsReferencing,
+		c.libraryPanelsReferencing,
+	} {
+		found, err := get(ctx, uid)
+		if err != nil && !IsNotFound(err) {
+			return nil, err
+		}
+
+		refs = append(refs, found...)
+	}
+
+	return
+}
+
+// LinksToDashboard checks whether the given JSON description (of a dashboard or
+// a panel) contains a link to the dashboard with the given UID, e.g. in its
+// links, its panels' links or data links, or the content of a text panel.
+func LinksToDashboard(content []byte, uid string) bool {
+	// The UID must be followed by a character which can't be part of it, so
+	// that the UID of a dashboard isn't matched by a link to a dashboard which
+	// UID starts with it.
+	link := regexp.MustCompile(`/d/` + regexp.QuoteMeta(uid) + `([^A-Za-z0-9_-]|$)`)
+	return link.Match(content)
+}
+
+// alertRulesReferencing returns the alert rules attached to the dashboard with
+// the given UID, using the alerting provisioning API (Grafana 9 and later).
+// Returns an error if there was an issue requesting the alert rules or parsing
+// the response body.
+func (c *Client) alertRulesReferencing(
+	ctx context.Context, uid string,
+) ([]DashboardReference, error) {
+	rules, err := c.GetAlertRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]DashboardReference, 0)
+	for _, rule := range rules {
+		if rule.DashboardUID() == uid {
+			refs = append(refs, DashboardReference{
+				Kind: ReferenceAlertRule,
+				Name: rule.Title,
+			})
+		}
+	}
+
+	return refs, nil
+}
+
+// playlistsReferencing returns the playlists including the dashboard with the
+// given UID.
+// Returns an error if there was an issue requesting the playlists or their
+// items, or parsing the response bodies.
+func (c *Client) playlistsReferencing(
+	ctx context.Context, uid string,
+) ([]DashboardReference, error) {
+	resp, err := c.request(ctx, "GET", "playlists", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var playlists []struct {
+		UID  string `json:"uid"`
+		Name string `json:"name"`
+	}
+	if err = json.Unmarshal(resp, &playlists); err != nil {
+		return nil, err
+	}
+
+	refs := make([]DashboardReference, 0)
+	for _, playlist := range playlists {
+		// Playlists are only identified by UIDs on Grafana 9 and later, and
+		// can only include dashboards by UID on these versions.
+		if len(playlist.UID) == 0 {
+			continue
+		}
+
+		resp, err := c.request(
+			ctx, "GET", "playlists/"+url.PathEscape(playlist.UID)+"/items", nil,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var items []struct {
+			Type  string `json:"type"`
+			Value string `json:"value"`
+		}
+		if err = json.Unmarshal(resp, &items); err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			if item.Type == "dashboard_by_uid" && item.Value == uid {
+				refs = append(refs, DashboardReference{
+					Kind: ReferencePlaylist,
+					Name: playlist.Name,
+				})
+
+				break
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+// libraryPanelsReferencing returns the library panels (Grafana 8 and later)
+// which link to the dashboard with the given UID.
+// Returns an error if there was an issue requesting a page of library panels
+// or parsing the response body.
+func (c *Client) libraryPanelsReferencing(
+	ctx context.Context, uid string,
+) ([]DashboardReference, error) {
+	refs := make([]DashboardReference, 0)
+
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("kind", "1")
+		query.Set("perPage", strconv.Itoa(libraryPanelsPageSize))
+		query.Set("page", strconv.Itoa(page))
+
+		resp, err := c.request(ctx, "GET", "library-elements?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var body struct {
+			Result struct {
+				Elements []struct {
+					Name  string          `json:"name"`
+					Model json.RawMessage `json:"model"`
+				} `json:"elements"`
+			} `json:"result"`
+		}
+		if err = json.Unmarshal(resp, &body); err != nil {
+			return nil, err
+		}
+
+		for _, element := range body.Result.Elements {
+			if LinksToDashboard(element.Model, uid) {
+				refs = append(refs, DashboardReference{
+					Kind: ReferenceLibraryPanel,
+					Name: element.Name,
+				})
+			}
+		}
+
+		if len(body.Result.Elements) < libraryPanelsPageSize {
+			return refs, nil
+		}
+	}
+}
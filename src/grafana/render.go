@@ -0,0 +1,57 @@
+package grafana
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Default dimensions (in pixels) of the images rendered by RenderDashboard.
+const (
+	defaultRenderWidth  = 1000
+	defaultRenderHeight = 500
+)
+
+// RenderDashboard requests the Grafana rendering API for a PNG image of the
+// dashboard with the given UID and slug, with the given dimensions (in pixels).
+// If a dimension is 0, its default value is used instead. Dashboards without an
+// UID (on Grafana versions older than 5.0) are rendered using the legacy route,
+// which identifies them by their slug only.
+// Returns the PNG image.
+// Returns an error if there was an issue performing the request, or if the
+// response isn't a PNG image (which usually means no image renderer is
+// available on the Grafana instance).
+func (c *Client) RenderDashboard(
+	ctx context.Context, uid string, slug string, width int, height int,
+) ([]byte, error) {
+	if width == 0 {
+		width = defaultRenderWidth
+	}
+
+	if height == 0 {
+		height = defaultRenderHeight
+	}
+
+	route := fmt.Sprintf(
+		"/render/d/%s/%s?width=%d&height=%d", uid, slug, width, height,
+	)
+	if len(uid) == 0 {
+		route = fmt.Sprintf(
+			"/render/dashboard/db/%s?width=%d&height=%d", slug, width, height,
+		)
+	}
+
+	png, err := c.requestRoute(ctx, "GET", route, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType := http.DetectContentType(png); contentType != "image/png" {
+		return nil, fmt.Errorf(
+			"Rendering dashboard %s returned %s instead of a PNG image",
+			slug, contentType,
+		)
+	}
+
+	return png, nil
+}
@@ -0,0 +1,54 @@
+package grafana
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// pngHeader is enough of a PNG image for http.DetectContentType to recognise
+// it.
+var pngHeader = []byte("\x89PNG\x0D\x0A\x1A\x0A")
+
+func TestRenderDashboard(t *testing.T) {
+	tests := []struct {
+		name     string
+		uid      string
+		expected string
+	}{
+		{
+			name:     "uid",
+			uid:      "abc",
+			expected: "/render/d/abc/my-dashboard",
+		},
+		{
+			name:     "no uid",
+			expected: "/render/dashboard/db/my-dashboard",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requested string
+			server := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					requested = r.URL.Path
+					w.Write(pngHeader)
+				},
+			))
+			defer server.Close()
+
+			client := NewClient(server.URL, "key")
+			if _, err := client.RenderDashboard(
+				context.Background(), test.uid, "my-dashboard", 0, 0,
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			if requested != test.expected {
+				t.Errorf("expected %s to be requested, got %s", test.expected, requested)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+
+	"github.com/sirupsen/logrus"
+)
+
+// schemaVersions lists, for Grafana versions, the latest dashboard schema
+// version they support, in ascending order.
+var schemaVersions = []struct {
+	major  int
+	minor  int
+	schema int
+}{
+	{5, 0, 16},
+	{6, 0, 18},
+	{6, 5, 21},
+	{7, 0, 25},
+	{7, 4, 27},
+	{8, 0, 30},
+	{8, 3, 33},
+	{8, 5, 36},
+	{9, 4, 37},
+	{10, 0, 38},
+	{10, 2, 39},
+	{11, 3, 40},
+	{12, 0, 41},
+}
+
+// SupportedVersions returns the oldest and newest Grafana versions the manager
+// supports (e.g. "5.0" and "12.x"), i.e. the ones it knows the dashboard
+// schema of.
+func SupportedVersions() (oldest string, newest string) {
+	first, last := schemaVersions[0], schemaVersions[len(schemaVersions)-1]
+	return fmt.Sprintf("%d.%d", first.major, first.minor), fmt.Sprintf("%d.x", last.major)
+}
+
+// v2SchemaMajor is the first major version of Grafana supporting the v2
+// dashboard schema.
+const v2SchemaMajor = 12
+
+// GetVersion requests the Grafana API for the version of the Grafana instance,
+// and returns it (e.g. "10.2.3"). The version is cached after the first
+// successful request.
+// Returns an error if there was an issue requesting the version or parsing the
+// response body.
+func (c *Client) GetVersion(ctx context.Context) (string, error) {
+	c.versionMutex.Lock()
+	defer c.versionMutex.Unlock()
+
+	if len(c.version) > 0 {
+		return c.version, nil
+	}
+
+	resp, err := c.request(ctx, "GET", "health", nil)
+	if err != nil {
+		return "", err
+	}
+
+	var health struct {
+		Version string `json:"version"`
+	}
+
+	if err = json.Unmarshal(resp, &health); err != nil {
+		return "", err
+	}
+
+	c.version = health.Version
+	return c.version, nil
+}
+
+// CheckCompatibility checks whether the dashboard described by the given JSON
+// description can be loaded by the Grafana instance, by comparing its schema
+// version with the latest one the instance supports. If the instance's version
+// can't be retrieved or is unknown, the dashboard is considered compatible.
+// Returns an error describing the incompatibility if the dashboard isn't
+// compatible, or if its JSON description couldn't be parsed.
+func (c *Client) CheckCompatibility(ctx context.Context, dashboardJSON []byte) error {
+	schemaVersion, v2, err := helpers.GetDashboardSchemaVersion(dashboardJSON)
+	if err != nil {
+		return err
+	}
+
+	version, err := c.GetVersion(ctx)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("Failed to retrieve Grafana's version, not checking compatibility")
+
+		return nil
+	}
+
+	major, minor, ok := parseVersion(version)
+	if !ok {
+		return nil
+	}
+
+	if v2 {
+		if major < v2SchemaMajor {
+			return fmt.Errorf(
+				"dashboard uses the v2 schema, which Grafana %s doesn't support",
+				version,
+			)
+		}
+
+		return nil
+	}
+
+	maxSchemaVersion := 0
+	for _, v := range schemaVersions {
+		if major > v.major || (major == v.major && minor >= v.minor) {
+			maxSchemaVersion = v.schema
+		}
+	}
+
+	// Versions older than the ones we know of, and the latest known version,
+	// are considered compatible with everything.
+	if maxSchemaVersion == 0 || maxSchemaVersion == schemaVersions[len(schemaVersions)-1].schema {
+		return nil
+	}
+
+	if schemaVersion > maxSchemaVersion {
+		return fmt.Errorf(
+			"dashboard uses schema version %d, but Grafana %s only supports up to %d",
+			schemaVersion, version, maxSchemaVersion,
+		)
+	}
+
+	return nil
+}
+
+// parseVersion extracts the major and minor version numbers from a Grafana
+// version (e.g. "10.2.3" or "11.0.0-preview").
+// Returns false if the version couldn't be parsed.
+func parseVersion(version string) (major int, minor int, ok bool) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return
+	}
+
+	var err error
+	if major, err = strconv.Atoi(parts[0]); err != nil {
+		return
+	}
+
+	if minor, err = strconv.Atoi(parts[1]); err != nil {
+		return
+	}
+
+	return major, minor, true
+}
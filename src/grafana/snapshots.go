@@ -0,0 +1,38 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Snapshot represents a dashboard snapshot, as listed by the Grafana API. Key
+// identifies the snapshot, and External is true if the snapshot was published
+// to an external snapshot server, in which case its content isn't stored on
+// the Grafana instance.
+type Snapshot struct {
+	Key      string `json:"key"`
+	Name     string `json:"name"`
+	External bool   `json:"external"`
+}
+
+// GetSnapshots requests the Grafana API for the list of all dashboard
+// snapshots.
+// Returns an error if there was an issue requesting the snapshots or parsing
+// the response body.
+func (c *Client) GetSnapshots(ctx context.Context) (snapshots []Snapshot, err error) {
+	resp, err := c.request(ctx, "GET", "dashboard/snapshots", nil)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(resp, &snapshots)
+	return
+}
+
+// GetSnapshot requests the Grafana API for the snapshot with the given key, and
+// returns its JSON description, which includes the snapshotted dashboard along
+// with the snapshot's metadata.
+// Returns an error if there was an issue requesting the snapshot.
+func (c *Client) GetSnapshot(ctx context.Context, key string) ([]byte, error) {
+	return c.request(ctx, "GET", "snapshots/"+key, nil)
+}
@@ -0,0 +1,138 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+)
+
+// Sorting options of the search API added by Grafana Enterprise's usage
+// insights, which rank dashboards by their total number of views and by their
+// number of views during the last 30 days.
+const (
+	sortViewsTotal  = "views-total"
+	sortViewsRecent = "views-recent"
+)
+
+// ErrUsageUnavailable is returned when the Grafana instance doesn't provide
+// usage insights, e.g. because it isn't running Grafana Enterprise.
+var ErrUsageUnavailable = errors.New("Usage insights aren't available on this Grafana instance")
+
+// DashboardUsage describes how much a dashboard is used: its total number of
+// views, and its number of views during the last 30 days.
+type DashboardUsage struct {
+	Ref         DashboardRef
+	Title       string
+	Views       int
+	RecentViews int
+}
+
+// usageSearchResponse represents an element of the response to a dashboard
+// search query sorted by views, in which the number of views is the sorting
+// metadata.
+type usageSearchResponse struct {
+	UID      string `json:"uid"`
+	URI      string `json:"uri"`
+	Title    string `json:"title"`
+	SortMeta int    `json:"sortMeta"`
+}
+
+// GetDashboardsUsage requests the Grafana API for the number of views of every
+// dashboard, using the search API's sorting options from Grafana Enterprise's
+// usage insights.
+// Returns an error of type ErrUsageUnavailable if the instance doesn't provide
+// these sorting options, or an error if there was an issue requesting the API
+// or parsing the responses.
+func (c *Client) GetDashboardsUsage(ctx context.Context) ([]DashboardUsage, error) {
+	body, err := c.request(ctx, "GET", "search/sorting", nil)
+	if err != nil {
+		// Grafana versions older than 7.0 don't have this route.
+		if IsNotFound(err) {
+			return nil, ErrUsageUnavailable
+		}
+
+		return nil, err
+	}
+
+	var options []struct {
+		Name string `json:"name"`
+	}
+	if err = json.Unmarshal(body, &options); err != nil {
+		return nil, err
+	}
+
+	available := make(map[string]bool)
+	for _, option := range options {
+		available[option.Name] = true
+	}
+
+	if !available[sortViewsTotal] || !available[sortViewsRecent] {
+		return nil, ErrUsageUnavailable
+	}
+
+	total, err := c.searchSortedBy(ctx, sortViewsTotal)
+	if err != nil {
+		return nil, err
+	}
+
+	recent, err := c.searchSortedBy(ctx, sortViewsRecent)
+	if err != nil {
+		return nil, err
+	}
+
+	recentViews := make(map[DashboardRef]int)
+	for _, db := range recent {
+		recentViews[DashboardRef{UID: db.UID, URI: db.URI}] = db.SortMeta
+	}
+
+	usage := make([]DashboardUsage, 0, len(total))
+	for _, db := range total {
+		ref := DashboardRef{UID: db.UID, URI: db.URI}
+		usage = append(usage, DashboardUsage{
+			Ref:         ref,
+			Title:       db.Title,
+			Views:       db.SortMeta,
+			RecentViews: recentViews[ref],
+		})
+	}
+
+	return usage, nil
+}
+
+// FindUsage looks for the usage of the dashboard identified by the given
+// reference in the given list, using its UID if it has one, else its URI.
+// Returns a boolean set to false if the dashboard couldn't be found.
+func FindUsage(usage []DashboardUsage, ref DashboardRef) (DashboardUsage, bool) {
+	for _, u := range usage {
+		if len(ref.UID) > 0 && u.Ref.UID == ref.UID {
+			return u, true
+		}
+
+		if len(ref.UID) == 0 && u.Ref.URI == ref.URI {
+			return u, true
+		}
+	}
+
+	return DashboardUsage{}, false
+}
+
+// searchSortedBy requests the Grafana API for the list of all dashboards,
+// sorted using the given sorting option.
+// Returns an error if there was an issue requesting the dashboards or parsing
+// the response body.
+func (c *Client) searchSortedBy(
+	ctx context.Context, sort string,
+) (results []usageSearchResponse, err error) {
+	query := url.Values{}
+	query.Set("type", "dash-db")
+	query.Set("sort", sort)
+
+	body, err := c.request(ctx, "GET", "search?"+query.Encode(), nil)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(body, &results)
+	return
+}
@@ -0,0 +1,33 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+)
+
+// User represents a user of the Grafana instance, with their login, name
+// (which can be empty) and email address.
+type User struct {
+	Login string `json:"login"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// LookupUser requests the Grafana API for the user with the given login or
+// email address. This requires the API key (or service account) to be allowed
+// to read the instance's users.
+// Returns an error if there was an issue requesting the user (e.g. if it
+// doesn't exist) or parsing the response body.
+func (c *Client) LookupUser(ctx context.Context, loginOrEmail string) (*User, error) {
+	resp, err := c.request(
+		ctx, "GET", "users/lookup?loginOrEmail="+url.QueryEscape(loginOrEmail), nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user := new(User)
+	err = json.Unmarshal(resp, user)
+	return user, err
+}
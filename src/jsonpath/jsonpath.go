@@ -0,0 +1,404 @@
+package jsonpath
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// excerptLength is the maximum length of the excerpts included in errors.
+const excerptLength = 60
+
+// pathInMessage matches the substrings of an error message which could be
+// paths of JSON values (e.g. "panels[3].targets[0].datasource"), i.e. a key
+// followed by at least one index or key.
+var pathInMessage = regexp.MustCompile(`[A-Za-z_$][\w$]*(?:\[\d+\]|\.[A-Za-z_$][\w$]*)+`)
+
+// identifier matches the keys which can be written after a dot in a path.
+var identifier = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+
+// Error is an error about a specific value in a dashboard's JSON description:
+// its path (e.g. "panels[3].targets[0].datasource", "." being the root), its
+// line and column in the description (both 0 if the description holds on a
+// single line, since they wouldn't help finding the value), and an excerpt of
+// the description starting at the value.
+type Error struct {
+	Path    string
+	Line    int
+	Column  int
+	Excerpt string
+	Err     error
+}
+
+// Error implements error.Error().
+func (e *Error) Error() string {
+	location := e.Path
+	if e.Line > 0 {
+		location += fmt.Sprintf(" (line %d, column %d)", e.Line, e.Column)
+	}
+
+	msg := location + ": " + e.Err.Error()
+	if len(e.Excerpt) > 0 {
+		msg += fmt.Sprintf(" [near %s]", e.Excerpt)
+	}
+
+	return msg
+}
+
+// Unwrap returns the error about the value, so the Error can be checked with
+// errors.Is and errors.As.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Annotate returns the given error about a dashboard's JSON description as an
+// Error locating the value it's about, if possible: for errors from parsing the
+// description (i.e. *json.SyntaxError and *json.UnmarshalTypeError), the value
+// at the offset of the error, else the first value which path is mentioned in
+// the error's message, if any (e.g. in an error returned by Grafana's API). A
+// "spec." prefix is ignored in paths from messages, since Grafana's newer APIs
+// nest the dashboard under it. Returns the error as is if it can't be located,
+// or if it's already an Error.
+func Annotate(dashboardJSON []byte, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var located *Error
+	if errors.As(err, &located) {
+		return err
+	}
+
+	// The offsets of parsing errors are the number of bytes read before the
+	// error, i.e. right after the offending character.
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return AtOffset(dashboardJSON, syntaxErr.Offset-1, err)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return AtOffset(dashboardJSON, typeErr.Offset-1, err)
+	}
+
+	for _, candidate := range pathInMessage.FindAllString(err.Error(), -1) {
+		for _, path := range []string{candidate, strings.TrimPrefix(candidate, "spec.")} {
+			if s, ok := find(dashboardJSON, path); ok {
+				return newError(dashboardJSON, s, err)
+			}
+		}
+	}
+
+	return err
+}
+
+// At returns the given error as an Error about the value at the given path in a
+// dashboard's JSON description, or as is if there's no value at this path.
+func At(dashboardJSON []byte, path string, err error) error {
+	s, ok := find(dashboardJSON, path)
+	if !ok {
+		return err
+	}
+
+	return newError(dashboardJSON, s, err)
+}
+
+// AtOffset returns the given error as an Error about the innermost value
+// containing the given byte offset in a dashboard's JSON description.
+func AtOffset(dashboardJSON []byte, offset int64, err error) error {
+	if offset < 0 {
+		offset = 0
+	}
+
+	s := locate(dashboardJSON, offset)
+
+	// Point at the offset itself if it's in an invalid part of the description
+	// rather than at the start of the value it belongs to.
+	if s.start < offset && !json.Valid(dashboardJSON) {
+		s.start = offset
+	}
+
+	return newError(dashboardJSON, s, err)
+}
+
+// AtLine returns the given error as an Error about the innermost value
+// containing the given position (lines and columns starting at 1) in a
+// dashboard's JSON description.
+func AtLine(dashboardJSON []byte, line int, column int, err error) error {
+	return AtOffset(dashboardJSON, offsetOf(dashboardJSON, line, column), err)
+}
+
+// Find returns the byte offset, in a dashboard's JSON description, of the start
+// of the value at the given path, and whether there's a value at this path.
+func Find(dashboardJSON []byte, path string) (int64, bool) {
+	s, ok := find(dashboardJSON, path)
+	return s.start, ok
+}
+
+// Locate returns the path of the innermost value containing the given byte
+// offset in a dashboard's JSON description, and the offset of the value's
+// start. If the description is invalid, the values opened before the error are
+// considered to contain the rest of the description.
+func Locate(dashboardJSON []byte, offset int64) (string, int64) {
+	s := locate(dashboardJSON, offset)
+	return s.path, s.start
+}
+
+// find returns the value at the given path in the given JSON description, and
+// whether there's one.
+func find(content []byte, path string) (span, bool) {
+	for _, s := range spans(content) {
+		if s.path == path {
+			return s, true
+		}
+	}
+
+	return span{}, false
+}
+
+// locate returns the innermost value containing the given byte offset in the
+// given JSON description (see Locate).
+func locate(content []byte, offset int64) span {
+	best := span{path: ".", end: int64(len(content))}
+	for _, s := range spans(content) {
+		if s.start <= offset && offset < s.end && s.start >= best.start {
+			best = s
+		}
+	}
+
+	return best
+}
+
+// newError returns a new Error about the given value of the given description.
+func newError(dashboardJSON []byte, s span, err error) *Error {
+	e := &Error{
+		Path:    s.path,
+		Excerpt: excerpt(dashboardJSON, s.start, s.end),
+		Err:     err,
+	}
+
+	if bytes.Count(dashboardJSON, []byte("\n")) > 1 {
+		e.Line, e.Column = position(dashboardJSON, s.start)
+	}
+
+	return e
+}
+
+// span is a value in a JSON description: its path, and the byte offsets of its
+// start and (exclusive) end.
+type span struct {
+	path  string
+	start int64
+	end   int64
+}
+
+// frame is an object or array being read from a JSON description: whether it's
+// an array, the index of its current element if it is, else the key of its
+// current value and whether the next token is a key, and the index of its span.
+type frame struct {
+	array     bool
+	index     int
+	key       string
+	expectKey bool
+	span      int
+}
+
+// spans returns the values in the given JSON description, in the order they
+// start in. If the description is invalid, the values opened before the error
+// end with the description, along with the value of the key read last, if its
+// value couldn't be read.
+func spans(content []byte) []span {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+
+	result := make([]span, 0)
+	stack := make([]*frame, 0)
+	end := int64(len(content))
+
+	for {
+		before := dec.InputOffset()
+		tok, err := dec.Token()
+		if err != nil {
+			// Account for the value of the last key, which couldn't be read.
+			if err != io.EOF && len(stack) > 0 {
+				top := stack[len(stack)-1]
+				if !top.array && !top.expectKey {
+					result = append(result, span{
+						path:  pathOf(stack),
+						start: skip(content, before),
+						end:   end,
+					})
+				}
+			}
+
+			// Values which weren't closed end with the description.
+			for i := range result {
+				if result[i].end == 0 {
+					result[i].end = end
+				}
+			}
+
+			return result
+		}
+
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		// Keys aren't values.
+		if top != nil && !top.array && top.expectKey {
+			if key, ok := tok.(string); ok {
+				top.key = key
+				top.expectKey = false
+				continue
+			}
+		}
+
+		start := skip(content, before)
+
+		if delim, ok := tok.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				result = append(result, span{path: pathOf(stack), start: start})
+				stack = append(stack, &frame{
+					array:     delim == '[',
+					expectKey: delim == '{',
+					span:      len(result) - 1,
+				})
+
+			default:
+				result[top.span].end = dec.InputOffset()
+				stack = stack[:len(stack)-1]
+				advance(stack)
+			}
+
+			continue
+		}
+
+		result = append(result, span{
+			path:  pathOf(stack),
+			start: start,
+			end:   dec.InputOffset(),
+		})
+		advance(stack)
+	}
+}
+
+// advance moves the innermost object or array in the given stack to its next
+// value, if there's one.
+func advance(stack []*frame) {
+	if len(stack) == 0 {
+		return
+	}
+
+	top := stack[len(stack)-1]
+	if top.array {
+		top.index++
+	} else {
+		top.expectKey = true
+	}
+}
+
+// pathOf returns the path of the current value of the innermost object or
+// array in the given stack, or "." for the root value.
+func pathOf(stack []*frame) string {
+	var path string
+	for _, f := range stack {
+		switch {
+		case f.array:
+			path += fmt.Sprintf("[%d]", f.index)
+		case f.expectKey:
+			// The object's current value is the object itself, since its next
+			// key wasn't read yet.
+		case identifier.MatchString(f.key):
+			if len(path) > 0 {
+				path += "."
+			}
+			path += f.key
+		default:
+			path += fmt.Sprintf("[%q]", f.key)
+		}
+	}
+
+	if len(path) == 0 {
+		return "."
+	}
+
+	return path
+}
+
+// skip returns the offset of the first character in the given content, from
+// the given offset, which isn't a whitespace or separator between values.
+func skip(content []byte, offset int64) int64 {
+	for offset < int64(len(content)) && strings.IndexByte(" \t\r\n,:", content[offset]) >= 0 {
+		offset++
+	}
+
+	return offset
+}
+
+// position returns the line and column (both starting at 1) of the given byte
+// offset in the given content.
+func position(content []byte, offset int64) (line int, column int) {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+
+	before := content[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = len(before) - bytes.LastIndexByte(before, '\n')
+
+	return
+}
+
+// offsetOf returns the byte offset of the given line and column (both starting
+// at 1) in the given content, or the content's length if it's beyond its end.
+func offsetOf(content []byte, line int, column int) int64 {
+	offset := 0
+	for l := 1; l < line; l++ {
+		i := bytes.IndexByte(content[offset:], '\n')
+		if i < 0 {
+			return int64(len(content))
+		}
+		offset += i + 1
+	}
+
+	if column > 1 {
+		offset += column - 1
+	}
+	if offset > len(content) {
+		offset = len(content)
+	}
+
+	return int64(offset)
+}
+
+// excerpt returns the part of the given content between the given offsets, on
+// a single line and with its whitespaces collapsed, truncated to the excerpts'
+// maximum length. Returns an empty string if there's nothing to show.
+func excerpt(content []byte, start int64, end int64) string {
+	if end > int64(len(content)) {
+		end = int64(len(content))
+	}
+	if end > start+4*excerptLength {
+		end = start + 4*excerptLength
+	}
+	if start >= end {
+		return ""
+	}
+
+	text := []rune(strings.Join(strings.Fields(string(content[start:end])), " "))
+	if len(text) == 0 {
+		return ""
+	}
+	if len(text) > excerptLength {
+		return "`" + string(text[:excerptLength]) + "...`"
+	}
+
+	return "`" + string(text) + "`"
+}
@@ -0,0 +1,113 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// journalSocket is the path of the socket the systemd journal receives entries
+// on, using its native protocol.
+const journalSocket = "/run/systemd/journal/socket"
+
+// journaldPriorities maps logrus' levels to syslog's priorities, which the
+// journal uses.
+var journaldPriorities = map[logrus.Level]int{
+	logrus.PanicLevel: 2,
+	logrus.FatalLevel: 2,
+	logrus.ErrorLevel: 3,
+	logrus.WarnLevel:  4,
+	logrus.InfoLevel:  6,
+	logrus.DebugLevel: 7,
+}
+
+// journaldHook is a logrus hook sending the log entries to the systemd journal,
+// with each of their fields as a journal field (e.g. the "run_id" field as
+// "RUN_ID"), so they can be used to filter the entries with journalctl.
+type journaldHook struct {
+	conn       *net.UnixConn
+	identifier string
+}
+
+// newJournaldHook creates a logrus hook sending the log entries to the systemd
+// journal, identified by the program's name.
+// Returns an error if the journal's socket couldn't be reached.
+func newJournaldHook() (*journaldHook, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{
+		Name: journalSocket,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &journaldHook{
+		conn:       conn,
+		identifier: filepath.Base(os.Args[0]),
+	}, nil
+}
+
+// Levels implements logrus.Hook.Levels().
+func (h *journaldHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook.Fire(). Entries too big to fit in a single
+// datagram are rejected by the journal, in which case an error is returned.
+func (h *journaldHook) Fire(entry *logrus.Entry) error {
+	buf := new(bytes.Buffer)
+	writeJournalField(buf, "MESSAGE", entry.Message)
+	writeJournalField(buf, "PRIORITY", fmt.Sprint(journaldPriorities[entry.Level]))
+	writeJournalField(buf, "SYSLOG_IDENTIFIER", h.identifier)
+
+	for key, value := range entry.Data {
+		writeJournalField(buf, journalFieldName(key), fmt.Sprint(value))
+	}
+
+	_, err := h.conn.Write(buf.Bytes())
+	return err
+}
+
+// writeJournalField writes the given field in the given buffer using the
+// journal's native protocol, in which values containing newlines are prefixed
+// with their length instead of following an equal sign.
+func writeJournalField(buf *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", name, value)
+		return
+	}
+
+	buf.WriteString(name + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+// journalFieldName converts the name of a logrus field into a valid journal
+// field name, which can only contain uppercase letters, digits and
+// underscores, and can't start with an underscore or a digit (e.g. "run_id"
+// becomes "RUN_ID").
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+
+	name = strings.TrimLeft(name, "_")
+	if len(name) == 0 || (name[0] >= '0' && name[0] <= '9') {
+		name = "FIELD_" + name
+	}
+
+	return name
+}
@@ -16,8 +16,12 @@ func (f utcFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	return f.Formatter.Format(entry)
 }
 
-// LogConfig sets the format of the default logger.
+// LogConfig sets the format of the default logger, and makes it tag the log
+// entries with the ID of the sync run their context belongs to, if any (see
+// StartRun).
 func LogConfig() {
+	logrus.AddHook(runHook{})
+
 	logrus.SetFormatter(&utcFormatter{
 		&logrus.TextFormatter{
 			TimestampFormat:  "2006-01-02T15:04:05.000000000Z07:00",
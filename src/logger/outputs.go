@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"io/ioutil"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigureOutputs sets up the additional outputs of the default logger (syslog
+// and the systemd journal) from the given settings, if any, and disables the
+// standard error output if requested. It must be called after Redact, so that
+// the entries sent to these outputs are redacted too.
+// Returns an error if the syslog daemon or the journal couldn't be reached.
+func ConfigureOutputs(cfg *config.LoggingSettings) error {
+	if cfg == nil {
+		return nil
+	}
+
+	if cfg.Syslog != nil {
+		hook, err := newSyslogHook(cfg.Syslog)
+		if err != nil {
+			return err
+		}
+
+		logrus.AddHook(hook)
+	}
+
+	if cfg.Journald {
+		hook, err := newJournaldHook()
+		if err != nil {
+			return err
+		}
+
+		logrus.AddHook(hook)
+	}
+
+	if cfg.DisableStderr {
+		logrus.SetOutput(ioutil.Discard)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runKey is the key under which the ID of a sync run is stored in the context
+// of the run.
+type runKey struct{}
+
+// runHook is a logrus hook adding the ID of the sync run an entry's context
+// belongs to, if any, to the fields of the entry.
+type runHook struct{}
+
+// StartRun marks the beginning of a sync run (e.g. a pull, or the processing of
+// a push event) by generating a random ID for the run. The ID is attached as
+// the "run_id" field to every log entry created with the returned context (see
+// logrus.WithContext). Since the ID only lives in the run's context, several
+// runs can be ongoing at the same time without their entries being mixed up.
+// Returns a copy of the given context carrying the run's ID.
+func StartRun(ctx context.Context) context.Context {
+	return WithRun(ctx, NewRunID())
+}
+
+// WithRun returns a copy of the given context carrying the given run ID, e.g.
+// an ID generated with NewRunID before the run actually started.
+func WithRun(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, runKey{}, id)
+}
+
+// RunID returns the ID of the sync run the given context belongs to, or an
+// empty string if it doesn't belong to any.
+func RunID(ctx context.Context) string {
+	id, _ := ctx.Value(runKey{}).(string)
+	return id
+}
+
+// NewRunID generates a random ID for a sync run. If the system's random
+// generator fails, which shouldn't happen, the ID is left empty so the
+// run isn't prevented from happening.
+func NewRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// Levels implements logrus.Hook.Levels().
+func (h runHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook.Fire(). The entry's fields are copied rather
+// than modified in place, since they can be shared with other entries.
+func (h runHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+
+	id := RunID(entry.Context)
+	if len(id) == 0 {
+		return nil
+	}
+
+	data := make(logrus.Fields, len(entry.Data)+1)
+	for key, value := range entry.Data {
+		data[key] = value
+	}
+
+	data["run_id"] = id
+	entry.Data = data
+	return nil
+}
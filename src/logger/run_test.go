@@ -0,0 +1,40 @@
+package logger
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestRunHook(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	log.AddHook(runHook{})
+	hook := test.NewLocal(log)
+
+	// Two runs ongoing at the same time must each tag their own entries.
+	first := StartRun(context.Background())
+	second := StartRun(context.Background())
+	if RunID(first) == RunID(second) {
+		t.Fatalf("expected distinct run IDs, got %s twice", RunID(first))
+	}
+
+	log.WithContext(first).Info("first")
+	log.WithContext(second).WithField("field", "value").Info("second")
+	log.Info("outside of a run")
+
+	entries := hook.AllEntries()
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+
+	for i, expected := range []string{RunID(first), RunID(second), ""} {
+		id, _ := entries[i].Data["run_id"].(string)
+		if id != expected {
+			t.Errorf("entry %d: expected run ID %q, got %q", i, expected, id)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package logger
+
+import (
+	"log/syslog"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+
+	"github.com/sirupsen/logrus"
+	logrus_syslog "github.com/sirupsen/logrus/hooks/syslog"
+)
+
+// newSyslogHook creates a logrus hook sending the log entries to the syslog
+// daemon described by the given settings, with the "daemon" facility.
+// Returns an error if the daemon couldn't be reached.
+func newSyslogHook(cfg *config.SyslogSettings) (logrus.Hook, error) {
+	return logrus_syslog.NewSyslogHook(
+		cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_DAEMON, cfg.Tag,
+	)
+}
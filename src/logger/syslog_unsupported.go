@@ -0,0 +1,17 @@
+//go:build windows || nacl || plan9
+// +build windows nacl plan9
+
+package logger
+
+import (
+	"errors"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newSyslogHook always fails, since syslog isn't available on this platform.
+func newSyslogHook(cfg *config.SyslogSettings) (logrus.Hook, error) {
+	return nil, errors.New("Syslog isn't supported on this platform")
+}
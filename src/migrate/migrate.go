@@ -0,0 +1,131 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// panelsMigrations maps the name of each migration applying to individual
+// panels to the function performing it. Each function migrates the given panel
+// in place, and returns true if it changed it.
+var panelsMigrations = map[string]func(panel map[string]interface{}) bool{
+	config.MigrationGraph:      migrateGraph,
+	config.MigrationSinglestat: migrateSinglestat,
+}
+
+// Migrate applies the given migrations to a dashboard's JSON description, and
+// returns the migrated description along with the names of the migrations that
+// changed it. If none did, the description is returned as is.
+// Returns an error if the description couldn't be parsed or re-encoded, or if
+// one of the migrations is unknown.
+func Migrate(
+	dashboardJSON []byte, migrations []string,
+) (migrated []byte, applied []string, err error) {
+	var dashboard map[string]interface{}
+	if err = json.Unmarshal(dashboardJSON, &dashboard); err != nil {
+		return
+	}
+
+	applied = make([]string, 0)
+	for _, migration := range migrations {
+		var changed bool
+		if migration == config.MigrationRows {
+			changed = migrateRows(dashboard)
+		} else if migratePanel, ok := panelsMigrations[migration]; ok {
+			for _, panel := range allPanels(dashboard) {
+				changed = migratePanel(panel) || changed
+			}
+		} else {
+			return nil, nil, fmt.Errorf("unknown migration %s", migration)
+		}
+
+		if changed {
+			applied = append(applied, migration)
+		}
+	}
+
+	if len(applied) == 0 {
+		return dashboardJSON, applied, nil
+	}
+
+	migrated, err = json.Marshal(dashboard)
+	return
+}
+
+// MigrateAll applies the given migrations to the dashboards in the given map,
+// mapping files' names to their contents, and returns the migrated dashboards
+// mapped to their files' names. Dashboards that fail to be migrated are kept
+// unchanged.
+// Logs the migrations applied to each dashboard, and the errors encountered.
+func MigrateAll(contents map[string][]byte, migrations []string) map[string][]byte {
+	migrated := make(map[string][]byte, len(contents))
+	for filename, content := range contents {
+		m, applied, err := Migrate(content, migrations)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("Failed to migrate the dashboard, pushing it as is")
+
+			migrated[filename] = content
+			continue
+		}
+
+		if len(applied) > 0 {
+			logrus.WithFields(logrus.Fields{
+				"filename":   filename,
+				"migrations": applied,
+			}).Info("Migrated the dashboard")
+		}
+
+		migrated[filename] = m
+	}
+
+	return migrated
+}
+
+// allPanels returns the panels of a decoded dashboard, including the ones in
+// its rows (for older dashboards) and in collapsed row panels.
+func allPanels(dashboard map[string]interface{}) []map[string]interface{} {
+	panels := objects(dashboard["panels"])
+	for _, row := range objects(dashboard["rows"]) {
+		panels = append(panels, objects(row["panels"])...)
+	}
+
+	for _, panel := range objects(dashboard["panels"]) {
+		panels = append(panels, objects(panel["panels"])...)
+	}
+
+	return panels
+}
+
+// objects returns the JSON objects contained in the given decoded JSON array,
+// ignoring other values. Returns an empty slice if the value isn't an array.
+func objects(value interface{}) []map[string]interface{} {
+	array, _ := value.([]interface{})
+
+	objs := make([]map[string]interface{}, 0, len(array))
+	for _, item := range array {
+		if obj, ok := item.(map[string]interface{}); ok {
+			objs = append(objs, obj)
+		}
+	}
+
+	return objs
+}
+
+// object returns the JSON object at the given key of the given decoded JSON
+// object, creating it if it doesn't exist (or isn't an object).
+func object(parent map[string]interface{}, key string) map[string]interface{} {
+	obj, ok := parent[key].(map[string]interface{})
+	if !ok {
+		obj = make(map[string]interface{})
+		parent[key] = obj
+	}
+
+	return obj
+}
@@ -0,0 +1,230 @@
+package migrate
+
+import (
+	"strconv"
+	"strings"
+)
+
+// singlestatCalcs maps the values of the legacy singlestat panels' "valueName"
+// option to the reducers of the stat panels.
+var singlestatCalcs = map[string]string{
+	"avg":     "mean",
+	"current": "lastNotNull",
+	"first":   "firstNotNull",
+	"max":     "max",
+	"min":     "min",
+	"total":   "sum",
+	"delta":   "delta",
+	"diff":    "diff",
+	"range":   "range",
+	"name":    "lastNotNull",
+}
+
+// migrateGraph replaces a legacy graph panel with a time series panel,
+// translating its most common options (draw style, fill, line width, stacking,
+// unit, axis bounds, legend and tooltip). Options that can't be translated are
+// dropped. Returns true if the panel was a graph panel.
+func migrateGraph(panel map[string]interface{}) bool {
+	if panel["type"] != "graph" {
+		return false
+	}
+
+	panel["type"] = "timeseries"
+
+	fieldConfig := object(panel, "fieldConfig")
+	defaults := object(fieldConfig, "defaults")
+	custom := object(defaults, "custom")
+	options := object(panel, "options")
+
+	drawStyle := "line"
+	if bars, _ := panel["bars"].(bool); bars {
+		drawStyle = "bars"
+	} else if points, _ := panel["points"].(bool); points {
+		if lines, ok := panel["lines"].(bool); ok && !lines {
+			drawStyle = "points"
+		}
+	}
+	custom["drawStyle"] = drawStyle
+
+	if fill, ok := panel["fill"].(float64); ok {
+		custom["fillOpacity"] = fill * 10
+	}
+
+	if lineWidth, ok := panel["linewidth"].(float64); ok {
+		custom["lineWidth"] = lineWidth
+	}
+
+	if stack, _ := panel["stack"].(bool); stack {
+		mode := "normal"
+		if percentage, _ := panel["percentage"].(bool); percentage {
+			mode = "percent"
+		}
+		custom["stacking"] = map[string]interface{}{"mode": mode, "group": "A"}
+	}
+
+	if yaxes := objects(panel["yaxes"]); len(yaxes) > 0 {
+		left := yaxes[0]
+		if format, ok := left["format"].(string); ok && format != "short" {
+			defaults["unit"] = format
+		}
+
+		if min, ok := axisBound(left["min"]); ok {
+			defaults["min"] = min
+		}
+
+		if max, ok := axisBound(left["max"]); ok {
+			defaults["max"] = max
+		}
+	}
+
+	if legend, ok := panel["legend"].(map[string]interface{}); ok {
+		show, _ := legend["show"].(bool)
+		displayMode := "list"
+		if alignAsTable, _ := legend["alignAsTable"].(bool); alignAsTable {
+			displayMode = "table"
+		}
+		placement := "bottom"
+		if rightSide, _ := legend["rightSide"].(bool); rightSide {
+			placement = "right"
+		}
+
+		options["legend"] = map[string]interface{}{
+			"showLegend":  show,
+			"displayMode": displayMode,
+			"placement":   placement,
+			"calcs":       []interface{}{},
+		}
+	}
+
+	tooltipMode := "single"
+	if tooltip, ok := panel["tooltip"].(map[string]interface{}); ok {
+		if shared, _ := tooltip["shared"].(bool); shared {
+			tooltipMode = "multi"
+		}
+	}
+	options["tooltip"] = map[string]interface{}{"mode": tooltipMode, "sort": "none"}
+
+	for _, key := range []string{
+		"bars", "lines", "points", "fill", "linewidth", "stack", "percentage",
+		"yaxes", "xaxis", "yaxis", "legend", "tooltip", "nullPointMode",
+		"steppedLine", "pointradius", "dashes", "dashLength", "spaceLength",
+		"renderer", "fillGradient", "hiddenSeries", "aliasColors",
+		"seriesOverrides", "thresholds", "timeRegions",
+	} {
+		delete(panel, key)
+	}
+
+	return true
+}
+
+// migrateSinglestat replaces a legacy singlestat panel with a stat panel,
+// translating its most common options (unit, decimals, reducer, thresholds and
+// colors). Options that can't be translated are dropped. Returns true if the
+// panel was a singlestat panel.
+func migrateSinglestat(panel map[string]interface{}) bool {
+	if panel["type"] != "singlestat" {
+		return false
+	}
+
+	panel["type"] = "stat"
+
+	fieldConfig := object(panel, "fieldConfig")
+	defaults := object(fieldConfig, "defaults")
+	options := object(panel, "options")
+
+	if format, ok := panel["format"].(string); ok && format != "none" {
+		defaults["unit"] = format
+	}
+
+	if decimals, ok := panel["decimals"].(float64); ok {
+		defaults["decimals"] = decimals
+	}
+
+	calc := "mean"
+	if valueName, ok := panel["valueName"].(string); ok {
+		if c, ok := singlestatCalcs[valueName]; ok {
+			calc = c
+		}
+	}
+	options["reduceOptions"] = map[string]interface{}{
+		"calcs":  []interface{}{calc},
+		"fields": "",
+		"values": false,
+	}
+
+	// Legacy thresholds are a comma-separated list of values, and colors the
+	// list of colors of the steps they delimit.
+	colors, _ := panel["colors"].([]interface{})
+	thresholds, _ := panel["thresholds"].(string)
+	values := make([]interface{}, 0)
+	for _, t := range strings.Split(thresholds, ",") {
+		if v, err := strconv.ParseFloat(strings.TrimSpace(t), 64); err == nil {
+			values = append(values, v)
+		}
+	}
+
+	steps := make([]interface{}, 0, len(values)+1)
+	for i := 0; i <= len(values); i++ {
+		color := "green"
+		if i < len(colors) {
+			if c, ok := colors[i].(string); ok {
+				color = c
+			}
+		}
+
+		var value interface{}
+		if i > 0 {
+			value = values[i-1]
+		}
+
+		steps = append(steps, map[string]interface{}{"color": color, "value": value})
+	}
+	defaults["thresholds"] = map[string]interface{}{
+		"mode":  "absolute",
+		"steps": steps,
+	}
+
+	colorMode := "none"
+	if colorValue, _ := panel["colorValue"].(bool); colorValue {
+		colorMode = "value"
+	}
+	if colorBackground, _ := panel["colorBackground"].(bool); colorBackground {
+		colorMode = "background"
+	}
+	options["colorMode"] = colorMode
+
+	graphMode := "none"
+	if sparkline, ok := panel["sparkline"].(map[string]interface{}); ok {
+		if show, _ := sparkline["show"].(bool); show {
+			graphMode = "area"
+		}
+	}
+	options["graphMode"] = graphMode
+
+	for _, key := range []string{
+		"format", "decimals", "valueName", "thresholds", "colors", "colorValue",
+		"colorBackground", "sparkline", "gauge", "valueMaps", "mappingType",
+		"mappingTypes", "rangeMaps", "nullPointMode", "nullText", "prefix",
+		"postfix", "prefixFontSize", "postfixFontSize", "valueFontSize",
+		"colorPostfix", "colorPrefix", "tableColumn",
+	} {
+		delete(panel, key)
+	}
+
+	return true
+}
+
+// axisBound parses a legacy graph axis bound, which can be a number, a string
+// or null.
+// Returns false if there's no bound.
+func axisBound(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
@@ -0,0 +1,177 @@
+package migrate
+
+import (
+	"math"
+)
+
+// Dimensions of the grid dashboards' panels are laid out on since schema
+// version 16: 24 columns, with rows 30 pixels high.
+const (
+	gridColumns   = 24
+	gridRowHeight = 30
+	// Legacy rows were 12 spans wide.
+	legacySpans = 12
+	// Default height of legacy rows, in pixels.
+	legacyRowHeight = 250
+)
+
+// rowsSchemaVersion is the schema version which replaced rows with a grid of
+// panels.
+const rowsSchemaVersion = 16
+
+// migrateRows replaces the rows of a legacy dashboard with panels laid out on
+// a grid, adding a row panel for each row with a visible title (or each row, if
+// there's more than one), as Grafana's frontend does when loading such a
+// dashboard. Returns true if the dashboard had rows.
+func migrateRows(dashboard map[string]interface{}) bool {
+	rows := objects(dashboard["rows"])
+	if len(rows) == 0 {
+		return false
+	}
+
+	panels := objects(dashboard["panels"])
+	y := 0
+	for _, p := range panels {
+		if gridPos, ok := p["gridPos"].(map[string]interface{}); ok {
+			if bottom := number(gridPos["y"]) + number(gridPos["h"]); bottom > y {
+				y = bottom
+			}
+		}
+	}
+
+	nextID := maxPanelID(dashboard) + 1
+	for _, row := range rows {
+		height := legacyRowHeight
+		switch h := row["height"].(type) {
+		case float64:
+			height = int(h)
+		case string:
+			if parsed := parsePixels(h); parsed > 0 {
+				height = parsed
+			}
+		}
+		h := int(math.Ceil(float64(height) / gridRowHeight))
+
+		showTitle, _ := row["showTitle"].(bool)
+		collapsed, _ := row["collapse"].(bool)
+
+		var rowPanel map[string]interface{}
+		if len(rows) > 1 || showTitle {
+			title, _ := row["title"].(string)
+			rowPanel = map[string]interface{}{
+				"id":        nextID,
+				"type":      "row",
+				"title":     title,
+				"collapsed": collapsed,
+				"panels":    []interface{}{},
+				"gridPos": map[string]interface{}{
+					"x": 0, "y": y, "w": gridColumns, "h": 1,
+				},
+			}
+			nextID++
+			panels = append(panels, rowPanel)
+			y++
+		}
+
+		x := 0
+		rowHeight := 0
+		for _, panel := range objects(row["panels"]) {
+			span := legacySpans / 2
+			if s, ok := panel["span"].(float64); ok && s > 0 {
+				span = int(s)
+			}
+			w := span * gridColumns / legacySpans
+
+			// Wrap the panel to a new line if it doesn't fit.
+			if x+w > gridColumns {
+				y += rowHeight
+				x = 0
+				rowHeight = 0
+			}
+
+			panelHeight := h
+			if ph, ok := panel["height"]; ok {
+				var pixels int
+				switch v := ph.(type) {
+				case float64:
+					pixels = int(v)
+				case string:
+					pixels = parsePixels(v)
+				}
+				if pixels > 0 {
+					panelHeight = int(math.Ceil(float64(pixels) / gridRowHeight))
+				}
+			}
+
+			delete(panel, "span")
+			delete(panel, "height")
+			panel["gridPos"] = map[string]interface{}{
+				"x": x, "y": y, "w": w, "h": panelHeight,
+			}
+
+			x += w
+			if panelHeight > rowHeight {
+				rowHeight = panelHeight
+			}
+
+			// The panels of a collapsed row live inside its row panel.
+			if rowPanel != nil && collapsed {
+				rowPanel["panels"] = append(rowPanel["panels"].([]interface{}), panel)
+			} else {
+				panels = append(panels, panel)
+			}
+		}
+
+		if !collapsed {
+			y += rowHeight
+		}
+	}
+
+	list := make([]interface{}, 0, len(panels))
+	for _, p := range panels {
+		list = append(list, p)
+	}
+
+	dashboard["panels"] = list
+	delete(dashboard, "rows")
+
+	if number(dashboard["schemaVersion"]) < rowsSchemaVersion {
+		dashboard["schemaVersion"] = rowsSchemaVersion
+	}
+
+	return true
+}
+
+// maxPanelID returns the highest ID among the dashboard's panels.
+func maxPanelID(dashboard map[string]interface{}) int {
+	max := 0
+	for _, panel := range allPanels(dashboard) {
+		if id := number(panel["id"]); id > max {
+			max = id
+		}
+	}
+
+	return max
+}
+
+// number returns the given decoded JSON number as an int, or 0 if the value
+// isn't a number.
+func number(value interface{}) int {
+	n, _ := value.(float64)
+	return int(n)
+}
+
+// parsePixels parses a legacy height (e.g. "250px" or "250"), and returns it in
+// pixels, or 0 if it couldn't be parsed.
+func parsePixels(s string) int {
+	var pixels int
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			break
+		}
+
+		pixels = pixels*10 + int(c-'0')
+	}
+
+	return pixels
+}
@@ -0,0 +1,385 @@
+package plan
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/textfile"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Types of actions a plan can contain.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// FormatVersion is the version of the plan file format. It must be bumped when
+// the format changes in a backwards-incompatible way.
+const FormatVersion = 1
+
+// Plan describes the changes to apply to Grafana so it matches the dashboards
+// in the repository.
+type Plan struct {
+	FormatVersion int       `json:"format_version"`
+	GeneratedAt   time.Time `json:"generated_at"`
+	Actions       []Action  `json:"actions"`
+}
+
+// Action describes a change to apply to a single dashboard. Dashboard is the
+// JSON description of the dashboard to push, and ContentHash its hash; both are
+// empty for deletions. LiveHash and LiveVersion describe the state of the
+// dashboard on Grafana when the plan was generated, and are empty for
+// creations.
+type Action struct {
+	Action      string          `json:"action"`
+	Slug        string          `json:"slug"`
+	Filename    string          `json:"filename,omitempty"`
+	Dashboard   json.RawMessage `json:"dashboard,omitempty"`
+	ContentHash string          `json:"content_hash,omitempty"`
+	LiveHash    string          `json:"live_hash,omitempty"`
+	LiveVersion int             `json:"live_version,omitempty"`
+}
+
+// Compute compares the given dashboards' JSON descriptions, mapped to their
+// files' names, against the dashboards on the Grafana instance, and returns a
+// plan containing the creations and updates needed for Grafana to match them.
+// If deleteRemoved is true, the plan also contains the deletion of the
+// dashboards that exist on Grafana but not in the given files, unless their
+// slug starts with the given ignore prefix.
+// Returns an error if there was an issue computing a dashboard's slug or
+// talking to the Grafana API.
+func Compute(
+	ctx context.Context, client *grafana.Client, contents map[string][]byte,
+	deleteRemoved bool, ignorePrefix string,
+) (p *Plan, err error) {
+	p = &Plan{
+		FormatVersion: FormatVersion,
+		GeneratedAt:   time.Now().UTC(),
+		Actions:       make([]Action, 0),
+	}
+
+	// Retrieve the slugs of the dashboards currently on Grafana.
+	uris, err := client.GetDashboardsURIs(ctx)
+	if err != nil {
+		return
+	}
+
+	live := make(map[string]bool)
+	for _, uri := range uris {
+		live[strings.TrimPrefix(uri, "db/")] = true
+	}
+
+	inRepo := make(map[string]bool)
+	for _, filename := range sortedKeys(contents) {
+		content := contents[filename]
+
+		slug, err := helpers.GetDashboardSlug(content)
+		if err != nil {
+			return nil, err
+		}
+
+		inRepo[slug] = true
+
+		contentHash, err := Hash(content)
+		if err != nil {
+			return nil, err
+		}
+
+		action := Action{
+			Action:      ActionCreate,
+			Slug:        slug,
+			Filename:    filename,
+			Dashboard:   json.RawMessage(content),
+			ContentHash: contentHash,
+		}
+
+		if live[slug] {
+			if err = action.setLiveState(ctx, client); err != nil {
+				return nil, err
+			}
+
+			if action.LiveHash == contentHash {
+				continue
+			}
+
+			action.Action = ActionUpdate
+		}
+
+		p.Actions = append(p.Actions, action)
+	}
+
+	if deleteRemoved {
+		for _, uri := range uris {
+			slug := strings.TrimPrefix(uri, "db/")
+			if inRepo[slug] {
+				continue
+			}
+
+			if len(ignorePrefix) > 0 && strings.HasPrefix(slug, ignorePrefix) {
+				continue
+			}
+
+			action := Action{
+				Action: ActionDelete,
+				Slug:   slug,
+			}
+
+			if err = action.setLiveState(ctx, client); err != nil {
+				return
+			}
+
+			p.Actions = append(p.Actions, action)
+		}
+	}
+
+	return
+}
+
+// Check checks that the plan can be applied, i.e. that its format is supported,
+// that the dashboards it contains match their hashes, and that the state of the
+// dashboards on Grafana hasn't changed since the plan was generated.
+// Returns an error if one of these checks failed, or if there was an issue
+// talking to the Grafana API.
+func (p *Plan) Check(ctx context.Context, client *grafana.Client) error {
+	if p.FormatVersion != FormatVersion {
+		return fmt.Errorf(
+			"Unsupported plan format version %d (expected %d)",
+			p.FormatVersion, FormatVersion,
+		)
+	}
+
+	// Retrieve the slugs of the dashboards currently on Grafana.
+	uris, err := client.GetDashboardsURIs(ctx)
+	if err != nil {
+		return err
+	}
+
+	live := make(map[string]bool)
+	for _, uri := range uris {
+		live[strings.TrimPrefix(uri, "db/")] = true
+	}
+
+	changed := make([]string, 0)
+	for _, action := range p.Actions {
+		if action.Action != ActionDelete {
+			contentHash, err := Hash(action.Dashboard)
+			if err != nil {
+				return err
+			}
+
+			if contentHash != action.ContentHash {
+				return fmt.Errorf(
+					"The content of dashboard %s doesn't match its hash", action.Slug,
+				)
+			}
+		}
+
+		// Dashboards to create must still be absent from Grafana, and the
+		// others must still be in the same state.
+		if action.Action == ActionCreate || !live[action.Slug] {
+			if live[action.Slug] != (action.Action != ActionCreate) {
+				changed = append(changed, action.Slug)
+			}
+
+			continue
+		}
+
+		current := action
+		if err = current.setLiveState(ctx, client); err != nil {
+			return err
+		}
+
+		if current.LiveHash != action.LiveHash || current.LiveVersion != action.LiveVersion {
+			changed = append(changed, action.Slug)
+		}
+	}
+
+	if len(changed) > 0 {
+		return fmt.Errorf(
+			"Dashboards changed on Grafana since the plan was generated: %s",
+			strings.Join(changed, ", "),
+		)
+	}
+
+	return nil
+}
+
+// Apply applies each action of the plan to Grafana.
+// Logs any errors encountered while applying an action, but doesn't return
+// until all actions have been applied.
+// Returns the number of actions that failed.
+func (p *Plan) Apply(ctx context.Context, client *grafana.Client) (failed int) {
+	for _, action := range p.Actions {
+		var err error
+		if action.Action == ActionDelete {
+			err = client.DeleteDashboard(ctx, action.Slug)
+		} else {
+			_, err = client.CreateOrUpdateDashboard(ctx, action.Dashboard)
+		}
+
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":  err,
+				"action": action.Action,
+				"slug":   action.Slug,
+			}).Error("Failed to apply the action")
+
+			failed++
+		}
+	}
+
+	return
+}
+
+// Write writes the plan as JSON into the file at the given path.
+// Returns an error if there was an issue encoding or writing the plan.
+func (p *Plan) Write(filename string) error {
+	content, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, content, 0644)
+}
+
+// Read reads a plan from the file at the given path.
+// Returns an error if there was an issue reading or decoding the plan.
+func Read(filename string) (p *Plan, err error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	p = new(Plan)
+	err = json.Unmarshal(content, p)
+	return
+}
+
+// setLiveState retrieves the dashboard targeted by the action from Grafana, and
+// sets the action's live hash and version from it.
+// Returns an error if there was an issue retrieving or hashing the dashboard.
+func (a *Action) setLiveState(ctx context.Context, client *grafana.Client) (err error) {
+	dashboard, err := client.GetDashboard(ctx, "db/"+a.Slug)
+	if err != nil {
+		return
+	}
+
+	a.LiveVersion = dashboard.Version
+	a.LiveHash, err = Hash(dashboard.RawJSON)
+	return
+}
+
+// Hash computes the SHA-256 hash of a dashboard's normalised JSON description,
+// and returns it as an hexadecimal string.
+// Returns an error if the description couldn't be parsed.
+func Hash(dashboardJSON []byte) (string, error) {
+	normalised, err := normalise(dashboardJSON)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(normalised))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Equal checks whether two JSON descriptions of a dashboard describe the same
+// dashboard, ignoring formatting and the fields Grafana sets itself (ID and
+// version).
+// Returns an error if one of the descriptions couldn't be parsed.
+func Equal(a []byte, b []byte) (bool, error) {
+	normalisedA, err := normalise(a)
+	if err != nil {
+		return false, err
+	}
+
+	normalisedB, err := normalise(b)
+	if err != nil {
+		return false, err
+	}
+
+	return normalisedA == normalisedB, nil
+}
+
+// normalise returns a canonical representation of a dashboard's JSON
+// description, without the fields Grafana sets itself.
+// Returns an error if the description couldn't be parsed.
+func normalise(dashboardJSON []byte) (string, error) {
+	var dashboard map[string]interface{}
+	if err := json.Unmarshal(dashboardJSON, &dashboard); err != nil {
+		return "", err
+	}
+
+	delete(dashboard, "id")
+	delete(dashboard, "version")
+
+	// encoding/json sorts the keys of maps.
+	canonical, err := json.Marshal(dashboard)
+	return string(canonical), err
+}
+
+// ReadDashboardFiles reads all JSON files in the given directory and its
+// subdirectories (except for hidden ones, e.g. ".git"), and returns their
+// contents mapped to their paths relative to the directory.
+// Returns an error if there was an issue walking the directory or reading a
+// file.
+func ReadDashboardFiles(dir string) (map[string][]byte, error) {
+	contents := make(map[string][]byte)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		content, err := textfile.Read(path)
+		if err != nil {
+			return err
+		}
+
+		contents[filepath.ToSlash(rel)] = content
+		return nil
+	})
+
+	return contents, err
+}
+
+// sortedKeys returns the keys of the given map in alphabetical order.
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
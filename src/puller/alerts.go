@@ -0,0 +1,93 @@
+package puller
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	gogit "github.com/go-git/go-git/v5"
+)
+
+// legacyAlertsFile is the content of a file holding the legacy alerts of a
+// dashboard in the legacy alerts' directory.
+type legacyAlertsFile struct {
+	DashboardUID   string               `json:"dashboardUid,omitempty"`
+	DashboardTitle string               `json:"dashboardTitle"`
+	Alerts         []grafana.PanelAlert `json:"alerts"`
+}
+
+// addLegacyAlertsToRepo writes the legacy alerts defined in the panels of the
+// given dashboard, along with the panels' IDs and titles, in a file named after
+// the dashboard's slug in the legacy alerts' directory, then adds it to the git
+// index so it can be comitted afterwards. The alerts are left in the
+// dashboard's file too, so pushing it doesn't remove them from Grafana. If the
+// dashboard doesn't have any legacy alert anymore, its file is removed, as are
+// the files from its previous slugs (from the given paths of its previous
+// files) if it was renamed.
+// Returns an error if there was an issue parsing the dashboard, or writing or
+// removing a file, or updating the index.
+func addLegacyAlertsToRepo(
+	dashboard *grafana.Dashboard, clonePath string, cfg *config.Config,
+	previousPaths []string, worktree *gogit.Worktree,
+) error {
+	filename := legacyAlertsFilename(cfg, dashboard.Slug)
+
+	for _, previous := range previousPaths {
+		previousSlug := strings.TrimSuffix(path.Base(previous), ".json")
+		previousFile := legacyAlertsFilename(cfg, previousSlug)
+		if previousFile == filename {
+			continue
+		}
+
+		if err := removeFile(clonePath, previousFile, cfg, worktree); err != nil {
+			return err
+		}
+	}
+
+	alerts, err := grafana.ExtractLegacyAlerts(dashboard.RawJSON)
+	if err != nil {
+		return err
+	}
+
+	if len(alerts) == 0 {
+		return removeFile(clonePath, filename, cfg, worktree)
+	}
+
+	content, err := json.Marshal(legacyAlertsFile{
+		DashboardUID:   dashboard.UID,
+		DashboardTitle: dashboard.Name,
+		Alerts:         alerts,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Join(clonePath, cfg.LegacyAlerts.Path), 0755); err != nil {
+		return err
+	}
+
+	if err = rewriteFile(filepath.Join(clonePath, filename), content, cfg.LineEndings); err != nil {
+		return err
+	}
+
+	// If worktree is nil, it means that it hasn't been initialised, which
+	// means the sync mode is "simple sync" and not Git.
+	if worktree != nil {
+		if _, err = worktree.Add(cfg.Git.RepositoryPath(filename)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// legacyAlertsFilename returns the path of the file holding the legacy alerts of
+// the dashboard with the given slug.
+func legacyAlertsFilename(cfg *config.Config, slug string) string {
+	return path.Join(cfg.LegacyAlerts.Path, slug+".json")
+}
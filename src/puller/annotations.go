@@ -0,0 +1,87 @@
+package puller
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// annotationsLimit is the maximum number of annotations retrieved for a single
+// day. Grafana's API doesn't paginate annotations, so days with more
+// annotations are truncated.
+const annotationsLimit = 10000
+
+// addAnnotationsToRepo writes the annotations of each day (in UTC) of the
+// window from the annotations settings, up to the current time, in a file
+// named after the day in the annotations' directory. The first day of the
+// window is exported whole, so each file always holds the annotations of a
+// whole day. The files of days which don't have any annotation anymore are
+// removed, and the files of days outside of the window are left untouched. It
+// then adds the changes to the git index so they can be comitted afterwards.
+// Returns an error if there was an issue retrieving the annotations from
+// Grafana, or writing or removing a file, or adding the changes to the index.
+func addAnnotationsToRepo(
+	ctx context.Context, client *grafana.Client, clonePath string,
+	cfg *config.Config, worktree *gogit.Worktree,
+) error {
+	dir := cfg.Annotations.Path
+	if err := os.MkdirAll(filepath.Join(clonePath, dir), 0755); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	start := now.Add(-cfg.Annotations.Window)
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+
+	for ; day.Before(now); day = day.AddDate(0, 0, 1) {
+		annotations, err := client.GetAnnotations(ctx, day, day.AddDate(0, 0, 1), annotationsLimit)
+		if err != nil {
+			return err
+		}
+
+		filename := path.Join(dir, day.Format("2006-01-02")+".json")
+
+		if len(annotations) == 0 {
+			if err = removeFile(clonePath, filename, cfg, worktree); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if len(annotations) >= annotationsLimit {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"day":   day.Format("2006-01-02"),
+				"limit": annotationsLimit,
+			}).Warn("Too many annotations for a single day, some of them weren't exported")
+		}
+
+		content, err := json.Marshal(annotations)
+		if err != nil {
+			return err
+		}
+
+		if err = rewriteFile(filepath.Join(clonePath, filename), content, cfg.LineEndings); err != nil {
+			return err
+		}
+
+		// If worktree is nil, it means that it hasn't been initialised, which
+		// means the sync mode is "simple sync" and not Git.
+		if worktree != nil {
+			if _, err = worktree.Add(cfg.Git.RepositoryPath(filename)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
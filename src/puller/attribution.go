@@ -0,0 +1,138 @@
+package puller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
+)
+
+// changeAuthor is a Grafana user who changed a dashboard: their login, and the
+// name and email address the puller's commits are attributed to them with.
+type changeAuthor struct {
+	login string
+	name  string
+	email string
+}
+
+// authorsResolver finds the Grafana users who last changed dashboards, and
+// looks up their names and email addresses on Grafana, once per user and per
+// pull.
+type authorsResolver struct {
+	client *grafana.Client
+	cfg    *config.GitAttributionSettings
+	users  map[string]changeAuthor
+}
+
+// newAuthorsResolver returns a new resolver of the authors of dashboards'
+// changes, which uses the given client and attribution settings.
+func newAuthorsResolver(
+	client *grafana.Client, cfg *config.GitAttributionSettings,
+) *authorsResolver {
+	return &authorsResolver{
+		client: client,
+		cfg:    cfg,
+		users:  make(map[string]changeAuthor),
+	}
+}
+
+// lastAuthor returns the Grafana user who saved the current version of the
+// given dashboard, according to the dashboard versions API. If the dashboard
+// has no UID (on Grafana versions older than 5.0) or its versions can't be
+// retrieved, the user from the dashboard's metadata is used instead. The user's
+// name defaults to their login, and their email address to their login at the
+// configured email domain, if any, if they can't be looked up. Returns an
+// author with an empty login if the user isn't known.
+func (r *authorsResolver) lastAuthor(
+	ctx context.Context, dashboard *grafana.Dashboard,
+) changeAuthor {
+	login := dashboard.UpdatedBy
+	if len(dashboard.UID) > 0 {
+		revisions, err := r.client.GetDashboardRevisions(ctx, dashboard.UID, 1)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error": err,
+				"name":  dashboard.Name,
+			}).Warn("Failed to retrieve the dashboard's last version, using its metadata to find its author")
+		} else if len(revisions) > 0 && revisions[0].Version == dashboard.Version {
+			login = revisions[0].CreatedBy
+		}
+	}
+
+	if len(login) == 0 {
+		return changeAuthor{}
+	}
+
+	if author, ok := r.users[login]; ok {
+		return author
+	}
+
+	author := changeAuthor{login: login, name: login}
+	user, err := r.client.LookupUser(ctx, login)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error": err,
+			"login": login,
+		}).Warn("Failed to look up the Grafana user")
+	} else {
+		if len(user.Name) > 0 {
+			author.name = user.Name
+		}
+		author.email = user.Email
+	}
+
+	if len(author.email) == 0 && len(r.cfg.EmailDomain) > 0 {
+		author.email = login + "@" + r.cfg.EmailDomain
+	}
+
+	r.users[login] = author
+	return author
+}
+
+// attributeCommit returns the author of a commit including the given version
+// updates, and the message of the commit with the Co-authored-by trailers it
+// needs, according to the given attribution settings: the Grafana user who
+// changed the dashboards if there's only one and the settings allow it, else
+// the manager (which is always the commit's committer). Users without an email
+// address aren't credited in trailers, since these require one.
+func attributeCommit(
+	dv map[string]diffVersion, message string, manager *object.Signature,
+	cfg *config.GitAttributionSettings,
+) (*object.Signature, string) {
+	byLogin := make(map[string]changeAuthor)
+	for _, diff := range dv {
+		if len(diff.author.login) > 0 {
+			byLogin[diff.author.login] = diff.author
+		}
+	}
+
+	authors := make([]changeAuthor, 0, len(byLogin))
+	for _, author := range byLogin {
+		authors = append(authors, author)
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		return authors[i].login < authors[j].login
+	})
+
+	if cfg.Mode == config.AttributionAuthor && len(authors) == 1 {
+		return &object.Signature{
+			Name:  authors[0].name,
+			Email: authors[0].email,
+			When:  time.Now(),
+		}, message
+	}
+
+	for _, author := range authors {
+		if len(author.email) > 0 {
+			message += fmt.Sprintf("Co-authored-by: %s <%s>\n", author.name, author.email)
+		}
+	}
+
+	return manager, message
+}
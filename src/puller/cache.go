@@ -0,0 +1,216 @@
+package puller
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	"github.com/sirupsen/logrus"
+)
+
+// cachedDashboard is the content of a file of the dashboards cache, describing
+// a dashboard as retrieved from Grafana at the given version.
+type cachedDashboard struct {
+	Name        string          `json:"name"`
+	Slug        string          `json:"slug"`
+	Version     int             `json:"version"`
+	FolderID    int             `json:"folderId"`
+	FolderTitle string          `json:"folderTitle"`
+	UpdatedBy   string          `json:"updatedBy,omitempty"`
+	URL         string          `json:"url,omitempty"`
+	Dashboard   json.RawMessage `json:"dashboard"`
+}
+
+// dashboardCache keeps the dashboards retrieved from Grafana in a directory,
+// one file per dashboard named after its UID, so a dashboard isn't downloaded
+// again as long as its version doesn't change. Since renaming a folder doesn't
+// create a new version of its dashboards, the title of a cached dashboard's
+// folder is checked against the folders on Grafana, which are retrieved once
+// per pull. hits and misses count the dashboards read from the cache and
+// downloaded from Grafana, for logging.
+type dashboardCache struct {
+	path    string
+	folders map[int]string
+	hits    int
+	misses  int
+}
+
+// newDashboardCache creates the directory of the cache from the given settings
+// if it doesn't exist, and retrieves the titles of the folders on Grafana.
+// Returns an error if there was an issue creating the directory or retrieving
+// the folders.
+func newDashboardCache(
+	ctx context.Context, client *grafana.Client, cfg *config.CacheSettings,
+) (*dashboardCache, error) {
+	if err := os.MkdirAll(cfg.Path, 0755); err != nil {
+		return nil, err
+	}
+
+	folders, err := client.GetFolders(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &dashboardCache{
+		path:    cfg.Path,
+		folders: make(map[int]string),
+	}
+	for _, folder := range folders {
+		cache.folders[folder.ID] = folder.Title
+	}
+
+	return cache, nil
+}
+
+// getDashboard returns the dashboard identified by the given reference. If
+// the cache holds the dashboard at its current version on Grafana, the cached
+// dashboard is returned, else the dashboard is downloaded from Grafana and
+// stored in the cache. Dashboards without a UID (on Grafana versions older
+// than 5.0) are always downloaded, since their versions can't be requested,
+// and so are all dashboards if the cache is nil (i.e. not configured).
+// Failures to read or write the cache are logged, and the dashboard is then
+// downloaded.
+// Returns an error if there was an issue retrieving the dashboard from Grafana.
+func (c *dashboardCache) getDashboard(
+	ctx context.Context, client *grafana.Client, ref grafana.DashboardRef,
+) (*grafana.Dashboard, error) {
+	if c == nil {
+		return client.GetDashboardByRef(ctx, ref)
+	}
+
+	if len(ref.UID) == 0 {
+		c.misses++
+		return client.GetDashboardByRef(ctx, ref)
+	}
+
+	if dashboard := c.load(ctx, client, ref.UID); dashboard != nil {
+		c.hits++
+		return dashboard, nil
+	}
+
+	c.misses++
+	dashboard, err := client.GetDashboardByRef(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.store(dashboard); err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error": err,
+			"uid":   dashboard.UID,
+			"cache": c.path,
+		}).Warn("Failed to store the dashboard in the cache")
+	}
+
+	return dashboard, nil
+}
+
+// load returns the dashboard with the given UID from the cache, if it's there
+// at the dashboard's current version on Grafana and in a folder which still
+// has the same title, else nil.
+func (c *dashboardCache) load(
+	ctx context.Context, client *grafana.Client, uid string,
+) *grafana.Dashboard {
+	data, err := ioutil.ReadFile(c.filename(uid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	var cached cachedDashboard
+	if err == nil {
+		err = json.Unmarshal(data, &cached)
+	}
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error": err,
+			"uid":   uid,
+			"cache": c.path,
+		}).Warn("Failed to read the dashboard from the cache")
+
+		return nil
+	}
+
+	revisions, err := client.GetDashboardRevisions(ctx, uid, 1)
+	if err != nil || len(revisions) == 0 || revisions[0].Version != cached.Version {
+		return nil
+	}
+
+	if cached.FolderID != 0 {
+		if title, ok := c.folders[cached.FolderID]; !ok || title != cached.FolderTitle {
+			return nil
+		}
+	}
+
+	return &grafana.Dashboard{
+		RawJSON:     cached.Dashboard,
+		Name:        cached.Name,
+		Slug:        cached.Slug,
+		UID:         uid,
+		Version:     cached.Version,
+		FolderID:    cached.FolderID,
+		FolderTitle: cached.FolderTitle,
+		UpdatedBy:   cached.UpdatedBy,
+		URL:         cached.URL,
+	}
+}
+
+// store writes the given dashboard in the cache, replacing its previous
+// version if there was one.
+// Returns an error if there was an issue writing the file.
+func (c *dashboardCache) store(dashboard *grafana.Dashboard) error {
+	data, err := json.Marshal(cachedDashboard{
+		Name:        dashboard.Name,
+		Slug:        dashboard.Slug,
+		Version:     dashboard.Version,
+		FolderID:    dashboard.FolderID,
+		FolderTitle: dashboard.FolderTitle,
+		UpdatedBy:   dashboard.UpdatedBy,
+		URL:         dashboard.URL,
+		Dashboard:   dashboard.RawJSON,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(c.filename(dashboard.UID), data, 0644)
+}
+
+// prune removes from the cache the dashboards which don't match any of the
+// given references, i.e. which were deleted from Grafana.
+// Returns an error if there was an issue listing or removing the files.
+func (c *dashboardCache) prune(refs []grafana.DashboardRef) error {
+	uids := make(map[string]bool)
+	for _, ref := range refs {
+		uids[ref.UID] = true
+	}
+
+	files, err := ioutil.ReadDir(c.path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		uid := strings.TrimSuffix(file.Name(), ".json")
+		if file.IsDir() || uid == file.Name() || uids[uid] {
+			continue
+		}
+
+		if err = os.Remove(filepath.Join(c.path, file.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// filename returns the path of the file caching the dashboard with the given
+// UID.
+func (c *dashboardCache) filename(uid string) string {
+	return filepath.Join(c.path, uid+".json")
+}
@@ -0,0 +1,309 @@
+package puller
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/git"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/templating"
+	"github.com/babolivier/grafana-dashboards-manager/src/textfile"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// errNoDashboards is returned when looking for orphaned files while there's no
+// dashboard on Grafana, which is more likely to come from a misconfiguration
+// (e.g. an API key for the wrong organisation) than from an empty instance.
+var errNoDashboards = errors.New("No dashboard on Grafana, refusing to consider every dashboard file as orphaned")
+
+// Clean removes the orphaned files from the sync path (see findOrphans), and
+// the versions of the removed dashboards from the versions file. In Git mode,
+// the repository is synchronised first, and the removal is then committed and
+// pushed. If dryRun is true, the orphaned files are only listed.
+// Returns the paths of the orphaned files, relative to the sync path.
+// Returns an error if there was an issue synchronising the repository,
+// retrieving the dashboards from Grafana, looking for or removing the orphaned
+// files, writing the versions file, or committing and pushing the changes.
+func Clean(
+	ctx context.Context, client *grafana.Client, cfg *config.Config,
+	dryRun bool,
+) ([]string, error) {
+	var repo *git.Repository
+	var w *gogit.Worktree
+	var syncPath string
+	var err error
+
+	if cfg.Git != nil {
+		syncPath = cfg.Git.SyncPath()
+
+		if repo, _, err = git.NewRepository(cfg.Git); err != nil {
+			return nil, err
+		}
+
+		if err = repo.Sync(false); err != nil {
+			return nil, err
+		}
+
+		if w, err = repo.Repo.Worktree(); err != nil {
+			return nil, err
+		}
+	} else {
+		syncPath = cfg.SimpleSync.SyncPath
+	}
+
+	refs, err := client.GetDashboardsRefs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return findOrphans(ctx, syncPath, refs, cfg)
+	}
+
+	dbVersions, err := getDashboardsVersions(syncPath, cfg.Metadata.VersionsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := removeOrphans(ctx, syncPath, refs, dbVersions, cfg, w)
+	if err != nil || len(removed) == 0 {
+		return removed, err
+	}
+
+	if cfg.Git == nil {
+		return removed, writeVersions(
+			dbVersions, nil, syncPath, cfg.Metadata.VersionsFile, cfg.LineEndings,
+		)
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"files": len(removed),
+	}).Info("Comitting the removal of the orphaned files")
+
+	if err = commitNewVersions(
+		dbVersions, nil, w, cfg, getCommitMessage("Removed orphaned files", nil),
+	); err != nil {
+		return nil, err
+	}
+
+	return removed, repo.Push()
+}
+
+// removeOrphans removes the orphaned files from the given sync path (see
+// findOrphans), along with the versions of the removed dashboards from the
+// given versions (under their UIDs, and under the slugs older releases recorded
+// them with), then removes them from the git index (if a worktree is given) so
+// the removal can be committed afterwards.
+// Returns the paths of the removed files, relative to the sync path.
+// Returns an error if there was an issue looking for, reading or removing the
+// files.
+func removeOrphans(
+	ctx context.Context, syncPath string, refs []grafana.DashboardRef, versions map[string]int,
+	cfg *config.Config, worktree *gogit.Worktree,
+) ([]string, error) {
+	orphans, err := findOrphans(ctx, syncPath, refs, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range orphans {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"filename": filename,
+		}).Info("File doesn't match any dashboard on Grafana, removing it")
+
+		if !cfg.IsPermissionsFile(filename) && strings.HasSuffix(filename, ".json") {
+			content, err := textfile.Read(filepath.Join(syncPath, filename))
+			if err != nil {
+				return nil, err
+			}
+
+			slug := strings.TrimSuffix(path.Base(filename), ".json")
+			uid, _ := helpers.GetDashboardUID(content)
+			delete(versions, grafana.VersionKey(uid, slug))
+			delete(versions, slug)
+		}
+
+		if err = removeFile(syncPath, filename, cfg, worktree); err != nil {
+			return nil, err
+		}
+	}
+
+	return orphans, nil
+}
+
+// findOrphans walks the given sync path (except for hidden directories, e.g.
+// ".git") and returns the paths, relative to the sync path, of the files that
+// don't match any of the given dashboards from Grafana anymore, sorted in
+// alphabetical order. These are the dashboards' files which dashboard doesn't
+// exist on Grafana (identified by UID, or by slug for dashboards without an
+// UID), along with the permissions files, screenshots and legacy alerts' files
+// of dashboards which file is either orphaned or missing. Metadata files, alert
+// notification channels' files, snapshots', annotations' and history files,
+// templates, files describing dashboards which slug starts with the ignore
+// prefix, and files that don't describe a dashboard are never considered
+// orphaned.
+// Returns an error if there's no dashboard on Grafana, or if there was an
+// issue walking the directory, or reading a file.
+func findOrphans(
+	ctx context.Context, syncPath string, refs []grafana.DashboardRef,
+	cfg *config.Config,
+) ([]string, error) {
+	if len(refs) == 0 {
+		return nil, errNoDashboards
+	}
+
+	live := make(map[string]bool)
+	for _, ref := range refs {
+		live[ref.UID] = true
+		live[ref.URI] = true
+	}
+
+	var screenshotsDir string
+	if cfg.Screenshots != nil {
+		screenshotsDir = path.Clean(cfg.Screenshots.Path)
+	}
+
+	orphans := make([]string, 0)
+	// Files describing dashboards which still exist on Grafana, which
+	// permissions files and screenshots are kept.
+	kept := make(map[string]bool)
+	keptSlugs := make(map[string]bool)
+	permissions := make([]string, 0)
+	screenshots := make([]string, 0)
+	legacyAlerts := make([]string, 0)
+
+	err := filepath.Walk(syncPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if p != syncPath && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		rel, err := filepath.Rel(syncPath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if len(screenshotsDir) > 0 && strings.HasPrefix(rel, screenshotsDir+"/") &&
+			strings.HasSuffix(rel, ".png") {
+			screenshots = append(screenshots, rel)
+			return nil
+		}
+
+		if cfg.IsLegacyAlertsFile(rel) {
+			legacyAlerts = append(legacyAlerts, rel)
+			return nil
+		}
+
+		if !strings.HasSuffix(rel, ".json") || cfg.Metadata.IsMetadataFile(rel) ||
+			cfg.IsAlertNotificationFile(rel) || cfg.IsSnapshotFile(rel) ||
+			cfg.IsAnnotationsFile(rel) || cfg.IsHistoryFile(rel) ||
+			cfg.IsLibraryPanelFile(rel) || cfg.IsPlaylistFile(rel) {
+			return nil
+		}
+
+		if cfg.IsPermissionsFile(rel) {
+			permissions = append(permissions, rel)
+			return nil
+		}
+
+		content, err := textfile.Read(p)
+		if err != nil {
+			return err
+		}
+
+		orphaned, err := isOrphaned(content, live, cfg)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"filename": rel,
+			}).Warn("Failed to parse the file, not considering it orphaned")
+		}
+
+		if orphaned {
+			orphans = append(orphans, rel)
+		} else {
+			kept[rel] = true
+			keptSlugs[strings.TrimSuffix(path.Base(rel), ".json")] = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range permissions {
+		dashboardFile := strings.TrimSuffix(filename, config.PermissionsFileSuffix) + ".json"
+		if !kept[dashboardFile] {
+			orphans = append(orphans, filename)
+		}
+	}
+
+	for _, filename := range screenshots {
+		dashboardFile := strings.TrimSuffix(
+			strings.TrimPrefix(filename, screenshotsDir+"/"), ".png",
+		) + ".json"
+		if !kept[dashboardFile] {
+			orphans = append(orphans, filename)
+		}
+	}
+
+	for _, filename := range legacyAlerts {
+		if !keptSlugs[strings.TrimSuffix(path.Base(filename), ".json")] {
+			orphans = append(orphans, filename)
+		}
+	}
+
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+// isOrphaned checks whether the dashboard described by the given JSON content
+// doesn't exist anymore, i.e. if neither its UID (if any) nor its URI are in the
+// given set of references of the dashboards on Grafana. Templates, dashboards
+// which slug starts with the ignore prefix, and contents that don't describe a
+// dashboard (i.e. without a title) aren't orphaned.
+// Returns an error if the content couldn't be parsed.
+func isOrphaned(content []byte, live map[string]bool, cfg *config.Config) (bool, error) {
+	if tmplCfg := templatingSettings(cfg); tmplCfg != nil && templating.IsTemplate(content, tmplCfg) {
+		return false, nil
+	}
+
+	slug, err := helpers.GetDashboardSlug(content)
+	if err != nil || len(slug) == 0 {
+		return false, err
+	}
+
+	prefix := cfg.Grafana.IgnorePrefix
+	if len(prefix) > 0 && strings.HasPrefix(slug, prefix) {
+		return false, nil
+	}
+
+	ref, err := grafana.RefFromJSON(content)
+	if err != nil {
+		return false, err
+	}
+
+	if len(ref.UID) > 0 {
+		return !live[ref.UID], nil
+	}
+
+	return !live[ref.URI], nil
+}
@@ -0,0 +1,59 @@
+package puller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errorCollector handles the errors encountered while pulling individual
+// dashboards (or other resources) according to the error policy from the
+// configuration: with the "fail-fast" policy, the first error aborts the pull,
+// whereas with the "continue" policy, errors are logged and the pull carries
+// on with the other resources.
+type errorCollector struct {
+	failFast bool
+	errs     []error
+}
+
+// newErrorCollector creates a new instance of the errorCollector structure
+// using the error policy from the given configuration.
+func newErrorCollector(cfg *config.Config) *errorCollector {
+	return &errorCollector{failFast: cfg.FailFast()}
+}
+
+// handle logs the given error with the given context, fields and message, then
+// records it if the pull can carry on.
+// Returns the error if the pull must be aborted, or nil if it can carry on.
+func (c *errorCollector) handle(
+	ctx context.Context, err error, fields logrus.Fields, msg string,
+) error {
+	fields["error"] = err
+	logrus.WithContext(ctx).WithFields(fields).Error(msg)
+
+	if c.failFast {
+		return err
+	}
+
+	c.errs = append(c.errs, err)
+	return nil
+}
+
+// err returns an error summarising the errors recorded during the pull, or nil
+// if there wasn't any.
+func (c *errorCollector) err() error {
+	switch len(c.errs) {
+	case 0:
+		return nil
+	case 1:
+		return c.errs[0]
+	default:
+		return fmt.Errorf(
+			"%d errors encountered during the pull, the first one being: %v",
+			len(c.errs), c.errs[0],
+		)
+	}
+}
@@ -0,0 +1,112 @@
+package puller
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// addFoldersMetadataToRepo writes, in each directory of the repository mapped
+// to a Grafana folder, a metadata file describing this folder (UID, title and
+// permissions), so that folders' properties are versioned alongside the
+// dashboards they contain. It then adds the files to the git index so they can
+// be comitted afterwards. Folders that don't exist on Grafana are skipped.
+// Returns an error if there was an issue retrieving a folder's metadata from
+// Grafana, or writing a file or adding it to the index.
+func addFoldersMetadataToRepo(
+	ctx context.Context, client *grafana.Client, clonePath string,
+	cfg *config.Config, worktree *gogit.Worktree,
+) error {
+	dirs, err := foldersDirs(ctx, client, cfg)
+	if err != nil {
+		return err
+	}
+
+	for dir, title := range dirs {
+		metadata, err := client.GetFolderMetadata(ctx, title)
+		if err != nil {
+			return err
+		}
+
+		if metadata == nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"folder": title,
+				"dir":    dir,
+			}).Warn("Folder doesn't exist on Grafana, not writing its metadata")
+
+			continue
+		}
+
+		content, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+
+		if err = os.MkdirAll(filepath.Join(clonePath, dir), 0755); err != nil {
+			return err
+		}
+
+		filename := path.Join(dir, cfg.Metadata.FolderFile)
+		if err = rewriteFile(filepath.Join(clonePath, filename), content, cfg.LineEndings); err != nil {
+			return err
+		}
+
+		// If worktree is nil, it means that it hasn't been initialised, which
+		// means the sync mode is "simple sync" and not Git.
+		if worktree != nil {
+			if _, err = worktree.Add(cfg.Git.RepositoryPath(filename)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// foldersDirs returns the titles of the Grafana folders (other than the
+// "General" folder) which metadata must be written in the repository, mapped to
+// the directories they're stored in. These are the folders mapped to
+// directories of the repository in the pusher's settings (the root of the
+// repository being mapped to master's folder), and, with the "folders" layout,
+// all of the folders on the Grafana instance.
+// Returns an error if there was an issue retrieving the folders from Grafana.
+func foldersDirs(
+	ctx context.Context, client *grafana.Client, cfg *config.Config,
+) (map[string]string, error) {
+	dirs := make(map[string]string)
+
+	if cfg.Layout == config.LayoutFolders {
+		folders, err := client.GetFolders(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, folder := range folders {
+			dirs[cfg.FolderDir(folder.Title)] = folder.Title
+		}
+	}
+
+	if cfg.Pusher == nil {
+		return dirs, nil
+	}
+
+	if folder := cfg.Pusher.Branches["master"]; len(folder) > 0 {
+		dirs["."] = folder
+	}
+
+	for dir, target := range cfg.Pusher.Dirs {
+		if len(target.Folder) > 0 {
+			dirs[path.Clean(dir)] = target.Folder
+		}
+	}
+
+	return dirs, nil
+}
@@ -0,0 +1,134 @@
+package puller
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// historyEntry is the content of a file describing a past version of a
+// dashboard in the history's directory.
+type historyEntry struct {
+	Version   int             `json:"version"`
+	Created   string          `json:"created"`
+	CreatedBy string          `json:"createdBy"`
+	Message   string          `json:"message"`
+	Dashboard json.RawMessage `json:"dashboard"`
+}
+
+// addHistoryToRepo writes the given number (from the history settings) of most
+// recent versions of the given dashboard, along with their authors and change
+// messages, in files named after the versions' numbers, in a directory named
+// after the dashboard's UID in the history's directory. The files of older
+// versions are removed. It then adds the changes to the git index so they can
+// be comitted afterwards. Since a version's content never changes, versions
+// which file already exists aren't retrieved again, and the dashboard's history
+// isn't retrieved at all if the file of its current version exists.
+// Returns an error if there was an issue retrieving the versions from Grafana,
+// or listing, writing or removing a file, or adding the changes to the index.
+func addHistoryToRepo(
+	ctx context.Context, client *grafana.Client, dashboard *grafana.Dashboard,
+	clonePath string, cfg *config.Config, worktree *gogit.Worktree,
+) error {
+	dir := path.Join(cfg.History.Path, dashboard.UID)
+
+	_, err := os.Stat(filepath.Join(clonePath, historyFilename(dir, dashboard.Version)))
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	revisions, err := client.GetDashboardRevisions(ctx, dashboard.UID, cfg.History.Versions)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Join(clonePath, dir), 0755); err != nil {
+		return err
+	}
+
+	kept := make(map[string]bool)
+	for _, revision := range revisions {
+		filename := historyFilename(dir, revision.Version)
+		kept[filename] = true
+
+		_, err = os.Stat(filepath.Join(clonePath, filename))
+		if err == nil {
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"name":    dashboard.Name,
+			"version": revision.Version,
+		}).Info("Exporting new version of the dashboard")
+
+		full, err := client.GetDashboardRevision(ctx, dashboard.UID, revision.Version)
+		if err != nil {
+			return err
+		}
+
+		content, err := json.Marshal(historyEntry{
+			Version:   full.Version,
+			Created:   full.Created,
+			CreatedBy: full.CreatedBy,
+			Message:   full.Message,
+			Dashboard: full.Data,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err = rewriteFile(filepath.Join(clonePath, filename), content, cfg.LineEndings); err != nil {
+			return err
+		}
+
+		// If worktree is nil, it means that it hasn't been initialised, which
+		// means the sync mode is "simple sync" and not Git.
+		if worktree != nil {
+			if _, err = worktree.Add(cfg.Git.RepositoryPath(filename)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Remove the versions that are now too old to be kept.
+	files, err := ioutil.ReadDir(filepath.Join(clonePath, dir))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		filename := path.Join(dir, file.Name())
+		if file.IsDir() || !strings.HasSuffix(filename, ".json") || kept[filename] {
+			continue
+		}
+
+		if err = removeFile(clonePath, filename, cfg, worktree); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// historyFilename returns the path of the file describing the given version of
+// a dashboard in the given directory of the history.
+func historyFilename(dir string, version int) string {
+	return path.Join(dir, strconv.Itoa(version)+".json")
+}
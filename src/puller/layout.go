@@ -0,0 +1,198 @@
+package puller
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/git"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/textfile"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotFoldersLayout is returned when trying to migrate a repository to the
+// "folders" layout while the configuration doesn't use it.
+var ErrNotFoldersLayout = errors.New("The layout must be set to \"folders\" in the configuration to migrate the repository to it")
+
+// Move describes a file moved from one path to another, both relative to the
+// sync path.
+type Move struct {
+	From string
+	To   string
+}
+
+// MigrateLayout moves the files of the dashboards at the root of the sync path
+// (i.e. laid out with the "flat" layout) to the directories of their folders on
+// Grafana, as expected by the "folders" layout, along with the files
+// describing their permissions. Dashboards in the "General" folder, or which
+// don't exist on Grafana, are left at the root. In Git mode, the repository is
+// synchronised first, the files are moved with the equivalent of "git mv" so
+// their history can be followed, and the moves are committed and pushed. If
+// dryRun is true, the moves are only listed.
+// Returns the moves.
+// Returns an error if the configuration doesn't use the "folders" layout, or if
+// there was an issue synchronising the repository, reading the files,
+// retrieving the dashboards from Grafana, moving the files, or committing and
+// pushing the changes.
+func MigrateLayout(
+	ctx context.Context, client *grafana.Client, cfg *config.Config,
+	dryRun bool,
+) ([]Move, error) {
+	if cfg.Layout != config.LayoutFolders {
+		return nil, ErrNotFoldersLayout
+	}
+
+	var repo *git.Repository
+	var w *gogit.Worktree
+	var syncPath string
+	var err error
+
+	if cfg.Git != nil {
+		syncPath = cfg.Git.SyncPath()
+
+		if repo, _, err = git.NewRepository(cfg.Git); err != nil {
+			return nil, err
+		}
+
+		if err = repo.Sync(false); err != nil {
+			return nil, err
+		}
+
+		if w, err = repo.Repo.Worktree(); err != nil {
+			return nil, err
+		}
+	} else {
+		syncPath = cfg.SimpleSync.SyncPath
+	}
+
+	moves, err := findLayoutMoves(ctx, client, syncPath, cfg)
+	if err != nil || dryRun || len(moves) == 0 {
+		return moves, err
+	}
+
+	for _, move := range moves {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"from": move.From,
+			"to":   move.To,
+		}).Info("Moving file to its folder's directory")
+
+		if err = os.MkdirAll(filepath.Join(syncPath, path.Dir(move.To)), 0755); err != nil {
+			return nil, err
+		}
+
+		// If worktree is nil, it means that it hasn't been initialised, which
+		// means the sync mode is "simple sync" and not Git.
+		if w != nil {
+			_, err = w.Move(cfg.Git.RepositoryPath(move.From), cfg.Git.RepositoryPath(move.To))
+		} else {
+			err = os.Rename(filepath.Join(syncPath, move.From), filepath.Join(syncPath, move.To))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Git == nil {
+		return moves, nil
+	}
+
+	dbVersions, err := getDashboardsVersions(syncPath, cfg.Metadata.VersionsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"files": len(moves),
+	}).Info("Comitting the migration to the folders layout")
+
+	if err = commitNewVersions(
+		dbVersions, nil, w, cfg, getCommitMessage("Migrated to the folders layout", nil),
+	); err != nil {
+		return nil, err
+	}
+
+	return moves, repo.Push()
+}
+
+// findLayoutMoves looks for the files of dashboards at the root of the given
+// sync path which belong to another directory with the "folders" layout, and
+// returns the moves needed to lay them out this way, including the moves of
+// the files describing their permissions. Files which don't describe a
+// dashboard, and files which would overwrite an existing file, are left out.
+// Returns an error if there was an issue reading the files, or retrieving a
+// dashboard from Grafana.
+func findLayoutMoves(
+	ctx context.Context, client *grafana.Client, syncPath string,
+	cfg *config.Config,
+) ([]Move, error) {
+	files, err := ioutil.ReadDir(syncPath)
+	if err != nil {
+		return nil, err
+	}
+
+	moves := make([]Move, 0)
+	for _, file := range files {
+		filename := file.Name()
+		if file.IsDir() || !strings.HasSuffix(filename, ".json") ||
+			cfg.Metadata.IsMetadataFile(filename) || cfg.IsPermissionsFile(filename) {
+			continue
+		}
+
+		content, err := textfile.Read(filepath.Join(syncPath, filename))
+		if err != nil {
+			return nil, err
+		}
+
+		ref, err := grafana.RefFromJSON(content)
+		if err != nil {
+			// Not a dashboard.
+			continue
+		}
+
+		dashboard, err := client.GetDashboardByRef(ctx, ref)
+		if grafana.IsNotFound(err) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"filename": filename,
+			}).Warn("Dashboard doesn't exist on Grafana, leaving its file at the root")
+
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		dir := cfg.FolderDir(dashboard.FolderTitle)
+		if dir == "." {
+			continue
+		}
+
+		candidates := []Move{{From: filename, To: path.Join(dir, filename)}}
+		permissions := config.PermissionsFile(filename)
+		if _, err = os.Stat(filepath.Join(syncPath, permissions)); err == nil {
+			candidates = append(candidates, Move{From: permissions, To: path.Join(dir, permissions)})
+		}
+
+		for _, move := range candidates {
+			if _, err = os.Stat(filepath.Join(syncPath, move.To)); err == nil {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"from": move.From,
+					"to":   move.To,
+				}).Warn("A file already exists in the folder's directory, not moving the file")
+
+				continue
+			}
+
+			moves = append(moves, move)
+		}
+	}
+
+	return moves, nil
+}
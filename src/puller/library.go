@@ -0,0 +1,140 @@
+package puller
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// exportedObject is an object from Grafana (e.g. a library panel) which is
+// written to the repository in a file named after its UID.
+type exportedObject struct {
+	uid     string
+	name    string
+	rawJSON []byte
+}
+
+// addLibraryPanelsToRepo writes the JSON description of each library panel on
+// Grafana in a file named after the library panel's UID, in the library
+// panels' directory, and removes the files describing library panels that
+// don't exist anymore. It then adds the changes to the git index so they can be
+// comitted afterwards.
+// Returns an error if there was an issue retrieving the library panels from
+// Grafana, or listing, writing or removing a file, or adding the changes to the
+// index.
+func addLibraryPanelsToRepo(
+	ctx context.Context, client *grafana.Client, clonePath string,
+	cfg *config.Config, worktree *gogit.Worktree,
+) error {
+	panels, err := client.GetLibraryPanels(ctx)
+	if err != nil {
+		return err
+	}
+
+	objects := make([]exportedObject, 0, len(panels))
+	for _, panel := range panels {
+		objects = append(objects, exportedObject{panel.UID, panel.Name, panel.RawJSON})
+	}
+
+	return writeObjects(ctx, clonePath, cfg.LibraryPanels.Path, "Library panel", objects, cfg, worktree)
+}
+
+// addPlaylistsToRepo writes the JSON description of each playlist on Grafana,
+// along with its items, in a file named after the playlist's UID, in the
+// playlists' directory, and removes the files describing playlists that don't
+// exist anymore. It then adds the changes to the git index so they can be
+// comitted afterwards. Playlists without an UID (before Grafana 9) are
+// skipped.
+// Returns an error if there was an issue retrieving the playlists from
+// Grafana, or listing, writing or removing a file, or adding the changes to the
+// index.
+func addPlaylistsToRepo(
+	ctx context.Context, client *grafana.Client, clonePath string,
+	cfg *config.Config, worktree *gogit.Worktree,
+) error {
+	playlists, err := client.GetPlaylists(ctx)
+	if err != nil {
+		return err
+	}
+
+	objects := make([]exportedObject, 0, len(playlists))
+	for _, playlist := range playlists {
+		objects = append(objects, exportedObject{playlist.UID, playlist.Name, playlist.RawJSON})
+	}
+
+	return writeObjects(ctx, clonePath, cfg.Playlists.Path, "Playlist", objects, cfg, worktree)
+}
+
+// writeObjects writes each of the given objects in a file named after its UID
+// in the given directory (relative to the clone path), and removes the other
+// JSON files from the directory, then adds the changes to the git index if the
+// given worktree isn't nil. Objects without an UID are skipped. The given kind
+// of the objects is only used in logs.
+// Returns an error if there was an issue listing, writing or removing a file,
+// or adding the changes to the index.
+func writeObjects(
+	ctx context.Context, clonePath string, dir string, kind string,
+	objects []exportedObject, cfg *config.Config, worktree *gogit.Worktree,
+) error {
+	if err := os.MkdirAll(filepath.Join(clonePath, dir), 0755); err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+	for _, object := range objects {
+		if len(object.uid) == 0 {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"name": object.name,
+			}).Warn(kind + " has no UID, skipping")
+
+			continue
+		}
+
+		filename := path.Join(dir, object.uid+".json")
+		if err := rewriteFile(filepath.Join(clonePath, filename), object.rawJSON, cfg.LineEndings); err != nil {
+			return err
+		}
+
+		written[filename] = true
+
+		// If worktree is nil, it means that it hasn't been initialised, which
+		// means the sync mode is "simple sync" and not Git.
+		if worktree != nil {
+			if _, err := worktree.Add(cfg.Git.RepositoryPath(filename)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Remove the objects that were deleted on Grafana.
+	files, err := ioutil.ReadDir(filepath.Join(clonePath, dir))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		filename := path.Join(dir, file.Name())
+		if file.IsDir() || !strings.HasSuffix(filename, ".json") || written[filename] {
+			continue
+		}
+
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"filename": filename,
+		}).Info(kind + " was removed from Grafana, removing its file")
+
+		if err = removeFile(clonePath, filename, cfg, worktree); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package puller
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/git"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pullCommitTitle is the title of the commits the puller creates when
+// retrieving new versions of dashboards, unless a template is configured.
+const pullCommitTitle = "Updated dashboards"
+
+// CommitMessageData is the data the template of the puller's commit messages
+// is executed with: the dashboards updated by the commit, sorted by slug, and
+// the logins of the users who last changed them on Grafana, sorted and without
+// duplicates.
+type CommitMessageData struct {
+	Dashboards []CommitMessageDashboard
+	Authors    []string
+}
+
+// CommitMessageDashboard describes a dashboard updated by a commit of the
+// puller: its slug, name and UID, its version in the repository before the
+// commit (0 if it's new) and after it, the login of the user who last changed
+// it on Grafana, and the URL of its page in Grafana's UI. The author and URL
+// are empty if Grafana didn't provide them.
+type CommitMessageDashboard struct {
+	Slug       string
+	Name       string
+	UID        string
+	OldVersion int
+	NewVersion int
+	Author     string
+	URL        string
+}
+
+// getPullCommitMessage creates the message of the commit including the given
+// version updates retrieved by the puller, using the template from the given
+// settings if there's one, else the default title and summary (see
+// getCommitMessage). The sync trailer is always added at the end of the
+// message, so the pusher doesn't push the commit back to Grafana. If the
+// template can't be executed, or renders an empty message, a warning is logged
+// and the default message is used.
+func getPullCommitMessage(
+	ctx context.Context, dv map[string]diffVersion, cfg *config.GitSettings,
+) string {
+	if len(cfg.CommitMessage) == 0 {
+		return getCommitMessage(pullCommitTitle, dv)
+	}
+
+	message, err := renderCommitMessage(cfg.CommitMessage, dv)
+	if err != nil || len(message) == 0 {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("Failed to render the commit message's template, using the default message")
+
+		return getCommitMessage(pullCommitTitle, dv)
+	}
+
+	return message + "\n\n" + git.SyncTrailer + "\n"
+}
+
+// renderCommitMessage executes the given template of commit messages with the
+// given version updates, and returns the result without its trailing spaces and
+// line breaks.
+// Returns an error if there was an issue parsing or executing the template.
+func renderCommitMessage(text string, dv map[string]diffVersion) (string, error) {
+	tmpl, err := template.New("commit_message").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	keys := make([]string, 0, len(dv))
+	for key := range dv {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if dv[keys[i]].slug != dv[keys[j]].slug {
+			return dv[keys[i]].slug < dv[keys[j]].slug
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	data := CommitMessageData{
+		Dashboards: make([]CommitMessageDashboard, 0, len(keys)),
+		Authors:    make([]string, 0),
+	}
+
+	authors := make(map[string]bool)
+	for _, key := range keys {
+		diff := dv[key]
+		data.Dashboards = append(data.Dashboards, CommitMessageDashboard{
+			Slug:       diff.slug,
+			Name:       diff.name,
+			UID:        diff.uid,
+			OldVersion: diff.oldVersion,
+			NewVersion: diff.newVersion,
+			Author:     diff.author.login,
+			URL:        diff.url,
+		})
+
+		if login := diff.author.login; len(login) > 0 && !authors[login] {
+			authors[login] = true
+			data.Authors = append(data.Authors, login)
+		}
+	}
+	sort.Strings(data.Authors)
+
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(buf.String(), " \t\r\n"), nil
+}
@@ -0,0 +1,93 @@
+package puller
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// addAlertNotificationsToRepo writes the JSON description of each legacy alert
+// notification channel on Grafana in a file named after the channel's UID, in
+// the alert notification channels' directory, and removes the files describing
+// channels that don't exist anymore. It then adds the changes to the git index
+// so they can be comitted afterwards. Channels without an UID are skipped.
+// Returns an error if there was an issue retrieving the channels from Grafana,
+// or listing, writing or removing a file, or adding the changes to the index.
+func addAlertNotificationsToRepo(
+	ctx context.Context, client *grafana.Client, clonePath string,
+	cfg *config.Config, worktree *gogit.Worktree,
+) error {
+	channels, err := client.GetAlertNotifications(ctx)
+	if err != nil {
+		return err
+	}
+
+	dir := cfg.AlertNotifications.Path
+	if err = os.MkdirAll(filepath.Join(clonePath, dir), 0755); err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+	for _, channel := range channels {
+		if len(channel.UID) == 0 {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"name": channel.Name,
+			}).Warn("Alert notification channel has no UID, skipping")
+
+			continue
+		}
+
+		filename := path.Join(dir, channel.UID+".json")
+		if err = rewriteFile(filepath.Join(clonePath, filename), channel.RawJSON, cfg.LineEndings); err != nil {
+			return err
+		}
+
+		written[filename] = true
+
+		// If worktree is nil, it means that it hasn't been initialised, which
+		// means the sync mode is "simple sync" and not Git.
+		if worktree != nil {
+			if _, err = worktree.Add(cfg.Git.RepositoryPath(filename)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Remove the channels that were deleted on Grafana.
+	files, err := ioutil.ReadDir(filepath.Join(clonePath, dir))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		filename := path.Join(dir, file.Name())
+		if file.IsDir() || !strings.HasSuffix(filename, ".json") || written[filename] {
+			continue
+		}
+
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"filename": filename,
+		}).Info("Alert notification channel was removed from Grafana, removing its file")
+
+		if err = os.Remove(filepath.Join(clonePath, filename)); err != nil {
+			return err
+		}
+
+		if worktree != nil {
+			if _, err = worktree.Remove(cfg.Git.RepositoryPath(filename)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
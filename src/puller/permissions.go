@@ -0,0 +1,59 @@
+package puller
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	gogit "github.com/go-git/go-git/v5"
+)
+
+// addPermissionsToRepo writes the permissions of a dashboard, along with its
+// UID, in a file next to the dashboard's file (in the given directory), then
+// adds the file to the git index so it can be comitted afterwards. Since
+// changing a dashboard's permissions doesn't change its version, this must be
+// done for every dashboard on each pull; the file only changes if the
+// permissions did.
+// Returns an error if there was an issue retrieving the permissions from
+// Grafana, writing the file or adding it to the index.
+func addPermissionsToRepo(
+	ctx context.Context, client *grafana.Client, dashboard *grafana.Dashboard,
+	dir string, clonePath string, cfg *config.Config, worktree *gogit.Worktree,
+) error {
+	permissions, err := client.GetDashboardPermissions(ctx, dashboard.UID)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(grafana.DashboardPermissions{
+		UID:         dashboard.UID,
+		Permissions: permissions,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Join(clonePath, dir), 0755); err != nil {
+		return err
+	}
+
+	filename := config.PermissionsFile(path.Join(dir, dashboard.Slug+".json"))
+	if err = rewriteFile(filepath.Join(clonePath, filename), content, cfg.LineEndings); err != nil {
+		return err
+	}
+
+	// If worktree is nil, it means that it hasn't been initialised, which means
+	// the sync mode is "simple sync" and not Git.
+	if worktree != nil {
+		if _, err = worktree.Add(cfg.Git.RepositoryPath(filename)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package puller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/textfile"
+
+	gogit "github.com/go-git/go-git/v5"
+	"gopkg.in/yaml.v2"
+)
+
+// provisioningFile is the content of a Grafana dashboards provisioning file,
+// as described in Grafana's documentation.
+type provisioningFile struct {
+	APIVersion int                    `yaml:"apiVersion"`
+	Providers  []provisioningProvider `yaml:"providers"`
+}
+
+// provisioningProvider describes a dashboards provider in a Grafana
+// provisioning file.
+type provisioningProvider struct {
+	Name                  string              `yaml:"name"`
+	OrgID                 int                 `yaml:"orgId"`
+	Folder                string              `yaml:"folder"`
+	Type                  string              `yaml:"type"`
+	DisableDeletion       bool                `yaml:"disableDeletion"`
+	UpdateIntervalSeconds int                 `yaml:"updateIntervalSeconds"`
+	AllowUIUpdates        bool                `yaml:"allowUiUpdates"`
+	Options               provisioningOptions `yaml:"options"`
+}
+
+// provisioningOptions contains the options of a dashboards provider reading
+// dashboards from files.
+type provisioningOptions struct {
+	Path                      string `yaml:"path"`
+	FoldersFromFilesStructure bool   `yaml:"foldersFromFilesStructure"`
+}
+
+// addProvisioningToRepo writes a Grafana dashboards provisioning file with a
+// single provider reading the dashboards from the repository, at the path from
+// the provisioning settings, so the repository can be mounted in Grafana's
+// provisioning directory as an alternative to pushing the dashboards through
+// the API. With the "folders" layout, Grafana maps each directory to the
+// folder named after it. The file has the line endings from the configuration.
+// It then adds the file to the git index so it can be comitted afterwards.
+// Returns an error if there was an issue generating or writing the file, or
+// adding it to the index.
+func addProvisioningToRepo(
+	clonePath string, cfg *config.Config, worktree *gogit.Worktree,
+) error {
+	settings := cfg.Provisioning
+
+	provider := provisioningProvider{
+		Name:                  settings.Name,
+		OrgID:                 1,
+		Type:                  "file",
+		UpdateIntervalSeconds: int(settings.UpdateInterval.Seconds()),
+		AllowUIUpdates:        settings.AllowUIUpdates,
+		Options: provisioningOptions{
+			Path:                      settings.DashboardsPath,
+			FoldersFromFilesStructure: cfg.Layout == config.LayoutFolders,
+		},
+	}
+
+	// Grafana doesn't allow setting a folder when it's derived from the files'
+	// structure.
+	if cfg.Layout != config.LayoutFolders {
+		provider.Folder = settings.Folder
+	}
+
+	content, err := yaml.Marshal(provisioningFile{
+		APIVersion: 1,
+		Providers:  []provisioningProvider{provider},
+	})
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(clonePath, settings.File)
+	if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(
+		filename, textfile.WithLineEndings(content, cfg.LineEndings), 0644,
+	); err != nil {
+		return err
+	}
+
+	// If worktree is nil, it means that it hasn't been initialised, which
+	// means the sync mode is "simple sync" and not Git.
+	if worktree != nil {
+		if _, err = worktree.Add(cfg.Git.RepositoryPath(settings.File)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
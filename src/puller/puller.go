@@ -1,40 +1,66 @@
-package main
+package puller
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
-
-	"config"
-	"git"
-	"grafana"
-
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/git"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/semantic"
+	"github.com/babolivier/grafana-dashboards-manager/src/state"
+	"github.com/babolivier/grafana-dashboards-manager/src/tags"
+	"github.com/babolivier/grafana-dashboards-manager/src/templating"
+	"github.com/babolivier/grafana-dashboards-manager/src/textfile"
+
+	gogit "github.com/go-git/go-git/v5"
 	"github.com/sirupsen/logrus"
-	gogit "gopkg.in/src-d/go-git.v4"
 )
 
-// diffVersion represents a dashboard version diff.
+// diffVersion represents a dashboard version diff. The dashboard's slug, name,
+// UID, last author on Grafana and URL are only set for the dashboards retrieved
+// by the puller, for its commit messages and the attribution of its commits.
 type diffVersion struct {
 	oldVersion int
 	newVersion int
+	slug       string
+	name       string
+	uid        string
+	author     changeAuthor
+	url        string
 }
 
 // PullGrafanaAndCommit pulls all the dashboards from Grafana except the ones
 // which name starts with "test", then commits each of them to Git except for
 // those that have a newer or equal version number already versionned in the
-// repo.
-func PullGrafanaAndCommit(client *grafana.Client, cfg *config.Config) (err error) {
+// repo. Errors on individual dashboards, folders or alert notification
+// channels are handled according to the error policy from the configuration:
+// they either abort the pull, or are logged and returned once the changes
+// pulled successfully have been committed.
+func PullGrafanaAndCommit(
+	ctx context.Context, client *grafana.Client, cfg *config.Config,
+) (err error) {
 	var repo *git.Repository
 	var w *gogit.Worktree
 	var syncPath string
 
+	// Expose the transfers with the Git remote which timed out in the
+	// metrics.
+	defer func() { git.RecordTimeout(cfg, err) }()
+
 	// Only do Git stuff if there's a configuration for that. On "simple sync"
 	// mode, we don't need do do any versioning.
 	// We need to set syncPath accordingly, though, because we use it later.
 	if cfg.Git != nil {
-		syncPath = cfg.Git.ClonePath
+		syncPath = cfg.Git.SyncPath()
 
 		// Clone or pull the repo
 		repo, _, err = git.NewRepository(cfg.Git)
@@ -52,39 +78,83 @@ func PullGrafanaAndCommit(client *grafana.Client, cfg *config.Config) (err error
 		}
 	} else {
 		syncPath = cfg.SimpleSync.SyncPath
+
+		// Unlike the clone path, the sync path isn't created beforehand.
+		if err = os.MkdirAll(syncPath, 0755); err != nil {
+			return err
+		}
 	}
 
-	// Get URIs for all known dashboards
-	logrus.Info("Getting dashboard URIs")
-	uris, err := client.GetDashboardsURIs()
+	// Get references (UIDs, or URIs on older Grafana versions) for all known
+	// dashboards
+	logrus.WithContext(ctx).Info("Getting dashboard references")
+	refs, err := client.GetDashboardsRefs(ctx)
 	if err != nil {
 		return err
 	}
 
 	dv := make(map[string]diffVersion)
 
+	var authors *authorsResolver
+	if cfg.Git != nil && cfg.Git.Attribution != nil {
+		authors = newAuthorsResolver(client, cfg.Git.Attribution)
+	}
+
 	// Load versions
-	logrus.Info("Getting local dashboard versions")
-	dbVersions, err := getDashboardsVersions(syncPath)
+	logrus.WithContext(ctx).Info("Getting local dashboard versions")
+	dbVersions, err := getDashboardsVersions(syncPath, cfg.Metadata.VersionsFile)
 	if err != nil {
 		return err
 	}
 
-	// Iterate over the dashboards URIs
-	for _, uri := range uris {
-		logrus.WithFields(logrus.Fields{
+	// Index the existing dashboards' files, to find out which ones must be
+	// moved because their dashboard was renamed or changed folder.
+	index, err := indexDashboardFiles(syncPath, cfg)
+	if err != nil {
+		return err
+	}
+
+	// Keep track of the dashboards successfully pulled, to record it in the
+	// state once they've been committed.
+	pulled := make([]string, 0, len(refs))
+
+	errs := newErrorCollector(cfg)
+
+	// Read the dashboards which didn't change since the previous pull from the
+	// cache, if there's one. The pull carries on without it if it can't be
+	// set up.
+	var cache *dashboardCache
+	if cfg.Cache != nil {
+		if cache, err = newDashboardCache(ctx, client, cfg.Cache); err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error": err,
+				"cache": cfg.Cache.Path,
+			}).Warn("Failed to set up the dashboards cache, downloading all dashboards")
+		}
+	}
+
+	// Iterate over the dashboards references
+	for _, ref := range refs {
+		uri := ref.String()
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
 			"uri": uri,
 		}).Info("Retrieving dashboard")
 
 		// Retrieve the dashboard JSON
-		dashboard, err := client.GetDashboard(uri)
+		dashboard, err := cache.getDashboard(ctx, client, ref)
 		if err != nil {
-			return err
+			if err = errs.handle(ctx, err, logrus.Fields{
+				"uri": uri,
+			}, "Failed to retrieve the dashboard"); err != nil {
+				return err
+			}
+
+			continue
 		}
 
 		if len(cfg.Grafana.IgnorePrefix) > 0 {
 			if strings.HasPrefix(dashboard.Slug, cfg.Grafana.IgnorePrefix) {
-				logrus.WithFields(logrus.Fields{
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
 					"uri":    uri,
 					"name":   dashboard.Name,
 					"prefix": cfg.Grafana.IgnorePrefix,
@@ -95,32 +165,252 @@ func PullGrafanaAndCommit(client *grafana.Client, cfg *config.Config) (err error
 		}
 
 		// Check if there's a version for this dashboard in the data loaded from
-		// the "versions.json" file. If there's a version and it's older (lower
+		// the versions file. If there's a version and it's older (lower
 		// version number) than the version we just retrieved from the Grafana
 		// API, or if there's no known version (ok will be false), write the
 		// changes in the repo and add the modified file to the git index.
-		version, ok := dbVersions[dashboard.Slug]
+		version, ok := grafana.RecordedVersion(dbVersions, dashboard.UID, dashboard.Slug)
 		if !ok || dashboard.Version > version {
-			logrus.WithFields(logrus.Fields{
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
 				"uri":           uri,
 				"name":          dashboard.Name,
 				"local_version": version,
 				"new_version":   dashboard.Version,
 			}).Info("Grafana has a newer version, updating")
 
+			// Warn if the dashboard's tags were changed in Grafana's UI, so it
+			// might not be identifiable as managed anymore.
+			if cfg.Tags != nil {
+				checkTags(ctx, dashboard, cfg.Tags)
+			}
+
+			previousPaths := index.previousPaths(dashboard, cfg)
 			if err = addDashboardChangesToRepo(
-				dashboard, syncPath, w,
+				ctx, dashboard, index.dir(dashboard, cfg), syncPath, w, cfg, previousPaths,
 			); err != nil {
-				return err
+				if err = errs.handle(ctx, err, logrus.Fields{
+					"uri":  uri,
+					"name": dashboard.Name,
+				}, "Failed to write the dashboard in the repository"); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			// Forget the versions older releases recorded under the
+			// dashboard's previous slugs (see grafana.RecordedVersion), if it
+			// was renamed.
+			for _, previous := range previousPaths {
+				previousSlug := strings.TrimSuffix(path.Base(previous), ".json")
+				if previousSlug != dashboard.Slug {
+					delete(dbVersions, previousSlug)
+				}
+			}
+
+			// If requested, render the dashboard and store the image alongside
+			// it. We don't want to abort the whole pull if there's no image
+			// renderer available, so we only log the error.
+			if cfg.Screenshots != nil {
+				if err = addScreenshotToRepo(
+					ctx, client, dashboard, index.dir(dashboard, cfg), syncPath, cfg, w,
+				); err != nil {
+					logrus.WithContext(ctx).WithFields(logrus.Fields{
+						"error": err,
+						"uri":   uri,
+						"name":  dashboard.Name,
+					}).Warn("Failed to render the dashboard")
+				}
+			}
+
+			// Find out who changed the dashboard, so the commit can be
+			// attributed to them if requested.
+			author := changeAuthor{login: dashboard.UpdatedBy}
+			if authors != nil {
+				author = authors.lastAuthor(ctx, dashboard)
 			}
 
 			// We don't need to check for the value of ok because if ok is false
 			// version will be initialised to the 0-value of the int type, which
 			// is 0, so the previous version number will be considered to be 0,
 			// which is the behaviour we want.
-			dv[dashboard.Slug] = diffVersion{
+			dv[grafana.VersionKey(dashboard.UID, dashboard.Slug)] = diffVersion{
 				oldVersion: version,
 				newVersion: dashboard.Version,
+				slug:       dashboard.Slug,
+				name:       dashboard.Name,
+				uid:        dashboard.UID,
+				author:     author,
+				url:        client.DashboardURL(dashboard),
+			}
+		}
+
+		// Write the dashboard's permissions if requested. Dashboards without
+		// an UID (on Grafana versions older than 5.0) can't have any.
+		if cfg.SyncPermissions && len(dashboard.UID) > 0 {
+			if err = addPermissionsToRepo(
+				ctx, client, dashboard, index.dir(dashboard, cfg), syncPath, cfg, w,
+			); err != nil {
+				if err = errs.handle(ctx, err, logrus.Fields{
+					"uri":  uri,
+					"name": dashboard.Name,
+				}, "Failed to write the dashboard's permissions in the repository"); err != nil {
+					return err
+				}
+
+				continue
+			}
+		}
+
+		// Export the dashboard's legacy alerts to their own file if
+		// requested, so changes to them can be reviewed separately.
+		if cfg.LegacyAlerts != nil {
+			if err = addLegacyAlertsToRepo(
+				dashboard, syncPath, cfg, index.previousPaths(dashboard, cfg), w,
+			); err != nil {
+				if err = errs.handle(ctx, err, logrus.Fields{
+					"uri":  uri,
+					"name": dashboard.Name,
+				}, "Failed to export the dashboard's legacy alerts"); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Export the dashboard's latest versions if requested. Dashboards
+		// without an UID (on Grafana versions older than 5.0) can't be
+		// identified in the history.
+		if cfg.History != nil && len(dashboard.UID) > 0 {
+			if err = addHistoryToRepo(ctx, client, dashboard, syncPath, cfg, w); err != nil {
+				if err = errs.handle(ctx, err, logrus.Fields{
+					"uri":  uri,
+					"name": dashboard.Name,
+				}, "Failed to export the dashboard's history"); err != nil {
+					return err
+				}
+			}
+		}
+
+		pulled = append(pulled, dashboard.Slug)
+	}
+
+	if cache != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"cached":     cache.hits,
+			"downloaded": cache.misses,
+		}).Info("Retrieved the dashboards")
+
+		if err = cache.prune(refs); err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error": err,
+				"cache": cfg.Cache.Path,
+			}).Warn("Failed to remove the deleted dashboards from the cache")
+		}
+	}
+
+	// Write the metadata of the folders mapped to directories of the
+	// repository, so they're versioned alongside the dashboards.
+	logrus.WithContext(ctx).Info("Getting folders metadata")
+	if err = addFoldersMetadataToRepo(ctx, client, syncPath, cfg, w); err != nil {
+		if err = errs.handle(
+			ctx, err, logrus.Fields{}, "Failed to write the folders metadata",
+		); err != nil {
+			return err
+		}
+	}
+
+	// Write the legacy alert notification channels, if requested, so they're
+	// versioned alongside the dashboards.
+	if cfg.AlertNotifications != nil {
+		logrus.WithContext(ctx).Info("Getting alert notification channels")
+		if err = addAlertNotificationsToRepo(ctx, client, syncPath, cfg, w); err != nil {
+			if err = errs.handle(
+				ctx, err, logrus.Fields{}, "Failed to write the alert notification channels",
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Write the library panels and the playlists, if requested, so they can
+	// be restored along with the dashboards.
+	if cfg.LibraryPanels != nil {
+		logrus.WithContext(ctx).Info("Getting library panels")
+		if err = addLibraryPanelsToRepo(ctx, client, syncPath, cfg, w); err != nil {
+			if err = errs.handle(
+				ctx, err, logrus.Fields{}, "Failed to write the library panels",
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cfg.Playlists != nil {
+		logrus.WithContext(ctx).Info("Getting playlists")
+		if err = addPlaylistsToRepo(ctx, client, syncPath, cfg, w); err != nil {
+			if err = errs.handle(
+				ctx, err, logrus.Fields{}, "Failed to write the playlists",
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Export the dashboard snapshots, if requested, so they're versioned
+	// alongside the dashboards.
+	if cfg.Snapshots != nil {
+		logrus.WithContext(ctx).Info("Getting snapshots")
+		if err = addSnapshotsToRepo(ctx, client, syncPath, cfg, w); err != nil {
+			if err = errs.handle(
+				ctx, err, logrus.Fields{}, "Failed to export the snapshots",
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Export the recent annotations, if requested, for audit purposes.
+	if cfg.Annotations != nil {
+		logrus.WithContext(ctx).Info("Getting annotations")
+		if err = addAnnotationsToRepo(ctx, client, syncPath, cfg, w); err != nil {
+			if err = errs.handle(
+				ctx, err, logrus.Fields{}, "Failed to export the annotations",
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Write the provisioning file, if requested, so the repository can be
+	// provisioned as is.
+	if cfg.Provisioning != nil {
+		if err = addProvisioningToRepo(syncPath, cfg, w); err != nil {
+			if err = errs.handle(
+				ctx, err, logrus.Fields{}, "Failed to write the provisioning file",
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Remove the files which don't match any dashboard on Grafana anymore, if
+	// requested. In "simple sync" mode, there's no history to recover them
+	// from, nor any other process writing to the sync path, so the files of the
+	// dashboards deleted from Grafana are always removed, unless there's no
+	// dashboard on Grafana at all, which is more likely to come from a
+	// misconfiguration.
+	if cfg.CleanOrphans || cfg.Git == nil {
+		logrus.WithContext(ctx).Info("Removing orphaned files")
+		_, err = removeOrphans(ctx, syncPath, refs, dbVersions, cfg, w)
+		if err == errNoDashboards && !cfg.CleanOrphans {
+			logrus.WithContext(ctx).Warn("No dashboard on Grafana, not removing any file")
+			err = nil
+		}
+		if err != nil {
+			if err = errs.handle(
+				ctx, err, logrus.Fields{}, "Failed to remove the orphaned files",
+			); err != nil {
+				return err
 			}
 		}
 	}
@@ -137,44 +427,349 @@ func PullGrafanaAndCommit(client *grafana.Client, cfg *config.Config) (err error
 		// Check if there's uncommited changes, and if that's the case, commit
 		// them.
 		if !status.IsClean() {
-			logrus.Info("Comitting changes")
+			logrus.WithContext(ctx).Info("Comitting changes")
 
-			if err = commitNewVersions(dbVersions, dv, w, cfg); err != nil {
+			if err = commitNewVersions(
+				dbVersions, dv, w, cfg, getPullCommitMessage(ctx, dv, cfg.Git),
+			); err != nil {
 				return err
 			}
 		}
 
 		// Push the changes (we don't do it in the if clause above in case there
-		// are pending commits in the local repo that haven't been pushed yet).
-		if err = repo.Push(); err != nil {
+		// are pending commits in the local repo that haven't been pushed yet),
+		// to a branch for review if requested.
+		if cfg.Git.Review != nil {
+			err = pushForReview(ctx, repo, cfg)
+		} else {
+			err = repo.Push()
+		}
+		if err != nil {
 			return err
 		}
+
+		// Tag the state of the repository if the run was successful and it's
+		// requested. This doesn't affect the pull itself, so we only log the
+		// error.
+		if cfg.Git.SyncTags != nil && errs.err() == nil {
+			tagSync(ctx, repo, cfg.Git.SyncTags)
+		}
 	} else {
 		// If we're on simple sync mode, write versions and don't do anything
 		// else.
-		if err = writeVersions(dbVersions, dv, syncPath); err != nil {
+		if err = writeVersions(
+			dbVersions, dv, syncPath, cfg.Metadata.VersionsFile, cfg.LineEndings,
+		); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	// Record the pull in the state, if requested. This doesn't affect the
+	// pull itself, so we only log the error.
+	if cfg.State != nil {
+		if err = state.RecordPull(cfg.State.Path, pulled, time.Now()); err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error": err,
+				"state": cfg.State.Path,
+			}).Warn("Failed to record the pull in the state")
+		}
+	}
+
+	return errs.err()
 }
 
-// addDashboardChangesToRepo writes a dashboard content in a file, then adds the
-// file to the git index so it can be comitted afterwards.
+// addDashboardChangesToRepo writes a dashboard content in a file, in the
+// given directory (see dashboardFiles.dir), then adds the file to the git
+// index so it can be comitted afterwards. The given previous paths of the dashboard's
+// file (relative to the clone path) other than the new one are removed, so a
+// dashboard moved to another folder or renamed doesn't end up in two files,
+// along with the files describing their permissions, if any. If
+// templating is enabled and the existing file is a template, it is left
+// untouched, since overwriting it with the rendered dashboard would lose the
+// template. If layout changes are ignored, the existing file is also left
+// untouched if the new version of the dashboard only moves, resizes or
+// renumbers its panels. The volatile fields listed in the configuration are
+// removed from the content before it's written.
 // Returns an error if there was an issue with either of the steps.
 func addDashboardChangesToRepo(
-	dashboard *grafana.Dashboard, clonePath string, worktree *gogit.Worktree,
+	ctx context.Context, dashboard *grafana.Dashboard, dir string,
+	clonePath string, worktree *gogit.Worktree, cfg *config.Config,
+	previousPaths []string,
+) error {
+	slugExt := path.Join(dir, dashboard.Slug+".json")
+
+	// Remove the volatile fields from the dashboard, if requested, so they
+	// don't add noise to the diffs.
+	content := dashboard.RawJSON
+	if len(cfg.StripFields) > 0 {
+		var err error
+		if content, err = semantic.StripFields(content, cfg.StripFields); err != nil {
+			return err
+		}
+	}
+
+	if tmplCfg := templatingSettings(cfg); tmplCfg != nil {
+		current, err := textfile.Read(filepath.Join(clonePath, slugExt))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err == nil && templating.IsTemplate(current, tmplCfg) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"slug": dashboard.Slug,
+			}).Info("Dashboard file is a template, not overwriting it")
+
+			return nil
+		}
+	}
+
+	// Leave the file untouched if the new version of the dashboard only
+	// changes its layout, if requested.
+	if cfg.IgnoreLayoutChanges {
+		current, err := textfile.Read(filepath.Join(clonePath, slugExt))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err == nil {
+			equal, err := semantic.Equal(current, content)
+			if err != nil {
+				return err
+			}
+
+			if equal {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"slug": dashboard.Slug,
+				}).Info("Only the dashboard's layout changed, not overwriting its file")
+
+				return nil
+			}
+		}
+	}
+
+	for _, previous := range previousPaths {
+		if previous == slugExt {
+			continue
+		}
+
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"slug": dashboard.Slug,
+			"from": previous,
+			"to":   slugExt,
+		}).Info("Dashboard renamed or moved to another folder, moving its file")
+
+		if err := removeFile(clonePath, previous, cfg, worktree); err != nil {
+			return err
+		}
+
+		if err := removeFile(clonePath, config.PermissionsFile(previous), cfg, worktree); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(clonePath, dir), 0755); err != nil {
+		return err
+	}
+
+	if err := rewriteFile(filepath.Join(clonePath, slugExt), content, cfg.LineEndings); err != nil {
+		return err
+	}
+
+	// If worktree is nil, it means that it hasn't been initialised, which means
+	// the sync mode is "simple sync" and not Git.
+	if worktree != nil {
+		if _, err := worktree.Add(cfg.Git.RepositoryPath(slugExt)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeFile removes the file at the given path (relative to the clone path),
+// if it exists, then removes it from the git index so the removal can be
+// comitted afterwards.
+// Returns an error if there was an issue removing the file or updating the
+// index.
+func removeFile(
+	clonePath string, filename string, cfg *config.Config,
+	worktree *gogit.Worktree,
+) error {
+	err := os.Remove(filepath.Join(clonePath, filename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// If worktree is nil, it means that it hasn't been initialised, which means
+	// the sync mode is "simple sync" and not Git.
+	if worktree != nil {
+		if _, err = worktree.Remove(cfg.Git.RepositoryPath(filename)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dashboardFiles indexes the paths of the dashboards' files in the repository
+// (relative to the clone path) by dashboard UID.
+type dashboardFiles struct {
+	byUID map[string][]string
+}
+
+// indexDashboardFiles lists the JSON files in the given directory and its
+// subdirectories (except for hidden ones, e.g. ".git"), other than metadata
+// files, alert notification channels' files, snapshots' files, annotations'
+// files, history files, legacy alerts' files and dashboards' permissions files,
+// and returns an index of their paths relative to the directory.
+// Returns an error if there was an issue walking the directory, or reading or
+// parsing a file.
+func indexDashboardFiles(dir string, cfg *config.Config) (*dashboardFiles, error) {
+	index := &dashboardFiles{
+		byUID: make(map[string][]string),
+	}
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if p != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		if cfg.Metadata.IsMetadataFile(rel) || cfg.IsAlertNotificationFile(rel) ||
+			cfg.IsPermissionsFile(rel) || cfg.IsSnapshotFile(rel) ||
+			cfg.IsAnnotationsFile(rel) || cfg.IsHistoryFile(rel) ||
+			cfg.IsLegacyAlertsFile(rel) || cfg.IsLibraryPanelFile(rel) ||
+			cfg.IsPlaylistFile(rel) {
+			return nil
+		}
+
+		content, err := textfile.Read(p)
+		if err != nil {
+			return err
+		}
+
+		// Files that don't describe a dashboard (or describe one without an
+		// UID) aren't indexed.
+		if uid, err := helpers.GetDashboardUID(content); err == nil && len(uid) > 0 {
+			index.byUID[uid] = append(index.byUID[uid], rel)
+		}
+
+		return nil
+	})
+
+	return index, err
+}
+
+// previousPaths returns the paths of the files the given dashboard might have
+// been written to by previous pulls, i.e. the files other than its own
+// describing a dashboard with the same UID, which the dashboard had before
+// being renamed or, with the "folders" layout, before changing folder. Files
+// with the same name in other directories which describe another dashboard
+// (e.g. one with the same title in another folder) are left alone. With the
+// "flat" layout, files in other directories than the dashboard's weren't
+// written by the puller, so they're left alone too. If rename tracking is
+// turned off, there are none.
+func (f *dashboardFiles) previousPaths(
+	dashboard *grafana.Dashboard, cfg *config.Config,
+) []string {
+	if !cfg.Feature(config.FeatureRenameTracking) {
+		return nil
+	}
+
+	dir := f.dir(dashboard, cfg)
+	filename := path.Join(dir, dashboard.Slug+".json")
+
+	paths := make([]string, 0)
+	for _, p := range f.byUID[dashboard.UID] {
+		if cfg.Layout != config.LayoutFolders && path.Dir(p) != dir {
+			continue
+		}
+
+		if p != filename {
+			paths = append(paths, p)
+		}
+	}
+
+	return paths
+}
+
+// dir returns the directory of the repository the given dashboard's file must
+// be written to. This is the directory of the folder the dashboard is in (see
+// config.FolderDir), unless the pusher derives the folders from the
+// directories (see config.PathFolder) and a file describing the dashboard is
+// already in a directory the pusher maps to its folder, in which case the file
+// is left in its directory, since the folder can't be mapped back to it.
+func (f *dashboardFiles) dir(dashboard *grafana.Dashboard, cfg *config.Config) string {
+	for _, p := range f.byUID[dashboard.UID] {
+		if folder, ok := cfg.PathFolder(p); ok && folder == dashboard.FolderTitle {
+			return path.Dir(p)
+		}
+	}
+
+	return cfg.FolderDir(dashboard.FolderTitle)
+}
+
+// templatingSettings returns the templating settings from the pusher's
+// settings, or nil if templating isn't enabled.
+func templatingSettings(cfg *config.Config) *config.TemplatingSettings {
+	if cfg.Pusher == nil {
+		return nil
+	}
+
+	return cfg.Pusher.Templating
+}
+
+// addScreenshotToRepo renders a dashboard as a PNG image and writes it in the
+// screenshots directory, at the path matching the dashboard's file in the given
+// directory (see config.ScreenshotsSettings.ScreenshotPath), then adds the file
+// to the git index so it can be comitted afterwards.
+// Returns an error if there was an issue rendering the dashboard, creating the
+// directory, writing the file or adding it to the index.
+func addScreenshotToRepo(
+	ctx context.Context, client *grafana.Client, dashboard *grafana.Dashboard,
+	dir string, clonePath string, cfg *config.Config, worktree *gogit.Worktree,
 ) error {
-	slugExt := dashboard.Slug + ".json"
-	if err := rewriteFile(clonePath+"/"+slugExt, dashboard.RawJSON); err != nil {
+	settings := cfg.Screenshots
+	png, err := client.RenderDashboard(
+		ctx, dashboard.UID, dashboard.Slug, settings.Width, settings.Height,
+	)
+	if err != nil {
+		return err
+	}
+
+	filename := settings.ScreenshotPath(path.Join(dir, dashboard.Slug+".json"))
+	if err = os.MkdirAll(filepath.Join(clonePath, path.Dir(filename)), 0755); err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(clonePath, filename), png, 0644); err != nil {
 		return err
 	}
 
 	// If worktree is nil, it means that it hasn't been initialised, which means
 	// the sync mode is "simple sync" and not Git.
 	if worktree != nil {
-		if _, err := worktree.Add(slugExt); err != nil {
+		if _, err = worktree.Add(cfg.Git.RepositoryPath(filename)); err != nil {
 			return err
 		}
 	}
@@ -183,14 +778,15 @@ func addDashboardChangesToRepo(
 }
 
 // rewriteFile removes a given file and re-creates it with a new content. The
-// content is provided as JSON, and is then indented before being written down.
+// content is provided as JSON, and is then indented and given the line endings
+// from the configuration before being written down.
 // We need the whole "remove then recreate" thing because, if the file already
 // exists, ioutil.WriteFile will append the content to it. However, we want to
 // replace the oldest version with another (so git can diff it), so we re-create
 // the file with the changed content.
 // Returns an error if there was an issue when removing or writing the file, or
 // indenting the JSON content.
-func rewriteFile(filename string, content []byte) error {
+func rewriteFile(filename string, content []byte, lineEndings string) error {
 	if err := os.Remove(filename); err != nil {
 		pe, ok := err.(*os.PathError)
 		if !ok || pe.Err.Error() != "no such file or directory" {
@@ -203,7 +799,7 @@ func rewriteFile(filename string, content []byte) error {
 		return err
 	}
 
-	return ioutil.WriteFile(filename, indentedContent, 0644)
+	return ioutil.WriteFile(filename, textfile.WithLineEndings(indentedContent, lineEndings), 0644)
 }
 
 // indent indents a given JSON content with tabs.
@@ -219,3 +815,28 @@ func indent(srcJSON []byte) (indentedJSON []byte, err error) {
 	indentedJSON, err = ioutil.ReadAll(buf)
 	return
 }
+
+// checkTags compares the tags of the given dashboard with the ones required by
+// the given settings, and logs a warning if tags to add are missing, or tags to
+// remove are present. They'll be fixed next time the dashboard is pushed.
+func checkTags(
+	ctx context.Context, dashboard *grafana.Dashboard, cfg *config.TagsSettings,
+) {
+	missing, unwanted, err := tags.Check(dashboard.RawJSON, cfg)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error": err,
+			"name":  dashboard.Name,
+		}).Warn("Failed to check the dashboard's tags")
+
+		return
+	}
+
+	if len(missing) > 0 || len(unwanted) > 0 {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"name":          dashboard.Name,
+			"missing_tags":  missing,
+			"unwanted_tags": unwanted,
+		}).Warn("The dashboard's tags don't match the configured ones")
+	}
+}
@@ -0,0 +1,63 @@
+package puller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+)
+
+func TestPreviousPaths(t *testing.T) {
+	tests := []struct {
+		name      string
+		layout    string
+		byUID     map[string][]string
+		dashboard *grafana.Dashboard
+		expected  []string
+	}{
+		{
+			name:   "same slug in another folder",
+			layout: config.LayoutFolders,
+			byUID: map[string][]string{
+				"a": {"Team A/latency.json"},
+				"b": {"Team B/latency.json"},
+			},
+			dashboard: &grafana.Dashboard{UID: "b", Slug: "latency", FolderTitle: "Team B"},
+			expected:  []string{},
+		},
+		{
+			name:      "moved to another folder",
+			layout:    config.LayoutFolders,
+			byUID:     map[string][]string{"a": {"Team A/latency.json"}},
+			dashboard: &grafana.Dashboard{UID: "a", Slug: "latency", FolderTitle: "Team B"},
+			expected:  []string{"Team A/latency.json"},
+		},
+		{
+			name:      "renamed",
+			layout:    config.LayoutFolders,
+			byUID:     map[string][]string{"a": {"Team A/old.json"}},
+			dashboard: &grafana.Dashboard{UID: "a", Slug: "latency", FolderTitle: "Team A"},
+			expected:  []string{"Team A/old.json"},
+		},
+		{
+			name:      "flat layout, other directory",
+			layout:    config.LayoutFlat,
+			byUID:     map[string][]string{"a": {"other/old.json"}},
+			dashboard: &grafana.Dashboard{UID: "a", Slug: "latency", FolderTitle: "Team A"},
+			expected:  []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{Layout: test.layout}
+			index := &dashboardFiles{byUID: test.byUID}
+
+			paths := index.previousPaths(test.dashboard, cfg)
+			if !reflect.DeepEqual(paths, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, paths)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package puller
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/forge"
+	"github.com/babolivier/grafana-dashboards-manager/src/git"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pushForReview pushes the commits of the clone's branch which weren't pushed
+// to the remote to the review branch from the given settings instead, opens a
+// merge request to merge it into the clone's branch if there isn't one already,
+// then resets the clone's branch to the remote's, so the changes only land on
+// it once the merge request is merged. Since each pull commits all of the
+// changes since the remote's branch, the review branch is overwritten, unless
+// it already holds the same changes.
+// Returns an error if there was an issue resolving the branches, pushing to the
+// review branch, resetting the clone, or opening the merge request.
+func pushForReview(
+	ctx context.Context, repo *git.Repository, cfg *config.Config,
+) error {
+	ahead, err := repo.IsAheadOfRemote()
+	if err != nil || !ahead {
+		return err
+	}
+
+	target, err := repo.CurrentBranch()
+	if err != nil {
+		return err
+	}
+
+	head, err := repo.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+
+	branch := cfg.Git.Review.ReviewBranch(time.Now())
+
+	// Don't overwrite the review branch with the same changes, so its history
+	// (and the merge request's) isn't cluttered with identical commits.
+	var pushErr error
+	if remote, err := repo.GetBranchHead(branch); err == nil && remote.TreeHash == head.TreeHash {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"branch": branch,
+		}).Info("Review branch already holds the changes, not pushing them")
+	} else {
+		pushErr = repo.PushToBranch(branch)
+	}
+
+	// The changes are committed again by the next pull if they weren't merged
+	// by then, so the clone's branch is reset even if the push failed.
+	if err = repo.ResetToRemote(); err != nil {
+		return err
+	}
+
+	if pushErr != nil {
+		return pushErr
+	}
+
+	title, description := mergeRequestMessage(head.Message)
+	url, opened, err := forge.NewClient(cfg.Forge).OpenMergeRequest(
+		branch, target, title, description,
+	)
+	if err != nil {
+		return err
+	}
+
+	if opened {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"branch":        branch,
+			"merge_request": url,
+		}).Info("Opened a merge request to review the changes")
+	} else {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"branch":        branch,
+			"merge_request": url,
+		}).Info("Updated the merge request reviewing the changes")
+	}
+
+	return nil
+}
+
+// mergeRequestMessage returns the title and the (Markdown) description of the
+// merge request to open for a commit with the given message: the title is the
+// commit's title, and the description lists the version updates the commit
+// message summarises, without the sync trailer.
+func mergeRequestMessage(message string) (title string, description string) {
+	lines := strings.Split(strings.TrimSpace(message), "\n")
+	title = lines[0]
+
+	for _, line := range lines[1:] {
+		if len(line) > 0 && line != git.SyncTrailer {
+			description += "* " + line + "\n"
+		}
+	}
+
+	return
+}
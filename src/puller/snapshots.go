@@ -0,0 +1,107 @@
+package puller
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// addSnapshotsToRepo writes the JSON description of each dashboard snapshot on
+// Grafana in a file named after the snapshot's key, in the snapshots'
+// directory, and removes the files describing snapshots that don't exist
+// anymore (e.g. because they expired). It then adds the changes to the git
+// index so they can be comitted afterwards. Since a snapshot's content never
+// changes, snapshots which file already exists aren't retrieved again.
+// Snapshots published to an external server are skipped, since their content
+// isn't stored on the Grafana instance.
+// Returns an error if there was an issue retrieving the snapshots from Grafana,
+// or listing, writing or removing a file, or adding the changes to the index.
+func addSnapshotsToRepo(
+	ctx context.Context, client *grafana.Client, clonePath string,
+	cfg *config.Config, worktree *gogit.Worktree,
+) error {
+	snapshots, err := client.GetSnapshots(ctx)
+	if err != nil {
+		return err
+	}
+
+	dir := cfg.Snapshots.Path
+	if err = os.MkdirAll(filepath.Join(clonePath, dir), 0755); err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool)
+	for _, snapshot := range snapshots {
+		if snapshot.External {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"name": snapshot.Name,
+			}).Info("Snapshot was published externally, skipping")
+
+			continue
+		}
+
+		filename := path.Join(dir, snapshot.Key+".json")
+		existing[filename] = true
+
+		_, err = os.Stat(filepath.Join(clonePath, filename))
+		if err == nil {
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"name": snapshot.Name,
+		}).Info("Exporting new snapshot")
+
+		content, err := client.GetSnapshot(ctx, snapshot.Key)
+		if err != nil {
+			return err
+		}
+
+		if err = rewriteFile(filepath.Join(clonePath, filename), content, cfg.LineEndings); err != nil {
+			return err
+		}
+
+		// If worktree is nil, it means that it hasn't been initialised, which
+		// means the sync mode is "simple sync" and not Git.
+		if worktree != nil {
+			if _, err = worktree.Add(cfg.Git.RepositoryPath(filename)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Remove the snapshots that expired or were deleted on Grafana.
+	files, err := ioutil.ReadDir(filepath.Join(clonePath, dir))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		filename := path.Join(dir, file.Name())
+		if file.IsDir() || !strings.HasSuffix(filename, ".json") || existing[filename] {
+			continue
+		}
+
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"filename": filename,
+		}).Info("Snapshot was removed from Grafana, removing its file")
+
+		if err = removeFile(clonePath, filename, cfg, worktree); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package puller
+
+import (
+	"context"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/git"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tagSync tags the state of the repository after a successful run of the
+// puller, with a tag named after the current time as required by the given
+// settings, and pushes the tag to the remote. Errors are logged.
+func tagSync(
+	ctx context.Context, repo *git.Repository,
+	cfg *config.GitSyncTagsSettings,
+) {
+	now := time.Now()
+	name := cfg.TagName(now)
+
+	created, err := repo.TagHead(
+		name, "Synchronised with Grafana on "+now.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error": err,
+			"tag":   name,
+		}).Error("Failed to tag the state of the repository")
+
+		return
+	}
+
+	if !created {
+		logrus.WithContext(ctx).Info("The state of the repository is already tagged, not tagging it again")
+	}
+}
@@ -1,109 +1,279 @@
-package main
+package puller
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"time"
 
-	"config"
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/git"
+	"github.com/babolivier/grafana-dashboards-manager/src/textfile"
 
-	gogit "gopkg.in/src-d/go-git.v4"
-	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
 )
 
-// getDashboardsVersions reads the "versions.json" file at the root of the git
-// repository and returns its content as a map.
+// getDashboardsVersions reads the versions file ("versions.json" unless
+// configured otherwise) at the root of the git repository and returns its
+// content as a map.
 // If the file doesn't exist, returns an empty map.
 // Return an error if there was an issue looking for the file (except when the
 // file doesn't exist), reading it or formatting its content into a map.
-func getDashboardsVersions(clonePath string) (versions map[string]int, err error) {
-	versions = make(map[string]int)
+func getDashboardsVersions(
+	clonePath string, versionsFile string,
+) (versions map[string]int, err error) {
+	return ReadVersionsFile(filepath.Join(clonePath, versionsFile))
+}
 
-	filename := clonePath + "/versions.json"
+// ReadVersionsFile reads the versions file at the given path and returns its
+// content as a map of versions, mapped to the dashboards' keys (see
+// grafana.VersionKey).
+// If the file doesn't exist, returns an empty map.
+// Returns an error if there was an issue reading the file (except when it
+// doesn't exist) or parsing its content.
+func ReadVersionsFile(filename string) (map[string]int, error) {
+	versions := make(map[string]int)
 
-	_, err = os.Stat(filename)
+	data, err := textfile.Read(filename)
 	if os.IsNotExist(err) {
 		return versions, nil
 	}
-
-	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return
+		return nil, err
 	}
 
 	err = json.Unmarshal(data, &versions)
-	return
+	return versions, err
 }
 
-// writeVersions updates or creates the "versions.json" file at the root of the
-// git repository. It takes as parameter a map of versions computed by
-// getDashboardsVersions and a map linking a dashboard slug to an instance of
-// diffVersion instance, and uses them both to compute an updated map of
-// versions that it will convert to JSON, indent and write down into the
-// "versions.json" file.
+// WriteVersionsFile writes the given versions, mapped to the dashboards' keys
+// (see grafana.VersionKey), in the versions file at the given path, one per
+// line and sorted by key, so the file's diffs only include the versions which
+// changed and concurrent changes to different dashboards don't conflict. The file is left
+// untouched if its content wouldn't change. The file is written with the given
+// line endings (see the LineEndings* constants in the config package).
+// Returns whether the file was written.
+// Returns an error if there was an issue encoding the versions, or reading or
+// writing the file.
+func WriteVersionsFile(
+	filename string, versions map[string]int, lineEndings string,
+) (bool, error) {
+	// The JSON encoder sorts the keys of maps.
+	rawJSON, err := json.Marshal(versions)
+	if err != nil {
+		return false, err
+	}
+
+	indentedJSON, err := indent(rawJSON)
+	if err != nil {
+		return false, err
+	}
+
+	current, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if err == nil && bytes.Equal(current, textfile.WithLineEndings(indentedJSON, lineEndings)) {
+		return false, nil
+	}
+
+	return true, rewriteFile(filename, indentedJSON, lineEndings)
+}
+
+// MergeVersions merges two sets of versions (ours and theirs) which both
+// derive from the given base set, e.g. the versions files of two branches and
+// of their merge base, and returns the result. The version of a dashboard
+// recorded on both sides is the most recent one. A dashboard removed on one
+// side is removed from the result, unless its version changed on the other
+// side, in which case it's kept with this version.
+func MergeVersions(base, ours, theirs map[string]int) map[string]int {
+	merged := make(map[string]int)
+
+	for key, version := range ours {
+		theirVersion, inTheirs := theirs[key]
+		baseVersion, inBase := base[key]
+
+		switch {
+		case inTheirs && theirVersion > version:
+			merged[key] = theirVersion
+		case inTheirs, !inBase, version != baseVersion:
+			merged[key] = version
+		}
+	}
+
+	for key, version := range theirs {
+		if _, inOurs := ours[key]; inOurs {
+			continue
+		}
+
+		if baseVersion, inBase := base[key]; !inBase || version != baseVersion {
+			merged[key] = version
+		}
+	}
+
+	return merged
+}
+
+// writeVersions updates or creates the versions file at the root of the git
+// repository. It takes as parameter a map of versions computed by
+// getDashboardsVersions and a map linking a dashboard's key (see
+// grafana.VersionKey) to an instance of diffVersion instance, and uses them
+// both to compute an updated map of versions that it writes down into the
+// versions file, with the given line endings (see WriteVersionsFile). The
+// versions older releases recorded under the slugs of the updated dashboards
+// are removed.
 // Returns an error if there was an issue when conerting to JSON, indenting or
 // writing on disk.
 func writeVersions(
 	versions map[string]int, dv map[string]diffVersion, clonePath string,
+	versionsFile string, lineEndings string,
 ) (err error) {
-	for slug, diff := range dv {
-		versions[slug] = diff.newVersion
+	for key, diff := range dv {
+		versions[key] = diff.newVersion
+		if len(diff.slug) > 0 && diff.slug != key {
+			delete(versions, diff.slug)
+		}
 	}
 
-	rawJSON, err := json.Marshal(versions)
+	_, err = WriteVersionsFile(filepath.Join(clonePath, versionsFile), versions, lineEndings)
+	return
+}
+
+// CommitPushedVersions records the given versions of dashboards, mapped to the
+// dashboards' keys (see grafana.VersionKey), in the versions file, then commits
+// and pushes it. It is
+// meant to be called after dashboards have been pushed to Grafana, with the
+// versions Grafana returned, so the puller doesn't consider these versions as
+// changes made on Grafana, without retrieving the dashboards from Grafana.
+// Versions which aren't newer than the ones already in the versions file are
+// ignored. The caller must hold the clone's lock (see git.LockClone).
+// Returns an error if there was an issue synchronising the repository, reading
+// or writing the versions file, or committing and pushing it.
+func CommitPushedVersions(
+	ctx context.Context, cfg *config.Config, versions map[string]int,
+) error {
+	if len(versions) == 0 {
+		return nil
+	}
+
+	repo, _, err := git.NewRepository(cfg.Git)
 	if err != nil {
-		return
+		return err
 	}
 
-	indentedJSON, err := indent(rawJSON)
+	if err = repo.Sync(false); err != nil {
+		return err
+	}
+
+	w, err := repo.Repo.Worktree()
 	if err != nil {
-		return
+		return err
 	}
 
-	filename := clonePath + "/versions.json"
-	return rewriteFile(filename, indentedJSON)
+	dbVersions, err := getDashboardsVersions(cfg.Git.SyncPath(), cfg.Metadata.VersionsFile)
+	if err != nil {
+		return err
+	}
+
+	dv := make(map[string]diffVersion)
+	for key, version := range versions {
+		if known, ok := dbVersions[key]; !ok || version > known {
+			dv[key] = diffVersion{
+				oldVersion: known,
+				newVersion: version,
+			}
+		}
+	}
+
+	if len(dv) > 0 {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"dashboards": len(dv),
+		}).Info("Comitting the versions of the pushed dashboards")
+
+		if err = commitNewVersions(
+			dbVersions, dv, w, cfg,
+			getCommitMessage("Recorded versions of pushed dashboards", dv),
+		); err != nil {
+			return err
+		}
+	}
+
+	return repo.Push()
 }
 
 // commitNewVersions creates a git commit from updated dashboard files (that
-// have previously been added to the git index) and an updated "versions.json"
-// file that it creates (with writeVersions) and add to the index.
-// Returns an error if there was an issue when creating the "versions.json"
-// file, adding it to the index or creating the commit.
+// have previously been added to the git index) and an updated versions file
+// that it creates (with writeVersions) and add to the index, with the given
+// message. The commit is attributed to the Grafana users who changed the
+// dashboards if the git settings require it (see attributeCommit).
+// Returns an error if there was an issue when creating the versions file,
+// adding it to the index or creating the commit.
 func commitNewVersions(
 	versions map[string]int, dv map[string]diffVersion, worktree *gogit.Worktree,
-	cfg *config.Config,
+	cfg *config.Config, message string,
 ) (err error) {
-	if err = writeVersions(versions, dv, cfg.Git.ClonePath); err != nil {
+	versionsFile := cfg.Metadata.VersionsFile
+	if err = writeVersions(
+		versions, dv, cfg.Git.SyncPath(), versionsFile, cfg.LineEndings,
+	); err != nil {
 		return err
 	}
 
-	if _, err = worktree.Add("versions.json"); err != nil {
+	if _, err = worktree.Add(cfg.Git.RepositoryPath(versionsFile)); err != nil {
 		return err
 	}
 
-	_, err = worktree.Commit(getCommitMessage(dv), &gogit.CommitOptions{
-		Author: &object.Signature{
-			Name:  cfg.Git.CommitsAuthor.Name,
-			Email: cfg.Git.CommitsAuthor.Email,
-			When:  time.Now(),
-		},
+	manager := &object.Signature{
+		Name:  cfg.Git.CommitsAuthor.Name,
+		Email: cfg.Git.CommitsAuthor.Email,
+		When:  time.Now(),
+	}
+
+	author := manager
+	if cfg.Git.Attribution != nil {
+		author, message = attributeCommit(dv, message, manager, cfg.Git.Attribution)
+	}
+
+	_, err = worktree.Commit(message, &gogit.CommitOptions{
+		Author:    author,
+		Committer: manager,
 	})
 
 	return
 }
 
-// getCommitMessage creates a commit message that summarises the version updates
-// included in the commit.
-func getCommitMessage(dv map[string]diffVersion) string {
-	message := "Updated dashboards\n"
+// getCommitMessage creates a commit message with the given title that
+// summarises the version updates included in the commit, sorted by slug, and
+// ends with the sync trailer so the pusher doesn't push the commit back to
+// Grafana. Updates which dashboard's slug isn't known (i.e. the versions of
+// pushed dashboards) are listed with the dashboard's key (see
+// grafana.VersionKey) instead.
+func getCommitMessage(title string, dv map[string]diffVersion) string {
+	message := title + "\n"
+
+	lines := make([]string, 0, len(dv))
+	for key, diff := range dv {
+		name := diff.slug
+		if len(name) == 0 {
+			name = key
+		}
+
+		lines = append(lines, fmt.Sprintf(
+			"%s: %d => %d\n", name, diff.oldVersion, diff.newVersion,
+		))
+	}
+	sort.Strings(lines)
 
-	for slug, diff := range dv {
-		message += fmt.Sprintf(
-			"%s: %d => %d\n", slug, diff.oldVersion, diff.newVersion,
-		)
+	for _, line := range lines {
+		message += line
 	}
 
-	return message
+	return message + "\n" + git.SyncTrailer + "\n"
 }
@@ -0,0 +1,82 @@
+package puller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeVersions(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     map[string]int
+		ours     map[string]int
+		theirs   map[string]int
+		expected map[string]int
+	}{
+		{
+			name:     "unchanged",
+			base:     map[string]int{"a": 1},
+			ours:     map[string]int{"a": 1},
+			theirs:   map[string]int{"a": 1},
+			expected: map[string]int{"a": 1},
+		},
+		{
+			name:     "updated on our side",
+			base:     map[string]int{"a": 1},
+			ours:     map[string]int{"a": 2},
+			theirs:   map[string]int{"a": 1},
+			expected: map[string]int{"a": 2},
+		},
+		{
+			name:     "updated on both sides",
+			base:     map[string]int{"a": 1, "b": 1},
+			ours:     map[string]int{"a": 2, "b": 4},
+			theirs:   map[string]int{"a": 3, "b": 2},
+			expected: map[string]int{"a": 3, "b": 4},
+		},
+		{
+			name:     "added on both sides",
+			base:     map[string]int{},
+			ours:     map[string]int{"a": 1},
+			theirs:   map[string]int{"b": 2},
+			expected: map[string]int{"a": 1, "b": 2},
+		},
+		{
+			name:     "removed on one side",
+			base:     map[string]int{"a": 1, "b": 1},
+			ours:     map[string]int{"b": 1},
+			theirs:   map[string]int{"a": 1},
+			expected: map[string]int{},
+		},
+		{
+			name:     "removed on one side and updated on the other",
+			base:     map[string]int{"a": 1, "b": 1},
+			ours:     map[string]int{"b": 3},
+			theirs:   map[string]int{"a": 2},
+			expected: map[string]int{"a": 2, "b": 3},
+		},
+		{
+			name:     "removed on both sides",
+			base:     map[string]int{"a": 1},
+			ours:     map[string]int{},
+			theirs:   map[string]int{},
+			expected: map[string]int{},
+		},
+		{
+			name:     "no base",
+			ours:     map[string]int{"a": 1},
+			theirs:   map[string]int{"a": 2, "b": 1},
+			expected: map[string]int{"a": 2, "b": 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged := MergeVersions(test.base, test.ours, test.theirs)
+
+			if !reflect.DeepEqual(merged, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, merged)
+			}
+		})
+	}
+}
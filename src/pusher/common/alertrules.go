@@ -0,0 +1,180 @@
+package common
+
+import (
+	"context"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+
+	"github.com/sirupsen/logrus"
+)
+
+// alertRulesChecker keeps the unified alert rules attached to pushed dashboards
+// pointing to the right panels. The alert rules are only retrieved once, when
+// they're first needed.
+type alertRulesChecker struct {
+	client *grafana.Client
+	rules  []grafana.AlertRule
+	loaded bool
+}
+
+// attachedRules describes the alert rules attached to a dashboard, along with
+// the dashboard's panels on Grafana before it was pushed (nil if it didn't
+// exist).
+type attachedRules struct {
+	uid      string
+	rules    []grafana.AlertRule
+	previous []grafana.Panel
+}
+
+// before returns the alert rules attached to the dashboard described by the
+// given content, and the panels of the dashboard on Grafana, which must be
+// retrieved before the dashboard is pushed. Returns nil if no alert rule is
+// attached to the dashboard, or if the dashboard has no UID (since alert rules
+// can only reference dashboards by UID).
+// Returns an error if there was an issue requesting the Grafana API or parsing
+// the dashboards.
+func (c *alertRulesChecker) before(
+	ctx context.Context, content []byte,
+) (*attachedRules, error) {
+	uid, err := helpers.GetDashboardUID(content)
+	if err != nil || len(uid) == 0 {
+		return nil, err
+	}
+
+	if !c.loaded {
+		rules, err := c.client.GetAlertRules(ctx)
+		if err != nil && !grafana.IsNotFound(err) {
+			return nil, err
+		}
+
+		c.rules = rules
+		c.loaded = true
+	}
+
+	attached := &attachedRules{uid: uid}
+	for _, rule := range c.rules {
+		if rule.DashboardUID() == uid {
+			attached.rules = append(attached.rules, rule)
+		}
+	}
+
+	if len(attached.rules) == 0 {
+		return nil, nil
+	}
+
+	live, err := c.client.GetDashboardByUID(ctx, uid)
+	if grafana.IsNotFound(err) {
+		return attached, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if attached.previous, err = grafana.DashboardPanels(live.RawJSON); err != nil {
+		return nil, err
+	}
+
+	return attached, nil
+}
+
+// after checks that the dashboard the given alert rules are attached to exists
+// on Grafana once pushed, and that each rule still points to the panel it
+// pointed to before the push, i.e. a panel with the same ID, title and type.
+// If the panel got a new ID, and a single panel of the pushed dashboard has its
+// title and type, the rule is updated to point to it.
+// Logs the rules which were updated, and the ones which couldn't be.
+func (c *alertRulesChecker) after(
+	ctx context.Context, filename string, attached *attachedRules,
+) {
+	pushed, err := c.client.GetDashboardByUID(ctx, attached.uid)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error":    err,
+			"filename": filename,
+			"uid":      attached.uid,
+			"rules":    len(attached.rules),
+		}).Error("Failed to retrieve the pushed dashboard, its alert rules might be detached from it")
+
+		return
+	}
+
+	panels, err := grafana.DashboardPanels(pushed.RawJSON)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error":    err,
+			"filename": filename,
+		}).Error("Failed to parse the pushed dashboard's panels")
+
+		return
+	}
+
+	for _, rule := range attached.rules {
+		panelID, ok := rule.PanelID()
+		if !ok {
+			continue
+		}
+
+		// Without the dashboard's previous version, the panel the rule was
+		// meant to point to can't be identified, so only its existence is
+		// checked.
+		previous, known := findPanel(attached.previous, panelID)
+		current, exists := findPanel(panels, panelID)
+		if exists && (!known || samePanel(previous, current)) {
+			continue
+		}
+
+		var matches []grafana.Panel
+		if known {
+			for _, panel := range panels {
+				if samePanel(previous, panel) {
+					matches = append(matches, panel)
+				}
+			}
+		}
+
+		if len(matches) != 1 {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"filename": filename,
+				"rule":     rule.Title,
+				"panel_id": panelID,
+			}).Warn("Alert rule's panel isn't on the pushed dashboard anymore")
+
+			continue
+		}
+
+		if err := c.client.AttachAlertRuleToPanel(ctx, rule, matches[0].ID); err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+				"rule":     rule.Title,
+			}).Error("Failed to update the alert rule's panel")
+
+			continue
+		}
+
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"filename":     filename,
+			"rule":         rule.Title,
+			"old_panel_id": panelID,
+			"new_panel_id": matches[0].ID,
+		}).Info("Updated the alert rule to point to the panel's new ID")
+	}
+}
+
+// findPanel returns the panel with the given ID among the given panels, and
+// false if there's none.
+func findPanel(panels []grafana.Panel, id int) (grafana.Panel, bool) {
+	for _, panel := range panels {
+		if panel.ID == id {
+			return panel, true
+		}
+	}
+
+	return grafana.Panel{}, false
+}
+
+// samePanel checks whether the two given panels have the same title and type,
+// and can therefore be considered to be the same panel, whatever their IDs.
+func samePanel(a grafana.Panel, b grafana.Panel) bool {
+	return a.Title == b.Title && a.Type == b.Type
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+)
+
+// checkAlertRemoval retrieves the current version of the dashboard described by
+// the given content from Grafana, and checks whether replacing it with the
+// content, or deleting it if deleting is true, would drop legacy alerts from
+// it. Nothing is checked if the pusher's settings allow removing alerts, or if
+// the dashboard doesn't exist on Grafana.
+// Returns an error listing the alerts which would be dropped, if any, or an
+// error of type *alertCheckError if there was an issue retrieving or parsing
+// the dashboard.
+func checkAlertRemoval(
+	ctx context.Context, content []byte, deleting bool, client *grafana.Client,
+	cfg *config.Config,
+) error {
+	if cfg.Pusher == nil || cfg.Pusher.AllowAlertRemoval {
+		return nil
+	}
+
+	ref, err := grafana.RefFromJSON(content)
+	if err != nil {
+		return &alertCheckError{err}
+	}
+
+	live, err := client.GetDashboardByRef(ctx, ref)
+	if err != nil {
+		if grafana.IsNotFound(err) {
+			return nil
+		}
+
+		return &alertCheckError{err}
+	}
+
+	after := content
+	if deleting {
+		after = nil
+	}
+
+	dropped, err := grafana.DroppedAlerts(live.RawJSON, after)
+	if err != nil {
+		return &alertCheckError{err}
+	}
+
+	if len(dropped) > 0 {
+		return fmt.Errorf("would remove legacy alerts: %s", strings.Join(quote(dropped), ", "))
+	}
+
+	return nil
+}
+
+// alertCheckError is returned when the alerts a dashboard would lose couldn't
+// be checked, as opposed to when the dashboard would lose alerts.
+type alertCheckError struct {
+	err error
+}
+
+// Error implements error.Error().
+func (e *alertCheckError) Error() string {
+	return "failed to check the dashboard's legacy alerts: " + e.err.Error()
+}
+
+// quote returns the given strings, quoted.
+func quote(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf("%q", value)
+	}
+
+	return quoted
+}
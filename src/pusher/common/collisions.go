@@ -0,0 +1,78 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+)
+
+// titleCollisionError is returned when the title of a dashboard is already
+// used by another dashboard in the folder it's pushed to, and the pusher's
+// settings require refusing to push it.
+type titleCollisionError struct {
+	title string
+	uid   string
+}
+
+// Error implements error.Error().
+func (e *titleCollisionError) Error() string {
+	return fmt.Sprintf(
+		"the title %q is already used by the dashboard with the UID %s in the folder",
+		e.title, e.uid,
+	)
+}
+
+// resolveTitleCollision checks whether the title of the dashboard described by
+// the given content is already used by another dashboard (i.e. with another
+// UID) in the folder with the given ID, before pushing it, and resolves the
+// collision as required by the pusher's settings (see the
+// config.TitleCollisions* constants): the given content is returned with
+// either the other dashboard's UID, or its own UID as a suffix of its title,
+// along with a boolean set to true. Dashboards without a UID aren't checked,
+// since Grafana identifies them by their title. Returns the given content as is
+// if there's no collision.
+// Returns a titleCollisionError if there's a collision and the pusher's
+// settings require refusing to push the dashboard, or an error if there was an
+// issue parsing the content, searching the dashboards on Grafana, or
+// re-encoding the content.
+func resolveTitleCollision(
+	ctx context.Context, content []byte, folderID int, client *grafana.Client,
+	cfg *config.Config,
+) ([]byte, bool, error) {
+	var dashboard map[string]interface{}
+	if err := json.Unmarshal(content, &dashboard); err != nil {
+		return nil, false, err
+	}
+
+	uid, _ := dashboard["uid"].(string)
+	title, _ := dashboard["title"].(string)
+	if len(uid) == 0 || len(title) == 0 {
+		return content, false, nil
+	}
+
+	existing, err := client.FindDashboardByTitle(ctx, title, folderID)
+	if err != nil || existing == nil || existing.UID == uid {
+		return content, false, err
+	}
+
+	policy := config.TitleCollisionsFail
+	if cfg.Pusher != nil {
+		policy = cfg.Pusher.TitleCollisions
+	}
+
+	switch policy {
+	case config.TitleCollisionsReuse:
+		dashboard["uid"] = existing.UID
+		delete(dashboard, "id")
+	case config.TitleCollisionsRename:
+		dashboard["title"] = title + " (" + uid + ")"
+	default:
+		return nil, true, &titleCollisionError{title: title, uid: existing.UID}
+	}
+
+	resolved, err := json.Marshal(dashboard)
+	return resolved, true, err
+}
@@ -1,26 +1,70 @@
 package common
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
 	"strings"
 
-	"config"
-	"grafana"
-	"grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/budget"
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/jsonpath"
+	"github.com/babolivier/grafana-dashboards-manager/src/tags"
 
 	"github.com/sirupsen/logrus"
 )
 
 // FilterIgnored takes a map mapping files' names to their contents and remove
 // all the files that are supposed to be ignored by the dashboard manager.
-// An ignored file is either named "versions.json" or describing a dashboard
+// An ignored file is either not a JSON file, a metadata file (such as
+// "versions.json") other than the folders' metadata files, a snapshot's,
+// annotations', history or legacy alerts' file, a file describing a
+// dashboard's permissions while these aren't synced, or describing a dashboard
 // which slug starts with a given prefix.
 // Returns an error if the slug couldn't be tested against the prefix.
 func FilterIgnored(
 	filesToPush *map[string][]byte, cfg *config.Config,
 ) (err error) {
 	for filename, content := range *filesToPush {
-		// Don't set versions.json to be pushed
-		if strings.HasSuffix(filename, "versions.json") {
+		// Only JSON files can describe dashboards, other files (e.g.
+		// screenshots) must not be pushed.
+		if !strings.HasSuffix(filename, ".json") {
+			delete(*filesToPush, filename)
+			continue
+		}
+
+		// Folders' metadata files are pushed so that folders renamed or moved
+		// in the repository are renamed or moved on Grafana, unless the
+		// feature is turned off.
+		if IsFolderFile(filename, cfg) {
+			if !cfg.Feature(config.FeatureFolderSync) {
+				delete(*filesToPush, filename)
+			}
+
+			continue
+		}
+
+		// Files describing permissions don't describe dashboards, so there's
+		// nothing else to check.
+		if HoldsPermissions(filename, cfg) {
+			if !cfg.SyncPermissions {
+				delete(*filesToPush, filename)
+			}
+
+			continue
+		}
+
+		// Don't set metadata files (e.g. versions.json) nor snapshots,
+		// annotations, history and legacy alerts' files to be pushed, since
+		// they're only exported. Library panels and playlists are only
+		// applied by restores.
+		if cfg.Metadata.IsMetadataFile(filename) || cfg.IsSnapshotFile(filename) ||
+			cfg.IsAnnotationsFile(filename) || cfg.IsHistoryFile(filename) ||
+			cfg.IsLegacyAlertsFile(filename) || cfg.IsLibraryPanelFile(filename) ||
+			cfg.IsPlaylistFile(filename) {
 			delete(*filesToPush, filename)
 			continue
 		}
@@ -39,49 +83,523 @@ func FilterIgnored(
 	return
 }
 
+// PushReport summarises the outcome of pushing files to Grafana.
+type PushReport struct {
+	// Names of the files that were successfully pushed.
+	Pushed []string
+	// Versions of the pushed dashboards, as returned by Grafana, mapped to
+	// their keys in the versions file (see grafana.VersionKey).
+	Versions map[string]int
+	// Errors encountered when pushing files, mapped to the files' names.
+	Failed map[string]error
+	// Reasons why files were rejected without being pushed (e.g. because
+	// they're incompatible with Grafana or exceed the budgets), mapped to the
+	// files' names.
+	Rejected map[string]error
+	// Errors encountered when verifying pushed dashboards, mapped to the files'
+	// names.
+	Unhealthy map[string]error
+	// Paths of the fields Grafana rewrote, dropped or added in pushed
+	// dashboards, mapped to the files' names.
+	Drifted map[string][]string
+	// Names of the files that weren't pushed because the push was aborted
+	// after an error, as required by the "fail-fast" error policy, or because
+	// Grafana rejected the credentials.
+	Skipped []string
+}
+
+// HasErrors checks whether at least one file failed to be pushed, was rejected
+// or failed verification.
+func (r *PushReport) HasErrors() bool {
+	return len(r.Failed) > 0 || len(r.Rejected) > 0 || len(r.Unhealthy) > 0
+}
+
 // PushFiles takes a slice of files' names and a map mapping a file's name to its
 // content, and iterates over the first slice. For each file name, it will push
 // to Grafana the content from the map that matches the name, as a creation or
-// an update of an existing dashboard.
+// an update of an existing dashboard, in the folder with the given ID (0 being
+// the "General" folder). If the configuration requests it, each
+// pushed dashboard is then verified by retrieving it (and rendering it if
+// needed) from Grafana, and compared with the pushed content. Dashboards which
+// push would remove legacy alerts from Grafana are rejected, unless the
+// pusher's settings allow it. If the given map of the dashboards' versions
+// recorded in the repository isn't nil (see RecordedVersions), dashboards
+// modified on Grafana since they were last pulled are either overwritten with
+// a warning, or rejected, depending on the pusher's settings. If the pusher's
+// settings require it, the unified alert rules attached to each pushed
+// dashboard are updated if the IDs of the panels they point to changed. Errors
+// about a specific part of a dashboard's file point to it (see
+// jsonpath.Annotate).
 // Logs any errors encountered during an iteration, but doesn't return until all
-// creation and/or update requests have been performed.
-func PushFiles(filenames []string, contents map[string][]byte, client *grafana.Client) {
+// creation and/or update requests have been performed, unless the error policy
+// is "fail-fast", in which case the files following the first one that failed
+// to be pushed or verified, or was rejected, are skipped. The files following
+// one that failed because Grafana rejected the credentials are skipped whatever
+// the error policy. Then logs a summary of the push, and returns it.
+func PushFiles(
+	ctx context.Context, filenames []string, contents map[string][]byte,
+	folderID int, client *grafana.Client, versions map[string]int,
+	cfg *config.Config,
+) *PushReport {
+	report := &PushReport{
+		Pushed:    make([]string, 0),
+		Versions:  make(map[string]int),
+		Failed:    make(map[string]error),
+		Rejected:  make(map[string]error),
+		Unhealthy: make(map[string]error),
+		Drifted:   make(map[string][]string),
+	}
+
+	var rulesChecker *alertRulesChecker
+	if cfg.Pusher != nil && cfg.Pusher.CheckAlertRules {
+		rulesChecker = &alertRulesChecker{client: client}
+	}
+
 	// Push all files to the Grafana API
-	for _, filename := range filenames {
-		if err := client.CreateOrUpdateDashboard(contents[filename]); err != nil {
-			logrus.WithFields(logrus.Fields{
+	for i, filename := range filenames {
+		if report.HasErrors() && cfg.FailFast() {
+			report.Skipped = filenames[i:]
+			break
+		}
+
+		// If Grafana rejected the credentials, the following requests would
+		// fail the same way.
+		if i > 0 && grafana.IsUnauthorized(report.Failed[filenames[i-1]]) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"skipped": len(filenames) - i,
+			}).Error("Grafana rejected the credentials, not pushing the remaining files")
+
+			report.Skipped = filenames[i:]
+			break
+		}
+
+		content := contents[filename]
+
+		// Enforce the managed dashboards' tags, if any, so the dashboard can
+		// be identified as managed in Grafana's UI.
+		if cfg.Tags != nil {
+			enforced, _, err := tags.Enforce(content, cfg.Tags)
+			if err != nil {
+				err = jsonpath.Annotate(content, err)
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":    err,
+					"filename": filename,
+				}).Error("Failed to enforce the dashboard's tags, not pushing it")
+
+				report.Failed[filename] = err
+				continue
+			}
+
+			content = enforced
+		}
+
+		// Check that the dashboard respects the budgets, if any.
+		if cfg.Budgets != nil {
+			violations, err := budget.Check(content, cfg.Budgets)
+			if err == nil && len(violations) > 0 {
+				err = fmt.Errorf("exceeded budgets: %s", strings.Join(violations, ", "))
+			} else if err != nil {
+				err = jsonpath.Annotate(content, err)
+			}
+
+			if err != nil {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":    err,
+					"filename": filename,
+				}).Error("Dashboard exceeds the budgets, not pushing it")
+
+				report.Rejected[filename] = err
+				continue
+			}
+		}
+
+		// Check that the instance can load the dashboard, and only push it
+		// anyway if the configuration allows it.
+		if err := client.CheckCompatibility(ctx, content); err != nil {
+			if cfg.Pusher != nil && cfg.Pusher.BlockIncompatible {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":    err,
+					"filename": filename,
+				}).Error("Dashboard isn't compatible with Grafana, not pushing it")
+
+				report.Rejected[filename] = err
+				continue
+			}
+
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Warn("Dashboard might not be compatible with Grafana")
+		}
+
+		// Check that the dashboard's title isn't used by another dashboard in
+		// the folder, which Grafana handles differently depending on its
+		// version, and resolve the collision as the configuration requires.
+		resolved, collided, err := resolveTitleCollision(ctx, content, folderID, client, cfg)
+		if _, ok := err.(*titleCollisionError); ok {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("Dashboard's title is already used in the folder, not pushing it")
+
+			report.Rejected[filename] = err
+			continue
+		} else if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("Failed to check whether the dashboard's title is already used in the folder, not pushing it")
+
+			report.Failed[filename] = err
+			continue
+		} else if collided {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"filename": filename,
+				"policy":   cfg.Pusher.TitleCollisions,
+			}).Warn("Dashboard's title is already used in the folder, resolving the collision")
+
+			content = resolved
+		}
+
+		// Check that pushing the dashboard doesn't drop any of its legacy
+		// alerts, unless the configuration allows it.
+		if err := checkAlertRemoval(ctx, content, false, client, cfg); err != nil {
+			if _, ok := err.(*alertCheckError); ok {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":    err,
+					"filename": filename,
+				}).Error("Failed to check the dashboard's legacy alerts, not pushing it")
+
+				report.Failed[filename] = err
+				continue
+			}
+
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("Pushing the dashboard would remove legacy alerts, not pushing it")
+
+			report.Rejected[filename] = err
+			continue
+		}
+
+		// Check that the dashboard wasn't modified on Grafana since it was
+		// last pulled, and only overwrite it anyway if the configuration
+		// allows it.
+		var fromVersion int
+		if versions != nil {
+			live, err := checkConflict(ctx, content, versions, client)
+			if _, ok := err.(*conflictError); ok && cfg.Pusher.Conflicts == config.ConflictsBlock {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":    err,
+					"filename": filename,
+				}).Error("Dashboard was modified on Grafana since it was last pulled, not pushing it")
+
+				report.Rejected[filename] = err
+				continue
+			} else if ok {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":    err,
+					"filename": filename,
+				}).Warn("Dashboard was modified on Grafana since it was last pulled, overwriting it")
+			} else if err != nil {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":    err,
+					"filename": filename,
+				}).Error("Failed to check whether the dashboard was modified on Grafana, not pushing it")
+
+				report.Failed[filename] = err
+				continue
+			}
+
+			// Make Grafana refuse the update if the dashboard is modified
+			// between the check and the push.
+			if cfg.Pusher.Conflicts == config.ConflictsBlock {
+				fromVersion = live
+			}
+		}
+
+		// Retrieve the alert rules attached to the dashboard, and the panels
+		// they point to, before the push changes them.
+		var attached *attachedRules
+		if rulesChecker != nil {
+			var err error
+			if attached, err = rulesChecker.before(ctx, content); err != nil {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":    err,
+					"filename": filename,
+				}).Warn("Failed to retrieve the alert rules attached to the dashboard, not checking them")
+			}
+		}
+
+		var version *grafana.DashboardVersion
+		if fromVersion > 0 {
+			version, err = client.UpdateDashboardFromVersion(ctx, content, folderID, fromVersion)
+		} else {
+			version, err = client.CreateOrUpdateDashboardInFolder(ctx, content, folderID)
+		}
+		if err == grafana.ErrVersionMismatch {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("Dashboard was modified on Grafana while pushing it, not pushing it")
+
+			report.Rejected[filename] = err
+			continue
+		}
+		if err != nil {
+			// Point to the part of the file the error is about, if any, so it
+			// can be fixed without searching the whole file.
+			err = jsonpath.Annotate(contents[filename], err)
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
 				"error":    err,
 				"filename": filename,
 			}).Error("Failed to push the file to Grafana")
+
+			report.Failed[filename] = err
+			continue
+		}
+
+		report.Pushed = append(report.Pushed, filename)
+		report.Versions[grafana.VersionKey(version.UID, version.Slug)] = version.Version
+
+		if attached != nil {
+			rulesChecker.after(ctx, filename, attached)
+		}
+
+		if cfg.Pusher != nil && cfg.Pusher.Verify != nil {
+			if err := verifyDashboard(ctx, content, client, cfg.Pusher.Verify); err != nil {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":    err,
+					"filename": filename,
+				}).Error("Dashboard pushed to Grafana failed verification")
+
+				report.Unhealthy[filename] = err
+				continue
+			}
+
+			if cfg.Pusher.Verify.RoundTrip {
+				discrepancies, err := CheckRoundTrip(ctx, content, client)
+				if err != nil {
+					logrus.WithContext(ctx).WithFields(logrus.Fields{
+						"error":    err,
+						"filename": filename,
+					}).Error("Failed to compare the pushed dashboard with Grafana's")
+				} else if len(discrepancies) > 0 {
+					logrus.WithContext(ctx).WithFields(logrus.Fields{
+						"filename": filename,
+						"fields":   strings.Join(discrepancies, ","),
+					}).Warn("Grafana changed the pushed dashboard, it will differ when pulled")
+
+					report.Drifted[filename] = discrepancies
+				}
+			}
 		}
 	}
+
+	if len(filenames) > 0 {
+		report.log(ctx)
+	}
+
+	return report
 }
 
 // DeleteDashboards takes a slice of files' names and a map mapping a file's name
 // to its content, and iterates over the first slice. For each file name, extract
-// a dashboard's slug from the content, in the map, that matches the name, and
-// will use it to send a deletion request to the Grafana API.
+// a reference to the dashboard (its UID, or its slug if it hasn't any) from the
+// content, in the map, that matches the name, and will use it to send a
+// deletion request to the Grafana API. Dashboards which UID is in the given
+// set of kept UIDs aren't deleted, since their file was only renamed or moved
+// (e.g. because the dashboard was renamed), nor are dashboards with legacy
+// alerts, unless the pusher's settings allow it. Before deleting a dashboard,
+// warns about the alert rules, playlists, library panels and dashboards from
+// the repository still referencing it, or doesn't delete it if the pusher's
+// settings require blocking these deletions.
 // Logs any errors encountered during an iteration, but doesn't return until all
 // deletion requests have been performed.
-func DeleteDashboards(filenames []string, contents map[string][]byte, client *grafana.Client) {
+// Returns the errors encountered, mapped to the files' names.
+func DeleteDashboards(
+	ctx context.Context, filenames []string, contents map[string][]byte,
+	kept map[string]bool, client *grafana.Client, cfg *config.Config,
+) map[string]error {
+	failed := make(map[string]error)
+	checker := &referenceChecker{client: client, cfg: cfg}
+
 	for _, filename := range filenames {
-		// Retrieve dashboard slug because we need it in the deletion request.
-		slug, err := helpers.GetDashboardSlug(contents[filename])
+		// Retrieve the dashboard's reference because we need it in the
+		// deletion request.
+		ref, err := grafana.RefFromJSON(contents[filename])
 		if err != nil {
-			logrus.WithFields(logrus.Fields{
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
 				"error":    err,
 				"filename": filename,
-			}).Error("Failed to compute the dahsboard's slug")
+			}).Error("Failed to compute the dahsboard's reference")
+
+			failed[filename] = err
+			continue
 		}
 
-		if err := client.DeleteDashboard(slug); err != nil {
-			logrus.WithFields(logrus.Fields{
+		if len(ref.UID) > 0 && kept[ref.UID] {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"filename": filename,
+				"uid":      ref.UID,
+			}).Info("Dashboard was renamed or moved, not removing it")
+
+			continue
+		}
+
+		if err := checkAlertRemoval(ctx, contents[filename], true, client, cfg); err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
 				"error":    err,
 				"filename": filename,
-				"slug":     slug,
+			}).Error("Removing the dashboard would remove legacy alerts, not removing it")
+
+			failed[filename] = err
+			continue
+		}
+
+		// Dashboards can only be referenced by their UIDs.
+		if len(ref.UID) > 0 {
+			refs, err := checker.references(ctx, ref.UID)
+			if err != nil {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":    err,
+					"filename": filename,
+					"uid":      ref.UID,
+				}).Warn("Failed to check whether the dashboard is still referenced")
+			}
+
+			if len(refs) > 0 {
+				if cfg.Pusher.BlockReferenced {
+					logrus.WithContext(ctx).WithFields(logrus.Fields{
+						"filename":   filename,
+						"uid":        ref.UID,
+						"references": refs,
+					}).Error("Dashboard is still referenced, not removing it")
+
+					failed[filename] = fmt.Errorf(
+						"dashboard still referenced by %d object(s)", len(refs),
+					)
+					continue
+				}
+
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"filename":   filename,
+					"uid":        ref.UID,
+					"references": refs,
+				}).Warn("Removing a dashboard which is still referenced")
+			}
+		}
+
+		if err := client.DeleteDashboardByRef(ctx, ref); err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":     err,
+				"filename":  filename,
+				"dashboard": ref,
 			}).Error("Failed to remove the dashboard from Grafana")
+
+			failed[filename] = err
+		}
+	}
+
+	return failed
+}
+
+// TargetFolder returns the title of the Grafana folder the dashboard described
+// in the file with the given name must be pushed to. This is the folder mapped
+// to the deepest directory containing the file in the pusher's settings, the
+// folder derived from the file's directory by the pusher's folder_from_path
+// template (see config.PathFolder), or, with the "folders" layout, the folder
+// named after the file's directory. If there's none, this is the given default
+// folder.
+func TargetFolder(filename string, defaultFolder string, cfg *config.Config) string {
+	folder := defaultFolder
+	matched := -1
+
+	for dir, target := range cfg.Pusher.Dirs {
+		dir = strings.Trim(dir, "/")
+		if strings.HasPrefix(filename, dir+"/") && len(dir) > matched {
+			folder = target.Folder
+			matched = len(dir)
+		}
+	}
+
+	if matched >= 0 {
+		return folder
+	}
+
+	if title, ok := cfg.PathFolder(filename); ok {
+		return title
+	}
+
+	if cfg.Layout == config.LayoutFolders {
+		if dir := path.Dir(filename); dir != "." {
+			folder = dir
+		}
+	}
+
+	return folder
+}
+
+// CompatFolder returns the title of the Grafana folder the dashboard described
+// by the given content, in the file with the given name, must be pushed to
+// while the repository is being migrated from the "flat" layout to the
+// "folders" layout: if the flat layout compatibility is turned on and the file
+// is at the root of the repository (and its directory isn't mapped to a
+// folder), this is the folder the dashboard currently is in on Grafana, so it
+// isn't moved to the default folder. Otherwise, or if the dashboard doesn't
+// exist on Grafana, this is the given folder.
+// Returns an error if there was an issue retrieving the dashboard from Grafana.
+func CompatFolder(
+	ctx context.Context, filename string, content []byte, folder string,
+	client *grafana.Client, cfg *config.Config,
+) (string, error) {
+	if cfg.Layout != config.LayoutFolders || !cfg.Feature(config.FeatureFlatLayoutCompat) ||
+		path.Dir(filename) != "." || TargetFolder(filename, "", cfg) != "" {
+		return folder, nil
+	}
+
+	ref, err := grafana.RefFromJSON(content)
+	if err != nil {
+		return "", err
+	}
+
+	dashboard, err := client.GetDashboardByRef(ctx, ref)
+	if grafana.IsNotFound(err) {
+		return folder, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return dashboard.FolderTitle, nil
+}
+
+// StripIdentifiers removes the "id" and "uid" fields from the JSON descriptions
+// of dashboards in the given map, so that pushing them creates new dashboards
+// (or updates the ones with the same title in the target folder) instead of
+// updating the dashboards they were pulled from.
+// Returns an error if one of the JSON descriptions couldn't be parsed or
+// re-encoded.
+func StripIdentifiers(contents map[string][]byte) error {
+	for filename, content := range contents {
+		var dashboard map[string]interface{}
+		if err := json.Unmarshal(content, &dashboard); err != nil {
+			return err
 		}
+
+		delete(dashboard, "id")
+		delete(dashboard, "uid")
+
+		stripped, err := json.Marshal(dashboard)
+		if err != nil {
+			return err
+		}
+
+		contents[filename] = stripped
 	}
+
+	return nil
 }
 
 // isIgnored checks whether the file must be ignored, by checking if there's an
@@ -107,3 +625,71 @@ func isIgnored(dashboardJSON []byte, cfg *config.Config) (bool, error) {
 
 	return false, nil
 }
+
+// verifyDashboard checks that a dashboard described by a given JSON content has
+// been correctly pushed to Grafana by retrieving it from the Grafana API, then,
+// if the verification settings require it, by rendering it.
+// Returns an error if the dashboard's reference or slug couldn't be computed,
+// or if the dashboard couldn't be retrieved, loaded or rendered.
+func verifyDashboard(
+	ctx context.Context, dashboardJSON []byte, client *grafana.Client,
+	cfg *config.VerifySettings,
+) error {
+	ref, err := grafana.RefFromJSON(dashboardJSON)
+	if err != nil {
+		return err
+	}
+
+	if err = client.VerifyDashboard(ctx, ref); err != nil {
+		return err
+	}
+
+	if cfg.Render {
+		slug, err := helpers.GetDashboardSlug(dashboardJSON)
+		if err != nil {
+			return err
+		}
+
+		_, err = client.RenderDashboard(ctx, ref.UID, slug, cfg.Width, cfg.Height)
+		return err
+	}
+
+	return err
+}
+
+// log logs the push report, as an error if at least one file failed to be
+// pushed or verified, else as an information.
+func (r *PushReport) log(ctx context.Context) {
+	failed := make([]string, 0)
+	for filename := range r.Failed {
+		failed = append(failed, filename)
+	}
+
+	for filename := range r.Rejected {
+		failed = append(failed, filename)
+	}
+
+	unhealthy := make([]string, 0)
+	for filename := range r.Unhealthy {
+		unhealthy = append(unhealthy, filename)
+	}
+
+	drifted := make([]string, 0)
+	for filename := range r.Drifted {
+		drifted = append(drifted, filename)
+	}
+
+	entry := logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"pushed":    len(r.Pushed),
+		"failed":    strings.Join(failed, ","),
+		"unhealthy": strings.Join(unhealthy, ","),
+		"drifted":   strings.Join(drifted, ","),
+		"skipped":   strings.Join(r.Skipped, ","),
+	})
+
+	if len(failed) > 0 || len(unhealthy) > 0 || len(r.Skipped) > 0 {
+		entry.Error("Push report: some dashboards failed to be pushed or verified")
+	} else {
+		entry.Info("Push report: all dashboards were pushed successfully")
+	}
+}
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/textfile"
+)
+
+// RecordedVersions reads the versions of the dashboards recorded in the
+// versions file of the repository (i.e. their versions on Grafana when they
+// were last pulled or pushed), and returns them mapped to their keys in the
+// file (see grafana.VersionKey). The file is read from the clone path, or from
+// the sync path in "simple sync" mode. Returns nil if the pusher's settings
+// don't require checking for conflicts, and an empty map if the file doesn't
+// exist.
+// Returns an error if there was an issue reading or parsing the file.
+func RecordedVersions(cfg *config.Config) (map[string]int, error) {
+	if cfg.Pusher == nil || cfg.Pusher.Conflicts == config.ConflictsOverwrite {
+		return nil, nil
+	}
+
+	var syncPath string
+	if cfg.Git != nil {
+		syncPath = cfg.Git.SyncPath()
+	} else {
+		syncPath = cfg.SimpleSync.SyncPath
+	}
+
+	data, err := textfile.Read(filepath.Join(syncPath, cfg.Metadata.VersionsFile))
+	if os.IsNotExist(err) {
+		return make(map[string]int), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]int)
+	err = json.Unmarshal(data, &versions)
+	return versions, err
+}
+
+// checkConflict compares the version on Grafana of the dashboard described by
+// the given content with its version recorded in the repository, from the
+// given map of versions, to check that it wasn't modified on Grafana since it
+// was last pulled, which pushing it would overwrite. Nothing is checked if the
+// dashboard's version isn't recorded (e.g. because it was never pulled) or if
+// the dashboard doesn't exist on Grafana.
+// Returns the dashboard's version on Grafana if it matches the recorded one, 0
+// if nothing was checked, an error of type *conflictError if the dashboard was
+// modified on Grafana, or another error if there was an issue retrieving or
+// parsing the dashboard.
+func checkConflict(
+	ctx context.Context, content []byte, versions map[string]int,
+	client *grafana.Client,
+) (int, error) {
+	slug, err := helpers.GetDashboardSlug(content)
+	if err != nil {
+		return 0, err
+	}
+
+	ref, err := grafana.RefFromJSON(content)
+	if err != nil {
+		return 0, err
+	}
+
+	recorded, ok := grafana.RecordedVersion(versions, ref.UID, slug)
+	if !ok {
+		return 0, nil
+	}
+
+	live, err := client.GetDashboardByRef(ctx, ref)
+	if err != nil {
+		if grafana.IsNotFound(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	if live.Version > recorded {
+		return 0, &conflictError{recorded: recorded, live: live.Version}
+	}
+
+	return live.Version, nil
+}
+
+// conflictError is returned when a dashboard was modified on Grafana since it
+// was last pulled.
+type conflictError struct {
+	recorded int
+	live     int
+}
+
+// Error implements error.Error().
+func (e *conflictError) Error() string {
+	return fmt.Sprintf(
+		"modified on Grafana (version %d) since it was last pulled (version %d)",
+		e.live, e.recorded,
+	)
+}
@@ -0,0 +1,96 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+)
+
+func TestRecordedVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gdm-conflicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The clone has its dashboards in a subdirectory, and the sync path of
+	// the simple sync mode is another directory, so reading the wrong one
+	// shows.
+	clonePath := filepath.Join(dir, "clone")
+	syncPath := filepath.Join(dir, "sync")
+	files := map[string]string{
+		filepath.Join(clonePath, "dashboards", "versions.json"): `{"clone": 3}`,
+		filepath.Join(syncPath, "versions.json"):                "\ufeff{\"simple\": 7}\r\n",
+	}
+	for filename, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		cfg      *config.Config
+		expected map[string]int
+	}{
+		{
+			name: "git",
+			cfg: &config.Config{
+				Git:    &config.GitSettings{ClonePath: clonePath, Subdirectory: "dashboards"},
+				Pusher: &config.PusherSettings{Conflicts: config.ConflictsWarn},
+			},
+			expected: map[string]int{"clone": 3},
+		},
+		{
+			name: "simple sync",
+			cfg: &config.Config{
+				SimpleSync: &config.SimpleSyncSettings{SyncPath: syncPath},
+				Pusher:     &config.PusherSettings{Conflicts: config.ConflictsBlock},
+			},
+			expected: map[string]int{"simple": 7},
+		},
+		{
+			name: "missing file",
+			cfg: &config.Config{
+				Git:    &config.GitSettings{ClonePath: clonePath},
+				Pusher: &config.PusherSettings{Conflicts: config.ConflictsBlock},
+			},
+			expected: map[string]int{},
+		},
+		{
+			name: "overwrite",
+			cfg: &config.Config{
+				Git:    &config.GitSettings{ClonePath: clonePath, Subdirectory: "dashboards"},
+				Pusher: &config.PusherSettings{Conflicts: config.ConflictsOverwrite},
+			},
+			expected: nil,
+		},
+		{
+			name:     "no pusher",
+			cfg:      &config.Config{SimpleSync: &config.SimpleSyncSettings{SyncPath: syncPath}},
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.cfg.Metadata.VersionsFile = "versions.json"
+
+			versions, err := RecordedVersions(test.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(versions, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, versions)
+			}
+		})
+	}
+}
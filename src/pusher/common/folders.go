@@ -0,0 +1,156 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	"github.com/sirupsen/logrus"
+)
+
+// IsFolderFile checks whether the file with the given name is a folder's
+// metadata file.
+func IsFolderFile(filename string, cfg *config.Config) bool {
+	return path.Base(filename) == cfg.Metadata.FolderFile
+}
+
+// PushFolder applies the folder's metadata described by the given JSON content
+// on Grafana. The folder is identified by the UID from the metadata: it's
+// created if it doesn't exist, renamed if its title differs from the
+// metadata's, and moved if the metadata sets a parent which differs from the
+// folder's. This way, renaming or moving a folder in the repository renames or
+// moves it on Grafana, along with its dashboards, instead of creating another
+// folder. If the permissions are synced, the folder's permissions are then
+// applied too.
+// Returns the folder.
+// Returns an error if the content couldn't be parsed or doesn't include the
+// folder's UID, or if there was an issue retrieving, creating, renaming or
+// moving the folder, or applying its permissions.
+func PushFolder(
+	ctx context.Context, content []byte, client *grafana.Client,
+	cfg *config.Config,
+) (*grafana.Folder, error) {
+	metadata, err := parseFolderMetadata(content)
+	if err != nil {
+		return nil, err
+	}
+
+	folder, err := client.GetFolderByUID(ctx, metadata.UID)
+	if err != nil {
+		return nil, err
+	}
+
+	if folder == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"uid":   metadata.UID,
+			"title": metadata.Title,
+		}).Info("Folder doesn't exist on Grafana, creating it")
+
+		if folder, err = client.CreateFolderFromMetadata(ctx, metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	if folder.Title != metadata.Title {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"uid":       metadata.UID,
+			"old_title": folder.Title,
+			"new_title": metadata.Title,
+		}).Info("Folder was renamed, renaming it on Grafana")
+
+		if folder, err = client.RenameFolder(ctx, metadata.UID, metadata.Title); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(metadata.ParentUID) > 0 && folder.ParentUID != metadata.ParentUID {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"uid":        metadata.UID,
+			"title":      metadata.Title,
+			"old_parent": folder.ParentUID,
+			"new_parent": metadata.ParentUID,
+		}).Info("Folder was moved, moving it on Grafana")
+
+		if folder, err = client.MoveFolder(ctx, metadata.UID, metadata.ParentUID); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.SyncPermissions {
+		if err = client.UpdateFolderPermissions(ctx, metadata.UID, metadata.Permissions); err != nil {
+			return nil, err
+		}
+	}
+
+	return folder, nil
+}
+
+// parseFolderMetadata parses the folder's metadata described by the given JSON
+// content.
+// Returns an error if the content couldn't be parsed or doesn't include the
+// folder's UID.
+func parseFolderMetadata(content []byte) (*grafana.FolderMetadata, error) {
+	metadata := new(grafana.FolderMetadata)
+	if err := json.Unmarshal(content, metadata); err != nil {
+		return nil, err
+	}
+
+	if len(metadata.UID) == 0 {
+		return nil, fmt.Errorf("No folder UID in the folder's metadata file")
+	}
+
+	return metadata, nil
+}
+
+// PruneFolder deletes the folder with the given title from Grafana if it's
+// empty, once dashboards were deleted from it, and if the pusher's settings
+// require it. The "General" folder (with an empty title) can't be deleted, and
+// neither are the folders which the settings require keeping, nor the ones
+// which don't exist on Grafana.
+// Returns whether the folder was deleted.
+// Returns an error if there was an issue retrieving, searching or deleting the
+// folder.
+func PruneFolder(
+	ctx context.Context, title string, client *grafana.Client, cfg *config.Config,
+) (bool, error) {
+	if cfg.Pusher == nil || cfg.Pusher.PruneFolders == nil || len(title) == 0 {
+		return false, nil
+	}
+
+	if cfg.Pusher.PruneFolders.Keeps(title) {
+		return false, nil
+	}
+
+	folders, err := client.GetFolders(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, folder := range folders {
+		if folder.Title != title {
+			continue
+		}
+
+		empty, err := client.IsFolderEmpty(ctx, folder.UID)
+		if err != nil || !empty {
+			return false, err
+		}
+
+		if err = client.DeleteFolder(ctx, folder.UID); err != nil {
+			return false, err
+		}
+
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"folder": title,
+			"uid":    folder.UID,
+		}).Info("Deleted the folder left empty by the deletions")
+
+		return true, nil
+	}
+
+	return false, nil
+}
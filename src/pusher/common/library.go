@@ -0,0 +1,60 @@
+package common
+
+import (
+	"context"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PushLibraryPanels takes a slice of files' names and a map mapping a file's
+// name to its content, and pushes the library panels described by the files in
+// the slice to Grafana, creating the library panels that don't exist and
+// updating the others.
+// Logs any errors encountered while pushing a library panel, but doesn't
+// return until all library panels have been pushed.
+// Returns the errors encountered, mapped to the files' names.
+func PushLibraryPanels(
+	ctx context.Context, filenames []string, contents map[string][]byte,
+	client *grafana.Client,
+) map[string]error {
+	return pushObjects(ctx, filenames, contents, client.CreateOrUpdateLibraryPanel, "library panel")
+}
+
+// PushPlaylists takes a slice of files' names and a map mapping a file's name
+// to its content, and pushes the playlists described by the files in the slice
+// to Grafana, creating the playlists that don't exist and updating the others.
+// Logs any errors encountered while pushing a playlist, but doesn't return
+// until all playlists have been pushed.
+// Returns the errors encountered, mapped to the files' names.
+func PushPlaylists(
+	ctx context.Context, filenames []string, contents map[string][]byte,
+	client *grafana.Client,
+) map[string]error {
+	return pushObjects(ctx, filenames, contents, client.CreateOrUpdatePlaylist, "playlist")
+}
+
+// pushObjects calls the given function with the content of each of the files
+// with the given names, and returns the errors it returned, mapped to the
+// files' names. The given kind of the objects the files describe is only used
+// in logs.
+func pushObjects(
+	ctx context.Context, filenames []string, contents map[string][]byte,
+	push func(ctx context.Context, contentJSON []byte) error, kind string,
+) map[string]error {
+	failed := make(map[string]error)
+
+	for _, filename := range filenames {
+		if err := push(ctx, contents[filename]); err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("Failed to push the " + kind + " to Grafana")
+
+			failed[filename] = err
+		}
+	}
+
+	return failed
+}
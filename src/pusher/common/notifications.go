@@ -0,0 +1,73 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PushAlertNotifications takes a slice of files' names and a map mapping a
+// file's name to its content, and pushes the legacy alert notification channels
+// described by the files in the slice to Grafana, creating the channels that
+// don't exist and updating the others.
+// Logs any errors encountered while pushing a channel, but doesn't return until
+// all channels have been pushed.
+// Returns the errors encountered, mapped to the files' names.
+func PushAlertNotifications(
+	ctx context.Context, filenames []string, contents map[string][]byte,
+	client *grafana.Client,
+) map[string]error {
+	failed := make(map[string]error)
+
+	for _, filename := range filenames {
+		if err := client.CreateOrUpdateAlertNotification(ctx, contents[filename]); err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("Failed to push the alert notification channel to Grafana")
+
+			failed[filename] = err
+		}
+	}
+
+	return failed
+}
+
+// DeleteAlertNotifications takes a slice of files' names and a map mapping a
+// file's name to its content, and deletes from Grafana the legacy alert
+// notification channels described by the files in the slice, identified by
+// their UIDs.
+// Logs any errors encountered while deleting a channel, but doesn't return
+// until all deletion requests have been performed.
+// Returns the errors encountered, mapped to the files' names.
+func DeleteAlertNotifications(
+	ctx context.Context, filenames []string, contents map[string][]byte,
+	client *grafana.Client,
+) map[string]error {
+	failed := make(map[string]error)
+
+	for _, filename := range filenames {
+		var channel struct {
+			UID string `json:"uid"`
+		}
+
+		err := json.Unmarshal(contents[filename], &channel)
+		if err == nil {
+			err = client.DeleteAlertNotification(ctx, channel.UID)
+		}
+
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("Failed to remove the alert notification channel from Grafana")
+
+			failed[filename] = err
+		}
+	}
+
+	return failed
+}
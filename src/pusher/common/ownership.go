@@ -0,0 +1,213 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/textfile"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OwnershipViolation describes a change made to a file by someone who doesn't
+// own it.
+type OwnershipViolation struct {
+	Filename string
+	Author   string
+}
+
+// LoadOwners returns the owners of the repository's directories, mapped to the
+// directories' paths. Owners are read from the directories mapping in the
+// pusher's settings, and from the CODEOWNERS file if one is configured. Only the
+// CODEOWNERS entries which pattern is a directory and which owners are email
+// addresses are taken into account. Since the CODEOWNERS file's patterns are
+// relative to the repository's root, they're translated to be relative to its
+// subdirectory if one is set. Returns an empty map if there are no pusher's
+// settings.
+// Returns an error if the CODEOWNERS file couldn't be read.
+func LoadOwners(cfg *config.Config) (map[string][]string, error) {
+	owners := make(map[string][]string)
+	if cfg.Pusher == nil {
+		return owners, nil
+	}
+
+	for dir, target := range cfg.Pusher.Dirs {
+		if len(target.Owners) > 0 {
+			dir = strings.Trim(dir, "/")
+			owners[dir] = append(owners[dir], target.Owners...)
+		}
+	}
+
+	if cfg.Pusher.Ownership == nil || len(cfg.Pusher.Ownership.CodeownersFile) == 0 {
+		return owners, nil
+	}
+
+	content, err := textfile.Read(
+		filepath.Join(cfg.Git.ClonePath, cfg.Pusher.Ownership.CodeownersFile),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	codeowners := make(map[string][]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		// "*" matches the whole repository, which we represent with an empty
+		// directory.
+		dir := strings.Trim(strings.TrimSuffix(fields[0], "*"), "/")
+		for _, owner := range fields[1:] {
+			if strings.Contains(owner, "@") && !strings.HasPrefix(owner, "@") {
+				codeowners[dir] = append(codeowners[dir], owner)
+			}
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for dir, dirOwners := range subdirectoryOwners(codeowners, cfg.Git) {
+		owners[dir] = append(owners[dir], dirOwners...)
+	}
+
+	return owners, nil
+}
+
+// subdirectoryOwners translates the given owners of the repository's
+// directories, mapped to the directories' paths relative to the repository's
+// root, to be mapped to the paths relative to its subdirectory, if one is set.
+// The directories outside of the subdirectory are left out, and the
+// subdirectory itself is owned by the owners of the deepest owned directory
+// containing it.
+func subdirectoryOwners(
+	owners map[string][]string, cfg *config.GitSettings,
+) map[string][]string {
+	if len(cfg.Subdirectory) == 0 {
+		return owners
+	}
+
+	translated := make(map[string][]string)
+	if rootOwners, owned := FileOwners(cfg.Subdirectory+"/", owners); owned {
+		translated[""] = rootOwners
+	}
+
+	for dir, dirOwners := range owners {
+		if dir, ok := cfg.SubdirectoryPath(dir); ok {
+			translated[dir] = dirOwners
+		}
+	}
+
+	return translated
+}
+
+// EnforceOwnership removes from the given slice of files' names the files that
+// were changed by at least one author who doesn't own them, i.e. who isn't
+// listed in the owners of the deepest owned directory containing the file.
+// Files which aren't contained in any owned directory can be changed by
+// anyone. authors maps the files' names to the email addresses of the authors
+// of the changes made to them.
+// Logs each violation, and returns them.
+func EnforceOwnership(
+	ctx context.Context, filenames *[]string, authors map[string][]string,
+	owners map[string][]string,
+) []OwnershipViolation {
+	violations := make([]OwnershipViolation, 0)
+	allowed := make([]string, 0)
+
+	for _, filename := range *filenames {
+		fileOwners, owned := FileOwners(filename, owners)
+
+		var violated bool
+		for _, author := range authors[filename] {
+			if owned && !contains(fileOwners, author) {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"filename": filename,
+					"author":   author,
+					"owners":   strings.Join(fileOwners, ","),
+				}).Error("Change made by someone who doesn't own the file, rejecting it")
+
+				violations = append(violations, OwnershipViolation{
+					Filename: filename,
+					Author:   author,
+				})
+				violated = true
+			}
+		}
+
+		if !violated {
+			allowed = append(allowed, filename)
+		}
+	}
+
+	*filenames = allowed
+	return violations
+}
+
+// FileOwners returns the owners of the deepest owned directory containing
+// the file with the given name, along with a boolean set to false if the file
+// isn't contained in any owned directory.
+func FileOwners(filename string, owners map[string][]string) ([]string, bool) {
+	var fileOwners []string
+	matched := -1
+
+	for dir, dirOwners := range owners {
+		if (len(dir) == 0 || strings.HasPrefix(filename, dir+"/")) && len(dir) > matched {
+			fileOwners = dirOwners
+			matched = len(dir)
+		}
+	}
+
+	return fileOwners, matched >= 0
+}
+
+// contains checks whether the given slice contains the given string, ignoring
+// case.
+func contains(slice []string, s string) bool {
+	for _, item := range slice {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FilterUnowned removes from the given slices of added/modified and removed
+// files' names the files that were changed by someone who doesn't own them, if
+// the pusher's settings require ownership to be enforced. authors maps the
+// files' names to the email addresses of the authors of the changes made to
+// them.
+// Returns an error if the owners couldn't be loaded.
+func FilterUnowned(
+	ctx context.Context, modified *[]string, removed *[]string, authors map[string][]string,
+	cfg *config.Config,
+) error {
+	if cfg.Pusher.Ownership == nil {
+		return nil
+	}
+
+	owners, err := LoadOwners(cfg)
+	if err != nil {
+		return err
+	}
+
+	violations := EnforceOwnership(ctx, modified, authors, owners)
+	violations = append(violations, EnforceOwnership(ctx, removed, authors, owners)...)
+
+	if len(violations) > 0 {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"violations": len(violations),
+		}).Error("Some changes were rejected because of ownership violations")
+	}
+
+	return nil
+}
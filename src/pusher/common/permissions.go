@@ -0,0 +1,103 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HoldsPermissions checks whether the file with the given name describes
+// permissions the pusher must apply: either a dashboard's permissions file, or,
+// if the permissions are synced, a folder's metadata file.
+func HoldsPermissions(filename string, cfg *config.Config) bool {
+	return cfg.IsPermissionsFile(filename) ||
+		(cfg.SyncPermissions && path.Base(filename) == cfg.Metadata.FolderFile)
+}
+
+// PushPermissions takes a slice of files' names and a map mapping a file's name
+// to its content, and applies the permissions described by the files in the
+// slice on Grafana, replacing the existing ones. Files are either dashboards'
+// permissions files or folders' metadata files, and the dashboards and folders
+// are identified by the UIDs stored in the files, so they must exist
+// beforehand.
+// Logs any errors encountered while applying permissions, but doesn't return
+// until all files have been processed.
+// Returns the errors encountered, mapped to the files' names.
+func PushPermissions(
+	ctx context.Context, filenames []string, contents map[string][]byte,
+	client *grafana.Client, cfg *config.Config,
+) map[string]error {
+	failed := make(map[string]error)
+
+	for _, filename := range filenames {
+		var err error
+		if cfg.IsPermissionsFile(filename) {
+			err = pushDashboardPermissions(ctx, contents[filename], client)
+		} else {
+			err = pushFolderPermissions(ctx, contents[filename], client)
+		}
+
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("Failed to apply the permissions on Grafana")
+
+			failed[filename] = err
+		}
+	}
+
+	return failed
+}
+
+// pushDashboardPermissions applies the dashboard's permissions described by the
+// given JSON content on Grafana.
+// Returns an error if the content couldn't be parsed or doesn't include the
+// dashboard's UID, or if there was an issue applying the permissions.
+func pushDashboardPermissions(ctx context.Context, content []byte, client *grafana.Client) error {
+	var permissions grafana.DashboardPermissions
+	if err := json.Unmarshal(content, &permissions); err != nil {
+		return err
+	}
+
+	if len(permissions.UID) == 0 {
+		return fmt.Errorf("No dashboard UID in the permissions file")
+	}
+
+	return client.UpdateDashboardPermissions(ctx, permissions.UID, permissions.Permissions)
+}
+
+// pushFolderPermissions applies the folder's permissions from the folder's
+// metadata described by the given JSON content on Grafana.
+// Returns an error if the content couldn't be parsed or doesn't include the
+// folder's UID, or if there was an issue applying the permissions.
+func pushFolderPermissions(ctx context.Context, content []byte, client *grafana.Client) error {
+	metadata, err := parseFolderMetadata(content)
+	if err != nil {
+		return err
+	}
+
+	return client.UpdateFolderPermissions(ctx, metadata.UID, metadata.Permissions)
+}
+
+// FilterPermissions removes from the given slice of files' names the files
+// describing permissions, along with the folders' metadata files. This is used
+// for branches other than master, which dashboards are copies of master's, so
+// the permissions of master's dashboards and folders must not be applied to
+// them, nor master's folders renamed or moved.
+func FilterPermissions(filenames *[]string, cfg *config.Config) {
+	filtered := make([]string, 0, len(*filenames))
+	for _, filename := range *filenames {
+		if !HoldsPermissions(filename, cfg) && !IsFolderFile(filename, cfg) {
+			filtered = append(filtered, filename)
+		}
+	}
+
+	*filenames = filtered
+}
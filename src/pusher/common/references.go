@@ -0,0 +1,64 @@
+package common
+
+import (
+	"context"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/plan"
+)
+
+// referenceChecker looks for the objects referencing dashboards which are
+// about to be deleted. The dashboards from the repository's clone are only read
+// once, when they're first needed.
+type referenceChecker struct {
+	client     *grafana.Client
+	cfg        *config.Config
+	dashboards map[string][]byte
+}
+
+// references returns the objects referencing the dashboard with the given UID,
+// i.e. the alert rules, playlists and library panels referencing it on
+// Grafana, and the dashboards from the repository linking to it.
+// Returns an error if there was an issue requesting the Grafana API or reading
+// the dashboards from the repository.
+func (r *referenceChecker) references(
+	ctx context.Context, uid string,
+) ([]grafana.DashboardReference, error) {
+	refs, err := r.client.GetDashboardReferences(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.dashboards == nil {
+		if r.dashboards, err = plan.ReadDashboardFiles(r.cfg.Git.SyncPath()); err != nil {
+			return nil, err
+		}
+
+		if err = FilterIgnored(&r.dashboards, r.cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	for filename, content := range r.dashboards {
+		if IsFolderFile(filename, r.cfg) || HoldsPermissions(filename, r.cfg) {
+			continue
+		}
+
+		// A dashboard linking to itself doesn't prevent deleting it, nor does
+		// the file it was moved to.
+		if fileUID, err := helpers.GetDashboardUID(content); err != nil || fileUID == uid {
+			continue
+		}
+
+		if grafana.LinksToDashboard(content, uid) {
+			refs = append(refs, grafana.DashboardReference{
+				Kind: grafana.ReferenceDashboard,
+				Name: filename,
+			})
+		}
+	}
+
+	return refs, nil
+}
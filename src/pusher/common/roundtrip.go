@@ -0,0 +1,128 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+)
+
+// roundTripIgnored lists the top-level fields of a dashboard's JSON description
+// that Grafana is expected to set itself, and which are therefore ignored when
+// comparing a pushed dashboard with its live version.
+var roundTripIgnored = map[string]bool{
+	"id":      true,
+	"uid":     true,
+	"version": true,
+}
+
+// CheckRoundTrip retrieves a dashboard described by a given JSON content from
+// the Grafana API after it has been pushed, and compares it with the pushed
+// content, ignoring formatting and the fields Grafana sets itself.
+// Returns the paths of the fields that differ (e.g. "panels[0].legend"), which
+// Grafana silently rewrote, dropped or added. A file containing such fields
+// would be changed by the puller after each push.
+// Returns an error if the dashboard's reference couldn't be computed, if the
+// dashboard couldn't be retrieved, or if one of the JSON descriptions couldn't
+// be parsed.
+func CheckRoundTrip(
+	ctx context.Context, dashboardJSON []byte, client *grafana.Client,
+) (discrepancies []string, err error) {
+	ref, err := grafana.RefFromJSON(dashboardJSON)
+	if err != nil {
+		return
+	}
+
+	live, err := client.GetDashboardByRef(ctx, ref)
+	if err != nil {
+		return
+	}
+
+	var pushedDashboard, liveDashboard map[string]interface{}
+	if err = json.Unmarshal(dashboardJSON, &pushedDashboard); err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(live.RawJSON, &liveDashboard); err != nil {
+		return
+	}
+
+	for field := range roundTripIgnored {
+		delete(pushedDashboard, field)
+		delete(liveDashboard, field)
+	}
+
+	discrepancies = make([]string, 0)
+	diffJSON("", pushedDashboard, liveDashboard, &discrepancies)
+	sort.Strings(discrepancies)
+
+	return
+}
+
+// diffJSON compares two decoded JSON values, and appends to the given slice the
+// paths (starting with the given one) at which they differ. For objects, a
+// field missing from one of the values is reported with a "(dropped)" or
+// "(added)" suffix.
+func diffJSON(path string, pushed interface{}, live interface{}, diffs *[]string) {
+	switch p := pushed.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			*diffs = append(*diffs, displayPath(path))
+			return
+		}
+
+		for key, value := range p {
+			liveValue, ok := l[key]
+			if !ok {
+				*diffs = append(*diffs, joinPath(path, key)+" (dropped)")
+				continue
+			}
+
+			diffJSON(joinPath(path, key), value, liveValue, diffs)
+		}
+
+		for key := range l {
+			if _, ok := p[key]; !ok {
+				*diffs = append(*diffs, joinPath(path, key)+" (added)")
+			}
+		}
+
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(p) {
+			*diffs = append(*diffs, displayPath(path))
+			return
+		}
+
+		for i := range p {
+			diffJSON(fmt.Sprintf("%s[%d]", path, i), p[i], l[i], diffs)
+		}
+
+	default:
+		if !reflect.DeepEqual(pushed, live) {
+			*diffs = append(*diffs, displayPath(path))
+		}
+	}
+}
+
+// joinPath appends the given key to a path of a JSON value.
+func joinPath(path string, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// displayPath returns the given path of a JSON value, or "." for the root.
+func displayPath(path string) string {
+	if len(path) == 0 {
+		return "."
+	}
+
+	return path
+}
@@ -0,0 +1,113 @@
+package common
+
+import (
+	"context"
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/datasources"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/plan"
+	"github.com/babolivier/grafana-dashboards-manager/src/templating"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FilterUnchanged removes from the given slice of files' names the files which
+// content matches the dashboard currently on Grafana (ignoring formatting and
+// the fields Grafana sets itself), if the Git settings require the manager's
+// commits to be inspected rather than skipped. This allows pushing the changes
+// made by humans to commits created by the manager (e.g. by amending or
+// cherry-picking them), without pushing back the manager's own changes.
+// If templating is enabled, the files are rendered with the main Grafana
+// instance's variables before being compared, and their data sources are
+// remapped to the main instance's if requested.
+// Returns an error if a file's reference couldn't be computed, if a file couldn't
+// be rendered, or if there was an issue retrieving or comparing a dashboard.
+func FilterUnchanged(
+	ctx context.Context, filenames *[]string, contents map[string][]byte,
+	client *grafana.Client, cfg *config.Config,
+) error {
+	if !cfg.Git.InspectsManagerCommits() {
+		return nil
+	}
+
+	refs, err := client.GetDashboardsRefs(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Files without an UID are looked up by their URI, even if the dashboards
+	// on Grafana have UIDs.
+	live := make(map[string]bool)
+	for _, ref := range refs {
+		live[ref.String()] = true
+		live[ref.URI] = true
+	}
+
+	changed := make([]string, 0)
+	for _, filename := range *filenames {
+		// Files that were filtered out of the contents (e.g. ignored files)
+		// aren't compared.
+		content, ok := contents[filename]
+		if !ok {
+			changed = append(changed, filename)
+			continue
+		}
+
+		// Files describing permissions or folders aren't compared, since
+		// applying the same permissions or folder again doesn't change
+		// anything.
+		if HoldsPermissions(filename, cfg) || IsFolderFile(filename, cfg) {
+			changed = append(changed, filename)
+			continue
+		}
+
+		if cfg.Pusher != nil && cfg.Pusher.Templating != nil {
+			if content, err = templating.Render(
+				content, cfg.Pusher.Templating.Variables, cfg.Pusher.Templating,
+			); err != nil {
+				return err
+			}
+		}
+
+		if cfg.Pusher != nil && len(cfg.Pusher.DatasourceMapping) > 0 {
+			if content, _, err = datasources.Remap(content, cfg.Pusher.DatasourceMapping); err != nil {
+				return err
+			}
+		}
+
+		ref, err := grafana.RefFromJSON(content)
+		if err != nil {
+			return err
+		}
+
+		// Dashboards that don't exist on Grafana have obviously changed.
+		if !live[ref.String()] {
+			changed = append(changed, filename)
+			continue
+		}
+
+		dashboard, err := client.GetDashboardByRef(ctx, ref)
+		if err != nil {
+			return err
+		}
+
+		equal, err := plan.Equal(content, dashboard.RawJSON)
+		if err != nil {
+			return err
+		}
+
+		if equal {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"filename":  filename,
+				"dashboard": ref,
+			}).Info("File matches the dashboard on Grafana, skipping")
+
+			continue
+		}
+
+		changed = append(changed, filename)
+	}
+
+	*filenames = changed
+	return nil
+}
@@ -0,0 +1,423 @@
+package freeze
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/logger"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/pause"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/targets"
+
+	"github.com/sirupsen/logrus"
+)
+
+// timeOfDayFormat is the format used for the start and end of recurring freeze
+// windows.
+const timeOfDayFormat = "15:04"
+
+// window is the parsed representation of a freeze window from the
+// configuration file.
+type window struct {
+	// start and end of a one-off window.
+	start time.Time
+	end   time.Time
+	// Start and end of a recurring window, as durations since midnight (UTC),
+	// and the days of the week on which it recurs.
+	startOfDay time.Duration
+	endOfDay   time.Duration
+	days       map[time.Weekday]bool
+}
+
+// pendingKey identifies a file in the queue. A file is identified by its name
+// and the title of the Grafana folder it must be pushed to, since the same file
+// can be pushed to different folders (e.g. from different branches).
+type pendingKey struct {
+	filename string
+	folder   string
+}
+
+// pendingChange represents a change that couldn't be applied to Grafana because
+// of a freeze.
+type pendingChange struct {
+	content []byte
+	remove  bool
+}
+
+// Queue applies changes to Grafana unless there's an ongoing freeze or the
+// pushes are paused, in which case it keeps them until the freeze lifts and the
+// pushes are resumed. Only the latest change for a given file is kept. Queued
+// changes are only kept in memory, so they're lost if the process stops before
+// they're applied. Changes are applied to all of the Grafana targets (see
+// targets.Pusher).
+type Queue struct {
+	windows []window
+	pusher  *targets.Pusher
+	paused  *pause.Switch
+	cfg     *config.Config
+	pending map[pendingKey]pendingChange
+	mutex   sync.Mutex
+}
+
+// NewQueue creates a new instance of the Queue structure using the freeze
+// settings from the given configuration, if any. Changes are applied using the
+// given Grafana client, and to the additional targets from the pusher's
+// settings (see targets.NewPusher), unless the pushes are paused with the given
+// switch.
+// Returns an error if one of the windows couldn't be parsed.
+func NewQueue(
+	cfg *config.Config, client *grafana.Client, paused *pause.Switch,
+) (*Queue, error) {
+	q := &Queue{
+		pusher:  targets.NewPusher(cfg, client),
+		paused:  paused,
+		cfg:     cfg,
+		pending: make(map[pendingKey]pendingChange),
+	}
+
+	if cfg.Pusher.Freeze == nil {
+		return q, nil
+	}
+
+	for _, w := range cfg.Pusher.Freeze.Windows {
+		parsed, err := parseWindow(w)
+		if err != nil {
+			return nil, err
+		}
+
+		q.windows = append(q.windows, parsed)
+	}
+
+	return q, nil
+}
+
+// Frozen checks whether the given time is included in at least one of the
+// freeze windows.
+func (q *Queue) Frozen(t time.Time) bool {
+	for _, w := range q.windows {
+		if w.includes(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ApplyToFolders pushes the given added/modified files to Grafana and deletes
+// the dashboards matching the given removed files, or queues these changes if
+// there's an ongoing freeze or the pushes are paused (see pause.Switch). If
+// changes were queued during a previous freeze, they are applied before the
+// new ones. If applying them is aborted, the new changes are queued behind the
+// ones left in the queue, so they're applied in order once the queue is
+// flushed again.
+// Each file is pushed to the folder mapped to its directory in the pusher's
+// settings, or to the given default folder if its directory isn't mapped to
+// any. Folders are identified by their titles, and created if they don't
+// exist. Files which dashboard's current folder couldn't be looked up (see
+// targets.Pusher.Folder) aren't pushed, and are counted as failed in the
+// status of each target (or, if the changes are queued, recorded as failed in
+// the state and subject to the "fail-fast" error policy right away).
+// Returns the versions of the dashboards that were pushed to the main Grafana
+// instance, mapped to their keys in the versions file (see grafana.VersionKey).
+// Returns an error if applying (or queueing) the changes was aborted because
+// of the "fail-fast" error policy.
+func (q *Queue) ApplyToFolders(
+	ctx context.Context, modified []string, removed []string,
+	contents map[string][]byte, defaultFolder string,
+) (map[string]int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	set := make(targets.ChangeSet)
+	batch := make(map[pendingKey]pendingChange)
+	failed := make(map[string]error)
+	for _, filename := range modified {
+		folder, err := q.pusher.Folder(ctx, filename, contents[filename], defaultFolder)
+		if err != nil {
+			// Don't risk moving the dashboard to the wrong folder.
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("Failed to find the dashboard's current folder, not pushing it")
+
+			failed[filename] = err
+			continue
+		}
+
+		set.Add(folder, filename, contents[filename], false)
+		batch[pendingKey{filename: filename, folder: folder}] = pendingChange{
+			content: contents[filename],
+		}
+	}
+
+	for _, filename := range removed {
+		folder := common.TargetFolder(filename, defaultFolder, q.cfg)
+		set.Add(folder, filename, contents[filename], true)
+		batch[pendingKey{filename: filename, folder: folder}] = pendingChange{
+			content: contents[filename],
+			remove:  true,
+		}
+	}
+
+	if q.holding(time.Now()) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"modified": len(modified) - len(failed),
+			"removed":  len(removed),
+			"pending":  len(q.pending) + len(batch),
+		}).Info("Changes freeze ongoing or pushes paused, queueing changes")
+
+		return nil, q.queue(ctx, batch, failed, contents)
+	}
+
+	versions, err := q.flush(ctx)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"modified": len(modified) - len(failed),
+			"removed":  len(removed),
+			"pending":  len(q.pending) + len(batch),
+		}).Warn("Applying the queued changes was aborted, queueing the new ones")
+
+		q.queue(ctx, batch, failed, contents)
+		return versions, err
+	}
+
+	pushed, _, err := q.pusher.Push(ctx, set, failed, contents)
+	for key, version := range pushed {
+		versions[key] = version
+	}
+
+	return versions, err
+}
+
+// queue adds the given batch of changes to the queue. The given files, which
+// failed to be prepared for the push (mapped to their errors), with the given
+// contents, won't be part of any push, so their failure is recorded in the
+// state right away. The caller must hold the queue's mutex.
+// Returns an error if files failed to be prepared and the error policy is
+// "fail-fast".
+func (q *Queue) queue(
+	ctx context.Context, batch map[pendingKey]pendingChange,
+	failed map[string]error, contents map[string][]byte,
+) error {
+	for key, change := range batch {
+		q.pending[key] = change
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	err := q.pusher.RecordFailures(ctx, failed, contents)
+	if !q.cfg.FailFast() {
+		return nil
+	}
+
+	return fmt.Errorf("%d dashboard(s) failed to be queued: %v", len(failed), err)
+}
+
+// Watch starts an infinite loop checking, at the given interval, whether the
+// freeze has lifted (and the pushes aren't paused). If so, and if there are
+// queued changes, it applies them to Grafana then calls the given callback
+// with the context of the run, the versions of the dashboards that were pushed
+// to the main Grafana instance, mapped to their keys in the versions file, and
+// the error that aborted applying the changes because of the "fail-fast" error
+// policy, if any. Applying the queued changes (and calling the callback) is
+// done as a sync run of its own.
+func (q *Queue) Watch(
+	interval time.Duration,
+	afterFlush func(ctx context.Context, versions map[string]int, err error),
+) {
+	for {
+		time.Sleep(interval)
+
+		if q.mustFlush() {
+			q.flushAsRun(afterFlush)
+		}
+	}
+}
+
+// mustFlush checks whether there are queued changes to apply because the
+// freeze lifted or the pushes were resumed.
+func (q *Queue) mustFlush() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return !q.holding(time.Now()) && len(q.pending) > 0
+}
+
+// flushAsRun applies the queued changes to Grafana, unless another freeze
+// started or the pushes were paused in the meantime, then calls the given
+// callback with the context of the run, the versions of the dashboards pushed
+// to the main Grafana instance and the error that aborted applying the
+// changes, if any. This is done as a sync run.
+func (q *Queue) flushAsRun(
+	afterFlush func(ctx context.Context, versions map[string]int, err error),
+) {
+	ctx := logger.StartRun(context.Background())
+
+	q.mutex.Lock()
+	if q.holding(time.Now()) || len(q.pending) == 0 {
+		q.mutex.Unlock()
+		return
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"pending": len(q.pending),
+	}).Info("Changes freeze lifted or pushes resumed, applying queued changes")
+
+	versions, err := q.flush(ctx)
+	q.mutex.Unlock()
+
+	if afterFlush != nil {
+		afterFlush(ctx, versions, err)
+	}
+}
+
+// flush applies all the queued changes to Grafana, and removes them from the
+// queue. If applying the changes was aborted, the ones which weren't applied to
+// all of the targets are left in the queue, so they're applied again when the
+// queue is next flushed. Otherwise, the changes which failed to be applied
+// have been accounted for like any other failed push (e.g. recorded in the
+// state), and aren't retried. The caller must hold the queue's mutex.
+// Returns the versions of the dashboards that were pushed to the main Grafana
+// instance, mapped to their keys in the versions file (see grafana.VersionKey).
+// Returns an error if applying the changes was aborted because of the
+// "fail-fast" error policy.
+func (q *Queue) flush(ctx context.Context) (map[string]int, error) {
+	if len(q.pending) == 0 {
+		return make(map[string]int), nil
+	}
+
+	set := make(targets.ChangeSet)
+	for key, change := range q.pending {
+		set.Add(key.folder, key.filename, change.content, change.remove)
+	}
+
+	versions, unapplied, err := q.pusher.Push(ctx, set, nil, nil)
+
+	left := make(map[pendingKey]pendingChange)
+	if err != nil {
+		for folder := range unapplied {
+			for _, filename := range unapplied.Files(folder) {
+				key := pendingKey{filename: filename, folder: folder}
+				left[key] = q.pending[key]
+			}
+		}
+	}
+
+	q.pending = left
+	return versions, err
+}
+
+// holding checks whether changes must be queued at the given time, i.e.
+// whether there's an ongoing freeze or the pushes are paused.
+func (q *Queue) holding(t time.Time) bool {
+	return q.paused.Paused() || q.Frozen(t)
+}
+
+// includes checks whether the given time is included in the window.
+func (w window) includes(t time.Time) bool {
+	// One-off window.
+	if w.days == nil {
+		return !t.Before(w.start) && t.Before(w.end)
+	}
+
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	sinceMidnight := t.Sub(midnight)
+
+	if w.startOfDay < w.endOfDay {
+		return w.days[t.Weekday()] &&
+			sinceMidnight >= w.startOfDay && sinceMidnight < w.endOfDay
+	}
+
+	// The window spans over midnight, so it either started today or the day
+	// before.
+	yesterday := t.AddDate(0, 0, -1).Weekday()
+	return (w.days[t.Weekday()] && sinceMidnight >= w.startOfDay) ||
+		(w.days[yesterday] && sinceMidnight < w.endOfDay)
+}
+
+// parseWindow parses a freeze window from the configuration file.
+// Returns an error if the start, the end or one of the days couldn't be
+// parsed, if a one-off window ends before it starts, or if a recurring window
+// starts and ends at the same time of day.
+func parseWindow(cfg config.FreezeWindow) (w window, err error) {
+	if len(cfg.Days) == 0 {
+		if w.start, err = time.Parse(time.RFC3339, cfg.Start); err != nil {
+			return
+		}
+
+		if w.end, err = time.Parse(time.RFC3339, cfg.End); err != nil {
+			return
+		}
+
+		if !w.end.After(w.start) {
+			err = fmt.Errorf(
+				"freeze window ending at %s ends before it starts", cfg.End,
+			)
+		}
+
+		return
+	}
+
+	if w.startOfDay, err = parseTimeOfDay(cfg.Start); err != nil {
+		return
+	}
+
+	if w.endOfDay, err = parseTimeOfDay(cfg.End); err != nil {
+		return
+	}
+
+	// Such a window would be considered as spanning over midnight, and
+	// freeze whole days.
+	if w.startOfDay == w.endOfDay {
+		err = fmt.Errorf(
+			"recurring freeze window starting at %s ends when it starts", cfg.Start,
+		)
+		return
+	}
+
+	w.days = make(map[time.Weekday]bool)
+	for _, day := range cfg.Days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			err = fmt.Errorf("invalid day in freeze window: %s", day)
+			return
+		}
+
+		w.days[weekday] = true
+	}
+
+	return
+}
+
+// parseTimeOfDay parses a time of day using the "15:04" format, and returns
+// it as a duration since midnight.
+// Returns an error if the time of day couldn't be parsed.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse(timeOfDayFormat, s)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute, nil
+}
+
+// weekdays maps the days that can be used in a recurring freeze window to
+// their time.Weekday value.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
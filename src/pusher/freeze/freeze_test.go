@@ -0,0 +1,153 @@
+package freeze
+
+import (
+	"testing"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+)
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config.FreezeWindow
+		valid bool
+	}{
+		{
+			name:  "one-off",
+			cfg:   config.FreezeWindow{Start: "2024-01-01T10:00:00Z", End: "2024-01-02T10:00:00Z"},
+			valid: true,
+		},
+		{
+			name: "one-off ending before it starts",
+			cfg:  config.FreezeWindow{Start: "2024-01-02T10:00:00Z", End: "2024-01-01T10:00:00Z"},
+		},
+		{
+			name: "one-off ending when it starts",
+			cfg:  config.FreezeWindow{Start: "2024-01-01T10:00:00Z", End: "2024-01-01T10:00:00Z"},
+		},
+		{
+			name: "one-off with invalid start",
+			cfg:  config.FreezeWindow{Start: "2024-01-01", End: "2024-01-02T10:00:00Z"},
+		},
+		{
+			name:  "recurring",
+			cfg:   config.FreezeWindow{Start: "18:00", End: "08:00", Days: []string{"Fri", "sat"}},
+			valid: true,
+		},
+		{
+			name: "recurring ending when it starts",
+			cfg:  config.FreezeWindow{Start: "18:00", End: "18:00", Days: []string{"fri"}},
+		},
+		{
+			name: "recurring with invalid end",
+			cfg:  config.FreezeWindow{Start: "18:00", End: "25:00", Days: []string{"fri"}},
+		},
+		{
+			name: "recurring with invalid day",
+			cfg:  config.FreezeWindow{Start: "18:00", End: "20:00", Days: []string{"friday"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseWindow(test.cfg)
+
+			if test.valid && err != nil {
+				t.Errorf("expected the window to be valid, got %v", err)
+			} else if !test.valid && err == nil {
+				t.Error("expected the window to be rejected")
+			}
+		})
+	}
+}
+
+func TestWindowIncludes(t *testing.T) {
+	// 2024-01-05 is a Friday.
+	tests := []struct {
+		name     string
+		cfg      config.FreezeWindow
+		t        string
+		expected bool
+	}{
+		{
+			name:     "one-off, during",
+			cfg:      config.FreezeWindow{Start: "2024-01-01T10:00:00Z", End: "2024-01-02T10:00:00Z"},
+			t:        "2024-01-01T12:00:00+01:00",
+			expected: true,
+		},
+		{
+			name:     "one-off, at the start",
+			cfg:      config.FreezeWindow{Start: "2024-01-01T10:00:00Z", End: "2024-01-02T10:00:00Z"},
+			t:        "2024-01-01T10:00:00Z",
+			expected: true,
+		},
+		{
+			name: "one-off, at the end",
+			cfg:  config.FreezeWindow{Start: "2024-01-01T10:00:00Z", End: "2024-01-02T10:00:00Z"},
+			t:    "2024-01-02T10:00:00Z",
+		},
+		{
+			name:     "recurring, during",
+			cfg:      config.FreezeWindow{Start: "09:00", End: "17:00", Days: []string{"fri"}},
+			t:        "2024-01-05T12:00:00Z",
+			expected: true,
+		},
+		{
+			name:     "recurring, during in another time zone",
+			cfg:      config.FreezeWindow{Start: "09:00", End: "17:00", Days: []string{"fri"}},
+			t:        "2024-01-05T17:30:00+02:00",
+			expected: true,
+		},
+		{
+			name: "recurring, on another day",
+			cfg:  config.FreezeWindow{Start: "09:00", End: "17:00", Days: []string{"fri"}},
+			t:    "2024-01-04T12:00:00Z",
+		},
+		{
+			name: "recurring, at the end",
+			cfg:  config.FreezeWindow{Start: "09:00", End: "17:00", Days: []string{"fri"}},
+			t:    "2024-01-05T17:00:00Z",
+		},
+		{
+			name:     "over midnight, before midnight",
+			cfg:      config.FreezeWindow{Start: "18:00", End: "08:00", Days: []string{"fri"}},
+			t:        "2024-01-05T23:00:00Z",
+			expected: true,
+		},
+		{
+			name:     "over midnight, after midnight",
+			cfg:      config.FreezeWindow{Start: "18:00", End: "08:00", Days: []string{"fri"}},
+			t:        "2024-01-06T07:00:00Z",
+			expected: true,
+		},
+		{
+			name: "over midnight, after midnight on the start day",
+			cfg:  config.FreezeWindow{Start: "18:00", End: "08:00", Days: []string{"fri"}},
+			t:    "2024-01-05T07:00:00Z",
+		},
+		{
+			name: "over midnight, after the end",
+			cfg:  config.FreezeWindow{Start: "18:00", End: "08:00", Days: []string{"fri"}},
+			t:    "2024-01-06T09:00:00Z",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, err := parseWindow(test.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tm, err := time.Parse(time.RFC3339, test.t)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if w.includes(tm) != test.expected {
+				t.Errorf("expected includes(%s) to be %t", test.t, test.expected)
+			}
+		})
+	}
+}
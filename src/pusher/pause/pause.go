@@ -0,0 +1,59 @@
+package pause
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Switch holds whether the pushes to Grafana are paused at runtime, in which
+// case the changes are queued as they are during a freeze. The same switch is
+// shared by the queues applying the changes (see freeze.Queue), and by the
+// ways of pausing and resuming the pushes (signals and the admin API).
+type Switch struct {
+	paused bool
+	mutex  sync.Mutex
+}
+
+// NewSwitch creates a new instance of the Switch structure, with the pushes
+// not paused.
+func NewSwitch() *Switch {
+	return &Switch{}
+}
+
+// Pause pauses the pushes to Grafana until Resume is called: the changes are
+// queued until then, and applied once the pushes are resumed (unless there's an
+// ongoing freeze). This is meant to be used e.g. while Grafana is under
+// maintenance.
+func (s *Switch) Pause() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.paused {
+		logrus.Info("Pausing the pushes to Grafana, changes will be queued")
+	}
+
+	s.paused = true
+}
+
+// Resume resumes the pushes to Grafana paused by Pause. The changes queued in
+// the meantime are applied when the queues next check whether they must be
+// flushed (see freeze.Queue.Watch).
+func (s *Switch) Resume() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.paused {
+		logrus.Info("Resuming the pushes to Grafana")
+	}
+
+	s.paused = false
+}
+
+// Paused checks whether the pushes to Grafana are paused.
+func (s *Switch) Paused() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.paused
+}
@@ -0,0 +1,27 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package pause
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandleSignals pauses the pushes to Grafana (see Pause) when the process
+// receives SIGUSR1, and resumes them (see Resume) when it receives SIGUSR2.
+func (s *Switch) HandleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGUSR1 {
+				s.Pause()
+			} else {
+				s.Resume()
+			}
+		}
+	}()
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package pause
+
+// HandleSignals does nothing, since SIGUSR1 and SIGUSR2 aren't available on
+// this platform. The pushes can still be paused and resumed using the admin
+// API.
+func (s *Switch) HandleSignals() {}
@@ -1,22 +1,32 @@
 package poller
 
 import (
+	"context"
 	"time"
 
-	"config"
-	"git"
-	"grafana"
-	puller "puller"
-	"pusher/common"
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/git"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/logger"
+	puller "github.com/babolivier/grafana-dashboards-manager/src/puller"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/freeze"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/pause"
 
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/sirupsen/logrus"
 )
 
 // Setup loads (and synchronise if needed) the Git repository mentioned in the
 // configuration file, then creates the poller that will pull from the Git
-// repository on a regular basis and push all the changes to Grafana.
-// Returns an error if the poller encountered one.
-func Setup(cfg *config.Config, client *grafana.Client, delRemoved bool) error {
+// repository on a regular basis and push all the changes to Grafana, unless the
+// pushes are paused with the given switch.
+// Returns an error if the poller couldn't be set up, or if it encountered one
+// and the error policy is "fail-fast".
+func Setup(
+	cfg *config.Config, client *grafana.Client, delRemoved bool,
+	paused *pause.Switch,
+) error {
 	// Load the Git repository.
 	r, needsSync, err := git.NewRepository(cfg.Git)
 	if err != nil {
@@ -30,12 +40,33 @@ func Setup(cfg *config.Config, client *grafana.Client, delRemoved bool) error {
 		}
 	}
 
+	// Initialise the queue that will hold changes during a freeze.
+	q, err := freeze.NewQueue(cfg, client, paused)
+	if err != nil {
+		return err
+	}
+
 	errs := make(chan error, 1)
 
+	// Apply the changes queued during a freeze once it lifts. The versions of
+	// the pushed dashboards are committed to the clone, which the poller's
+	// loop might be using at the same time.
+	go q.Watch(time.Minute, func(
+		ctx context.Context, versions map[string]int, err error,
+	) {
+		unlock := git.LockClone(cfg.Git.ClonePath)
+		commitPushedVersions(ctx, cfg, versions)
+		unlock()
+
+		if err != nil {
+			errs <- err
+		}
+	})
+
 	// In the future we may want to poll from several Git repositories, so we
 	// run the poller in a go routine.
 	go func() {
-		if err = poller(cfg, r, client, delRemoved); err != nil {
+		if err = poller(cfg, r, client, q, delRemoved); err != nil {
 			errs <- err
 			return
 		}
@@ -45,114 +76,296 @@ func Setup(cfg *config.Config, client *grafana.Client, delRemoved bool) error {
 	return err
 }
 
+// branchState stores the state of a watched branch as it was at the previous
+// iteration of the poller's loop.
+type branchState struct {
+	// We'll need to know the previous commit in order to compare its hash with
+	// the one from the most recent commit after we pull from the remote, se we
+	// know if there was any new commit.
+	commit *object.Commit
+	// We need to store the content of the files from the previous iteration of
+	// the loop in order to manage removed files which contents won't be
+	// accessible anymore.
+	filesContents map[string][]byte
+}
+
 // poller gets the current status of the Git repository that has previously been
 // loaded, and then starts an infinite loop that will pull from the Git
-// remote, then, if there was any new commit, retrieve the contents of the
-// modified and added files to push them to Grafana. If set by the user via
-// a command-line flag, it will also check for removed files and delete the
-// corresponding dashboards from Grafana. It then sleeps for the time specified
-// in the configuration file, before starting its next iteration.
-// Returns an error if there was an issue checking the Git repository status,
-// synchronising it, reading the files' contents, filtering out ignored files,
-// or discussing with the Grafana API.
+// remote, then, if there was any new commit on one of the watched branches,
+// retrieve the contents of the modified and added files to push them to the
+// Grafana folder matching the branch. If set by the user via a command-line
+// flag, it will also check for removed files and delete the corresponding
+// dashboards from Grafana. It then sleeps for the time specified in the
+// configuration file, before starting its next iteration.
+// Errors encountered during an iteration are logged, and the poller carries on
+// with the next branch, unless the error policy is "fail-fast".
+// Returns an error if there was an issue retrieving the initial state of the
+// watched branches, or if an error was encountered during an iteration and the
+// error policy is "fail-fast".
 func poller(
 	cfg *config.Config, repo *git.Repository, client *grafana.Client,
-	delRemoved bool,
+	queue *freeze.Queue, delRemoved bool,
 ) (err error) {
-	// Get current state of the repo.
-	// This is mainly to give an initial value to variables that will see their
+	// Get current state of each watched branch.
+	// This is mainly to give an initial value to the states that will see their
 	// content changed with every iteration of the loop.
-	latestCommit, err := repo.GetLatestCommit()
+	states := make(map[string]*branchState)
+	for branch := range cfg.Pusher.Branches {
+		latestCommit, err := getLatestCommit(repo, branch)
+		if err != nil {
+			return err
+		}
+
+		filesContents, err := repo.GetFilesContentsAtCommit(latestCommit)
+		if err != nil {
+			return err
+		}
+
+		states[branch] = &branchState{
+			commit:        latestCommit,
+			filesContents: filesContents,
+		}
+	}
+
+	maintainer := git.NewMaintainer(cfg.Git)
+
+	// Start looping
+	for {
+		if err = pollOnce(
+			cfg, repo, client, queue, delRemoved, states, maintainer,
+		); err != nil {
+			return
+		}
+
+		// Sleep before the next iteration.
+		time.Sleep(time.Duration(cfg.Pusher.Config.Interval) * time.Second)
+	}
+}
+
+// pollOnce runs an iteration of the poller's loop, as a sync run: it pulls from
+// the Git remote, then pushes the changes from each watched branch to Grafana,
+// commits the versions of the pushed dashboards, and runs the maintenance of
+// the clone if it's due. The clone is locked during the whole iteration.
+// Errors are logged, and the iteration carries on with the next branch, unless
+// the error policy is "fail-fast".
+// Returns an error if one was encountered and the error policy is "fail-fast".
+func pollOnce(
+	cfg *config.Config, repo *git.Repository, client *grafana.Client,
+	queue *freeze.Queue, delRemoved bool, states map[string]*branchState,
+	maintainer *git.Maintainer,
+) error {
+	ctx := logger.StartRun(context.Background())
+
+	defer git.LockClone(cfg.Git.ClonePath)()
+
+	// Synchronise the repository (i.e. pull from remote), and only look for
+	// new commits if it succeeded.
+	versions := make(map[string]int)
+	if err := repo.Sync(true); err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error":      err,
+			"clone_path": cfg.Git.ClonePath,
+		}).Error("Failed to synchronise the Git repository")
+
+		git.RecordTimeout(cfg, err)
+
+		if cfg.FailFast() {
+			return err
+		}
+	} else {
+		for branch, folder := range cfg.Pusher.Branches {
+			branchVersions, err := pollBranch(
+				ctx, cfg, repo, client, queue, delRemoved, branch, folder,
+				states[branch],
+			)
+
+			for key, version := range branchVersions {
+				versions[key] = version
+			}
+
+			if err != nil {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":  err,
+					"branch": branch,
+				}).Error("Failed to apply the changes from the branch")
+
+				git.RecordTimeout(cfg, err)
+
+				if cfg.FailFast() {
+					commitPushedVersions(ctx, cfg, versions)
+					return err
+				}
+			}
+		}
+	}
+
+	commitPushedVersions(ctx, cfg, versions)
+
+	// Keep the clone's size in check.
+	if err := maintainer.RunIfDue(repo); err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error":      err,
+			"clone_path": cfg.Git.ClonePath,
+		}).Error("Failed to run the maintenance of the Git repository")
+	}
+
+	return nil
+}
+
+// pollBranch retrieves the latest commit of the given branch and, if it differs
+// from the one in the branch's previous state, pushes the changes it introduces
+// to Grafana, in the folder with the given title unless the files' directories
+// are mapped to other folders. It then updates the branch's state to prepare
+// for the next iteration.
+// Returns the versions of the dashboards that were pushed to the main Grafana
+// instance, mapped to their keys in the versions file (see grafana.VersionKey).
+// Returns an error if there was an issue retrieving the commit, reading the
+// files' contents, filtering out ignored files, or discussing with the Grafana
+// API, or if applying the changes was aborted because of the "fail-fast" error
+// policy.
+func pollBranch(
+	ctx context.Context, cfg *config.Config, repo *git.Repository,
+	client *grafana.Client, queue *freeze.Queue, delRemoved bool, branch string,
+	folder string, state *branchState,
+) (versions map[string]int, err error) {
+	// Retrieve the latest commit in order to compare its hash with the
+	// previous one.
+	latestCommit, err := getLatestCommit(repo, branch)
 	if err != nil {
 		return
 	}
 
+	// If there isn't any new commit, there's nothing to do.
+	if state.commit.Hash.String() == latestCommit.Hash.String() {
+		return
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"branch":        branch,
+		"previous_hash": state.commit.Hash.String(),
+		"new_hash":      latestCommit.Hash.String(),
+	}).Info("New commit(s) detected")
+
+	// Get the updated files contents.
 	filesContents, err := repo.GetFilesContentsAtCommit(latestCommit)
 	if err != nil {
 		return
 	}
 
-	// We'll need to know the previous commit in order to compare its hash with
-	// the one from the most recent commit after we pull from the remote, se we
-	// know if there was any new commit.
-	previousCommit := latestCommit
-	// We need to store the content of the files from the previous iteration of
-	// the loop in order to manage removed files which contents won't be
-	// accessible anymore.
-	previousFilesContents := filesContents
+	// Get the name of the files that have been added/modified and
+	// removed between the two iterations.
+	modified, removed, err := repo.GetModifiedAndRemovedFiles(state.commit, latestCommit)
+	if err != nil {
+		return
+	}
 
-	// Start looping
-	for {
-		// Synchronise the repository (i.e. pull from remote).
-		if err = repo.Sync(true); err != nil {
+	// Get a map containing the latest known content of each added,
+	// modified and removed file.
+	mergedContents := mergeContents(modified, removed, filesContents, state.filesContents)
+
+	// Update the commit and files contents to prepare for the next iteration.
+	previousCommit := state.commit
+	state.commit = latestCommit
+	state.filesContents = filesContents
+
+	// Filter out all files that are supposed to be ignored by the
+	// dashboard manager.
+	if err = common.FilterIgnored(&mergedContents, cfg); err != nil {
+		return
+	}
+
+	// Dashboards from branches other than the one the clone follows must not
+	// update the dashboards they were pulled from, which live in this
+	// branch's folder, nor their permissions.
+	current, err := repo.CurrentBranch()
+	if err != nil {
+		return
+	}
+
+	if branch != current {
+		if err = common.StripIdentifiers(mergedContents); err != nil {
 			return
 		}
 
-		// Retrieve the latest commit in order to compare its hash with the
-		// previous one.
-		latestCommit, err = repo.GetLatestCommit()
+		common.FilterPermissions(&modified, cfg)
+		common.FilterPermissions(&removed, cfg)
+	}
+
+	// Reject the changes made by people who don't own the changed files, if
+	// the user requested it.
+	if cfg.Pusher.Ownership != nil {
+		authors, err := repo.GetFilesAuthors(previousCommit, latestCommit)
 		if err != nil {
-			return
+			return nil, err
 		}
 
-		// If there is at least one new commit, handle the changes it introduces.
-		if previousCommit.Hash.String() != latestCommit.Hash.String() {
-			logrus.WithFields(logrus.Fields{
-				"previous_hash": previousCommit.Hash.String(),
-				"new_hash":      latestCommit.Hash.String(),
-			}).Info("New commit(s) detected")
+		if err = common.FilterUnowned(ctx, &modified, &removed, authors, cfg); err != nil {
+			return nil, err
+		}
+	}
 
-			// Get the updated files contents.
-			filesContents, err = repo.GetFilesContentsAtCommit(latestCommit)
-			if err != nil {
-				return err
-			}
+	// Don't push back the files which content already matches Grafana's, if
+	// the manager's commits are inspected rather than skipped.
+	if err = common.FilterUnchanged(ctx, &modified, mergedContents, client, cfg); err != nil {
+		return nil, err
+	}
 
-			// Get the name of the files that have been added/modified and
-			// removed between the two iterations.
-			modified, removed, err := repo.GetModifiedAndRemovedFiles(previousCommit, latestCommit)
-			if err != nil {
-				return err
-			}
+	// Only delete the dashboards that were removed from the repository
+	// if the user requested it.
+	if !delRemoved {
+		removed = nil
+	}
 
-			// Get a map containing the latest known content of each added,
-			// modified and removed file.
-			mergedContents := mergeContents(modified, removed, filesContents, previousFilesContents)
+	// Push the contents of the files that were added or modified to their
+	// folders on the Grafana API, and delete the removed dashboards, unless
+	// there's an ongoing freeze.
+	versions, err = queue.ApplyToFolders(ctx, modified, removed, mergedContents, folder)
+	return
+}
 
-			// Filter out all files that are supposed to be ignored by the
-			// dashboard manager.
-			if err = common.FilterIgnored(&mergedContents, cfg); err != nil {
-				return err
-			}
+// getLatestCommit returns the latest commit of the given branch. The clone
+// follows the branch it checked out (i.e. the remote's default branch), so the
+// latest commit of this branch is retrieved from the local repository. For
+// other branches, it is retrieved from the remote.
+// Returns an error if there was an issue resolving the clone's branch or
+// retrieving the commit.
+func getLatestCommit(repo *git.Repository, branch string) (*object.Commit, error) {
+	current, err := repo.CurrentBranch()
+	if err != nil {
+		return nil, err
+	}
 
-			// Push the contents of the files that were added or modified to the
-			// Grafana API.
-			common.PushFiles(modified, mergedContents, client)
+	if branch == current {
+		return repo.GetLatestCommit()
+	}
 
-			// If the user requested it, delete all dashboards that were removed
-			// from the repository.
-			if delRemoved {
-				common.DeleteDashboards(removed, mergedContents, client)
-			}
+	return repo.GetBranchHead(branch)
+}
 
-			// Grafana will auto-update the version number after we pushed the new
-			// dashboards, so we use the puller mechanic to pull the updated numbers and
-			// commit them in the git repo.
-			if err = puller.PullGrafanaAndCommit(client, cfg); err != nil {
-				logrus.WithFields(logrus.Fields{
-					"error":      err,
-					"repo":       cfg.Git.User + "@" + cfg.Git.URL,
-					"clone_path": cfg.Git.ClonePath,
-				}).Error("Call to puller returned an error")
-			}
+// commitPushedVersions is called after changes have been applied to Grafana.
+// Grafana auto-updates the version number of the dashboards we pushed, and
+// returns the new numbers, which we commit in the git repo (along with the
+// dashboards' UIDs) so the puller doesn't consider them as changes made on
+// Grafana, unless the pusher's settings turn it off.
+func commitPushedVersions(
+	ctx context.Context, cfg *config.Config, versions map[string]int,
+) {
+	if !cfg.Pusher.PullsAfterPush() {
+		if len(versions) > 0 {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"dashboards": len(versions),
+			}).Info("Not committing the versions of the pushed dashboards, since post_push_pull is false")
 		}
 
-		// Update the commit and files contents to prepare for the next iteration.
-		previousCommit = latestCommit
-		previousFilesContents = filesContents
+		return
+	}
 
-		// Sleep before the next iteration.
-		time.Sleep(time.Duration(cfg.Pusher.Config.Interval) * time.Second)
+	if err := puller.CommitPushedVersions(ctx, cfg, versions); err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error":      err,
+			"repo":       cfg.Git.User + "@" + cfg.Git.URL,
+			"clone_path": cfg.Git.ClonePath,
+		}).Error("Call to puller returned an error")
 	}
 }
 
@@ -0,0 +1,194 @@
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Apply writes the given files (which contents are mapped to their paths,
+// relative to the provisioning directory from the given settings) to the
+// directory, and removes the files at the given paths from it, along with the
+// directories this leaves empty. Since the directory is watched by Grafana's
+// provisioner, the changes are applied atomically: they're applied to a copy of
+// the directory's current version, in a new directory next to it, then the
+// symbolic link at the provisioning directory's path is replaced with a link to
+// the new directory, and the previous version is removed. The provisioner
+// therefore never sees partially applied changes.
+// Returns an error if the provisioning directory's path exists but isn't a
+// symbolic link, or if there was an issue copying the current version, writing
+// or removing a file, or swapping the link.
+func Apply(
+	ctx context.Context, cfg *config.ProvisioningDirSettings,
+	written map[string][]byte, removed []string,
+) error {
+	parent := filepath.Dir(cfg.Path)
+
+	current, err := currentVersion(cfg.Path)
+	if err != nil {
+		return err
+	}
+
+	next := filepath.Join(
+		parent,
+		"."+filepath.Base(cfg.Path)+"-"+strconv.FormatInt(time.Now().UnixNano(), 10),
+	)
+
+	if len(current) > 0 {
+		err = copyDir(current, next)
+	} else {
+		err = os.MkdirAll(next, 0755)
+	}
+	if err != nil {
+		os.RemoveAll(next)
+		return err
+	}
+
+	if err = applyChanges(next, written, removed); err != nil {
+		os.RemoveAll(next)
+		return err
+	}
+
+	// Replace the link atomically, by renaming a new link over it.
+	tmp := cfg.Path + ".tmp"
+	if err = os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(next)
+		return err
+	}
+
+	if err = os.Symlink(filepath.Base(next), tmp); err != nil {
+		os.RemoveAll(next)
+		return err
+	}
+
+	if err = os.Rename(tmp, cfg.Path); err != nil {
+		os.Remove(tmp)
+		os.RemoveAll(next)
+		return err
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"path":    cfg.Path,
+		"version": next,
+		"written": len(written),
+		"removed": len(removed),
+	}).Info("Applied the changes to the provisioning directory")
+
+	// The previous version isn't used anymore, so failing to remove it
+	// doesn't affect the changes.
+	if len(current) > 0 {
+		if err = os.RemoveAll(current); err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":   err,
+				"version": current,
+			}).Warn("Failed to remove the previous version of the provisioning directory")
+		}
+	}
+
+	return nil
+}
+
+// currentVersion returns the path of the directory the symbolic link at the
+// given path points to, or an empty string if there's no link yet.
+// Returns an error if the path exists but isn't a symbolic link, or if there
+// was an issue reading the link.
+func currentVersion(linkPath string) (string, error) {
+	info, err := os.Lstat(linkPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if info.Mode()&os.ModeSymlink == 0 {
+		return "", fmt.Errorf(
+			"%s isn't a symbolic link managed by the pusher", linkPath,
+		)
+	}
+
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		return "", err
+	}
+
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(linkPath), target)
+	}
+
+	return target, nil
+}
+
+// applyChanges writes the given files and removes the given ones in the given
+// directory, along with the directories left empty by the removals.
+// Returns an error if there was an issue writing or removing a file.
+func applyChanges(dir string, written map[string][]byte, removed []string) error {
+	for filename, content := range written {
+		filePath := filepath.Join(dir, filepath.FromSlash(filename))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+			return err
+		}
+	}
+
+	for _, filename := range removed {
+		filePath := filepath.Join(dir, filepath.FromSlash(filename))
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		// Remove the parent directories left empty, which fails (and stops)
+		// on the first one which isn't.
+		for parent := filepath.Dir(filePath); parent != dir; parent = filepath.Dir(parent) {
+			if os.Remove(parent) != nil {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// copyDir copies the regular files and directories in the given source
+// directory to the given destination directory, which is created.
+// Returns an error if there was an issue walking the source directory, or
+// creating a directory or copying a file in the destination.
+func copyDir(src string, dst string) error {
+	return filepath.Walk(src, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, filePath)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(target, content, 0644)
+	})
+}
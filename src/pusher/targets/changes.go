@@ -0,0 +1,181 @@
+package targets
+
+import (
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/datasources"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+	"github.com/babolivier/grafana-dashboards-manager/src/templating"
+)
+
+// folderChanges contains the changes to apply to a single Grafana folder.
+type folderChanges struct {
+	modified []string
+	removed  []string
+	contents map[string][]byte
+}
+
+// ChangeSet groups changes to apply to Grafana by the title of their target
+// folder.
+type ChangeSet map[string]*folderChanges
+
+// Add adds a change to the file with the given name and content to the set, in
+// the given folder. If remove is true, the change is a deletion.
+func (s ChangeSet) Add(folder string, filename string, content []byte, remove bool) {
+	changes, ok := s[folder]
+	if !ok {
+		changes = &folderChanges{contents: make(map[string][]byte)}
+		s[folder] = changes
+	}
+
+	if remove {
+		changes.removed = append(changes.removed, filename)
+	} else {
+		changes.modified = append(changes.modified, filename)
+	}
+
+	changes.contents[filename] = content
+}
+
+// Files returns the names of the files changed in the given folder, whether
+// they're added/modified or removed.
+func (s ChangeSet) Files(folder string) []string {
+	changes, ok := s[folder]
+	if !ok {
+		return nil
+	}
+
+	return changes.files()
+}
+
+// files returns the names of the added/modified and removed files.
+func (c *folderChanges) files() []string {
+	files := make([]string, 0, len(c.modified)+len(c.removed))
+	files = append(files, c.modified...)
+	return append(files, c.removed...)
+}
+
+// render returns a copy of the changes with the dashboards rendered using the
+// given variables. If a dashboard failed to be rendered, returns the original
+// changes along with the error.
+func (c *folderChanges) render(
+	vars config.TemplateVariables, cfg *config.TemplatingSettings,
+) (*folderChanges, error) {
+	contents, err := templating.RenderAll(c.contents, vars, cfg)
+	if err != nil {
+		return c, err
+	}
+
+	return &folderChanges{
+		modified: c.modified,
+		removed:  c.removed,
+		contents: contents,
+	}, nil
+}
+
+// remapDatasources returns a copy of the changes with the references to data
+// sources in the dashboards rewritten using the given mapping.
+func (c *folderChanges) remapDatasources(mapping map[string]string) *folderChanges {
+	return &folderChanges{
+		modified: c.modified,
+		removed:  c.removed,
+		contents: datasources.RemapAll(c.contents, mapping),
+	}
+}
+
+// splitAlertNotifications returns the changes to the files describing
+// dashboards, and the changes to the files describing legacy alert
+// notification channels, as two separate sets of changes.
+func (c *folderChanges) splitAlertNotifications(
+	cfg *config.Config,
+) (dashboards *folderChanges, channels *folderChanges) {
+	dashboards = &folderChanges{contents: c.contents}
+	channels = &folderChanges{contents: c.contents}
+
+	for _, filename := range c.modified {
+		if cfg.IsAlertNotificationFile(filename) {
+			channels.modified = append(channels.modified, filename)
+		} else {
+			dashboards.modified = append(dashboards.modified, filename)
+		}
+	}
+
+	for _, filename := range c.removed {
+		if cfg.IsAlertNotificationFile(filename) {
+			channels.removed = append(channels.removed, filename)
+		} else {
+			dashboards.removed = append(dashboards.removed, filename)
+		}
+	}
+
+	return
+}
+
+// splitFolders returns the changes to the files describing dashboards (or
+// permissions), and the folders' metadata files that were added or modified
+// (unless folder sync is turned off). Removing a folder's metadata file leaves
+// the folder untouched, so removed files are left out.
+func (c *folderChanges) splitFolders(
+	cfg *config.Config,
+) (dashboards *folderChanges, folders []string) {
+	dashboards = &folderChanges{contents: c.contents}
+
+	for _, filename := range c.modified {
+		if common.IsFolderFile(filename, cfg) {
+			if cfg.Feature(config.FeatureFolderSync) {
+				folders = append(folders, filename)
+			}
+		} else {
+			dashboards.modified = append(dashboards.modified, filename)
+		}
+	}
+
+	for _, filename := range c.removed {
+		if !common.IsFolderFile(filename, cfg) {
+			dashboards.removed = append(dashboards.removed, filename)
+		}
+	}
+
+	return
+}
+
+// splitPermissions returns the changes to the files describing dashboards, and
+// the files describing permissions (of dashboards, or of folders) that were
+// added or modified. Removing a file describing permissions leaves the
+// permissions untouched, so removed files are left out.
+func (c *folderChanges) splitPermissions(
+	cfg *config.Config,
+) (dashboards *folderChanges, permissions []string) {
+	dashboards = &folderChanges{contents: c.contents}
+
+	for _, filename := range c.modified {
+		if common.HoldsPermissions(filename, cfg) {
+			permissions = append(permissions, filename)
+		} else {
+			dashboards.modified = append(dashboards.modified, filename)
+		}
+	}
+
+	for _, filename := range c.removed {
+		if !common.HoldsPermissions(filename, cfg) {
+			dashboards.removed = append(dashboards.removed, filename)
+		}
+	}
+
+	return
+}
+
+// keptUIDs returns the set of the UIDs of the dashboards modified by the given
+// changes, which mustn't be deleted even if a removed file has the same UID.
+func keptUIDs(changes []*folderChanges) map[string]bool {
+	kept := make(map[string]bool)
+	for _, c := range changes {
+		for _, filename := range c.modified {
+			if uid, err := helpers.GetDashboardUID(c.contents[filename]); err == nil && len(uid) > 0 {
+				kept[uid] = true
+			}
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,108 @@
+package targets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+	"github.com/babolivier/grafana-dashboards-manager/src/state"
+
+	"github.com/sirupsen/logrus"
+)
+
+// openFailureIssues opens an issue on the forge for each of the given
+// dashboards (which files' names are mapped to their slugs) which failed to be
+// pushed at least as many consecutive times as the threshold from the pusher's
+// settings, unless one was already opened since the dashboard was last pushed
+// successfully. The issue includes the latest error, the path of the file in
+// the repository, and the file's owners, if any, so the failure can be fixed by
+// the dashboard's authors. Failing to open an issue doesn't affect the push, so
+// errors are only logged.
+func (p *Pusher) openFailureIssues(
+	ctx context.Context, filenames map[string]string,
+) {
+	s, err := state.Load(p.cfg.State.Path)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error": err,
+			"state": p.cfg.State.Path,
+		}).Warn("Failed to load the state, not opening issues about failed pushes")
+
+		return
+	}
+
+	var owners map[string][]string
+	for slug, filename := range filenames {
+		d, ok := s.Dashboards[slug]
+		if !ok || d.ConsecutivePushFailures < p.cfg.Pusher.FailureIssues.Threshold ||
+			len(d.FailureIssue) > 0 {
+			continue
+		}
+
+		// Only load the owners once, and only if there's an issue to open.
+		if owners == nil {
+			if owners, err = common.LoadOwners(p.cfg); err != nil {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error": err,
+				}).Warn("Failed to load the owners of the dashboards, not mentioning them in the issues")
+
+				owners = make(map[string][]string)
+			}
+		}
+
+		fileOwners, _ := common.FileOwners(filename, owners)
+		title, description := failureIssue(
+			p.cfg.Git.RepositoryPath(filename), d, fileOwners,
+		)
+
+		issueURL, err := p.forge.OpenIssue(
+			title, description, p.cfg.Pusher.FailureIssues.Labels,
+		)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("Failed to open an issue about the dashboard's failed pushes")
+
+			continue
+		}
+
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"filename": filename,
+			"failures": d.ConsecutivePushFailures,
+			"issue":    issueURL,
+		}).Info("Opened an issue about the dashboard's failed pushes")
+
+		if err = state.RecordFailureIssue(p.cfg.State.Path, slug, issueURL); err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error": err,
+				"state": p.cfg.State.Path,
+			}).Warn("Failed to record the issue in the state")
+		}
+	}
+}
+
+// failureIssue returns the title and (Markdown) description of the issue about
+// the failed pushes of the dashboard described by the file at the given path in
+// the repository, with the given state and owners.
+func failureIssue(
+	repoPath string, d *state.DashboardState, owners []string,
+) (title string, description string) {
+	title = fmt.Sprintf("Failed to push %s to Grafana", repoPath)
+
+	description = fmt.Sprintf(
+		"The dashboard described by `%s` failed to be pushed to Grafana %d times in a row.\n\n",
+		repoPath, d.ConsecutivePushFailures,
+	)
+	description += "The latest error was:\n\n```\n" + d.LastPushError + "\n```\n\n"
+
+	if len(owners) > 0 {
+		description += "Owners of the file: " + strings.Join(owners, ", ") + "\n\n"
+	}
+
+	description += "This issue was opened by the Grafana dashboards manager. " +
+		"Another one won't be opened for this dashboard until it's pushed successfully.\n"
+
+	return
+}
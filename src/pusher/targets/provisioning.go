@@ -0,0 +1,258 @@
+package targets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/provisioning"
+	"github.com/babolivier/grafana-dashboards-manager/src/textfile"
+
+	"github.com/sirupsen/logrus"
+)
+
+// provision applies the given changes to the dashboards to the provisioning
+// directory from the pusher's settings, as a single atomic change (see
+// provisioning.Apply), and returns the target's status. The dashboards are
+// prepared for the given target (i.e. the main instance) as they are before
+// being pushed to its API, and stored in a directory named after their
+// folder's title (the "General" folder being the directory's root), so that
+// Grafana's provisioner, with the foldersFromFilesStructure option, provisions
+// them in the right folders. Since the provisioner takes care of applying the
+// dashboards, the changes to folders' metadata, permissions and legacy alert
+// notification channels are left out, and the dashboards' versions aren't
+// known. Dashboards which would be written to the same file as another one
+// (see checkCollision) are counted as failed, as are the given files which
+// failed to be prepared for the push (see fail).
+func (p *Pusher) provision(
+	ctx context.Context, target Target, set ChangeSet, failed map[string]error,
+	failedContents map[string][]byte,
+) (status targetStatus) {
+	status = targetStatus{
+		target:   target.Name,
+		versions: make(map[string]int),
+		applied:  make(map[string]map[string]bool),
+	}
+
+	if p.fail(ctx, target, failed, failedContents, &status) {
+		return
+	}
+
+	folders := make([]string, 0, len(set))
+	for folder := range set {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+
+	written := make(map[string][]byte)
+	removed := make(map[string]bool)
+	// Names of the files the written ones are provisioned from, mapped to
+	// their paths in the provisioning directory.
+	sources := make(map[string]string)
+	report := &common.PushReport{Failed: make(map[string]error)}
+	contents := make(map[string][]byte)
+	// Folders which dashboards failed to be rendered.
+	unrendered := make(map[string]bool)
+	for _, folder := range folders {
+		changes := set[folder]
+
+		if p.cfg.Pusher.Templating != nil {
+			var err error
+			if changes, err = changes.render(target.Variables, p.cfg.Pusher.Templating); err != nil {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":  err,
+					"target": target.Name,
+					"folder": folder,
+				}).Error("Failed to render the dashboards")
+
+				status.failed += len(changes.modified)
+				status.deleteFailed += len(changes.removed)
+				if p.abort(ctx, target, &status, err) {
+					return
+				}
+
+				unrendered[folder] = true
+				continue
+			}
+		}
+
+		if len(target.DatasourceMapping) > 0 {
+			changes = changes.remapDatasources(target.DatasourceMapping)
+		}
+
+		changes, _ = changes.splitAlertNotifications(p.cfg)
+		changes, _ = changes.splitFolders(p.cfg)
+		changes, _ = changes.splitPermissions(p.cfg)
+
+		for _, filename := range changes.removed {
+			removed[provisionedPath(folder, filename)] = true
+		}
+
+		for _, filename := range changes.modified {
+			provisioned := provisionedPath(folder, filename)
+
+			content, err := provisionedDashboard(changes.contents[filename])
+			if err == nil {
+				err = p.checkCollision(provisioned, filename, content, sources, removed)
+			}
+			if err != nil {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":    err,
+					"filename": filename,
+				}).Error("Failed to prepare the dashboard for provisioning")
+
+				report.Failed[filename] = err
+				contents[filename] = changes.contents[filename]
+				status.failed++
+				if p.abort(ctx, target, &status, err) {
+					return
+				}
+
+				continue
+			}
+
+			written[provisioned] = content
+			sources[provisioned] = filename
+			report.Pushed = append(report.Pushed, filename)
+			contents[filename] = changes.contents[filename]
+		}
+	}
+
+	// A file removed from a directory and added to another one of the same
+	// folder is provisioned to the same path, which must then be overwritten
+	// rather than removed.
+	toRemove := make([]string, 0, len(removed))
+	for provisioned := range removed {
+		if _, ok := written[provisioned]; !ok {
+			toRemove = append(toRemove, provisioned)
+		}
+	}
+	sort.Strings(toRemove)
+
+	if err := provisioning.Apply(ctx, p.cfg.Pusher.ProvisioningDir, written, toRemove); err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error":  err,
+			"target": target.Name,
+		}).Error("Failed to apply the changes to the provisioning directory")
+
+		for _, filename := range report.Pushed {
+			report.Failed[filename] = err
+		}
+		report.Pushed = nil
+
+		status.failed += len(written)
+		status.deleteFailed += len(toRemove)
+		p.recordPush(ctx, report, contents)
+		p.abort(ctx, target, &status, err)
+		return
+	}
+
+	status.pushed += len(written)
+	status.deleted += len(toRemove)
+	p.recordPush(ctx, report, contents)
+
+	// The changes are applied as a whole, except for the ones which failed to
+	// be prepared, and the ones left out (e.g. to folders' metadata files)
+	// don't need to be applied.
+	left, _ := failedFiles(report.Failed)
+	for folder, changes := range set {
+		if !unrendered[folder] {
+			status.markApplied(folder, changes.files(), left)
+		}
+	}
+
+	return
+}
+
+// provisionedPath returns the path, relative to the provisioning directory, of
+// the file of the dashboard from the file with the given name, in the folder
+// with the given title.
+func provisionedPath(folder string, filename string) string {
+	return path.Join(strings.Replace(folder, "/", "-", -1), path.Base(filename))
+}
+
+// checkCollision checks that the dashboard with the given content, from the
+// file with the given name, can be provisioned to the given path. Since the
+// provisioned files are named after the files they're provisioned from, files
+// with the same name in different directories of the repository which are
+// pushed to the same folder would otherwise overwrite each other. The given
+// map of the paths already written in the current batch of changes to the
+// names of the files they're provisioned from is used to detect collisions in
+// the batch, and the file currently at the path in the provisioning directory,
+// if any, is checked to describe the same dashboard (i.e. to have the same
+// UID), unless the path is in the given set of the paths removed by the batch.
+// Returns an error if the path is already used by another file in the batch, or
+// by another dashboard in the provisioning directory, or if there was an issue
+// reading the latter.
+func (p *Pusher) checkCollision(
+	provisioned string, filename string, content []byte,
+	sources map[string]string, removed map[string]bool,
+) error {
+	if source, ok := sources[provisioned]; ok {
+		return fmt.Errorf(
+			"provisioned to %s, as is %s, which is pushed to the same folder",
+			provisioned, source,
+		)
+	}
+
+	if removed[provisioned] {
+		return nil
+	}
+
+	existing, err := textfile.Read(filepath.Join(
+		p.cfg.Pusher.ProvisioningDir.Path, filepath.FromSlash(provisioned),
+	))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	existingUID, err := helpers.GetDashboardUID(existing)
+	if err != nil {
+		return err
+	}
+
+	uid, err := helpers.GetDashboardUID(content)
+	if err != nil {
+		return err
+	}
+
+	if len(existingUID) > 0 && existingUID != uid {
+		return fmt.Errorf(
+			"provisioned to %s, which already describes the dashboard with the UID %s",
+			provisioned, existingUID,
+		)
+	}
+
+	return nil
+}
+
+// provisionedDashboard returns the given JSON description of a dashboard
+// without its ID, which is specific to the Grafana instance it was pulled from
+// and would conflict with the provisioned dashboards' own IDs. Numbers are
+// decoded as json.Number so they're re-encoded as they were, rather than as
+// floats.
+// Returns an error if the description couldn't be parsed or re-encoded.
+func provisionedDashboard(content []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	var dashboard map[string]interface{}
+	if err := decoder.Decode(&dashboard); err != nil {
+		return nil, err
+	}
+
+	delete(dashboard, "id")
+
+	return json.MarshalIndent(dashboard, "", "  ")
+}
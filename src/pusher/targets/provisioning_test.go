@@ -0,0 +1,98 @@
+package targets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+)
+
+func TestCheckCollision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gdm-provisioning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = os.MkdirAll(filepath.Join(dir, "Team"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(
+		filepath.Join(dir, "Team", "existing.json"), []byte(`{"uid": "abc"}`), 0644,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Pusher{cfg: &config.Config{Pusher: &config.PusherSettings{
+		ProvisioningDir: &config.ProvisioningDirSettings{Path: dir},
+	}}}
+
+	tests := []struct {
+		name        string
+		provisioned string
+		content     string
+		sources     map[string]string
+		removed     map[string]bool
+		collides    bool
+	}{
+		{
+			name:        "new file",
+			provisioned: "Team/new.json",
+			content:     `{"uid": "def"}`,
+		},
+		{
+			name:        "same dashboard",
+			provisioned: "Team/existing.json",
+			content:     `{"uid": "abc"}`,
+		},
+		{
+			name:        "other dashboard",
+			provisioned: "Team/existing.json",
+			content:     `{"uid": "def"}`,
+			collides:    true,
+		},
+		{
+			name:        "other dashboard replacing a removed one",
+			provisioned: "Team/existing.json",
+			content:     `{"uid": "def"}`,
+			removed:     map[string]bool{"Team/existing.json": true},
+		},
+		{
+			name:        "same batch",
+			provisioned: "Team/new.json",
+			content:     `{"uid": "def"}`,
+			sources:     map[string]string{"Team/new.json": "a/new.json"},
+			collides:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := p.checkCollision(
+				test.provisioned, "b/new.json", []byte(test.content), test.sources,
+				test.removed,
+			)
+			if test.collides && err == nil {
+				t.Error("expected a collision")
+			} else if !test.collides && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestProvisionedDashboard(t *testing.T) {
+	content, err := provisionedDashboard(
+		[]byte(`{"id": 3, "uid": "abc", "panels": [{"id": 9007199254740993}]}`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "{\n  \"panels\": [\n    {\n      \"id\": 9007199254740993\n    }\n  ],\n  \"uid\": \"abc\"\n}"
+	if string(content) != expected {
+		t.Errorf("expected %s, got %s", expected, content)
+	}
+}
@@ -0,0 +1,800 @@
+package targets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/forge"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana/helpers"
+	"github.com/babolivier/grafana-dashboards-manager/src/migrate"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+	"github.com/babolivier/grafana-dashboards-manager/src/state"
+
+	"github.com/sirupsen/logrus"
+)
+
+// retryBackoff is the time to wait before the first retry of a failed push or
+// deletion. It doubles with each retry.
+const retryBackoff = 5 * time.Second
+
+// Target is a Grafana instance changes are pushed to. Variables are the ones
+// dashboards are rendered with before being pushed to the instance, if
+// templating is enabled. DatasourceMapping maps the data sources referenced by
+// the dashboards to the ones to reference on the instance.
+type Target struct {
+	Name              string
+	Client            *grafana.Client
+	Variables         config.TemplateVariables
+	DatasourceMapping map[string]string
+}
+
+// targetStatus summarises the outcome of applying a set of changes to a
+// target.
+type targetStatus struct {
+	target       string
+	pushed       int
+	failed       int
+	unhealthy    int
+	drifted      int
+	deleted      int
+	deleteFailed int
+	skipped      int
+	// Number of folders deleted because the deletions left them empty.
+	prunedFolders int
+	// Versions of the dashboards that were pushed to the target, mapped to
+	// their keys in the versions file (see grafana.VersionKey).
+	versions map[string]int
+	// Error that aborted applying the changes to the target, as required by
+	// the "fail-fast" error policy, if any.
+	err error
+	// Names of the files which changes were applied to the target (or didn't
+	// need to be), by title of the folder they were applied to.
+	applied map[string]map[string]bool
+}
+
+// Pusher applies sets of changes to all of the Grafana targets: the main
+// instance and the additional targets from the pusher's settings. Issues about
+// failed pushes are opened on the given forge, if any.
+type Pusher struct {
+	cfg     *config.Config
+	targets []Target
+	forge   *forge.Client
+}
+
+// NewPusher creates a new instance of the Pusher structure, which applies
+// changes using the given Grafana client, and to the additional targets from
+// the pusher's settings. Issues about failed pushes are opened on the forge
+// from the configuration if the pusher's settings require it.
+func NewPusher(cfg *config.Config, client *grafana.Client) *Pusher {
+	p := &Pusher{
+		cfg:     cfg,
+		targets: newTargets(cfg, client),
+	}
+
+	if cfg.Pusher.FailureIssues != nil {
+		p.forge = forge.NewClient(cfg.Forge)
+	}
+
+	return p
+}
+
+// Folder returns the title of the Grafana folder the dashboard described by the
+// given content, in the file with the given name, must be pushed to (see
+// common.TargetFolder), or the folder it currently is in on the main instance
+// while the repository is being migrated to the "folders" layout (see
+// common.CompatFolder). If the file's directory isn't mapped to any folder,
+// this is the given default folder.
+// Returns an error if there was an issue looking up the dashboard's current
+// folder.
+func (p *Pusher) Folder(
+	ctx context.Context, filename string, content []byte, defaultFolder string,
+) (string, error) {
+	return common.CompatFolder(
+		ctx, filename, content,
+		common.TargetFolder(filename, defaultFolder, p.cfg), p.targets[0].Client,
+		p.cfg,
+	)
+}
+
+// RecordFailures records the given files, which failed to be prepared for a
+// push (mapped to their errors), with the given contents, as failed in the
+// state, as a failed push to the main instance would be (see recordPush).
+// Returns one of the errors, for the "fail-fast" error policy.
+func (p *Pusher) RecordFailures(
+	ctx context.Context, failed map[string]error, contents map[string][]byte,
+) error {
+	p.recordPush(ctx, &common.PushReport{Failed: failed}, contents)
+
+	_, err := failedFiles(failed)
+	return err
+}
+
+// newTargets returns the targets to push changes to: the Grafana instance the
+// given client talks to (named "default"), followed by the additional targets
+// from the pusher's settings.
+func newTargets(cfg *config.Config, client *grafana.Client) []Target {
+	main := Target{
+		Name:              "default",
+		Client:            client,
+		DatasourceMapping: cfg.Pusher.DatasourceMapping,
+	}
+	if cfg.Pusher.Templating != nil {
+		main.Variables = cfg.Pusher.Templating.Variables
+	}
+
+	targets := []Target{main}
+	for i := range cfg.Pusher.Targets {
+		targets = append(targets, Target{
+			Name:              cfg.Pusher.Targets[i].Name,
+			Client:            grafana.NewClientFromConfig(&cfg.Pusher.Targets[i].Grafana),
+			Variables:         cfg.Pusher.Targets[i].Variables,
+			DatasourceMapping: cfg.Pusher.Targets[i].DatasourceMapping,
+		})
+	}
+
+	return targets
+}
+
+// Push applies the given changes to all of the targets concurrently, then logs
+// the status of each target. Dashboards are migrated first if the pusher's
+// settings require it. The changes are applied to the main instance's
+// provisioning directory instead of its API if the pusher's settings require it
+// (see provision). The given files, which failed to be prepared for the push
+// (mapped to their errors), with the given contents, are counted as failed on
+// each target (see fail).
+// Returns the versions of the dashboards that were pushed to the main Grafana
+// instance, mapped to their keys in the versions file (see grafana.VersionKey),
+// and the changes from the set which weren't applied to all of the targets
+// (e.g. because they failed, or were skipped when applying the changes was
+// aborted).
+// Returns an error if applying the changes to one of the targets was aborted
+// because of the "fail-fast" error policy.
+func (p *Pusher) Push(
+	ctx context.Context, set ChangeSet, failed map[string]error,
+	contents map[string][]byte,
+) (map[string]int, ChangeSet, error) {
+	// Migrate legacy dashboards if requested. This doesn't depend on the
+	// target, so it's only done once.
+	if len(p.cfg.Pusher.Migrations) > 0 {
+		for _, changes := range set {
+			changes.contents = migrate.MigrateAll(changes.contents, p.cfg.Pusher.Migrations)
+		}
+	}
+
+	statuses := make([]targetStatus, len(p.targets))
+
+	var wg sync.WaitGroup
+	for i, target := range p.targets {
+		wg.Add(1)
+		go func(i int, target Target) {
+			defer wg.Done()
+
+			// The main instance's dashboards are written to its provisioning
+			// directory rather than pushed using its API, if requested.
+			if i == 0 && p.cfg.Pusher.ProvisioningDir != nil {
+				statuses[i] = p.provision(ctx, target, set, failed, contents)
+				return
+			}
+
+			statuses[i] = p.pushToTarget(ctx, target, set, failed, contents)
+		}(i, target)
+	}
+	wg.Wait()
+
+	var err error
+	for _, status := range statuses {
+		status.log(ctx)
+
+		if status.err != nil && err == nil {
+			err = fmt.Errorf("applying changes to %s aborted: %v", status.target, status.err)
+		}
+	}
+
+	unapplied := make(ChangeSet)
+	for folder, changes := range set {
+		for _, filename := range changes.modified {
+			if !appliedToAll(statuses, folder, filename) {
+				unapplied.Add(folder, filename, changes.contents[filename], false)
+			}
+		}
+
+		for _, filename := range changes.removed {
+			if !appliedToAll(statuses, folder, filename) {
+				unapplied.Add(folder, filename, changes.contents[filename], true)
+			}
+		}
+	}
+
+	// The main instance is always the first target.
+	return statuses[0].versions, unapplied, err
+}
+
+// appliedToAll checks whether the change to the file with the given name, in
+// the folder with the given title, was applied to all of the targets which
+// statuses are given.
+func appliedToAll(statuses []targetStatus, folder string, filename string) bool {
+	for _, status := range statuses {
+		if !status.applied[folder][filename] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pushToTarget applies the given changes to a single target, retrying the
+// failed pushes and deletions as many times as the pusher's settings allow, and
+// returns the target's status. Dashboards are pushed to all folders before any
+// is deleted, so a dashboard which file was renamed or moved to another folder
+// (and which is identified by the same UID) is updated rather than deleted. The
+// dashboards' permissions are applied once all of the dashboards have been
+// pushed, so that new dashboards exist when their permissions are applied.
+// Folders' metadata files are applied before the dashboards of their folders
+// are pushed, and the dashboards are then pushed to the folders they describe,
+// so that a folder renamed or moved in the repository is renamed or moved on
+// Grafana rather than duplicated. The legacy alert notification channels of
+// all the folders are applied before any dashboard is pushed. Folders left
+// empty by the deletions are deleted if the pusher's settings require it.
+// With the "fail-fast" error policy, the first change that can't be applied
+// aborts applying the remaining ones, which are counted as skipped. The given
+// files which failed to be prepared for the push are counted as failed (see
+// fail).
+func (p *Pusher) pushToTarget(
+	ctx context.Context, target Target, set ChangeSet,
+	failed map[string]error, contents map[string][]byte,
+) (status targetStatus) {
+	status = targetStatus{
+		target:   target.Name,
+		versions: make(map[string]int),
+		applied:  make(map[string]map[string]bool),
+	}
+
+	total := len(failed)
+	for _, changes := range set {
+		total += len(changes.modified)
+		for _, filename := range changes.removed {
+			if !common.HoldsPermissions(filename, p.cfg) && !common.IsFolderFile(filename, p.cfg) {
+				total++
+			}
+		}
+	}
+
+	// The changes which were neither applied nor failed were skipped because
+	// of an error.
+	defer func() {
+		status.skipped = total - status.pushed - status.failed - status.deleted -
+			status.deleteFailed
+	}()
+
+	if p.fail(ctx, target, failed, contents, &status) {
+		return
+	}
+
+	// Iterate over the folders in a stable order so that logs are easier to
+	// follow.
+	folders := make([]string, 0, len(set))
+	for folder := range set {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+
+	// Prepare the changes of every folder for the target, and set the
+	// alert notification channels aside, since they don't belong to any
+	// folder.
+	prepared := make(map[string]*folderChanges, len(folders))
+	channels := &folderChanges{contents: make(map[string][]byte)}
+	channelFiles := make(map[string][]string)
+	for _, folder := range folders {
+		changes := set[folder]
+
+		// Render the dashboards with the target's variables if requested. The
+		// removed dashboards are rendered too, since their slugs can depend on
+		// the variables.
+		if p.cfg.Pusher.Templating != nil {
+			var err error
+			if changes, err = changes.render(target.Variables, p.cfg.Pusher.Templating); err != nil {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":  err,
+					"target": target.Name,
+					"folder": folder,
+				}).Error("Failed to render the dashboards")
+
+				status.failed += len(changes.modified)
+				status.deleteFailed += len(changes.removed)
+				if p.abort(ctx, target, &status, err) {
+					return
+				}
+
+				continue
+			}
+		}
+
+		// Point the dashboards to the target's data sources if requested.
+		if len(target.DatasourceMapping) > 0 {
+			changes = changes.remapDatasources(target.DatasourceMapping)
+		}
+
+		var folderChannels *folderChanges
+		prepared[folder], folderChannels = changes.splitAlertNotifications(p.cfg)
+		channels.modified = append(channels.modified, folderChannels.modified...)
+		channels.removed = append(channels.removed, folderChannels.removed...)
+		channelFiles[folder] = folderChannels.files()
+		for _, filename := range channelFiles[folder] {
+			channels.contents[filename] = folderChannels.contents[filename]
+		}
+	}
+
+	// Apply all of the alert notification channels before any dashboard, since
+	// the dashboards' legacy alerts can notify them.
+	left, err := p.applyAlertNotifications(ctx, target, channels, &status)
+	for folder, filenames := range channelFiles {
+		status.markApplied(folder, filenames, left)
+	}
+
+	if p.abort(ctx, target, &status, err) {
+		return
+	}
+
+	pushed := make([]*folderChanges, 0, len(folders))
+	pushedFolders := make([]string, 0, len(folders))
+	permissions := &folderChanges{contents: make(map[string][]byte)}
+	permissionFiles := make(map[string][]string)
+	for _, folder := range folders {
+		changes, ok := prepared[folder]
+		if !ok {
+			continue
+		}
+
+		// The folders' metadata files also hold the folders' permissions, which
+		// are applied along with the rest of the metadata.
+		all := changes
+		var folderFiles []string
+		changes, folderFiles = changes.splitFolders(p.cfg)
+
+		var folderPermissions []string
+		changes, folderPermissions = changes.splitPermissions(p.cfg)
+		for _, filename := range folderPermissions {
+			permissions.modified = append(permissions.modified, filename)
+			permissions.contents[filename] = changes.contents[filename]
+		}
+		permissionFiles[folder] = folderPermissions
+
+		// The changes left out by the splits (e.g. removed folders' metadata
+		// files) don't need to be applied.
+		status.markApplied(folder, all.files(), append(
+			append(changes.files(), folderFiles...), folderPermissions...,
+		))
+
+		if len(changes.modified) == 0 && len(changes.removed) == 0 && len(folderFiles) == 0 {
+			continue
+		}
+
+		// Identify the folder by the UID from its metadata file if it changed,
+		// since its title on Grafana can differ until the file is applied,
+		// else by its title.
+		var folderID int
+		var err error
+		if len(folderFiles) > 0 {
+			folderID, err = p.pushFolders(
+				ctx, target, folder, folderFiles, changes.contents, &status,
+			)
+		} else {
+			err = p.retry(ctx, target, func() (err error) {
+				folderID, err = target.Client.GetFolderID(ctx, folder)
+				return
+			})
+		}
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":  err,
+				"target": target.Name,
+				"folder": folder,
+			}).Error("Failed to retrieve the Grafana folder")
+
+			status.failed += len(changes.modified)
+			status.deleteFailed += len(changes.removed)
+			if p.abort(ctx, target, &status, err) {
+				return
+			}
+
+			continue
+		}
+
+		// Only retry the files that failed to be pushed, along with the ones
+		// that were skipped because of the failure.
+		toPush := changes.modified
+
+		// The versions recorded in the repository are the ones from the main
+		// instance, so conflicts can only be detected on it.
+		var versions map[string]int
+		if target.Client == p.targets[0].Client {
+			if versions, err = common.RecordedVersions(p.cfg); err != nil {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":  err,
+					"target": target.Name,
+				}).Error("Failed to read the recorded versions, not checking for conflicts")
+			}
+		}
+
+		// The outcome of the push is gathered across the attempts, and only
+		// recorded once they're over, so files which are pushed when retried
+		// aren't recorded as failed.
+		outcome := &common.PushReport{
+			Pushed:   make([]string, 0),
+			Failed:   make(map[string]error),
+			Rejected: make(map[string]error),
+		}
+
+		var pushErr error
+		p.retry(ctx, target, func() error {
+			report := common.PushFiles(ctx, toPush, changes.contents, folderID, target.Client, versions, p.cfg)
+			status.pushed += len(report.Pushed)
+			for key, version := range report.Versions {
+				status.versions[key] = version
+			}
+
+			outcome.Pushed = append(outcome.Pushed, report.Pushed...)
+			for filename, err := range report.Rejected {
+				outcome.Rejected[filename] = err
+			}
+			outcome.Failed = report.Failed
+
+			status.failed += len(report.Rejected)
+			status.unhealthy += len(report.Unhealthy)
+			status.drifted += len(report.Drifted)
+
+			// Rejected and unhealthy dashboards aren't retried.
+			for _, errs := range []map[string]error{report.Rejected, report.Unhealthy} {
+				if _, err := failedFiles(errs); err != nil && pushErr == nil {
+					pushErr = err
+				}
+			}
+
+			var err error
+			toPush, err = failedFiles(report.Failed)
+			toPush = append(toPush, report.Skipped...)
+			return err
+		})
+		status.failed += len(toPush)
+		status.markApplied(folder, outcome.Pushed, nil)
+
+		// Only the syncs with the main instance are recorded in the state.
+		if target.Client == p.targets[0].Client {
+			p.recordPush(ctx, outcome, changes.contents)
+		}
+
+		if len(toPush) > 0 && pushErr == nil {
+			pushErr = fmt.Errorf("%d dashboard(s) failed to be pushed", len(toPush))
+		}
+
+		if p.abort(ctx, target, &status, pushErr) {
+			return
+		}
+
+		pushed = append(pushed, changes)
+		pushedFolders = append(pushedFolders, folder)
+	}
+
+	toApply := permissions.modified
+	err = p.retry(ctx, target, func() error {
+		failed := common.PushPermissions(ctx, toApply, permissions.contents, target.Client, p.cfg)
+		status.pushed += len(toApply) - len(failed)
+
+		var err error
+		toApply, err = failedFiles(failed)
+		return err
+	})
+	status.failed += len(toApply)
+	for folder, filenames := range permissionFiles {
+		status.markApplied(folder, filenames, toApply)
+	}
+
+	if p.abort(ctx, target, &status, err) {
+		return
+	}
+
+	kept := keptUIDs(pushed)
+	for i, changes := range pushed {
+		deleted := status.deleted
+		toDelete := changes.removed
+		err := p.retry(ctx, target, func() error {
+			failed := common.DeleteDashboards(
+				ctx, toDelete, changes.contents, kept, target.Client, p.cfg,
+			)
+			status.deleted += len(toDelete) - len(failed)
+
+			var err error
+			toDelete, err = failedFiles(failed)
+			return err
+		})
+		status.deleteFailed += len(toDelete)
+		status.markApplied(pushedFolders[i], changes.removed, toDelete)
+
+		if p.abort(ctx, target, &status, err) {
+			return
+		}
+
+		// Delete the folder if the deletions left it empty, and the pusher's
+		// settings require it.
+		if status.deleted > deleted {
+			pruned, err := common.PruneFolder(ctx, pushedFolders[i], target.Client, p.cfg)
+			if err != nil {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"error":  err,
+					"target": target.Name,
+					"folder": pushedFolders[i],
+				}).Warn("Failed to delete the folder left empty by the deletions")
+			} else if pruned {
+				status.prunedFolders++
+			}
+		}
+	}
+
+	return
+}
+
+// pushFolders applies the given folders' metadata files, from the folder with
+// the given title, to a single target, retrying each file as many times as the
+// pusher's settings allow, and updates the target's status. If a file still
+// can't be applied after the retries, the following ones are counted as failed
+// without being applied.
+// Returns the ID of the folder described by the files, which all map to the
+// same folder.
+// Returns an error if a file couldn't be applied.
+func (p *Pusher) pushFolders(
+	ctx context.Context, target Target, folder string, filenames []string,
+	contents map[string][]byte, status *targetStatus,
+) (folderID int, err error) {
+	for i, filename := range filenames {
+		var pushed *grafana.Folder
+		err = p.retry(ctx, target, func() (err error) {
+			pushed, err = common.PushFolder(ctx, contents[filename], target.Client, p.cfg)
+			return
+		})
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":    err,
+				"target":   target.Name,
+				"filename": filename,
+			}).Error("Failed to apply the folder's metadata")
+
+			status.failed += len(filenames) - i
+			return
+		}
+
+		status.pushed++
+		status.markApplied(folder, []string{filename}, nil)
+		folderID = pushed.ID
+	}
+
+	return
+}
+
+// fail counts the given files, which failed to be prepared for the push (e.g.
+// because their dashboard's current folder couldn't be looked up), with the
+// given contents, as failed in the given target's status, and records them as
+// failed in the state if the target is the main instance.
+// Returns whether applying the changes to the target must be aborted because
+// of the failures (see abort).
+func (p *Pusher) fail(
+	ctx context.Context, target Target, failed map[string]error,
+	contents map[string][]byte, status *targetStatus,
+) bool {
+	if len(failed) == 0 {
+		return false
+	}
+
+	status.failed += len(failed)
+
+	if target.Client == p.targets[0].Client {
+		p.recordPush(ctx, &common.PushReport{Failed: failed}, contents)
+	}
+
+	_, err := failedFiles(failed)
+	return p.abort(ctx, target, status, err)
+}
+
+// abort checks whether applying changes to the given target must be aborted
+// because of the given error, i.e. if the error isn't nil and the error policy
+// is "fail-fast". If so, the error is recorded in the target's status.
+func (p *Pusher) abort(
+	ctx context.Context, target Target, status *targetStatus, err error,
+) bool {
+	if err == nil || !p.cfg.FailFast() {
+		return false
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"error":  err,
+		"target": target.Name,
+	}).Error("Aborting applying the remaining changes to the target")
+
+	status.err = err
+	return true
+}
+
+// applyAlertNotifications pushes the given changes to legacy alert notification
+// channels to a single target, retrying the failed pushes and deletions as many
+// times as the pusher's settings allow, and updates the target's status.
+// Returns the names of the files which changes weren't applied.
+// Returns an error if a change still couldn't be applied after the retries. With
+// the "fail-fast" error policy, deletions aren't attempted if a push failed.
+func (p *Pusher) applyAlertNotifications(
+	ctx context.Context, target Target, channels *folderChanges,
+	status *targetStatus,
+) ([]string, error) {
+	toPush := channels.modified
+	err := p.retry(ctx, target, func() error {
+		failed := common.PushAlertNotifications(ctx, toPush, channels.contents, target.Client)
+		status.pushed += len(toPush) - len(failed)
+
+		var err error
+		toPush, err = failedFiles(failed)
+		return err
+	})
+	status.failed += len(toPush)
+
+	if err != nil && p.cfg.FailFast() {
+		return append(toPush, channels.removed...), err
+	}
+
+	toDelete := channels.removed
+	deleteErr := p.retry(ctx, target, func() error {
+		failed := common.DeleteAlertNotifications(ctx, toDelete, channels.contents, target.Client)
+		status.deleted += len(toDelete) - len(failed)
+
+		var err error
+		toDelete, err = failedFiles(failed)
+		return err
+	})
+	status.deleteFailed += len(toDelete)
+
+	if err == nil {
+		err = deleteErr
+	}
+
+	return append(toPush, toDelete...), err
+}
+
+// retry calls the given function until it succeeds or the number of retries
+// allowed by the pusher's settings is reached, with an exponential backoff
+// between attempts. Errors which retrying can't fix (e.g. a dashboard rejected
+// by Grafana, see grafana.IsRetryable) aren't retried.
+// Returns the error from the last attempt, if any.
+func (p *Pusher) retry(
+	ctx context.Context, target Target, f func() error,
+) (err error) {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		if err = f(); err == nil || attempt >= *p.cfg.Pusher.Retries {
+			return
+		}
+
+		if !grafana.IsRetryable(err) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"error":  err,
+				"target": target.Name,
+			}).Warn("Failed to apply changes to the target, not retrying since the error isn't transient")
+
+			return
+		}
+
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error":   err,
+			"target":  target.Name,
+			"attempt": attempt + 1,
+			"backoff": backoff,
+		}).Warn("Failed to apply changes to the target, retrying")
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// recordPush records the outcome of the given push in the state, if the
+// settings require it, then opens issues about the dashboards which failed to
+// be pushed too many times in a row, if requested (see openFailureIssues).
+// Files which slug couldn't be computed are left out.
+// Failing to record the push doesn't affect it, so errors are only logged.
+func (p *Pusher) recordPush(
+	ctx context.Context, report *common.PushReport,
+	contents map[string][]byte,
+) {
+	if p.cfg.State == nil {
+		return
+	}
+
+	pushed := make([]string, 0, len(report.Pushed))
+	for _, filename := range report.Pushed {
+		if slug, err := helpers.GetDashboardSlug(contents[filename]); err == nil {
+			pushed = append(pushed, slug)
+		}
+	}
+
+	failed := make(map[string]error)
+	failedFilenames := make(map[string]string)
+	for _, errs := range []map[string]error{report.Failed, report.Rejected} {
+		for filename, pushErr := range errs {
+			if slug, err := helpers.GetDashboardSlug(contents[filename]); err == nil {
+				failed[slug] = pushErr
+				failedFilenames[slug] = filename
+			}
+		}
+	}
+
+	if err := state.RecordPush(p.cfg.State.Path, pushed, failed, time.Now()); err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error": err,
+			"state": p.cfg.State.Path,
+		}).Warn("Failed to record the push in the state")
+
+		return
+	}
+
+	if p.forge != nil && len(failed) > 0 {
+		p.openFailureIssues(ctx, failedFilenames)
+	}
+}
+
+// markApplied records the changes to the given files, in the folder with the
+// given title, as applied to the target, except for the changes to the files
+// in left, which are still left to apply.
+func (s *targetStatus) markApplied(folder string, filenames []string, left []string) {
+	isLeft := make(map[string]bool, len(left))
+	for _, filename := range left {
+		isLeft[filename] = true
+	}
+
+	if s.applied[folder] == nil {
+		s.applied[folder] = make(map[string]bool)
+	}
+
+	for _, filename := range filenames {
+		if !isLeft[filename] {
+			s.applied[folder][filename] = true
+		}
+	}
+}
+
+// failedFiles returns the names of the files in the given map of errors, along
+// with one of the errors (or nil if the map is empty), which is a transient one
+// if there's any, so the files are retried as long as one of them might
+// succeed.
+func failedFiles(failed map[string]error) (filenames []string, err error) {
+	filenames = make([]string, 0, len(failed))
+	for filename, fileErr := range failed {
+		filenames = append(filenames, filename)
+		if err == nil || (!grafana.IsRetryable(err) && grafana.IsRetryable(fileErr)) {
+			err = fileErr
+		}
+	}
+
+	return
+}
+
+// log logs the status of the target, as an error if at least one change
+// couldn't be applied, else as an information.
+func (s targetStatus) log(ctx context.Context) {
+	entry := logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"target":         s.target,
+		"pushed":         s.pushed,
+		"failed":         s.failed,
+		"unhealthy":      s.unhealthy,
+		"drifted":        s.drifted,
+		"deleted":        s.deleted,
+		"delete_failed":  s.deleteFailed,
+		"skipped":        s.skipped,
+		"pruned_folders": s.prunedFolders,
+	})
+
+	if s.failed > 0 || s.unhealthy > 0 || s.deleteFailed > 0 || s.skipped > 0 {
+		entry.Error("Target status: some changes failed to be applied")
+	} else {
+		entry.Info("Target status: all changes were applied")
+	}
+}
@@ -0,0 +1,38 @@
+package targets
+
+import (
+	"testing"
+)
+
+func TestAppliedToAll(t *testing.T) {
+	main := targetStatus{applied: make(map[string]map[string]bool)}
+	main.markApplied("Team", []string{"a.json", "b.json"}, []string{"b.json"})
+	main.markApplied("Other", []string{"a.json"}, nil)
+
+	mirror := targetStatus{applied: make(map[string]map[string]bool)}
+	mirror.markApplied("Team", []string{"a.json", "b.json"}, nil)
+
+	statuses := []targetStatus{main, mirror}
+
+	tests := []struct {
+		folder   string
+		filename string
+		applied  bool
+	}{
+		{"Team", "a.json", true},
+		// Left to apply to the main instance.
+		{"Team", "b.json", false},
+		// Not applied to the mirror, e.g. because the push was aborted.
+		{"Other", "a.json", false},
+		{"Team", "c.json", false},
+	}
+
+	for _, tt := range tests {
+		if applied := appliedToAll(statuses, tt.folder, tt.filename); applied != tt.applied {
+			t.Errorf(
+				"appliedToAll(%q, %q) = %v, want %v",
+				tt.folder, tt.filename, applied, tt.applied,
+			)
+		}
+	}
+}
@@ -1,164 +1,649 @@
 package webhook
 
 import (
-	"io/ioutil"
+	"context"
+	"net/http"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	"config"
-	"git"
-	"grafana"
-	puller "puller"
-	"pusher/common"
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/git"
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/logger"
+	puller "github.com/babolivier/grafana-dashboards-manager/src/puller"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/common"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/freeze"
+	"github.com/babolivier/grafana-dashboards-manager/src/pusher/pause"
+	"github.com/babolivier/grafana-dashboards-manager/src/textfile"
 
+	"github.com/go-playground/webhooks/v6/gitlab"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/go-playground/webhooks.v3"
-	"gopkg.in/go-playground/webhooks.v3/gitlab"
 )
 
-// Some variables need to be global to the package since we need them in the
-// webhook handlers.
-var (
-	grafanaClient *grafana.Client
+// Webhook handles the push events GitLab sends for a given configuration. It
+// implements http.Handler, so several webhooks (e.g. for different
+// repositories) can be exposed by the same process, on different paths.
+// Push events are processed one at a time, in the order they were received:
+// the ones received while another is being processed wait in pending, and
+// processing is true while there's a goroutine processing them. The files'
+// contents are read from the disk for pushes to the branch checked out in the
+// clone (branch), and from the branch's history for the others.
+type Webhook struct {
 	cfg           *config.Config
+	client        *grafana.Client
 	deleteRemoved bool
 	repo          *git.Repository
-)
+	branch        string
+	queue         *freeze.Queue
+	maintainer    *git.Maintainer
+	hook          *gitlab.Webhook
+	handler       http.Handler
+	pending       []pushEvent
+	processing    bool
+	pendingMutex  sync.Mutex
+	// Errors which must stop the pusher, because of the "fail-fast" error
+	// policy.
+	errs chan error
+}
+
+// pushEvent is a push event queued to be processed, along with the ID of the
+// sync run it was given when it was received (see handleEvent).
+type pushEvent struct {
+	payload gitlab.PushEventPayload
+	runID   string
+}
 
-// Setup creates and exposes a GitLab webhook using a given configuration.
-// Returns an error if the webhook couldn't be set up.
-func Setup(conf *config.Config, client *grafana.Client, delRemoved bool) (err error) {
-	cfg = conf
-	grafanaClient = client
-	deleteRemoved = delRemoved
+// New loads (and synchronises if needed) the Git repository mentioned in the
+// given configuration, and creates a webhook pushing the changes made to it to
+// the given Grafana client, unless the pushes are paused with the given switch.
+// It also starts watching for the freeze to lift in order to apply the changes
+// queued during a freeze.
+// Returns an error if the repository couldn't be loaded or synchronised, its
+// checked out branch resolved, or if the freeze queue couldn't be initialised.
+func New(
+	cfg *config.Config, client *grafana.Client, deleteRemoved bool,
+	paused *pause.Switch,
+) (*Webhook, error) {
+	wh := &Webhook{
+		cfg:           cfg,
+		client:        client,
+		deleteRemoved: deleteRemoved,
+		maintainer:    git.NewMaintainer(cfg.Git),
+		errs:          make(chan error, 1),
+	}
 
 	// Load the Git repository.
 	var needsSync bool
-	repo, needsSync, err = git.NewRepository(cfg.Git)
+	var err error
+	wh.repo, needsSync, err = git.NewRepository(cfg.Git)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Synchronise the repository if needed.
 	if needsSync {
-		if err = repo.Sync(false); err != nil {
-			return err
+		if err = wh.repo.Sync(false); err != nil {
+			return nil, err
 		}
 	}
 
-	// Initialise the webhook
-	hook := gitlab.New(&gitlab.Config{
-		Secret: cfg.Pusher.Config.Secret,
+	if wh.branch, err = wh.repo.CurrentBranch(); err != nil {
+		return nil, err
+	}
+
+	// Initialise the queue that will hold changes during a freeze, and watch
+	// for the freeze to lift.
+	if wh.queue, err = freeze.NewQueue(cfg, client, paused); err != nil {
+		return nil, err
+	}
+
+	go wh.queue.Watch(time.Minute, func(
+		ctx context.Context, versions map[string]int, err error,
+	) {
+		unlock := git.LockClone(cfg.Git.ClonePath)
+		wh.commitPushedVersions(ctx, versions)
+		unlock()
+
+		if err != nil {
+			wh.fail(ctx, err, logrus.Fields{}, "Failed to apply the queued changes")
+		}
 	})
-	// Register the handler
-	hook.RegisterEvents(HandlePush, gitlab.PushEvents)
+
+	// Initialise the webhook and register the handler.
+	if wh.hook, err = gitlab.New(gitlab.Options.Secret(cfg.Pusher.Config.Secret)); err != nil {
+		return nil, err
+	}
+
+	wh.handler = http.HandlerFunc(wh.handleEvent)
+
+	return wh, nil
+}
+
+// ServeHTTP implements http.Handler by processing the GitLab event sent in the
+// given request.
+func (wh *Webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wh.handler.ServeHTTP(w, r)
+}
+
+// Errors returns a channel on which the errors encountered while handling push
+// events are sent if the error policy is "fail-fast". Only the first of these
+// errors is sent.
+func (wh *Webhook) Errors() <-chan error {
+	return wh.errs
+}
+
+// Setup creates and exposes a GitLab webhook using a given configuration (see
+// New).
+// Returns an error if the webhook couldn't be set up, or if an error was
+// encountered while handling a push event and the error policy is
+// "fail-fast".
+func Setup(
+	cfg *config.Config, client *grafana.Client, delRemoved bool,
+	paused *pause.Switch,
+) error {
+	wh, err := New(cfg, client, delRemoved, paused)
+	if err != nil {
+		return err
+	}
 
 	// Expose the webhook
-	return webhooks.Run(
-		hook,
-		cfg.Pusher.Config.Interface+":"+cfg.Pusher.Config.Port,
-		cfg.Pusher.Config.Path,
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Pusher.Config.Path, wh)
+
+	addr := cfg.Pusher.Config.Interface + ":" + cfg.Pusher.Config.Port
+	logrus.WithFields(logrus.Fields{
+		"address": addr,
+		"path":    cfg.Pusher.Config.Path,
+	}).Info("Exposing the webhook")
+
+	serveErrs := make(chan error, 1)
+	go func() {
+		serveErrs <- http.ListenAndServe(addr, mux)
+	}()
+
+	select {
+	case err = <-serveErrs:
+	case err = <-wh.Errors():
+	}
+
+	return err
+}
+
+// fail logs the given error, encountered while handling a push event, with the
+// given context, fields and message. If the error policy is "fail-fast", it
+// then stops the pusher by sending the error on the webhook's errors channel.
+func (wh *Webhook) fail(
+	ctx context.Context, err error, fields logrus.Fields, msg string,
+) {
+	fields["error"] = err
+	logrus.WithContext(ctx).WithFields(fields).Error(msg)
+
+	if wh.cfg.FailFast() {
+		// Only the first error is returned, so we don't block if there's
+		// already one.
+		select {
+		case wh.errs <- err:
+		default:
+		}
+	}
+}
+
+// handleEvent parses the GitLab event sent in the given request, checking its
+// secret token, and, if it's a push event, queues it to be processed in the
+// background so GitLab doesn't time out waiting for the response. Other events
+// are ignored.
+// The push event is given the ID of the sync run which will process it, which
+// is returned in the response's X-Run-ID header so the logs of its processing
+// can be found from the Git server's webhook logs. The logs of the handling of
+// the request are tagged with this ID too.
+func (wh *Webhook) handleEvent(w http.ResponseWriter, r *http.Request) {
+	id := logger.NewRunID()
+	ctx := logger.WithRun(r.Context(), id)
+	w.Header().Set("X-Run-ID", id)
+
+	payload, err := wh.hook.Parse(r, gitlab.PushEvents)
+	switch err {
+	case nil:
+	case gitlab.ErrEventNotFound:
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"event": r.Header.Get("X-Gitlab-Event"),
+		}).Info("Ignoring event which isn't a push event")
+		return
+	case gitlab.ErrInvalidHTTPMethod:
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	case gitlab.ErrGitLabTokenVerificationFailed:
+		logrus.WithContext(ctx).Warn("Received an event with an invalid secret token")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	default:
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Failed to parse the event sent by GitLab")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wh.enqueue(ctx, pushEvent{
+		payload: payload.(gitlab.PushEventPayload),
+		runID:   id,
+	})
+}
+
+// enqueue queues the given push event to be processed, and starts processing
+// the queued events in the background if it isn't already being done. The
+// given context is the one of the request the event was received with.
+func (wh *Webhook) enqueue(ctx context.Context, event pushEvent) {
+	wh.pendingMutex.Lock()
+	defer wh.pendingMutex.Unlock()
+
+	wh.pending = append(wh.pending, event)
+	if wh.processing {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"ref":     event.payload.Ref,
+			"pending": len(wh.pending),
+		}).Info("Another push event is being processed, queueing this one")
+
+		return
+	}
+
+	wh.processing = true
+	go wh.processPending()
+}
+
+// processPending processes the queued push events until there's none left.
+// The events queued while the previous ones were being processed (e.g. a burst
+// of pushes) are coalesced (see coalescePushes), so each branch is only
+// synchronised and pushed to Grafana once per burst. Each branch's push is
+// processed as a sync run with the ID its first event was given, so the ID
+// returned for this event matches the logs of the processing. The IDs of the
+// other events of the push are logged at the start of the run.
+func (wh *Webhook) processPending() {
+	for {
+		wh.pendingMutex.Lock()
+		pending := wh.pending
+		wh.pending = nil
+		if len(pending) == 0 {
+			wh.processing = false
+			wh.pendingMutex.Unlock()
+			return
+		}
+		wh.pendingMutex.Unlock()
+
+		events := make([]gitlab.PushEventPayload, 0, len(pending))
+		runIDs := make(map[string][]string)
+		for _, event := range pending {
+			events = append(events, event.payload)
+			runIDs[event.payload.Ref] = append(
+				runIDs[event.payload.Ref], event.runID,
+			)
+		}
+
+		for _, pl := range coalescePushes(events) {
+			ids := runIDs[pl.Ref]
+			ctx := logger.WithRun(context.Background(), ids[0])
+
+			if len(ids) > 1 {
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"ref":            pl.Ref,
+					"coalesced_runs": ids[1:],
+				}).Info("Coalesced the queued push events to the branch")
+			}
+
+			wh.HandlePush(ctx, pl)
+		}
+	}
+}
+
+// coalescePushes merges the given push events, in the order they were
+// received, into one push event per branch, which starts where the first push
+// to the branch started, ends where the last one ended, and includes all of
+// their commits, in order. The branches' pushes are returned in the order of
+// their first event.
+func coalescePushes(events []gitlab.PushEventPayload) []gitlab.PushEventPayload {
+	pushes := make([]gitlab.PushEventPayload, 0, len(events))
+	byRef := make(map[string]int)
+
+	for _, pl := range events {
+		i, ok := byRef[pl.Ref]
+		if !ok {
+			byRef[pl.Ref] = len(pushes)
+			pushes = append(pushes, pl)
+			continue
+		}
+
+		pushes[i].After = pl.After
+		pushes[i].CheckoutSHA = pl.CheckoutSHA
+		pushes[i].Commits = append(pushes[i].Commits, pl.Commits...)
+		pushes[i].TotalCommitsCount += pl.TotalCommitsCount
+	}
+
+	return pushes
+}
+
+// netChanges returns the files added, modified and removed by the given
+// commits, in order, taken as a whole: a file added then modified is only
+// added, a file removed then added again is modified, and a file added then
+// removed is left out, since it didn't exist before the commits and doesn't
+// exist after them. The files are sorted by name.
+func netChanges(commits []gitlab.Commit) (added, modified, removed []string) {
+	const (
+		fileAdded = iota
+		fileModified
+		fileRemoved
+		fileTransient
 	)
+
+	states := make(map[string]int)
+	for _, commit := range commits {
+		for _, filename := range commit.Added {
+			if state, ok := states[filename]; ok && state == fileRemoved {
+				states[filename] = fileModified
+			} else {
+				states[filename] = fileAdded
+			}
+		}
+
+		for _, filename := range commit.Modified {
+			if state, ok := states[filename]; !ok || state != fileAdded {
+				states[filename] = fileModified
+			}
+		}
+
+		for _, filename := range commit.Removed {
+			if state, ok := states[filename]; ok && (state == fileAdded || state == fileTransient) {
+				states[filename] = fileTransient
+			} else {
+				states[filename] = fileRemoved
+			}
+		}
+	}
+
+	added, modified, removed = make([]string, 0), make([]string, 0), make([]string, 0)
+	for filename, state := range states {
+		switch state {
+		case fileAdded:
+			added = append(added, filename)
+		case fileModified:
+			modified = append(modified, filename)
+		case fileRemoved:
+			removed = append(removed, filename)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(modified)
+	sort.Strings(removed)
+
+	return
 }
 
-// HandlePush is called each time a push event is sent by GitLab on the webhook.
-func HandlePush(payload interface{}, header webhooks.Header) {
+// subdirectoryFiles returns the files with the given paths (relative to the
+// repository's root) which are in the repository's subdirectory, with paths
+// relative to it (see config.GitSettings.SubdirectoryPath).
+func subdirectoryFiles(filenames []string, cfg *config.GitSettings) []string {
+	files := make([]string, 0, len(filenames))
+	for _, filename := range filenames {
+		if file, ok := cfg.SubdirectoryPath(filename); ok {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+// HandlePush processes a push event sent by GitLab on the webhook, as part of
+// the sync run the given context belongs to, holding the clone's lock (see
+// git.LockClone) while doing so.
+func (wh *Webhook) HandlePush(ctx context.Context, pl gitlab.PushEventPayload) {
 	var err error
 
+	defer git.LockClone(wh.cfg.Git.ClonePath)()
+
 	var (
-		added    = make([]string, 0)
-		modified = make([]string, 0)
-		removed  = make([]string, 0)
+		commits  = make([]gitlab.Commit, 0, len(pl.Commits))
 		contents = make(map[string][]byte)
+		authors  = make(map[string][]string)
 	)
 
-	// Process the payload using the right structure
-	pl := payload.(gitlab.PushEventPayload)
-
-	// Only push changes made on master to Grafana
-	if pl.Ref != "refs/heads/master" {
+	// Only push changes made on the watched branches to Grafana
+	branch := strings.TrimPrefix(pl.Ref, "refs/heads/")
+	folder, ok := wh.cfg.Pusher.Branches[branch]
+	if !ok {
 		return
 	}
 
 	for _, commit := range pl.Commits {
 		// We don't want to process commits made by the puller
-		if commit.Author.Email == cfg.Git.CommitsAuthor.Email {
-			logrus.WithFields(logrus.Fields{
+		if git.SkipsCommit(commit.Message, commit.Author.Email, wh.cfg.Git) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
 				"hash":          commit.ID,
 				"author_email":  commit.Author.Email,
-				"manager_email": cfg.Git.CommitsAuthor.Email,
+				"manager_email": wh.cfg.Git.CommitsAuthor.Email,
 			}).Info("Commit was made by the manager, skipping")
 
 			continue
 		}
 
-		// Copy added files' names
-		for _, addedFile := range commit.Added {
-			added = append(added, addedFile)
+		// Only consider the files in the repository's subdirectory, with
+		// paths relative to it.
+		commit.Added = subdirectoryFiles(commit.Added, wh.cfg.Git)
+		commit.Modified = subdirectoryFiles(commit.Modified, wh.cfg.Git)
+		commit.Removed = subdirectoryFiles(commit.Removed, wh.cfg.Git)
+
+		commits = append(commits, commit)
+
+		// Keep track of who changed which file, ignoring the manager which
+		// isn't the author of the changes it commits.
+		if git.IsManagerCommit(commit.Message, commit.Author.Email, wh.cfg.Git) {
+			continue
 		}
 
-		// Copy modified files' names
-		for _, modifiedFile := range commit.Modified {
-			modified = append(modified, modifiedFile)
+		for _, filename := range append(append(commit.Added, commit.Modified...), commit.Removed...) {
+			authors[filename] = append(authors[filename], commit.Author.Email)
 		}
+	}
 
-		// Copy removed files' names
-		for _, removedFile := range commit.Removed {
-			removed = append(removed, removedFile)
+	// Only keep the overall changes to each file, e.g. so a file added then
+	// removed by the push isn't looked for.
+	added, modified, removed := netChanges(commits)
+
+	// The clone follows the branch it checked out (i.e. the remote's default
+	// branch), so we can read the files' contents from the disk for this
+	// branch. For other branches, we read them from the branch's history
+	// instead.
+	if branch == wh.branch {
+		err = wh.getFilesContentsFromClone(added, modified, removed, &contents)
+	} else {
+		err = wh.getFilesContentsFromBranch(
+			branch, pl.Before, added, modified, removed, &contents,
+		)
+	}
+
+	if err != nil {
+		wh.fail(ctx, err, logrus.Fields{
+			"branch": branch,
+		}, "Failed to retrieve the files' contents")
+
+		git.RecordTimeout(wh.cfg, err)
+
+		return
+	}
+
+	// Remove the ignored files from the map
+	if err = common.FilterIgnored(&contents, wh.cfg); err != nil {
+		wh.fail(ctx, err, logrus.Fields{
+			"branch": branch,
+		}, "Failed to filter out the ignored files")
+
+		return
+	}
+
+	// Dashboards from branches other than the one the clone follows must not
+	// update the dashboards they were pulled from, which live in this
+	// branch's folder, nor their permissions.
+	if branch != wh.branch {
+		if err = common.StripIdentifiers(contents); err != nil {
+			wh.fail(ctx, err, logrus.Fields{
+				"branch": branch,
+			}, "Failed to strip the dashboards' identifiers")
+
+			return
 		}
+
+		common.FilterPermissions(&added, wh.cfg)
+		common.FilterPermissions(&modified, wh.cfg)
+		common.FilterPermissions(&removed, wh.cfg)
+	}
+
+	// Reject the changes made by people who don't own the changed files, if
+	// the user requested it.
+	changed := append(added, modified...)
+	if err = common.FilterUnowned(ctx, &changed, &removed, authors, wh.cfg); err != nil {
+		wh.fail(ctx, err, logrus.Fields{
+			"branch": branch,
+		}, "Failed to check the ownership of the changed files")
+
+		return
 	}
 
-	// Get the content of the removed files before pulling from the remote, because
-	// we won't be able to access them afterwards
-	if err = getFilesContents(removed, &contents, cfg); err != nil {
+	// Don't push back the files which content already matches Grafana's, if
+	// the manager's commits are inspected rather than skipped.
+	if err = common.FilterUnchanged(ctx, &changed, contents, wh.client, wh.cfg); err != nil {
+		wh.fail(ctx, err, logrus.Fields{
+			"branch": branch,
+		}, "Failed to compare the files with the dashboards on Grafana")
+
 		return
 	}
 
-	// Synchronise the repository (i.e. pull from remote)
-	if err = repo.Sync(false); err != nil {
-		logrus.WithFields(logrus.Fields{
+	// Only delete the dashboards that were removed from the repository if the
+	// user requested it.
+	if !wh.deleteRemoved {
+		removed = nil
+	}
+
+	// Push all added and modified dashboards to their folders on Grafana, and
+	// delete the removed ones, unless there's an ongoing freeze.
+	versions, err := wh.queue.ApplyToFolders(ctx, changed, removed, contents, folder)
+	wh.commitPushedVersions(ctx, versions)
+
+	if err != nil {
+		wh.fail(ctx, err, logrus.Fields{
+			"branch": branch,
+		}, "Failed to apply the changes from the branch")
+	}
+
+	// Keep the clone's size in check.
+	if err = wh.maintainer.RunIfDue(wh.repo); err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
 			"error":      err,
-			"repo":       cfg.Git.User + "@" + cfg.Git.URL,
-			"clone_path": cfg.Git.ClonePath,
-		}).Error("Failed to synchronise the Git repository with the remote")
+			"clone_path": wh.cfg.Git.ClonePath,
+		}).Error("Failed to run the maintenance of the Git repository")
+	}
+}
+
+// commitPushedVersions is called after changes have been applied to Grafana.
+// Grafana auto-updates the version number of the dashboards we pushed, and
+// returns the new numbers, which we commit in the git repo (along with the
+// dashboards' UIDs) so the puller doesn't consider them as changes made on
+// Grafana, unless the pusher's settings turn it off.
+func (wh *Webhook) commitPushedVersions(
+	ctx context.Context, versions map[string]int,
+) {
+	if !wh.cfg.Pusher.PullsAfterPush() {
+		if len(versions) > 0 {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"dashboards": len(versions),
+			}).Info("Not committing the versions of the pushed dashboards, since post_push_pull is false")
+		}
 
 		return
 	}
 
-	// Get the content of the added files
-	if err = getFilesContents(added, &contents, cfg); err != nil {
+	if err := puller.CommitPushedVersions(ctx, wh.cfg, versions); err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"error":      err,
+			"repo":       wh.cfg.Git.User + "@" + wh.cfg.Git.URL,
+			"clone_path": wh.cfg.Git.ClonePath,
+		}).Error("Call to puller returned an error")
+	}
+}
+
+// getFilesContentsFromClone reads the contents of the given added, modified
+// and removed files from the clone path, and appends them to the given map. It
+// reads the removed files' contents before pulling from the remote, because we
+// won't be able to access them afterwards.
+// Returns an error if there was an issue reading a file or pulling from the
+// remote.
+func (wh *Webhook) getFilesContentsFromClone(
+	added []string, modified []string, removed []string,
+	contents *map[string][]byte,
+) (err error) {
+	// Get the content of the removed files before pulling from the remote
+	if err = getFilesContents(removed, contents, wh.cfg); err != nil {
 		return
 	}
 
-	// Get the content of the modified files
-	if err = getFilesContents(modified, &contents, cfg); err != nil {
+	// Synchronise the repository (i.e. pull from remote)
+	if err = wh.repo.Sync(false); err != nil {
 		return
 	}
 
-	// Remove the ignored files from the map
-	if err = common.FilterIgnored(&contents, cfg); err != nil {
+	// Get the content of the added and modified files
+	if err = getFilesContents(added, contents, wh.cfg); err != nil {
 		return
 	}
 
-	// Push all added and modified dashboards to Grafana
-	common.PushFiles(added, contents, grafanaClient)
-	common.PushFiles(modified, contents, grafanaClient)
+	return getFilesContents(modified, contents, wh.cfg)
+}
+
+// getFilesContentsFromBranch fetches the given branch from the remote and
+// appends to the given map the contents of the given added and modified files
+// at the branch's latest commit, and the contents of the given removed files at
+// the commit with the given hash (i.e. the latest commit before the push).
+// Returns an error if there was an issue fetching the branch or loading the
+// files' contents.
+func (wh *Webhook) getFilesContentsFromBranch(
+	branch string, beforeHash string, added []string, modified []string,
+	removed []string, contents *map[string][]byte,
+) error {
+	head, err := wh.repo.GetBranchHead(branch)
+	if err != nil {
+		return err
+	}
+
+	headContents, err := wh.repo.GetFilesContentsAtCommit(head)
+	if err != nil {
+		return err
+	}
 
-	// If the user requested it, delete all dashboards that were removed
-	// from the repository.
-	if deleteRemoved {
-		common.DeleteDashboards(removed, contents, grafanaClient)
+	for _, filename := range append(added, modified...) {
+		(*contents)[filename] = headContents[filename]
 	}
 
-	// Grafana will auto-update the version number after we pushed the new
-	// dashboards, so we use the puller mechanic to pull the updated numbers and
-	// commit them in the git repo.
-	if err = puller.PullGrafanaAndCommit(grafanaClient, cfg); err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error":      err,
-			"repo":       cfg.Git.User + "@" + cfg.Git.URL,
-			"clone_path": cfg.Git.ClonePath,
-		}).Error("Call to puller returned an error")
+	if len(removed) == 0 {
+		return nil
+	}
+
+	before, err := wh.repo.GetCommit(beforeHash)
+	if err != nil {
+		return err
+	}
+
+	beforeContents, err := wh.repo.GetFilesContentsAtCommit(before)
+	if err != nil {
+		return err
 	}
+
+	for _, filename := range removed {
+		(*contents)[filename] = beforeContents[filename]
+	}
+
+	return nil
 }
 
 // getFilesContents takes a slice of files' names and a map mapping a file's name
@@ -171,9 +656,9 @@ func getFilesContents(
 	// Iterate over files' names
 	for _, filename := range filenames {
 		// Compute the file's path
-		filePath := filepath.Join(cfg.Git.ClonePath, filename)
+		filePath := filepath.Join(cfg.Git.SyncPath(), filename)
 		// Read the file's content
-		fileContent, err := ioutil.ReadFile(filePath)
+		fileContent, err := textfile.Read(filePath)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,160 @@
+package webhook
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-playground/webhooks/v6/gitlab"
+)
+
+func TestNetChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		commits  []gitlab.Commit
+		added    []string
+		modified []string
+		removed  []string
+	}{
+		{
+			name:     "no commit",
+			added:    []string{},
+			modified: []string{},
+			removed:  []string{},
+		},
+		{
+			name: "single commit",
+			commits: []gitlab.Commit{
+				{Added: []string{"b.json", "a.json"}, Modified: []string{"c.json"}, Removed: []string{"d.json"}},
+			},
+			added:    []string{"a.json", "b.json"},
+			modified: []string{"c.json"},
+			removed:  []string{"d.json"},
+		},
+		{
+			name: "added then modified",
+			commits: []gitlab.Commit{
+				{Added: []string{"a.json"}},
+				{Modified: []string{"a.json"}},
+			},
+			added:    []string{"a.json"},
+			modified: []string{},
+			removed:  []string{},
+		},
+		{
+			name: "removed then added",
+			commits: []gitlab.Commit{
+				{Removed: []string{"a.json"}},
+				{Added: []string{"a.json"}},
+			},
+			added:    []string{},
+			modified: []string{"a.json"},
+			removed:  []string{},
+		},
+		{
+			name: "added then removed",
+			commits: []gitlab.Commit{
+				{Added: []string{"a.json"}},
+				{Modified: []string{"a.json"}},
+				{Removed: []string{"a.json"}},
+			},
+			added:    []string{},
+			modified: []string{},
+			removed:  []string{},
+		},
+		{
+			name: "added, removed and added again",
+			commits: []gitlab.Commit{
+				{Added: []string{"a.json"}},
+				{Removed: []string{"a.json"}},
+				{Added: []string{"a.json"}},
+			},
+			added:    []string{"a.json"},
+			modified: []string{},
+			removed:  []string{},
+		},
+		{
+			name: "modified then removed",
+			commits: []gitlab.Commit{
+				{Modified: []string{"a.json"}},
+				{Removed: []string{"a.json"}},
+			},
+			added:    []string{},
+			modified: []string{},
+			removed:  []string{"a.json"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			added, modified, removed := netChanges(test.commits)
+
+			if !reflect.DeepEqual(added, test.added) {
+				t.Errorf("expected %v to be added, got %v", test.added, added)
+			}
+			if !reflect.DeepEqual(modified, test.modified) {
+				t.Errorf("expected %v to be modified, got %v", test.modified, modified)
+			}
+			if !reflect.DeepEqual(removed, test.removed) {
+				t.Errorf("expected %v to be removed, got %v", test.removed, removed)
+			}
+		})
+	}
+}
+
+func TestCoalescePushes(t *testing.T) {
+	first := gitlab.Commit{ID: "1"}
+	second := gitlab.Commit{ID: "2"}
+	third := gitlab.Commit{ID: "3"}
+
+	tests := []struct {
+		name     string
+		events   []gitlab.PushEventPayload
+		expected []gitlab.PushEventPayload
+	}{
+		{
+			name:     "no event",
+			expected: []gitlab.PushEventPayload{},
+		},
+		{
+			name: "single push",
+			events: []gitlab.PushEventPayload{
+				{Ref: "refs/heads/master", Before: "0", After: "1", CheckoutSHA: "1", Commits: []gitlab.Commit{first}, TotalCommitsCount: 1},
+			},
+			expected: []gitlab.PushEventPayload{
+				{Ref: "refs/heads/master", Before: "0", After: "1", CheckoutSHA: "1", Commits: []gitlab.Commit{first}, TotalCommitsCount: 1},
+			},
+		},
+		{
+			name: "same branch",
+			events: []gitlab.PushEventPayload{
+				{Ref: "refs/heads/master", Before: "0", After: "1", CheckoutSHA: "1", Commits: []gitlab.Commit{first}, TotalCommitsCount: 1},
+				{Ref: "refs/heads/master", Before: "1", After: "3", CheckoutSHA: "3", Commits: []gitlab.Commit{second, third}, TotalCommitsCount: 2},
+			},
+			expected: []gitlab.PushEventPayload{
+				{Ref: "refs/heads/master", Before: "0", After: "3", CheckoutSHA: "3", Commits: []gitlab.Commit{first, second, third}, TotalCommitsCount: 3},
+			},
+		},
+		{
+			name: "different branches",
+			events: []gitlab.PushEventPayload{
+				{Ref: "refs/heads/staging", Before: "0", After: "1", CheckoutSHA: "1", Commits: []gitlab.Commit{first}, TotalCommitsCount: 1},
+				{Ref: "refs/heads/master", Before: "0", After: "2", CheckoutSHA: "2", Commits: []gitlab.Commit{second}, TotalCommitsCount: 1},
+				{Ref: "refs/heads/staging", Before: "1", After: "3", CheckoutSHA: "3", Commits: []gitlab.Commit{third}, TotalCommitsCount: 1},
+			},
+			expected: []gitlab.PushEventPayload{
+				{Ref: "refs/heads/staging", Before: "0", After: "3", CheckoutSHA: "3", Commits: []gitlab.Commit{first, third}, TotalCommitsCount: 2},
+				{Ref: "refs/heads/master", Before: "0", After: "2", CheckoutSHA: "2", Commits: []gitlab.Commit{second}, TotalCommitsCount: 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pushes := coalescePushes(test.events)
+
+			if !reflect.DeepEqual(pushes, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, pushes)
+			}
+		})
+	}
+}
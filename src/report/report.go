@@ -0,0 +1,337 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+	"github.com/babolivier/grafana-dashboards-manager/src/semantic"
+)
+
+// Statuses of a changed dashboard.
+const (
+	StatusAdded    = "added"
+	StatusModified = "modified"
+	StatusRemoved  = "removed"
+)
+
+// DashboardChange describes the changes made to a dashboard between two states
+// of the repository.
+type DashboardChange struct {
+	Filename       string
+	Title          string
+	Status         string
+	PanelsAdded    []string
+	PanelsRemoved  []string
+	PanelsModified []string
+	// Path to the dashboard's screenshot in the repository, if any.
+	Screenshot string
+	// Usage of the dashboard on Grafana, if known.
+	Usage *grafana.DashboardUsage
+}
+
+// panel represents the parts of a panel's JSON description needed to compute
+// panel-level diffs.
+type panel struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	// Canonical JSON representation of the whole panel.
+	raw string
+}
+
+// Compute computes the changes made to the dashboards described in the given
+// added/modified and removed files, using the contents of the files before and
+// after the changes. If a screenshots path is provided, the screenshot of each
+// dashboard is looked up in the new contents, at the path of the dashboard's
+// file under the screenshots path (see config.ScreenshotsSettings). If ignoreLayout is true, panels
+// are matched by title and the ones which were only moved, resized or
+// renumbered aren't considered modified.
+// Returns an error if the JSON description of a dashboard couldn't be parsed.
+func Compute(
+	modified []string, removed []string,
+	oldContents map[string][]byte, newContents map[string][]byte,
+	screenshotsPath string, ignoreLayout bool,
+) (changes []DashboardChange, err error) {
+	changes = make([]DashboardChange, 0)
+
+	for _, filename := range modified {
+		oldJSON, existed := oldContents[filename]
+
+		change := DashboardChange{
+			Filename: filename,
+			Status:   StatusModified,
+		}
+		if !existed {
+			change.Status = StatusAdded
+		}
+
+		if change.Title, err = getTitle(newContents[filename]); err != nil {
+			return
+		}
+
+		if err = change.diffPanels(oldJSON, newContents[filename], ignoreLayout); err != nil {
+			return
+		}
+
+		if len(screenshotsPath) > 0 {
+			screenshot := path.Join(
+				screenshotsPath, strings.TrimSuffix(filename, ".json")+".png",
+			)
+			if _, ok := newContents[screenshot]; ok {
+				change.Screenshot = screenshot
+			}
+		}
+
+		changes = append(changes, change)
+	}
+
+	for _, filename := range removed {
+		change := DashboardChange{
+			Filename: filename,
+			Status:   StatusRemoved,
+		}
+
+		if change.Title, err = getTitle(oldContents[filename]); err != nil {
+			return
+		}
+
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Filename < changes[j].Filename
+	})
+
+	return
+}
+
+// AddUsage sets the usage of each changed dashboard from the given list of
+// dashboards' usage, if the dashboard is in the list. Dashboards are looked up
+// using the new contents of the added/modified files, and the old contents of
+// the removed ones.
+// Returns an error if the JSON description of a dashboard couldn't be parsed.
+func AddUsage(
+	changes []DashboardChange, usage []grafana.DashboardUsage,
+	oldContents map[string][]byte, newContents map[string][]byte,
+) error {
+	for i := range changes {
+		content := newContents[changes[i].Filename]
+		if changes[i].Status == StatusRemoved {
+			content = oldContents[changes[i].Filename]
+		}
+
+		ref, err := grafana.RefFromJSON(content)
+		if err != nil {
+			return err
+		}
+
+		if u, ok := grafana.FindUsage(usage, ref); ok {
+			changes[i].Usage = &u
+		}
+	}
+
+	return nil
+}
+
+// Markdown generates a Markdown summary of the given changes. If the usage of
+// at least one dashboard is known, the summary includes the dashboards' number
+// of views.
+func Markdown(changes []DashboardChange) string {
+	if len(changes) == 0 {
+		return "No dashboard changes.\n"
+	}
+
+	withUsage := false
+	for _, change := range changes {
+		if change.Usage != nil {
+			withUsage = true
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("## Dashboard changes\n\n")
+	if withUsage {
+		b.WriteString("| Dashboard | File | Status | Views (last 30 days) | Views (total) |\n")
+		b.WriteString("| --- | --- | --- | --- | --- |\n")
+	} else {
+		b.WriteString("| Dashboard | File | Status |\n")
+		b.WriteString("| --- | --- | --- |\n")
+	}
+	for _, change := range changes {
+		fmt.Fprintf(
+			&b, "| %s | `%s` | %s |",
+			change.Title, change.Filename, change.Status,
+		)
+
+		if withUsage {
+			if change.Usage != nil {
+				fmt.Fprintf(&b, " %d | %d |", change.Usage.RecentViews, change.Usage.Views)
+			} else {
+				b.WriteString(" - | - |")
+			}
+		}
+
+		b.WriteString("\n")
+	}
+
+	for _, change := range changes {
+		if change.Status == StatusRemoved {
+			continue
+		}
+
+		fmt.Fprintf(&b, "\n### %s\n\n", change.Title)
+		writePanelList(&b, "Added panels", change.PanelsAdded)
+		writePanelList(&b, "Removed panels", change.PanelsRemoved)
+		writePanelList(&b, "Modified panels", change.PanelsModified)
+
+		if len(change.PanelsAdded)+len(change.PanelsRemoved)+len(change.PanelsModified) == 0 {
+			b.WriteString("No panel-level changes.\n")
+		}
+
+		if len(change.Screenshot) > 0 {
+			fmt.Fprintf(&b, "\nScreenshot: `%s`\n", change.Screenshot)
+		}
+	}
+
+	return b.String()
+}
+
+// writePanelList writes a titled list of panels' names, if it isn't empty.
+func writePanelList(b *strings.Builder, title string, panels []string) {
+	if len(panels) == 0 {
+		return
+	}
+
+	fmt.Fprintf(b, "%s:\n\n", title)
+	for _, p := range panels {
+		fmt.Fprintf(b, "* %s\n", p)
+	}
+	b.WriteString("\n")
+}
+
+// diffPanels compares the panels of two versions of a dashboard's JSON
+// description, and fills the change's lists of added, removed and modified
+// panels accordingly. If the old version is nil, all panels are considered
+// added. If ignoreLayout is true, the panels' layout is left out of the
+// comparison.
+// Returns an error if one of the JSON descriptions couldn't be parsed.
+func (c *DashboardChange) diffPanels(
+	oldJSON []byte, newJSON []byte, ignoreLayout bool,
+) error {
+	oldPanels := make(map[string]panel)
+	if oldJSON != nil {
+		var err error
+		if oldPanels, err = getPanels(oldJSON, ignoreLayout); err != nil {
+			return err
+		}
+	}
+
+	newPanels, err := getPanels(newJSON, ignoreLayout)
+	if err != nil {
+		return err
+	}
+
+	for key, p := range newPanels {
+		oldPanel, ok := oldPanels[key]
+		if !ok {
+			c.PanelsAdded = append(c.PanelsAdded, p.name())
+		} else if oldPanel.raw != p.raw {
+			c.PanelsModified = append(c.PanelsModified, p.name())
+		}
+	}
+
+	for key, p := range oldPanels {
+		if _, ok := newPanels[key]; !ok {
+			c.PanelsRemoved = append(c.PanelsRemoved, p.name())
+		}
+	}
+
+	sort.Strings(c.PanelsAdded)
+	sort.Strings(c.PanelsRemoved)
+	sort.Strings(c.PanelsModified)
+
+	return nil
+}
+
+// getPanels extracts the panels from a dashboard's JSON description, either at
+// the root of the dashboard or inside its rows (for older dashboards), and
+// returns them mapped to their ID (or title if the panel doesn't have an ID).
+// If ignoreLayout is true, the panels' layout is left out of their canonical
+// representations, and they're mapped to their title if they have one, since
+// Grafana can renumber panels when a dashboard is saved.
+// Returns an error if the JSON description couldn't be parsed.
+func getPanels(dashboardJSON []byte, ignoreLayout bool) (map[string]panel, error) {
+	var dashboard struct {
+		Panels []json.RawMessage `json:"panels"`
+		Rows   []struct {
+			Panels []json.RawMessage `json:"panels"`
+		} `json:"rows"`
+	}
+
+	if err := json.Unmarshal(dashboardJSON, &dashboard); err != nil {
+		return nil, err
+	}
+
+	rawPanels := dashboard.Panels
+	for _, row := range dashboard.Rows {
+		rawPanels = append(rawPanels, row.Panels...)
+	}
+
+	panels := make(map[string]panel)
+	for _, rawPanel := range rawPanels {
+		var p panel
+		if err := json.Unmarshal(rawPanel, &p); err != nil {
+			return nil, err
+		}
+
+		// Re-encode the panel so that formatting changes aren't considered as
+		// modifications. encoding/json sorts the keys of maps.
+		var content map[string]interface{}
+		if err := json.Unmarshal(rawPanel, &content); err != nil {
+			return nil, err
+		}
+
+		if ignoreLayout {
+			semantic.StripLayout(content)
+		}
+
+		canonical, err := json.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+
+		p.raw = string(canonical)
+
+		key := fmt.Sprintf("id:%d", p.ID)
+		if p.ID == 0 || (ignoreLayout && len(p.Title) > 0) {
+			key = "title:" + p.Title
+		}
+
+		panels[key] = p
+	}
+
+	return panels, nil
+}
+
+// name returns a human-readable name for the panel.
+func (p panel) name() string {
+	if len(p.Title) > 0 {
+		return p.Title
+	}
+
+	return fmt.Sprintf("Panel #%d", p.ID)
+}
+
+// getTitle extracts the title from a dashboard's JSON description.
+// Returns an error if the JSON description couldn't be parsed.
+func getTitle(dashboardJSON []byte) (string, error) {
+	var dashboard struct {
+		Title string `json:"title"`
+	}
+
+	err := json.Unmarshal(dashboardJSON, &dashboard)
+	return dashboard.Title, err
+}
@@ -0,0 +1,143 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of the resources applied by the stages of a restore, in the order in
+// which they depend on each other: library panels are stored in folders,
+// dashboards reference folders, alert notification channels and library
+// panels, permissions apply to folders and dashboards, and playlists include
+// dashboards.
+const (
+	KindFolders            = "folders"
+	KindAlertNotifications = "alert notification channels"
+	KindLibraryPanels      = "library panels"
+	KindDashboards         = "dashboards"
+	KindPermissions        = "permissions"
+	KindPlaylists          = "playlists"
+)
+
+// Stage applies all the resources of a given kind to Grafana. Total is the
+// number of resources to apply. Apply applies them, and returns the errors
+// encountered, mapped to the names of the files describing the resources which
+// failed to be applied. If Required is true, the following stages can't be
+// applied if this one fails (e.g. because they need the IDs of the folders it
+// creates), whatever the error policy.
+type Stage struct {
+	Kind     string
+	Total    int
+	Required bool
+	Apply    func(ctx context.Context) map[string]error
+}
+
+// StageReport is the outcome of a stage. Applied is the number of resources
+// which were applied, and Failed the errors encountered, mapped to the names of
+// the files describing the resources which failed to be applied. Skipped is
+// true if the stage wasn't run because a previous one failed.
+type StageReport struct {
+	Kind    string
+	Applied int
+	Failed  map[string]error
+	Skipped bool
+}
+
+// Apply runs the given stages in order, each one only starting once the
+// previous one is over, so the resources a stage applies can rely on the ones
+// applied by the previous stages (e.g. a dashboard on the library panels it
+// includes). Stages without any resource to apply are left out. If a stage
+// fails, the following ones are skipped if it's required or if failFast is
+// true, else they're run anyway, with a warning since the resources they apply
+// might reference the ones that failed. The outcome of each stage is logged.
+// Returns the reports of the stages, in order.
+func Apply(ctx context.Context, stages []Stage, failFast bool) []StageReport {
+	reports := make([]StageReport, 0, len(stages))
+
+	var failedStage *Stage
+	for i := range stages {
+		stage := &stages[i]
+		if stage.Total == 0 {
+			continue
+		}
+
+		report := StageReport{Kind: stage.Kind, Failed: make(map[string]error)}
+		if failedStage != nil && (failedStage.Required || failFast) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"kind":       stage.Kind,
+				"total":      stage.Total,
+				"depends_on": failedStage.Kind,
+			}).Error("Not applying resources since some they depend on failed to be applied")
+
+			report.Skipped = true
+			reports = append(reports, report)
+			continue
+		}
+
+		if failedStage != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"kind":       stage.Kind,
+				"depends_on": failedStage.Kind,
+			}).Warn("Applying resources even though some they might depend on failed to be applied")
+		}
+
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"kind":  stage.Kind,
+			"total": stage.Total,
+		}).Info("Applying resources")
+
+		report.Failed = stage.Apply(ctx)
+		report.Applied = stage.Total - len(report.Failed)
+
+		fields := logrus.Fields{
+			"kind":    stage.Kind,
+			"applied": report.Applied,
+			"failed":  len(report.Failed),
+		}
+		if len(report.Failed) > 0 {
+			logrus.WithContext(ctx).WithFields(fields).Error("Some resources failed to be applied")
+
+			if failedStage == nil || stage.Required {
+				failedStage = stage
+			}
+		} else {
+			logrus.WithContext(ctx).WithFields(fields).Info("Resources applied")
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports
+}
+
+// Summary returns an error summarising the failures of the given stages, or
+// nil if none of them failed nor was skipped.
+func Summary(reports []StageReport) error {
+	parts := make([]string, 0)
+	for _, report := range reports {
+		if report.Skipped {
+			parts = append(parts, report.Kind+" skipped")
+		} else if len(report.Failed) > 0 {
+			filenames := make([]string, 0, len(report.Failed))
+			for filename := range report.Failed {
+				filenames = append(filenames, filename)
+			}
+			sort.Strings(filenames)
+
+			parts = append(parts, fmt.Sprintf(
+				"%d %s failed (%s)", len(report.Failed), report.Kind,
+				strings.Join(filenames, ", "),
+			))
+		}
+	}
+
+	if len(parts) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("Restore incomplete: %s", strings.Join(parts, "; "))
+}
@@ -0,0 +1,187 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/grafana"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Folder is a Grafana folder to restore, along with the directory of the
+// repository it is mapped to.
+type Folder struct {
+	Dir      string
+	Metadata grafana.FolderMetadata
+}
+
+// ReadFolders parses the given folders' metadata files, mapped to their paths
+// relative to the root of the repository, and returns the folders they
+// describe, sorted so that parents come before their children. A folder's
+// parent is the one from its metadata file if set, else the folder mapped to
+// the deepest directory containing its own.
+// Returns an error if a file couldn't be parsed, if a folder doesn't have a
+// UID, if two folders share the same UID, or if a folder's parent can't be
+// found.
+func ReadFolders(files map[string][]byte) ([]Folder, error) {
+	byUID := make(map[string]*Folder)
+	byDir := make(map[string]*Folder)
+
+	for filename, content := range files {
+		folder := &Folder{Dir: path.Dir(filename)}
+		if err := json.Unmarshal(content, &folder.Metadata); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+
+		uid := folder.Metadata.UID
+		if len(uid) == 0 {
+			return nil, fmt.Errorf("%s: the folder doesn't have a UID", filename)
+		}
+
+		if other, ok := byUID[uid]; ok {
+			return nil, fmt.Errorf(
+				"Folders in %s and %s share the same UID %s",
+				other.Dir, folder.Dir, uid,
+			)
+		}
+
+		byUID[uid] = folder
+		byDir[folder.Dir] = folder
+	}
+
+	// Infer the parents which aren't explicitly set from the directories'
+	// nesting. The root of the repository can't be nested in anything.
+	for _, folder := range byUID {
+		if len(folder.Metadata.ParentUID) > 0 {
+			if _, ok := byUID[folder.Metadata.ParentUID]; !ok {
+				return nil, fmt.Errorf(
+					"Unknown parent %s for folder %s",
+					folder.Metadata.ParentUID, folder.Metadata.Title,
+				)
+			}
+
+			continue
+		}
+
+		for dir := path.Dir(folder.Dir); dir != "."; dir = path.Dir(dir) {
+			if parent, ok := byDir[dir]; ok {
+				folder.Metadata.ParentUID = parent.Metadata.UID
+				break
+			}
+		}
+	}
+
+	return sortFolders(byUID)
+}
+
+// sortFolders returns the given folders, mapped to their UIDs, sorted so that
+// parents come before their children (and in alphabetical order of their
+// directories otherwise, so the order is stable).
+// Returns an error if the parents' references form a cycle.
+func sortFolders(byUID map[string]*Folder) ([]Folder, error) {
+	uids := make([]string, 0, len(byUID))
+	for uid := range byUID {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool {
+		return byUID[uids[i]].Dir < byUID[uids[j]].Dir
+	})
+
+	sorted := make([]Folder, 0, len(byUID))
+	done := make(map[string]bool)
+	visiting := make(map[string]bool)
+
+	var visit func(uid string) error
+	visit = func(uid string) error {
+		if done[uid] {
+			return nil
+		}
+
+		if visiting[uid] {
+			return fmt.Errorf("Folder %s is its own ancestor", byUID[uid].Metadata.Title)
+		}
+
+		visiting[uid] = true
+		if parent := byUID[uid].Metadata.ParentUID; len(parent) > 0 {
+			if err := visit(parent); err != nil {
+				return err
+			}
+		}
+
+		done[uid] = true
+		sorted = append(sorted, *byUID[uid])
+		return nil
+	}
+
+	for _, uid := range uids {
+		if err := visit(uid); err != nil {
+			return nil, err
+		}
+	}
+
+	return sorted, nil
+}
+
+// RestoreFolders creates, in the given order, the given folders that don't
+// exist on the Grafana instance, then applies their permissions. Folders are
+// identified by their UIDs, so restoring the same folders again doesn't
+// create duplicates.
+// Returns the IDs of the folders on the Grafana instance, mapped to the
+// directories of the repository they are mapped to.
+// Returns an error if there was an issue retrieving or creating a folder, or
+// applying its permissions.
+func RestoreFolders(
+	ctx context.Context, client *grafana.Client, folders []Folder,
+) (map[string]int, error) {
+	ids := make(map[string]int)
+
+	for i := range folders {
+		folder := &folders[i]
+
+		existing, err := client.GetFolderByUID(ctx, folder.Metadata.UID)
+		if err != nil {
+			return nil, err
+		}
+
+		if existing == nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"uid":    folder.Metadata.UID,
+				"title":  folder.Metadata.Title,
+				"parent": folder.Metadata.ParentUID,
+			}).Info("Creating folder")
+
+			if existing, err = client.CreateFolderFromMetadata(ctx, &folder.Metadata); err != nil {
+				return nil, err
+			}
+		}
+
+		if err = client.UpdateFolderPermissions(
+			ctx, folder.Metadata.UID, folder.Metadata.Permissions,
+		); err != nil {
+			return nil, err
+		}
+
+		ids[folder.Dir] = existing.ID
+	}
+
+	return ids, nil
+}
+
+// FolderID returns the ID of the folder mapped to the deepest directory
+// containing the file with the given name, from the given IDs mapped to
+// directories. Returns false if there's no such folder.
+func FolderID(filename string, ids map[string]int) (int, bool) {
+	for dir := path.Dir(filename); ; dir = path.Dir(dir) {
+		if id, ok := ids[dir]; ok {
+			return id, true
+		}
+
+		if dir == "." {
+			return 0, false
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package semantic
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// layoutFields lists the fields of a panel's JSON description which only
+// describe where the panel is displayed on the dashboard, or which Grafana
+// rewrites when the dashboard is saved, and are therefore ignored when
+// comparing dashboards semantically.
+var layoutFields = []string{"id", "gridPos"}
+
+// Equal checks whether two JSON descriptions of a dashboard describe the same
+// dashboard once their layout is left out, i.e. whether the changes between
+// them only consist in panels being moved around, resized or renumbered.
+// Returns an error if one of the descriptions couldn't be parsed.
+func Equal(a []byte, b []byte) (bool, error) {
+	normalisedA, err := Normalise(a)
+	if err != nil {
+		return false, err
+	}
+
+	normalisedB, err := Normalise(b)
+	if err != nil {
+		return false, err
+	}
+
+	return normalisedA == normalisedB, nil
+}
+
+// Normalise returns a canonical representation of a dashboard's JSON
+// description which doesn't depend on its layout: the fields Grafana sets
+// itself (ID and version) and the panels' IDs and positions are left out, and
+// the panels are sorted, including the ones nested in collapsed rows or in the
+// rows of older dashboards.
+// Returns an error if the description couldn't be parsed.
+func Normalise(dashboardJSON []byte) (string, error) {
+	var dashboard map[string]interface{}
+	if err := json.Unmarshal(dashboardJSON, &dashboard); err != nil {
+		return "", err
+	}
+
+	delete(dashboard, "id")
+	delete(dashboard, "version")
+
+	if err := normalisePanels(dashboard); err != nil {
+		return "", err
+	}
+
+	if rows, ok := dashboard["rows"].([]interface{}); ok {
+		for _, row := range rows {
+			if r, ok := row.(map[string]interface{}); ok {
+				if err := normalisePanels(r); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+
+	// encoding/json sorts the keys of maps.
+	canonical, err := json.Marshal(dashboard)
+	return string(canonical), err
+}
+
+// StripLayout removes the fields describing a panel's layout from its JSON
+// description, in place.
+func StripLayout(panel map[string]interface{}) {
+	for _, field := range layoutFields {
+		delete(panel, field)
+	}
+}
+
+// normalisePanels strips the layout of the panels listed in the "panels" field
+// of the given object (a dashboard or a row), and of the panels nested in them,
+// then sorts them by their canonical representations, in place.
+// Returns an error if a panel couldn't be re-encoded.
+func normalisePanels(parent map[string]interface{}) error {
+	panels, ok := parent["panels"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	canonical := make([]string, len(panels))
+	for i, panel := range panels {
+		if p, ok := panel.(map[string]interface{}); ok {
+			StripLayout(p)
+
+			if err := normalisePanels(p); err != nil {
+				return err
+			}
+		}
+
+		encoded, err := json.Marshal(panel)
+		if err != nil {
+			return err
+		}
+
+		canonical[i] = string(encoded)
+	}
+
+	sort.Sort(byCanonical{panels: panels, canonical: canonical})
+	return nil
+}
+
+// byCanonical sorts a list of panels by their canonical representations, which
+// are sorted along with them.
+type byCanonical struct {
+	panels    []interface{}
+	canonical []string
+}
+
+// Len implements sort.Interface.Len().
+func (b byCanonical) Len() int {
+	return len(b.panels)
+}
+
+// Less implements sort.Interface.Less().
+func (b byCanonical) Less(i, j int) bool {
+	return b.canonical[i] < b.canonical[j]
+}
+
+// Swap implements sort.Interface.Swap().
+func (b byCanonical) Swap(i, j int) {
+	b.panels[i], b.panels[j] = b.panels[j], b.panels[i]
+	b.canonical[i], b.canonical[j] = b.canonical[j], b.canonical[i]
+}
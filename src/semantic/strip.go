@@ -0,0 +1,62 @@
+package semantic
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// StripFields removes the fields at the given paths from a dashboard's JSON
+// description. A path lists the keys leading to the field, separated by dots
+// (e.g. "templating.list"), and a key suffixed with "[]" designates each
+// element of the array it holds (e.g. "templating.list[].current"). Paths
+// leading to a field which doesn't exist are ignored.
+// Returns the JSON description without the fields, with its keys sorted (as
+// the Grafana API returns them).
+// Returns an error if the description couldn't be parsed or re-encoded.
+func StripFields(dashboardJSON []byte, paths []string) ([]byte, error) {
+	// Decode numbers as json.Number so they're re-encoded as they were,
+	// rather than as floats.
+	decoder := json.NewDecoder(bytes.NewReader(dashboardJSON))
+	decoder.UseNumber()
+
+	var dashboard interface{}
+	if err := decoder.Decode(&dashboard); err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		stripPath(dashboard, strings.Split(path, "."))
+	}
+
+	return json.Marshal(dashboard)
+}
+
+// stripPath removes the field at the path made of the given keys from the given
+// decoded JSON value, in place.
+func stripPath(value interface{}, keys []string) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	key := strings.TrimSuffix(keys[0], "[]")
+	if len(keys) == 1 {
+		delete(object, key)
+		return
+	}
+
+	if key == keys[0] {
+		stripPath(object[key], keys[1:])
+		return
+	}
+
+	elements, ok := object[key].([]interface{})
+	if !ok {
+		return
+	}
+
+	for _, element := range elements {
+		stripPath(element, keys[1:])
+	}
+}
@@ -0,0 +1,178 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// mutex prevents concurrent updates of the state file from the same process
+// from overwriting each other.
+var mutex sync.Mutex
+
+// DashboardState describes the latest syncs of a dashboard: when it was last
+// successfully pulled from Grafana, last successfully pushed to Grafana, and
+// last failed to be pushed (along with the error), how many times it failed to
+// be pushed since it was last pushed successfully, and the URL of the issue
+// opened about these failures, if any.
+type DashboardState struct {
+	LastPull                *time.Time `json:"last_pull,omitempty"`
+	LastPush                *time.Time `json:"last_push,omitempty"`
+	LastPushFailure         *time.Time `json:"last_push_failure,omitempty"`
+	LastPushError           string     `json:"last_push_error,omitempty"`
+	ConsecutivePushFailures int        `json:"consecutive_push_failures,omitempty"`
+	FailureIssue            string     `json:"failure_issue,omitempty"`
+}
+
+// GitState describes the transfers with the Git remote which timed out:
+// Timeouts maps the Git operations (e.g. "fetch") to the number of times they
+// timed out, and LastTimeout is the time of the latest timeout.
+type GitState struct {
+	Timeouts    map[string]int `json:"timeouts,omitempty"`
+	LastTimeout *time.Time     `json:"last_timeout,omitempty"`
+}
+
+// State is the state the manager keeps across runs, stored as JSON in a file.
+// Dashboards maps dashboards' slugs to their states.
+type State struct {
+	Dashboards map[string]*DashboardState `json:"dashboards"`
+	Git        *GitState                  `json:"git,omitempty"`
+}
+
+// Load reads the state from the file at the given path. If the file doesn't
+// exist, returns an empty state.
+// Returns an error if there was an issue reading or decoding the file.
+func Load(filename string) (*State, error) {
+	s := &State{Dashboards: make(map[string]*DashboardState)}
+
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(content, s); err != nil {
+		return nil, err
+	}
+
+	if s.Dashboards == nil {
+		s.Dashboards = make(map[string]*DashboardState)
+	}
+
+	return s, nil
+}
+
+// RecordPull records that the dashboards with the given slugs were
+// successfully pulled from Grafana at the given time, in the state file at the
+// given path.
+// Returns an error if there was an issue reading or writing the state file.
+func RecordPull(filename string, slugs []string, t time.Time) error {
+	return update(filename, func(s *State) {
+		for _, slug := range slugs {
+			s.dashboard(slug).LastPull = &t
+		}
+	})
+}
+
+// RecordPush records that the dashboards with the given slugs were
+// successfully pushed to Grafana at the given time, and that the ones in the
+// given map of errors (mapped to their slugs) failed to be pushed, in the state
+// file at the given path. A successful push resets the dashboard's count of
+// consecutive failures, and forgets the issue opened about them.
+// Returns an error if there was an issue reading or writing the state file.
+func RecordPush(
+	filename string, pushed []string, failed map[string]error, t time.Time,
+) error {
+	return update(filename, func(s *State) {
+		for _, slug := range pushed {
+			d := s.dashboard(slug)
+			d.LastPush = &t
+			d.ConsecutivePushFailures = 0
+			d.FailureIssue = ""
+		}
+
+		for slug, err := range failed {
+			d := s.dashboard(slug)
+			d.LastPushFailure = &t
+			d.LastPushError = err.Error()
+			d.ConsecutivePushFailures++
+		}
+	})
+}
+
+// RecordFailureIssue records that the issue with the given URL was opened about
+// the push failures of the dashboard with the given slug, in the state file at
+// the given path.
+// Returns an error if there was an issue reading or writing the state file.
+func RecordFailureIssue(filename string, slug string, issueURL string) error {
+	return update(filename, func(s *State) {
+		s.dashboard(slug).FailureIssue = issueURL
+	})
+}
+
+// RecordGitTimeout records that the given Git operation (e.g. "fetch") timed
+// out at the given time, in the state file at the given path.
+// Returns an error if there was an issue reading or writing the state file.
+func RecordGitTimeout(filename string, operation string, t time.Time) error {
+	return update(filename, func(s *State) {
+		if s.Git == nil {
+			s.Git = new(GitState)
+		}
+
+		if s.Git.Timeouts == nil {
+			s.Git.Timeouts = make(map[string]int)
+		}
+
+		s.Git.Timeouts[operation]++
+		s.Git.LastTimeout = &t
+	})
+}
+
+// dashboard returns the state of the dashboard with the given slug, creating it
+// if it doesn't exist.
+func (s *State) dashboard(slug string) *DashboardState {
+	d, ok := s.Dashboards[slug]
+	if !ok {
+		d = new(DashboardState)
+		s.Dashboards[slug] = d
+	}
+
+	return d
+}
+
+// update loads the state from the file at the given path, applies the given
+// function to it, then writes it back. The file is replaced atomically, so
+// other processes reading it never see a partially written state.
+// Returns an error if there was an issue reading or writing the file.
+func update(filename string, f func(s *State)) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	s, err := Load(filename)
+	if err != nil {
+		return err
+	}
+
+	f(s)
+
+	content, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	tmp := filename + ".tmp"
+	if err = ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filename)
+}
@@ -0,0 +1,116 @@
+package tags
+
+import (
+	"encoding/json"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+)
+
+// Enforce adds the tags to add from the given settings to a dashboard's JSON
+// description, after its own tags, and removes the tags to remove from it. It
+// returns the updated description along with a boolean set to true if it
+// changed. If it didn't, the description is returned as is.
+// Returns an error if the description couldn't be parsed or re-encoded.
+func Enforce(
+	dashboardJSON []byte, cfg *config.TagsSettings,
+) (enforced []byte, changed bool, err error) {
+	missing, unwanted, err := Check(dashboardJSON, cfg)
+	if err != nil || len(missing) == 0 && len(unwanted) == 0 {
+		return dashboardJSON, false, err
+	}
+
+	var dashboard map[string]interface{}
+	if err = json.Unmarshal(dashboardJSON, &dashboard); err != nil {
+		return
+	}
+
+	tags := make([]interface{}, 0)
+	for _, tag := range dashboardTags(dashboard) {
+		if !contains(unwanted, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	for _, tag := range missing {
+		tags = append(tags, tag)
+	}
+
+	dashboard["tags"] = tags
+
+	enforced, err = json.Marshal(dashboard)
+	return enforced, true, err
+}
+
+// EnforceAll enforces the given settings' tags (see Enforce) on the dashboards
+// in the given map, mapping files' names to their contents, and returns the
+// updated dashboards mapped to their files' names. Dashboards which description
+// can't be parsed are kept unchanged.
+func EnforceAll(contents map[string][]byte, cfg *config.TagsSettings) map[string][]byte {
+	enforced := make(map[string][]byte, len(contents))
+	for filename, content := range contents {
+		e, _, err := Enforce(content, cfg)
+		if err != nil {
+			e = content
+		}
+
+		enforced[filename] = e
+	}
+
+	return enforced
+}
+
+// Check compares the tags of a dashboard's JSON description with the given
+// settings, and returns the tags to add which the dashboard doesn't have, and
+// the tags to remove which it has, in the settings' order.
+// Returns an error if the description couldn't be parsed.
+func Check(
+	dashboardJSON []byte, cfg *config.TagsSettings,
+) (missing []string, unwanted []string, err error) {
+	var dashboard map[string]interface{}
+	if err = json.Unmarshal(dashboardJSON, &dashboard); err != nil {
+		return
+	}
+
+	tags := dashboardTags(dashboard)
+
+	missing, unwanted = make([]string, 0), make([]string, 0)
+	for _, tag := range cfg.Add {
+		if !contains(tags, tag) {
+			missing = append(missing, tag)
+		}
+	}
+
+	for _, tag := range cfg.Remove {
+		if contains(tags, tag) {
+			unwanted = append(unwanted, tag)
+		}
+	}
+
+	return
+}
+
+// dashboardTags returns the tags of a decoded dashboard, ignoring the values
+// which aren't strings.
+func dashboardTags(dashboard map[string]interface{}) []string {
+	values, _ := dashboard["tags"].([]interface{})
+
+	tags := make([]string, 0, len(values))
+	for _, value := range values {
+		if tag, ok := value.(string); ok {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// contains checks whether the given slice contains the given string.
+func contains(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
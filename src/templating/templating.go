@@ -0,0 +1,181 @@
+package templating
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+	"github.com/babolivier/grafana-dashboards-manager/src/jsonpath"
+)
+
+// Default delimiters of the template actions in dashboards' JSON descriptions.
+const (
+	defaultLeftDelimiter  = "{{"
+	defaultRightDelimiter = "}}"
+)
+
+// templateErrorPosition matches the position of the action an error from
+// parsing or executing a dashboard's template is about (e.g. "dashboard:12:34"
+// in "template: dashboard:12:34: executing ..."), capturing its line and, if
+// any, its column.
+var templateErrorPosition = regexp.MustCompile(`^template: dashboard:(\d+)(?::(\d+))?:`)
+
+// Keys of the data a dashboard's template is executed with, besides the
+// environment's values. Env is the name of the environment the dashboard is
+// pushed to, Vars contains the arbitrary variables of the environment, and
+// Datasources maps generic names of data sources to their names in the
+// environment.
+const (
+	keyEnv         = "Env"
+	keyVars        = "Vars"
+	keyDatasources = "Datasources"
+)
+
+// data is the data a dashboard's template is executed with. It's a map so
+// templates can access the environment's values directly (e.g.
+// "{{ .cluster }}"), along with the keys above, which take precedence.
+type data map[string]interface{}
+
+// newData returns the data a dashboard's template is executed with, from the
+// given variables.
+func newData(vars config.TemplateVariables) data {
+	d := make(data, len(vars.Values)+3)
+	for key, value := range vars.Values {
+		d[key] = value
+	}
+
+	d[keyEnv] = vars.Env
+	d[keyVars] = vars.Vars
+	d[keyDatasources] = vars.Datasources
+	return d
+}
+
+// Datasource returns the name of the data source mapped to the given name in the
+// environment's variables.
+// Returns an error if there's no such data source.
+func (d data) Datasource(name string) (string, error) {
+	datasources, _ := d[keyDatasources].(map[string]string)
+	datasource, ok := datasources[name]
+	if !ok {
+		return "", fmt.Errorf("no data source named %s in the variables", name)
+	}
+
+	return datasource, nil
+}
+
+// Var returns the value of the variable with the given name.
+// Returns an error if there's no such variable.
+func (d data) Var(name string) (string, error) {
+	vars, _ := d[keyVars].(map[string]string)
+	value, ok := vars[name]
+	if !ok {
+		return "", fmt.Errorf("no variable named %s in the variables", name)
+	}
+
+	return value, nil
+}
+
+// IsTemplate checks whether the given dashboard's JSON description contains
+// template actions.
+func IsTemplate(content []byte, cfg *config.TemplatingSettings) bool {
+	left, _ := delimiters(cfg)
+	return bytes.Contains(content, []byte(left))
+}
+
+// Render executes the given dashboard's JSON description as a template, using
+// the given variables, and returns the result. Descriptions that don't contain
+// any template action are returned as is.
+// Returns an error if the template couldn't be parsed or executed (e.g. because
+// it uses a variable that isn't defined), or if the result isn't valid JSON.
+// The error points to the part of the template, or of the result, it's about
+// (see jsonpath.Error).
+func Render(
+	content []byte, vars config.TemplateVariables,
+	cfg *config.TemplatingSettings,
+) ([]byte, error) {
+	if !IsTemplate(content, cfg) {
+		return content, nil
+	}
+
+	left, right := delimiters(cfg)
+	tmpl, err := template.New("dashboard").
+		Delims(left, right).
+		Option("missingkey=error").
+		Parse(string(content))
+	if err != nil {
+		return nil, annotate(content, err)
+	}
+
+	var b bytes.Buffer
+	if err = tmpl.Execute(&b, newData(vars)); err != nil {
+		return nil, annotate(content, err)
+	}
+
+	var rendered interface{}
+	if err = json.Unmarshal(b.Bytes(), &rendered); err != nil {
+		return nil, jsonpath.Annotate(
+			b.Bytes(), fmt.Errorf("rendered dashboard isn't valid JSON: %w", err),
+		)
+	}
+
+	return b.Bytes(), nil
+}
+
+// annotate returns the given error from parsing or executing the given
+// dashboard's template as an error about the part of the dashboard's JSON
+// description it's about, or as is if the error doesn't include a position.
+func annotate(content []byte, err error) error {
+	match := templateErrorPosition.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+
+	// Columns in templates' errors start at 0, and are absent from parsing
+	// errors, in which case the error is about the start of the line.
+	line, _ := strconv.Atoi(match[1])
+	column, _ := strconv.Atoi(match[2])
+
+	return jsonpath.AtLine(content, line, column+1, err)
+}
+
+// RenderAll renders the dashboards in the given map, mapping files' names to
+// their contents, using the given variables, and returns the rendered
+// dashboards mapped to their files' names.
+// Returns an error, mentioning the file's name, if one of the dashboards
+// couldn't be rendered.
+func RenderAll(
+	contents map[string][]byte, vars config.TemplateVariables,
+	cfg *config.TemplatingSettings,
+) (map[string][]byte, error) {
+	rendered := make(map[string][]byte, len(contents))
+	for filename, content := range contents {
+		r, err := Render(content, vars, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+
+		rendered[filename] = r
+	}
+
+	return rendered, nil
+}
+
+// delimiters returns the delimiters of the template actions, as set in the
+// templating settings or the default ones.
+func delimiters(cfg *config.TemplatingSettings) (left string, right string) {
+	left, right = defaultLeftDelimiter, defaultRightDelimiter
+	if len(strings.TrimSpace(cfg.LeftDelimiter)) > 0 {
+		left = cfg.LeftDelimiter
+	}
+
+	if len(strings.TrimSpace(cfg.RightDelimiter)) > 0 {
+		right = cfg.RightDelimiter
+	}
+
+	return
+}
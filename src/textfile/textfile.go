@@ -0,0 +1,63 @@
+package textfile
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+)
+
+// bom is the UTF-8 byte order mark, which some Windows editors add at the start
+// of the files they save.
+var bom = []byte{0xEF, 0xBB, 0xBF}
+
+// Normalise returns the given content of a file without its UTF-8 byte order
+// mark, if any, since it makes JSON parsers reject the file, and with its
+// Windows line endings (CRLF) converted to Unix ones (LF), so the contents of
+// files edited on Windows compare equal to the same contents edited elsewhere.
+// JSON strings can't contain raw line breaks, so this doesn't change the value
+// a JSON description describes. The content is returned as is if there's
+// nothing to normalise.
+func Normalise(content []byte) []byte {
+	content = bytes.TrimPrefix(content, bom)
+
+	if !bytes.Contains(content, []byte("\r\n")) {
+		return content
+	}
+
+	return bytes.Replace(content, []byte("\r\n"), []byte("\n"), -1)
+}
+
+// WithLineEndings returns the given content, which uses Unix line endings, with
+// the given line endings (see the LineEndings* constants in the config
+// package).
+func WithLineEndings(content []byte, lineEndings string) []byte {
+	if lineEndings != config.LineEndingsCRLF {
+		return content
+	}
+
+	return bytes.Replace(Normalise(content), []byte("\n"), []byte("\r\n"), -1)
+}
+
+// LineEndings returns the line endings the given content uses, i.e. CRLF if
+// its first line ends with it, else LF.
+func LineEndings(content []byte) string {
+	i := bytes.IndexByte(content, '\n')
+	if i > 0 && content[i-1] == '\r' {
+		return config.LineEndingsCRLF
+	}
+
+	return config.LineEndingsLF
+}
+
+// Read reads the file at the given path and returns its normalised content (see
+// Normalise).
+// Returns an error if there was an issue reading the file.
+func Read(filename string) ([]byte, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return Normalise(content), nil
+}
@@ -0,0 +1,101 @@
+package textfile
+
+import (
+	"testing"
+
+	"github.com/babolivier/grafana-dashboards-manager/src/config"
+)
+
+func TestNormalise(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "unix",
+			content:  "{\n  \"a\": 1\n}\n",
+			expected: "{\n  \"a\": 1\n}\n",
+		},
+		{
+			name:     "windows",
+			content:  "{\r\n  \"a\": 1\r\n}\r\n",
+			expected: "{\n  \"a\": 1\n}\n",
+		},
+		{
+			name:     "byte order mark",
+			content:  "\ufeff{\n  \"a\": 1\n}\n",
+			expected: "{\n  \"a\": 1\n}\n",
+		},
+		{
+			name:     "byte order mark and windows",
+			content:  "\ufeff{\r\n  \"a\": 1\r\n}",
+			expected: "{\n  \"a\": 1\n}",
+		},
+		{
+			name:     "lone carriage return",
+			content:  "{\r  \"a\": 1\n}",
+			expected: "{\r  \"a\": 1\n}",
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := Normalise([]byte(test.content))
+
+			if string(content) != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, content)
+			}
+		})
+	}
+}
+
+func TestWithLineEndings(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		lineEndings string
+		expected    string
+	}{
+		{
+			name:        "lf",
+			content:     "{\n  \"a\": 1\n}\n",
+			lineEndings: config.LineEndingsLF,
+			expected:    "{\n  \"a\": 1\n}\n",
+		},
+		{
+			name:     "default",
+			content:  "{\n  \"a\": 1\n}\n",
+			expected: "{\n  \"a\": 1\n}\n",
+		},
+		{
+			name:        "crlf",
+			content:     "{\n  \"a\": 1\n}\n",
+			lineEndings: config.LineEndingsCRLF,
+			expected:    "{\r\n  \"a\": 1\r\n}\r\n",
+		},
+		{
+			name:        "crlf already",
+			content:     "{\r\n  \"a\": 1\r\n}\r\n",
+			lineEndings: config.LineEndingsCRLF,
+			expected:    "{\r\n  \"a\": 1\r\n}\r\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := WithLineEndings([]byte(test.content), test.lineEndings)
+
+			if string(content) != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, content)
+			}
+
+			if lineEndings := LineEndings(content); test.lineEndings != "" && lineEndings != test.lineEndings {
+				t.Errorf("expected %s line endings, got %s", test.lineEndings, lineEndings)
+			}
+		})
+	}
+}